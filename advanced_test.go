@@ -3,6 +3,7 @@ package bcl
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -108,6 +109,67 @@ profile "prod" {
 	}
 }
 
+func TestProvenanceTracksLayers(t *testing.T) {
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "base.bcl"), `
+region "eu"
+`)
+	root := filepath.Join(dir, "main.bcl")
+	mustWrite(t, root, `
+import "./base.bcl"
+
+log_level "info"
+db {
+  host "localhost"
+  port 5432
+}
+
+server "web" {
+  port 8080
+}
+
+override "server.web" {
+  port 9090
+}
+
+profile "prod" {
+  log_level "debug"
+  override db {
+    host "db.internal"
+  }
+}
+`)
+	n, err := CompileFile(root, &Options{ResolveImports: true, Profile: "prod", TrackProvenance: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prov := Provenance(n)
+	for path, layer := range map[string]string{
+		"region":          "import",
+		"log_level":       "profile prod",
+		"db.host":         "profile prod",
+		"db.port":         "document",
+		"server.web.port": "override server.web",
+	} {
+		if prov[path].Layer != layer {
+			t.Fatalf("%s: origin = %#v, want layer %q", path, prov[path], layer)
+		}
+	}
+	if prov["log_level"].Span.File != root || prov["region"].Span.File != filepath.Join(dir, "base.bcl") {
+		t.Fatalf("origins = %#v", prov)
+	}
+	if _, ok := prov["override.server.web"]; ok {
+		t.Fatalf("override block path left in provenance: %#v", prov)
+	}
+	n, err = CompileFile(root, &Options{ResolveImports: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Provenance(n) != nil {
+		t.Fatal("provenance tracked without TrackProvenance")
+	}
+}
+
 func TestSchemaReferenceAndCycleValidation(t *testing.T) {
 	doc, err := Parse([]byte(`
 schema policy {
@@ -163,3 +225,49 @@ func mustParsePath(t *testing.T, path string) *Document {
 	}
 	return doc
 }
+
+func TestRenamesMoveDeprecatedKeys(t *testing.T) {
+	src := []byte(`
+db {
+  user "app"
+  host "localhost"
+}
+timeout = 5
+retries = 3
+max_retries = 5
+`)
+	n, err := CompileBytes(src, &Options{TrackProvenance: true, Renames: map[string]string{
+		"db.user":   "database.username",
+		"timeout":   "http.timeout",
+		"retries":   "max_retries",
+		"unset.key": "other",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.Body["database"].(map[string]any)["username"]; got != "app" {
+		t.Fatalf("database.username = %#v", got)
+	}
+	if db := n.Body["db"].(map[string]any); db["user"] != nil || db["host"] != "localhost" {
+		t.Fatalf("db = %#v", db)
+	}
+	if _, ok := n.Body["timeout"]; ok || n.Body["http"].(map[string]any)["timeout"] == nil {
+		t.Fatalf("timeout not moved: %#v", n.Body)
+	}
+	if _, ok := n.Body["retries"]; ok || n.Body["max_retries"] != int64(5) {
+		t.Fatalf("conflicting rename should keep the new value: %#v", n.Body)
+	}
+	text := FormatDiagnostics(n.Diagnostics)
+	for _, want := range []string{`"db.user" is deprecated; use "database.username"`, `"retries" is deprecated; use "max_retries" (both are set`} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("missing %q in:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "unset.key") {
+		t.Fatalf("unset paths should not warn:\n%s", text)
+	}
+	prov := Provenance(n)
+	if _, ok := prov["db.user"]; ok || prov["database.username"].Layer != "document" {
+		t.Fatalf("provenance did not follow the rename: %+v", prov)
+	}
+}
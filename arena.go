@@ -0,0 +1,168 @@
+package bcl
+
+import "unsafe"
+
+// nodeArena hands out Literal and Assignment nodes from slabs.
+// Those two types make up most of a typical document, so allocating them in
+// bulk cuts per-node allocations; a slab is released by the collector once
+// no node in it is referenced, i.e. together with the document.
+type nodeArena struct {
+	lits    []Literal
+	assigns []Assignment
+}
+
+const arenaSlab = 64
+
+// newNodeArena sizes the first slabs from the token count; roughly one
+// token in ten starts a literal or an assignment.
+func newNodeArena(tokens int) *nodeArena {
+	n := max(tokens/10, 8)
+	return &nodeArena{lits: make([]Literal, 0, n), assigns: make([]Assignment, 0, n)}
+}
+
+func (a *nodeArena) literal(l Literal) *Literal {
+	if len(a.lits) == cap(a.lits) {
+		a.lits = make([]Literal, 0, arenaSlab)
+	}
+	a.lits = append(a.lits, l)
+	return &a.lits[len(a.lits)-1]
+}
+
+func (a *nodeArena) assignment(as Assignment) *Assignment {
+	if len(a.assigns) == cap(a.assigns) {
+		a.assigns = make([]Assignment, 0, arenaSlab)
+	}
+	a.assigns = append(a.assigns, as)
+	return &a.assigns[len(a.assigns)-1]
+}
+
+func (p *parser) lit(l Literal) *Literal {
+	if p.arena == nil {
+		n := new(Literal)
+		*n = l
+		return n
+	}
+	return p.arena.literal(l)
+}
+
+func (p *parser) assign(a Assignment) *Assignment {
+	if p.arena == nil {
+		n := new(Assignment)
+		*n = a
+		return n
+	}
+	return p.arena.assignment(a)
+}
+
+// ASTStats reports the size of a parsed document: node counts by kind
+// ("assignment", "block", "literal", ...) and an estimate of the bytes held
+// by the nodes and the strings they own. Interned keys are counted once
+// per occurrence, so Bytes is an upper bound.
+type ASTStats struct {
+	Nodes  int            `json:"nodes"`
+	ByKind map[string]int `json:"by_kind"`
+	Bytes  int64          `json:"bytes"`
+}
+
+func (d *Document) Stats() ASTStats {
+	s := ASTStats{ByKind: map[string]int{}}
+	if d == nil {
+		return s
+	}
+	s.Bytes = int64(unsafe.Sizeof(*d))
+	s.nodes(d.Items)
+	return s
+}
+
+func (s *ASTStats) add(kind string, size uintptr, strs ...string) {
+	s.Nodes++
+	s.ByKind[kind]++
+	s.Bytes += int64(size)
+	for _, str := range strs {
+		s.Bytes += int64(len(str))
+	}
+}
+
+func (s *ASTStats) nodes(items []Node) {
+	for _, n := range items {
+		switch x := n.(type) {
+		case *Assignment:
+			s.add("assignment", unsafe.Sizeof(*x), x.Name)
+			s.value(x.Value)
+		case *Block:
+			s.add("block", unsafe.Sizeof(*x), x.Type, x.ID)
+			s.nodes(x.Body)
+		case *Spread:
+			s.add("spread", unsafe.Sizeof(*x), x.Target)
+			s.nodes(x.Body)
+		case *IfChain:
+			s.add("if", unsafe.Sizeof(*x))
+			for _, b := range x.Branches {
+				s.value(b.Cond)
+				s.nodes(b.Body)
+			}
+			s.nodes(x.Else)
+		case *ConstDecl:
+			s.add("const", unsafe.Sizeof(*x), x.Name)
+			s.value(x.Value)
+		case *ImportDecl:
+			s.add("import", unsafe.Sizeof(*x), x.Path, x.Alias)
+		case *ParamDecl:
+			s.add("param", unsafe.Sizeof(*x), x.Name, x.Type, x.Description)
+			s.value(x.Default)
+		case *TypeDecl:
+			s.add("type", unsafe.Sizeof(*x), x.Name)
+		case *SchemaDecl:
+			s.add("schema", unsafe.Sizeof(*x), x.Name)
+		case *Object:
+			s.value(x)
+		case nil:
+		default:
+			s.add("other", 0)
+		}
+	}
+}
+
+func (s *ASTStats) value(v Value) {
+	switch x := v.(type) {
+	case *Literal:
+		if x == nil {
+			return
+		}
+		str, _ := x.Data.(string)
+		s.add("literal", unsafe.Sizeof(*x), x.Type, x.Raw, str)
+	case *List:
+		s.add("list", unsafe.Sizeof(*x))
+		for _, it := range x.Items {
+			s.value(it)
+		}
+	case *Object:
+		s.add("object", unsafe.Sizeof(*x))
+		s.nodes(x.Fields)
+	case *Call:
+		s.add("call", unsafe.Sizeof(*x), x.Name)
+		for _, a := range x.Args {
+			s.value(a)
+		}
+	case *Expr:
+		if x != nil {
+			s.add("expression", unsafe.Sizeof(*x), x.Raw)
+		}
+	case *Reference:
+		s.add("reference", unsafe.Sizeof(*x), x.Path)
+	case *Condition:
+		if x == nil {
+			return
+		}
+		s.add("condition", unsafe.Sizeof(*x), x.Op)
+		if x.Expr != nil {
+			s.value(x.Expr)
+		}
+		for _, ch := range x.Children {
+			s.value(ch)
+		}
+	case nil:
+	default:
+		s.add("other", 0)
+	}
+}
@@ -49,6 +49,7 @@ type Assignment struct {
 	Name      string `json:"name"`
 	Value     Value  `json:"value"`
 	Sensitive bool   `json:"sensitive,omitempty"`
+	Quoted    bool   `json:"quoted,omitempty"`
 	Span      Span   `json:"span,omitempty"`
 }
 
@@ -74,6 +75,59 @@ type Spread struct {
 func (*Spread) node()           {}
 func (s *Spread) GetSpan() Span { return s.Span }
 
+// Directive is a statement-level @name(args) { body } form, expanded at
+// compile time by the function registered for its name.
+type Directive struct {
+	Name string  `json:"name"`
+	Args []Value `json:"args,omitempty"`
+	Body []Node  `json:"body,omitempty"`
+	Span Span    `json:"span,omitempty"`
+}
+
+func (*Directive) node()           {}
+func (d *Directive) GetSpan() Span { return d.Span }
+
+// ForLoop stamps out Body once per element of In. `for item in list`
+// binds each item, `for i, item in list` also its index, and
+// `for key, value in object` each entry in key order.
+type ForLoop struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value"`
+	In    *Expr  `json:"in"`
+	Body  []Node `json:"body"`
+	Span  Span   `json:"span,omitempty"`
+}
+
+func (*ForLoop) node()           {}
+func (f *ForLoop) GetSpan() Span { return f.Span }
+
+type IfChain struct {
+	Branches []IfBranch `json:"branches"`
+	Else     []Node     `json:"else,omitempty"`
+	Span     Span       `json:"span,omitempty"`
+}
+
+type IfBranch struct {
+	Cond *Expr  `json:"cond"`
+	Body []Node `json:"body"`
+}
+
+func (*IfChain) node()           {}
+func (i *IfChain) GetSpan() Span { return i.Span }
+
+// FuncDecl is a function declared in a document, `func greet(name) {
+// return "hi " + name }`, callable from expressions and values like the
+// functions registered through EvalFunctions.
+type FuncDecl struct {
+	Name   string   `json:"name"`
+	Params []string `json:"params"`
+	Body   *Expr    `json:"body"`
+	Span   Span     `json:"span,omitempty"`
+}
+
+func (*FuncDecl) node()           {}
+func (f *FuncDecl) GetSpan() Span { return f.Span }
+
 type ConstDecl struct {
 	Name  string `json:"name"`
 	Value Value  `json:"value"`
@@ -104,6 +158,20 @@ type ParamDecl struct {
 func (*ParamDecl) node()           {}
 func (p *ParamDecl) GetSpan() Span { return p.Span }
 
+// Comment is a comment kept in a node list by ParseFileWithComments. A
+// trailing comment followed code on its line and is printed at the end of
+// the previous line, which for the first node of a body is the line that
+// opens it; a leading one is printed on its own line before the next
+// node. Compile and Validate skip comments.
+type Comment struct {
+	Text     string `json:"text"`
+	Trailing bool   `json:"trailing,omitempty"`
+	Span     Span   `json:"span,omitempty"`
+}
+
+func (*Comment) node()           {}
+func (c *Comment) GetSpan() Span { return c.Span }
+
 func (*TypeDecl) node()           {}
 func (t *TypeDecl) GetSpan() Span { return t.Span }
 
@@ -112,6 +180,9 @@ type SchemaDecl struct {
 	Options  map[string]Value `json:"options,omitempty"`
 	Fields   []SchemaField    `json:"fields"`
 	Sections map[string]Value `json:"sections,omitempty"`
+	AllOf    []string         `json:"all_of,omitempty"`
+	AnyOf    []string         `json:"any_of,omitempty"`
+	OneOf    []string         `json:"one_of,omitempty"`
 	Span     Span             `json:"span,omitempty"`
 }
 
@@ -126,6 +197,7 @@ type SchemaField struct {
 	Const                Value            `json:"const,omitempty"`
 	Default              Value            `json:"default,omitempty"`
 	Enum                 []Value          `json:"enum,omitempty"`
+	SubsetOf             []Value          `json:"subset_of,omitempty"`
 	Fields               []SchemaField    `json:"fields,omitempty"`
 	Items                string           `json:"items,omitempty"`
 	PrefixItems          []string         `json:"prefix_items,omitempty"`
@@ -156,10 +228,12 @@ type SchemaField struct {
 	MaxProps             Value            `json:"max_props,omitempty"`
 	Pattern              string           `json:"pattern,omitempty"`
 	Format               string           `json:"format,omitempty"`
+	Schemes              []string         `json:"schemes,omitempty"`
 	ContentEncoding      string           `json:"content_encoding,omitempty"`
 	ContentMediaType     string           `json:"content_media_type,omitempty"`
 	Examples             []Value          `json:"examples,omitempty"`
 	PatternProperties    Value            `json:"pattern_properties,omitempty"`
+	KeyPattern           string           `json:"key_pattern,omitempty"`
 	DependentRequired    Value            `json:"dependent_required,omitempty"`
 	LTField              string           `json:"lt_field,omitempty"`
 	LTEField             string           `json:"lte_field,omitempty"`
@@ -254,6 +328,10 @@ func (o *Object) ToInterface(redact bool) any {
 type Expr struct {
 	Raw  string `json:"raw"`
 	Span Span   `json:"span,omitempty"`
+
+	// scope holds the loop variables bound where the expression was
+	// stamped out by a for loop.
+	scope map[string]any
 }
 
 func (*Expr) value()          {}
@@ -328,6 +406,10 @@ type Normalized struct {
 	Namespaces  map[string]any      `json:"namespaces,omitempty"`
 	Schemas     map[string]any      `json:"schemas,omitempty"`
 	Diagnostics []Diagnostic        `json:"diagnostics,omitempty"`
+
+	provenance map[string]ValueOrigin
+	// redacted reports that sensitive values were compiled to "****".
+	redacted bool
 }
 
 type CompileResult struct {
@@ -344,6 +426,9 @@ type CompileResult struct {
 	Tests         []map[string]any `json:"tests,omitempty"`
 	Strict        bool             `json:"strict"`
 	ActiveProfile string           `json:"active_profile,omitempty"`
+
+	// redacted reports that Sensitive paths hold "****" in Normalized.
+	redacted bool
 }
 
 type Dependency struct {
@@ -366,6 +451,12 @@ type TypeDecl struct {
 	Span Span   `json:"span,omitempty"`
 }
 
+// Redacted reports whether sensitive values in n were compiled to "****",
+// so tools that write n elsewhere can refuse instead of clobbering them.
+func (n *Normalized) Redacted() bool {
+	return n != nil && n.redacted
+}
+
 func (n *Normalized) JSON(redact bool) ([]byte, error) {
 	if redact {
 		cp := *n
@@ -0,0 +1,277 @@
+// Package ast is the stable surface for tools that read or transform parsed
+// BCL without depending on compiler internals. The node types are those
+// produced by bcl.Parse; Walk, Inspect and Rewrite traverse them in source
+// order.
+//
+//	doc, _ := bcl.Parse(src)
+//	ast.Inspect(doc, func(e ast.Element) bool {
+//		if b, ok := e.(*ast.Block); ok {
+//			fmt.Println(b.Type, b.ID)
+//		}
+//		return true
+//	})
+package ast
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/oarkflow/bcl"
+)
+
+type (
+	Position = bcl.Position
+	Span     = bcl.Span
+
+	// Node is a statement: an item of a document, block body or object.
+	Node = bcl.Node
+	// Value is the right-hand side of an assignment, constant or argument.
+	Value = bcl.Value
+
+	Document    = bcl.Document
+	Assignment  = bcl.Assignment
+	Block       = bcl.Block
+	Spread      = bcl.Spread
+	IfChain     = bcl.IfChain
+	ForLoop     = bcl.ForLoop
+	FuncDecl    = bcl.FuncDecl
+	Directive   = bcl.Directive
+	IfBranch    = bcl.IfBranch
+	ConstDecl   = bcl.ConstDecl
+	ImportDecl  = bcl.ImportDecl
+	ParamDecl   = bcl.ParamDecl
+	Comment     = bcl.Comment
+	TypeDecl    = bcl.TypeDecl
+	SchemaDecl  = bcl.SchemaDecl
+	SchemaField = bcl.SchemaField
+
+	Literal   = bcl.Literal
+	List      = bcl.List
+	Object    = bcl.Object
+	Expr      = bcl.Expr
+	Condition = bcl.Condition
+	Call      = bcl.Call
+	Reference = bcl.Reference
+)
+
+// Element is any Node or Value.
+type Element interface {
+	GetSpan() Span
+}
+
+// A Visitor's Visit method is invoked for each element encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children of
+// the element with w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(e Element) (w Visitor)
+}
+
+// Walk traverses the tree rooted at e in depth-first source order.
+func Walk(v Visitor, e Element) {
+	if isNil(e) {
+		return
+	}
+	if v = v.Visit(e); v == nil {
+		return
+	}
+	eachChild(e, func(child Element) { Walk(v, child) })
+	v.Visit(nil)
+}
+
+type inspector func(Element) bool
+
+func (f inspector) Visit(e Element) Visitor {
+	if f(e) {
+		return f
+	}
+	return nil
+}
+
+// Inspect calls f for e and, while f returns true, for each of its
+// children; after the children it calls f(nil).
+func Inspect(e Element, f func(Element) bool) {
+	Walk(inspector(f), e)
+}
+
+// Rewrite replaces the tree bottom-up: the children of e are rewritten
+// first, then f receives e and returns its replacement. Returning nil
+// removes a node from its enclosing list; returning an element of the wrong
+// kind for its slot keeps the original. Nodes are modified in place.
+func Rewrite(e Element, f func(Element) Element) Element {
+	if isNil(e) {
+		return e
+	}
+	switch x := e.(type) {
+	case *Document:
+		x.Items = rewriteNodes(x.Items, f)
+	case *Assignment:
+		x.Value = rewriteValue(x.Value, f)
+	case *Block:
+		x.Body = rewriteNodes(x.Body, f)
+	case *Spread:
+		x.Body = rewriteNodes(x.Body, f)
+	case *IfChain:
+		for i := range x.Branches {
+			if c, ok := Rewrite(x.Branches[i].Cond, f).(*Expr); ok {
+				x.Branches[i].Cond = c
+			}
+			x.Branches[i].Body = rewriteNodes(x.Branches[i].Body, f)
+		}
+		x.Else = rewriteNodes(x.Else, f)
+	case *ForLoop:
+		if in, ok := Rewrite(x.In, f).(*Expr); ok {
+			x.In = in
+		}
+		x.Body = rewriteNodes(x.Body, f)
+	case *FuncDecl:
+		if body, ok := Rewrite(x.Body, f).(*Expr); ok {
+			x.Body = body
+		}
+	case *Directive:
+		for i, arg := range x.Args {
+			x.Args[i] = rewriteValue(arg, f)
+		}
+		x.Body = rewriteNodes(x.Body, f)
+	case *ConstDecl:
+		x.Value = rewriteValue(x.Value, f)
+	case *ParamDecl:
+		x.Default = rewriteValue(x.Default, f)
+	case *SchemaDecl:
+		for k, v := range x.Options {
+			x.Options[k] = rewriteValue(v, f)
+		}
+		for k, v := range x.Sections {
+			x.Sections[k] = rewriteValue(v, f)
+		}
+	case *List:
+		for i, item := range x.Items {
+			x.Items[i] = rewriteValue(item, f)
+		}
+	case *Object:
+		x.Fields = rewriteNodes(x.Fields, f)
+	case *Condition:
+		if c, ok := Rewrite(x.Expr, f).(*Expr); ok {
+			x.Expr = c
+		}
+		for i, child := range x.Children {
+			if c, ok := Rewrite(child, f).(*Condition); ok {
+				x.Children[i] = c
+			}
+		}
+	case *Call:
+		for i, arg := range x.Args {
+			x.Args[i] = rewriteValue(arg, f)
+		}
+	}
+	return f(e)
+}
+
+func rewriteNodes(nodes []Node, f func(Element) Element) []Node {
+	out := nodes[:0]
+	for _, n := range nodes {
+		switch r := Rewrite(n, f).(type) {
+		case nil:
+		case Node:
+			out = append(out, r)
+		default:
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func rewriteValue(v Value, f func(Element) Element) Value {
+	if isNil(v) {
+		return v
+	}
+	if r, ok := Rewrite(v, f).(Value); ok {
+		return r
+	}
+	return v
+}
+
+// eachChild calls fn for the direct children of e in source order.
+func eachChild(e Element, fn func(Element)) {
+	nodes := func(ns []Node) {
+		for _, n := range ns {
+			fn(n)
+		}
+	}
+	values := func(vs []Value) {
+		for _, v := range vs {
+			if !isNil(v) {
+				fn(v)
+			}
+		}
+	}
+	switch x := e.(type) {
+	case *Document:
+		nodes(x.Items)
+	case *Assignment:
+		values([]Value{x.Value})
+	case *Block:
+		nodes(x.Body)
+	case *Spread:
+		nodes(x.Body)
+	case *IfChain:
+		for _, b := range x.Branches {
+			if b.Cond != nil {
+				fn(b.Cond)
+			}
+			nodes(b.Body)
+		}
+		nodes(x.Else)
+	case *ForLoop:
+		if x.In != nil {
+			fn(x.In)
+		}
+		nodes(x.Body)
+	case *FuncDecl:
+		if x.Body != nil {
+			fn(x.Body)
+		}
+	case *Directive:
+		values(x.Args)
+		nodes(x.Body)
+	case *ConstDecl:
+		values([]Value{x.Value})
+	case *ParamDecl:
+		values([]Value{x.Default})
+	case *SchemaDecl:
+		values(sortedValues(x.Options))
+		values(sortedValues(x.Sections))
+	case *List:
+		values(x.Items)
+	case *Object:
+		nodes(x.Fields)
+	case *Condition:
+		if x.Expr != nil {
+			fn(x.Expr)
+		}
+		for _, c := range x.Children {
+			fn(c)
+		}
+	case *Call:
+		values(x.Args)
+	}
+}
+
+// sortedValues orders map values by source position.
+func sortedValues(m map[string]Value) []Value {
+	out := make([]Value, 0, len(m))
+	for _, v := range m {
+		out = append(out, v)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].GetSpan().Start.Offset < out[j].GetSpan().Start.Offset
+	})
+	return out
+}
+
+func isNil(e Element) bool {
+	if e == nil {
+		return true
+	}
+	rv := reflect.ValueOf(e)
+	return rv.Kind() == reflect.Pointer && rv.IsNil()
+}
@@ -0,0 +1,108 @@
+package ast
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/oarkflow/bcl"
+)
+
+func TestWalkAndRewrite(t *testing.T) {
+	doc, err := bcl.Parse([]byte(`
+const PORT = 8080
+server web {
+  port PORT
+  hosts ["a", "b"]
+  debug true
+  tls {
+    cert concat("certs/", "web.pem")
+  }
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	Inspect(doc, func(e Element) bool {
+		switch x := e.(type) {
+		case *Block:
+			kinds = append(kinds, "block:"+x.Type)
+		case *Assignment:
+			kinds = append(kinds, "assign:"+x.Name)
+		case *Literal:
+			kinds = append(kinds, "lit")
+		case *Call:
+			kinds = append(kinds, "call:"+x.Name)
+		}
+		return true
+	})
+	want := "block:server assign:port assign:hosts lit lit assign:debug lit assign:tls assign:cert call:concat lit lit"
+	if got := strings.Join(kinds, " "); !strings.Contains(got, want) {
+		t.Fatalf("walk order = %s", got)
+	}
+
+	Rewrite(doc, func(e Element) Element {
+		switch x := e.(type) {
+		case *Assignment:
+			if x.Name == "debug" {
+				return nil
+			}
+		case *Literal:
+			if s, ok := x.Data.(string); ok {
+				return &Literal{Type: "string", Data: strings.ToUpper(s), Span: x.Span}
+			}
+		case *Block:
+			if x.Type == "server" {
+				x.ID = "api"
+			}
+		}
+		return e
+	})
+	n, err := bcl.Compile(doc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := n.Blocks[0]["body"].(map[string]any)
+	if n.Blocks[0]["id"] != "api" || body["debug"] != nil || body["tls"].(map[string]any)["cert"] != "CERTS/WEB.PEM" {
+		t.Fatalf("rewritten = %#v", n.Blocks)
+	}
+	if hosts := body["hosts"].([]any); hosts[0] != "A" || body["port"] != int64(8080) {
+		t.Fatalf("rewritten body = %#v", body)
+	}
+}
+
+func TestWalkAndRewriteDirectives(t *testing.T) {
+	doc, err := bcl.Parse([]byte(`
+@region("eu") {
+  zone "a"
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	Inspect(doc, func(e Element) bool {
+		switch x := e.(type) {
+		case *Directive:
+			kinds = append(kinds, "directive:"+x.Name)
+		case *Assignment:
+			kinds = append(kinds, "assign:"+x.Name)
+		case *Literal:
+			kinds = append(kinds, "lit")
+		}
+		return true
+	})
+	if got := strings.Join(kinds, " "); got != "directive:region lit assign:zone lit" {
+		t.Fatalf("walk order = %s", got)
+	}
+	Rewrite(doc, func(e Element) Element {
+		if x, ok := e.(*Literal); ok {
+			return &Literal{Type: "string", Data: strings.ToUpper(x.Data.(string)), Span: x.Span}
+		}
+		return e
+	})
+	d := doc.Items[0].(*Directive)
+	if d.Args[0].(*Literal).Data != "EU" || d.Body[0].(*Assignment).Value.(*Literal).Data != "A" {
+		t.Fatalf("rewritten = %#v", d)
+	}
+}
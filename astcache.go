@@ -0,0 +1,209 @@
+package bcl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+)
+
+// astCacheFormat is part of every ASTCache key. It hashes the shape of the
+// gob-registered AST types and the version of this module, so entries
+// written by a build whose AST or parser differs are never decoded.
+var astCacheFormat string
+
+// astCacheEvictInterval is how often a cache fill also runs Evict.
+const astCacheEvictInterval = time.Hour
+
+func init() {
+	nodes := []any{&Assignment{}, &Block{}, &Spread{}, &Directive{}, &IfChain{}, &ForLoop{}, &FuncDecl{}, &ConstDecl{}, &ImportDecl{}, &ParamDecl{}, &Comment{}, &TypeDecl{}, &SchemaDecl{}, &Document{},
+		&Literal{}, &List{}, &Object{}, &Expr{}, &Condition{}, &Call{}, &Reference{}}
+	h := sha256.New()
+	seen := map[reflect.Type]bool{}
+	for _, n := range nodes {
+		gob.Register(n)
+		writeTypeShape(h, reflect.TypeOf(n), seen)
+	}
+	version := "(devel)"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, m := range append([]*debug.Module{&info.Main}, info.Deps...) {
+			if m.Path == "github.com/oarkflow/bcl" {
+				version = m.Version
+			}
+		}
+	}
+	astCacheFormat = "bcl-ast-" + version + "-" + hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// writeTypeShape writes the exported fields of t, recursively, as gob
+// sees them.
+func writeTypeShape(w io.Writer, t reflect.Type, seen map[reflect.Type]bool) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+		if t.Kind() == reflect.Map {
+			writeTypeShape(w, t.Key(), seen)
+		}
+		fmt.Fprint(w, t.Kind(), ";")
+		t = t.Elem()
+	}
+	fmt.Fprint(w, t.String(), ";")
+	if t.Kind() != reflect.Struct || seen[t] {
+		return
+	}
+	seen[t] = true
+	for i := range t.NumField() {
+		if f := t.Field(i); f.IsExported() {
+			fmt.Fprint(w, f.Name, ":")
+			writeTypeShape(w, f.Type, seen)
+		}
+	}
+}
+
+// ASTCache keeps parsed documents on disk keyed by a hash of their name and
+// source, so CLI invocations and other short-lived processes skip parsing
+// files that did not change. Each hit refreshes the entry; entries unused
+// for MaxAge are evicted, then the least recently used ones while the
+// cache is larger than MaxBytes. A corrupt or unreadable entry is treated
+// as a miss.
+type ASTCache struct {
+	Dir string
+	// MaxAge defaults to 7 days and MaxBytes to 64 MiB.
+	MaxAge   time.Duration
+	MaxBytes int64
+}
+
+// NewASTCache returns a cache in dir, or in the user cache directory when
+// dir is empty.
+func NewASTCache(dir string) *ASTCache {
+	if dir == "" {
+		if base, err := os.UserCacheDir(); err == nil {
+			dir = filepath.Join(base, "bcl", "ast")
+		} else {
+			dir = filepath.Join(os.TempDir(), "bcl-ast")
+		}
+	}
+	return &ASTCache{Dir: dir}
+}
+
+// Parse is ParseFile backed by the cache. Parse errors are not cached.
+func (c *ASTCache) Parse(name string, src []byte) (*Document, error) {
+	if c == nil {
+		return ParseFile(name, src)
+	}
+	store := &ResultCache{Dir: c.Dir}
+	key := c.key(name, src)
+	if data, ok := store.Get(key, c.maxAge()); ok {
+		var doc Document
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&doc); err == nil {
+			now := time.Now()
+			_ = os.Chtimes(store.path(key), now, now)
+			return &doc, nil
+		}
+	}
+	doc, err := ParseFile(name, src)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(doc); err == nil && store.Put(key, buf.Bytes()) == nil {
+		c.evictPeriodically()
+	}
+	return doc, nil
+}
+
+// evictPeriodically runs Evict when the last run, recorded in the mtime
+// of a stamp file, is older than astCacheEvictInterval, so a cache miss
+// does not scan the whole directory.
+func (c *ASTCache) evictPeriodically() {
+	stamp := filepath.Join(c.Dir, ".evicted")
+	if info, err := os.Stat(stamp); err == nil && time.Since(info.ModTime()) < astCacheEvictInterval {
+		return
+	}
+	if err := os.WriteFile(stamp, nil, 0o644); err == nil {
+		_ = c.Evict()
+	}
+}
+
+// ParsePath is ParsePath backed by the cache.
+func (c *ASTCache) ParsePath(path string) (*Document, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if IsEncrypted(b) {
+		return nil, fmt.Errorf("%s is encrypted; decrypt it with a key provider first", path)
+	}
+	return c.Parse(path, b)
+}
+
+// Evict removes the entries unused for MaxAge, then the least recently
+// used ones until the cache fits in MaxBytes.
+func (c *ASTCache) Evict() error {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	type entry struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var live []entry
+	var total int64
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(c.Dir, e.Name())
+		if time.Since(info.ModTime()) >= c.maxAge() {
+			_ = os.Remove(path)
+			continue
+		}
+		live = append(live, entry{path: path, size: info.Size(), used: info.ModTime()})
+		total += info.Size()
+	}
+	slices.SortFunc(live, func(a, b entry) int { return a.used.Compare(b.used) })
+	for _, e := range live {
+		if total <= c.maxBytes() {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= e.size
+	}
+	return nil
+}
+
+func (c *ASTCache) key(name string, src []byte) string {
+	h := sha256.New()
+	h.Write([]byte(astCacheFormat + "\x00" + name + "\x00"))
+	h.Write(src)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *ASTCache) maxAge() time.Duration {
+	if c.MaxAge > 0 {
+		return c.MaxAge
+	}
+	return 7 * 24 * time.Hour
+}
+
+func (c *ASTCache) maxBytes() int64 {
+	if c.MaxBytes > 0 {
+		return c.MaxBytes
+	}
+	return 64 << 20
+}
@@ -0,0 +1,96 @@
+package bcl
+
+import (
+	"sync"
+	"time"
+)
+
+// AuditEvent records one side effect performed while loading a config:
+// an env read, env file load, import or module read, file or HTTP fetch.
+type AuditEvent struct {
+	Kind   string    `json:"kind"`
+	Target string    `json:"target"`
+	Args   []any     `json:"args,omitempty"`
+	Result string    `json:"result,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Span   Span      `json:"span,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// AuditLog collects AuditEvents. Pass it as Options.Audit and read Events
+// after Compile or Unmarshal returns. Env values are recorded as "****"
+// unless IncludeValues is set.
+type AuditLog struct {
+	IncludeValues bool
+
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (l *AuditLog) Record(ev AuditEvent) {
+	if l == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	l.mu.Lock()
+	l.events = append(l.events, ev)
+	l.mu.Unlock()
+}
+
+func (l *AuditLog) Events() []AuditEvent {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]AuditEvent(nil), l.events...)
+}
+
+func (l *AuditLog) Reset() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.events = nil
+	l.mu.Unlock()
+}
+
+// EnvValues returns the env keys read during loading with the values they
+// resolved to, for storing alongside whatever the config produced. Unset
+// keys are omitted; values are "****" unless IncludeValues is set.
+func (l *AuditLog) EnvValues() map[string]string {
+	out := map[string]string{}
+	for _, ev := range l.Events() {
+		if ev.Kind == "env" && ev.Result != "unset" {
+			out[ev.Target] = ev.Result
+		}
+	}
+	return out
+}
+
+func (l *AuditLog) recordEnv(key, value string, found bool, sp Span) {
+	if l == nil {
+		return
+	}
+	result := "unset"
+	if found {
+		result = "****"
+		if l.IncludeValues {
+			result = value
+		}
+	}
+	l.Record(AuditEvent{Kind: "env", Target: key, Result: result, Span: sp})
+}
+
+func (l *AuditLog) recordErr(kind, target string, err error, sp Span) {
+	if l == nil {
+		return
+	}
+	ev := AuditEvent{Kind: kind, Target: target, Result: "ok", Span: sp}
+	if err != nil {
+		ev.Result, ev.Error = "", err.Error()
+	}
+	l.Record(ev)
+}
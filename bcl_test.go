@@ -2,10 +2,20 @@ package bcl
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func TestCompileGenericDocument(t *testing.T) {
@@ -334,19 +344,20 @@ func TestFormatAndDecode(t *testing.T) {
 roles { admin
 superadmin
 }
+"10.0.0.1" "db"
 `)
 	out, err := Format(src)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !bytes.Contains(out, []byte("roles [admin, superadmin]")) {
+	if !bytes.Contains(out, []byte("roles [admin, superadmin]")) || !bytes.Contains(out, []byte(`"10.0.0.1" "db"`)) {
 		t.Fatalf("formatted output: %s", out)
 	}
 	var dst map[string]any
 	if err := Unmarshal(out, &dst); err != nil {
 		t.Fatal(err)
 	}
-	if dst["name"] != "x" {
+	if dst["name"] != "x" || dst["10.0.0.1"] != "db" {
 		t.Fatalf("decode = %#v", dst)
 	}
 }
@@ -590,6 +601,109 @@ tables "users" {
 	}
 }
 
+func TestDeviceRolesMergeIntoDevices(t *testing.T) {
+	n, err := CompileBytes([]byte(`
+network "campus" {
+  role "access-switch" {
+    vendor = "arista"
+    vlans = [10, 20]
+    snmp = { community = "public", version = 2 }
+  }
+  device sw1 {
+    role = "access-switch"
+    snmp = { community = "private" }
+  }
+  device sw2 {
+    role = "access-switch"
+    vlans = [30]
+  }
+  device fw1 {
+    role = "firewall"
+  }
+}
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := findBlock(n.Blocks, "network", "campus")["body"].(map[string]any)
+	devices := map[string]map[string]any{}
+	for _, d := range body["device"].([]any) {
+		d := d.(map[string]any)
+		devices[d["id"].(string)] = d["body"].(map[string]any)
+	}
+	sw1, sw2, fw1 := devices["sw1"], devices["sw2"], devices["fw1"]
+	snmp := sw1["snmp"].(map[string]any)
+	if sw1["vendor"] != "arista" || snmp["community"] != "private" || snmp["version"] != int64(2) || len(sw1["vlans"].([]any)) != 2 {
+		t.Fatalf("sw1 = %#v", sw1)
+	}
+	if len(sw2["vlans"].([]any)) != 1 || sw2["vendor"] != "arista" {
+		t.Fatalf("sw2 = %#v", sw2)
+	}
+	if len(fw1) != 1 || fw1["role"] != "firewall" {
+		t.Fatalf("fw1 = %#v", fw1)
+	}
+}
+
+func TestGraphEdgesTypedProperties(t *testing.T) {
+	n, err := CompileBytes([]byte(`
+pipeline "orders" {
+  entrypoint "plan"
+  step "plan" {}
+  step "risk" {}
+  step "ship" {}
+  connection "plan-to-risk" {
+    from step.plan
+    to step.risk
+    on "done"
+    weight 2.5
+    latency 15ms
+  }
+  connection "plan-to-ship" {
+    from "plan"
+    to "ship"
+    latency 200ms
+  }
+  connection "skipped" {
+    from "risk"
+    to "ship"
+    when = 1 > 2
+  }
+}
+network "n" {
+  connection "uplink" {
+    from device.r1.interfaces.eth0
+    to device.r2.interfaces.eth0
+  }
+}
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edges, err := GraphEdges(n)
+	if err != nil || len(edges) != 3 {
+		t.Fatalf("edges = %#v %v", edges, err)
+	}
+	e := edges[0]
+	if e.Graph != "pipeline.orders" || e.From != "plan" || e.To != "risk" || e.On != "done" || e.Weight != 2.5 || e.Latency != 15*time.Millisecond {
+		t.Fatalf("edge = %#v", e)
+	}
+	if edges[1].Weight != 1 || edges[2].Graph != "network.n" || edges[2].From != "device.r1.interfaces.eth0" {
+		t.Fatalf("edges = %#v", edges)
+	}
+	fast := FilterEdges(edges, func(e GraphEdge) bool { return e.Latency < 100*time.Millisecond })
+	if len(fast) != 2 || fast[0].ID != "plan-to-risk" || fast[1].ID != "uplink" {
+		t.Fatalf("fast = %#v", fast)
+	}
+	bad, _ := CompileBytes([]byte(`pipeline "p" {
+  connection "c" {
+    weight "heavy"
+  }
+}`), nil)
+	if _, err := GraphEdges(bad); err == nil {
+		t.Fatal("expected weight error")
+	}
+}
+
 func findBlock(blocks []map[string]any, typ, id string) map[string]any {
 	for _, b := range blocks {
 		if b["type"] == typ && b["id"] == id {
@@ -817,3 +931,810 @@ predicate_suite {
 		t.Fatalf("unmarshal singular case blocks into plural Cases failed: %#v", out)
 	}
 }
+
+func TestUnmarshalMergesOntoPopulatedStruct(t *testing.T) {
+	type Server struct {
+		Name string `bcl:",id"`
+		Port int    `bcl:"port"`
+	}
+	type DB struct {
+		Host string `bcl:"host"`
+		Pool int    `bcl:"pool"`
+	}
+	type Config struct {
+		Name    string            `bcl:"name"`
+		Workers int               `bcl:"workers"`
+		Tags    []string          `bcl:"tags"`
+		DB      DB                `bcl:"db"`
+		Limits  map[string]DB     `bcl:"limits"`
+		Labels  map[string]string `bcl:"labels"`
+		Servers []Server          `bcl:"server,block"`
+	}
+	defaults := func() Config {
+		return Config{
+			Name:    "api",
+			Workers: 4,
+			Tags:    []string{"base"},
+			DB:      DB{Host: "localhost", Pool: 5},
+			Limits:  map[string]DB{"primary": {Host: "p", Pool: 1}},
+			Labels:  map[string]string{"team": "core"},
+			Servers: []Server{{Name: "web", Port: 80}},
+		}
+	}
+	src := []byte(`
+workers 8
+tags ["extra"]
+db {
+  pool 20
+}
+limits {
+  primary {
+    pool 9
+  }
+}
+labels {
+  env "prod"
+}
+`)
+	cfg := defaults()
+	if err := Unmarshal(src, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "api" || cfg.Workers != 8 || cfg.DB != (DB{Host: "localhost", Pool: 20}) {
+		t.Fatalf("scalars/structs not merged: %#v", cfg)
+	}
+	if cfg.Limits["primary"] != (DB{Host: "p", Pool: 9}) || cfg.Labels["team"] != "core" || cfg.Labels["env"] != "prod" {
+		t.Fatalf("maps not merged: %#v", cfg)
+	}
+	if len(cfg.Servers) != 1 || cfg.Servers[0].Port != 80 {
+		t.Fatalf("absent blocks should keep defaults: %#v", cfg.Servers)
+	}
+	if strings.Join(cfg.Tags, ",") != "extra" {
+		t.Fatalf("ClearSlices should replace: %#v", cfg.Tags)
+	}
+	cfg = defaults()
+	if err := UnmarshalWithOptions(src, &cfg, &Options{SliceMerge: AppendSlices}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(cfg.Tags, ",") != "base,extra" {
+		t.Fatalf("AppendSlices should append: %#v", cfg.Tags)
+	}
+}
+
+func TestUnmarshalFallsBackToEnvAndEnvDefault(t *testing.T) {
+	type DB struct {
+		Host string `bcl:"host" envDefault:"localhost"`
+		Port int    `bcl:"port" envDefault:"5432"`
+	}
+	type Config struct {
+		Name    string        `bcl:"name" env:"SERVICE_NAME"`
+		Debug   bool          `bcl:"debug"`
+		Hosts   []string      `bcl:"hosts"`
+		Timeout time.Duration `bcl:"timeout" envDefault:"3s"`
+		DB      DB            `bcl:"db"`
+	}
+	env := map[string]string{
+		"SERVICE_NAME": "billing",
+		"APP_DEBUG":    "true",
+		"APP_HOSTS":    "a, b",
+		"APP_DB_PORT":  "6543",
+	}
+	lookup := func(k string) (string, bool) { v, ok := env[k]; return v, ok }
+	var cfg Config
+	if err := UnmarshalWithOptions([]byte("db {\n  host \"db.internal\"\n}\n"), &cfg, &Options{Env: lookup, EnvPrefix: "APP"}); err != nil {
+		t.Fatal(err)
+	}
+	want := Config{Name: "billing", Debug: true, Hosts: []string{"a", "b"}, Timeout: 3 * time.Second, DB: DB{Host: "db.internal", Port: 6543}}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("cfg = %#v", cfg)
+	}
+	var fromDoc Config
+	if err := UnmarshalWithOptions([]byte("name \"doc\"\n"), &fromDoc, &Options{Env: lookup, EnvFallback: true}); err != nil {
+		t.Fatal(err)
+	}
+	if fromDoc.Name != "doc" || fromDoc.Debug || fromDoc.DB.Port != 5432 {
+		t.Fatalf("document keys must win and prefix lookups need EnvPrefix: %#v", fromDoc)
+	}
+	audit := &AuditLog{IncludeValues: true}
+	var limited Config
+	if err := UnmarshalWithOptions(nil, &limited, &Options{Env: lookup, EnvPrefix: "APP", AllowedEnvKeys: []string{"APP_DB_*", "SERVICE_NAME"}, Audit: audit}); err != nil {
+		t.Fatal(err)
+	}
+	if limited.Name != "billing" || limited.Debug || limited.DB.Port != 6543 {
+		t.Fatalf("AllowedEnvKeys not applied to fallbacks: %#v", limited)
+	}
+	if got := audit.EnvValues(); got["SERVICE_NAME"] != "billing" || got["APP_DB_PORT"] != "6543" || len(got) != 2 {
+		t.Fatalf("audited env = %#v", got)
+	}
+	env["APP_DB_PORT"] = "not-a-port"
+	if err := UnmarshalWithOptions(nil, &cfg, &Options{Env: lookup, EnvPrefix: "APP"}); err == nil || !strings.Contains(err.Error(), "APP_DB_PORT") {
+		t.Fatalf("expected parse error naming the variable, got %v", err)
+	}
+	t.Setenv("SVC_DB_HOST", "from-env")
+	var bare Config
+	if err := UnmarshalEnv(&bare, "SVC"); err != nil {
+		t.Fatal(err)
+	}
+	if bare.DB.Host != "from-env" || bare.DB.Port != 5432 {
+		t.Fatalf("UnmarshalEnv = %#v", bare)
+	}
+}
+
+func TestParserResetReusesBuffers(t *testing.T) {
+	p := NewParser("name \"first\"\nport 8080\n")
+	p.File = "rules.bcl"
+	first, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Reset("name \"second\"\n")
+	second, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Items) != 2 || first.Items[0].(*Assignment).Value.(*Literal).Data != "first" {
+		t.Fatalf("first document changed after reuse: %#v", first.Items)
+	}
+	if len(second.Items) != 1 || second.Items[0].(*Assignment).Value.(*Literal).Data != "second" || second.File != "rules.bcl" {
+		t.Fatalf("second = %#v", second)
+	}
+	p.Reset("name \"unterminated\n")
+	if _, err := p.Parse(); err == nil || !strings.Contains(err.Error(), "rules.bcl") {
+		t.Fatalf("expected parse error naming the file, got %v", err)
+	}
+	p.Reset("ok true\n")
+	if doc, err := p.Parse(); err != nil || len(doc.Items) != 1 {
+		t.Fatalf("parse after error: %#v, %v", doc, err)
+	}
+}
+
+func TestParseInternsKeys(t *testing.T) {
+	a, err := Parse([]byte("host \"a\"\n\"port\" 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Parse([]byte("server web {\n  host \"b\"\n  \"port\" 2\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := b.Items[0].(*Block).Body
+	for i, name := range []string{"host", "port"} {
+		x := a.Items[i].(*Assignment).Name
+		y := inner[i].(*Assignment).Name
+		if x != name || y != name || unsafe.StringData(x) != unsafe.StringData(y) {
+			t.Fatalf("key %q not shared: %q %q", name, x, y)
+		}
+	}
+}
+
+func TestParserArenaAndStats(t *testing.T) {
+	src := "name \"api\"\nport 8080\nserver web {\n  host \"a\"\n  tags [\"x\", \"y\"]\n}\n"
+	heap, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewParser(src)
+	p.Arena = true
+	arena, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Reset("other 1\n")
+	if _, err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := json.Marshal(heap)
+	b, _ := json.Marshal(arena)
+	if string(a) != string(b) {
+		t.Fatalf("arena document differs:\n%s\n%s", a, b)
+	}
+	st := arena.Stats()
+	if st.ByKind["assignment"] != 4 || st.ByKind["block"] != 1 || st.ByKind["literal"] != 5 || st.ByKind["list"] != 1 {
+		t.Fatalf("stats = %+v", st)
+	}
+	if st.Nodes != 11 || st.Bytes <= 0 || st.Bytes != heap.Stats().Bytes {
+		t.Fatalf("stats = %+v", st)
+	}
+}
+
+func TestValidateStructNestedRules(t *testing.T) {
+	type TLS struct {
+		Cert string `bcl:"cert" validate:"required"`
+	}
+	type Listener struct {
+		Host string `bcl:"host" validate:"required,hostname"`
+		Port int    `bcl:"port" validate:"min=1,max=65535"`
+		TLS  *TLS   `bcl:"tls"`
+	}
+	type Config struct {
+		Mode      string              `bcl:"mode" validate:"oneof=fast safe"`
+		Listeners []Listener          `bcl:"listener,block" validate:"min=1"`
+		Upstreams map[string]Listener `bcl:"upstreams"`
+		Timeout   time.Duration       `bcl:"timeout"`
+	}
+	good := Config{Mode: "safe", Listeners: []Listener{{Host: "api.local", Port: 443, TLS: &TLS{Cert: "c.pem"}}}}
+	if diags := ValidateStruct(&good); len(diags) != 0 {
+		t.Fatalf("valid config diagnostics = %v", diags)
+	}
+	bad := Config{
+		Mode:      "turbo",
+		Listeners: []Listener{{Host: "ok.local", Port: 80}, {Port: 70000, TLS: &TLS{}}},
+		Upstreams: map[string]Listener{"db": {Host: "-bad-", Port: 5432}},
+	}
+	text := FormatDiagnostics(ValidateStruct(bad))
+	for _, want := range []string{
+		`"mode" must be one of fast, safe`,
+		`"listener[1].host" is required`,
+		`"listener[1].port" must be <= 65535`,
+		`"listener[1].tls.cert" is required`,
+		`"upstreams[db].host" does not match format hostname`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("missing %q in:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "listener[0]") {
+		t.Fatalf("valid listener reported:\n%s", text)
+	}
+	if diags := ValidateStruct(Config{Mode: "fast"}); len(diags) != 0 {
+		t.Fatalf("zero optional fields should pass: %v", diags)
+	}
+}
+
+func TestUnmarshalOnlyAndExclude(t *testing.T) {
+	src := []byte(`
+server {
+  host "0.0.0.0"
+  port 8080
+}
+database {
+  url "postgres://db"
+  pool { size 4 }
+}
+internal {
+  token "x"
+  debug true
+}
+metrics { enabled true }
+worker jobs { concurrency 2 }
+worker mail { concurrency 1 }
+`)
+	var cfg struct {
+		Server   map[string]any `bcl:"server"`
+		Database map[string]any `bcl:"database"`
+		Internal map[string]any `bcl:"internal"`
+		Metrics  map[string]any `bcl:"metrics"`
+	}
+	if err := UnmarshalWithOptions(src, &cfg, &Options{Only: []string{"server", "database.pool"}}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server["port"] != int64(8080) || cfg.Database["url"] != nil || cfg.Database["pool"] == nil || cfg.Internal != nil || cfg.Metrics != nil {
+		t.Fatalf("only = %+v", cfg)
+	}
+	n, err := CompileBytes(src, &Options{Exclude: []string{"internal.*", "worker.mail"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.Body["internal"]; ok || n.Body["metrics"] == nil {
+		t.Fatalf("exclude body = %#v", n.Body)
+	}
+	if len(n.Blocks) != 1 || n.Blocks[0]["id"] != "jobs" {
+		t.Fatalf("exclude blocks = %#v", n.Blocks)
+	}
+	n, err = CompileBytes(src, &Options{Only: []string{"worker"}, Exclude: []string{"*.token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.Body) != 0 || len(n.Blocks) != 2 {
+		t.Fatalf("only blocks = %#v %#v", n.Body, n.Blocks)
+	}
+}
+
+func TestUnmarshalDisallowUnknownFields(t *testing.T) {
+	type worker struct {
+		Name        string `bcl:",id"`
+		Concurrency int    `bcl:"concurrency"`
+	}
+	type Base struct {
+		Debug bool `bcl:"debug"`
+	}
+	type config struct {
+		Base    `bcl:",inline"`
+		Server  struct{ Port int } `bcl:"server"`
+		Workers []worker           `bcl:"workers,block"`
+	}
+	strict := &Options{DisallowUnknownFields: true}
+	var cfg config
+	if err := UnmarshalWithOptions([]byte("debug true\nserver { port 80 }\nworker jobs { concurrency 2 }\n"), &cfg, strict); err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Debug || cfg.Server.Port != 80 || len(cfg.Workers) != 1 || cfg.Workers[0].Concurrency != 2 {
+		t.Fatalf("cfg = %+v", cfg)
+	}
+	for src, want := range map[string]string{
+		"debgu true\n":                     `unknown field "debgu"`,
+		"server { port 80\nhots \"x\" }\n": `unknown field "server.hots"`,
+		"worker jobs { concurency 2 }\n":   `unknown field "workers.concurency"`,
+		"cron nightly { at \"02:00\" }\n":  `unknown block "cron"`,
+	} {
+		var cfg config
+		err := UnmarshalWithOptions([]byte(src), &cfg, strict)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%q: err = %v, want %s", src, err, want)
+		}
+		if err := UnmarshalWithOptions([]byte(src), &cfg, nil); err != nil {
+			t.Fatalf("%q without DisallowUnknownFields: %v", src, err)
+		}
+	}
+}
+
+func TestUnmarshalTyped(t *testing.T) {
+	type config struct {
+		Name string `bcl:"name"`
+		Port int    `bcl:"port"`
+	}
+	cfg, nodes, err := UnmarshalTyped[config]([]byte("name \"api\"\nport 8080\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "api" || cfg.Port != 8080 || len(nodes) != 2 {
+		t.Fatalf("cfg = %+v, nodes = %d", cfg, len(nodes))
+	}
+	ptr, _, err := UnmarshalTyped[*config]([]byte("port 1\n"))
+	if err != nil || ptr == nil || ptr.Port != 1 {
+		t.Fatalf("pointer target = %+v, %v", ptr, err)
+	}
+	if _, _, err := UnmarshalTyped[config]([]byte("name \"api\n")); err == nil {
+		t.Fatal("expected parse error")
+	}
+}
+
+func TestUnmarshalNormalizers(t *testing.T) {
+	src := []byte(`
+Name "  api  "
+tls {
+  Cert "certs/api.pem"
+  key "/etc/ssl/api.key"
+}
+cache "~/cache"
+`)
+	var cfg struct {
+		Name string `bcl:"name"`
+		TLS  struct {
+			Cert string `bcl:"cert"`
+			Key  string `bcl:"key"`
+		} `bcl:"tls"`
+		Cache string `bcl:"cache"`
+	}
+	opts := &Options{
+		BaseDir:     "/srv/app",
+		Normalizers: []Normalizer{LowercaseKeys, TrimStrings, ExpandHome, ResolvePaths("tls.*")},
+	}
+	if err := UnmarshalWithOptions(src, &cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	home, _ := os.UserHomeDir()
+	if cfg.Name != "api" || cfg.TLS.Cert != "/srv/app/certs/api.pem" || cfg.TLS.Key != "/etc/ssl/api.key" || cfg.Cache != filepath.Join(home, "cache") {
+		t.Fatalf("cfg = %+v", cfg)
+	}
+	failing := func(map[string]any, string) (map[string]any, error) { return nil, errors.New("boom") }
+	if err := UnmarshalWithOptions(src, &cfg, &Options{Normalizers: []Normalizer{failing}}); err == nil || err.Error() != "boom" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestDurationFieldsRoundTrip(t *testing.T) {
+	type config struct {
+		Timeout time.Duration   `bcl:"timeout"`
+		TTL     time.Duration   `bcl:"ttl"`
+		Retries []time.Duration `bcl:"retries"`
+		Grace   *time.Duration  `bcl:"grace"`
+		Nanos   time.Duration   `bcl:"nanos"`
+	}
+	var cfg config
+	if err := Unmarshal([]byte("timeout = 5s\nttl \"1h30m\"\nretries [100ms, 1.5s]\ngrace 2m\nnanos 250\n"), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Timeout != 5*time.Second || cfg.TTL != 90*time.Minute || !reflect.DeepEqual(cfg.Retries, []time.Duration{100 * time.Millisecond, 1500 * time.Millisecond}) || cfg.Grace == nil || *cfg.Grace != 2*time.Minute || cfg.Nanos != 250 {
+		t.Fatalf("cfg = %+v", cfg)
+	}
+	for _, d := range []time.Duration{0, 90 * time.Minute, 3 * time.Microsecond, -time.Second} {
+		data, err := Marshal(config{Timeout: d})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back config
+		if err := Unmarshal(data, &back); err != nil || back.Timeout != d {
+			t.Fatalf("%v: %s decoded to %v, %v", d, data, back.Timeout, err)
+		}
+	}
+	if err := Unmarshal([]byte("ttl \"soon\"\n"), &cfg); err == nil || !strings.Contains(err.Error(), "invalid duration") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+type testColor struct{ R, G, B uint8 }
+
+func (c testColor) MarshalText() ([]byte, error) {
+	return fmt.Appendf(nil, "#%02x%02x%02x", c.R, c.G, c.B), nil
+}
+
+func (c *testColor) UnmarshalText(b []byte) error {
+	_, err := fmt.Sscanf(string(b), "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	return err
+}
+
+// testPort has only a pointer MarshalText, which map values cannot call
+// directly.
+type testPort int
+
+func (p *testPort) MarshalText() ([]byte, error) { return fmt.Appendf(nil, "port-%d", *p), nil }
+
+func (p *testPort) UnmarshalText(b []byte) error {
+	_, err := fmt.Sscanf(string(b), "port-%d", (*int)(p))
+	return err
+}
+
+type testLevel int
+
+func (l testLevel) String() string { return [...]string{"debug", "info", "warn"}[l] }
+
+type testName string
+
+func TestMarshalNamedAndTextTypes(t *testing.T) {
+	type config struct {
+		Name    testName               `bcl:"name"`
+		Level   testLevel              `bcl:"level"`
+		Accent  testColor              `bcl:"accent"`
+		Border  *testColor             `bcl:"border"`
+		Palette []testColor            `bcl:"palette"`
+		ByColor map[testColor]string   `bcl:"by_color"`
+		Ports   map[string]testPort    `bcl:"ports"`
+		Levels  map[testLevel]testName `bcl:"levels"`
+		Codes   map[int]bool           `bcl:"codes"`
+		Hosts   map[netip.Addr]string  `bcl:"hosts"`
+		Routes  map[string]int         `bcl:"routes"`
+	}
+	want := config{
+		Name:    "api",
+		Level:   1,
+		Accent:  testColor{1, 2, 3},
+		Border:  &testColor{255, 0, 16},
+		Palette: []testColor{{1, 1, 1}, {2, 2, 2}},
+		ByColor: map[testColor]string{{3, 3, 3}: "grey", {0, 0, 0}: "black"},
+		Ports:   map[string]testPort{"http": 80},
+		Levels:  map[testLevel]testName{0: "verbose", 2: "loud"},
+		Codes:   map[int]bool{404: true, -1: false},
+		Hosts:   map[netip.Addr]string{netip.MustParseAddr("10.0.0.1"): "db", netip.MustParseAddr("::1"): "local"},
+		Routes:  map[string]int{"api.v1": 1, "api": 2},
+	}
+	data, err := Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{`accent "#010203"`, `palette ["#010101", "#020202"]`, `"#030303" "grey"`, `http "port-80"`, `"2" "loud"`, "level 1", `"10.0.0.1" "db"`, `"api.v1" 1`} {
+		if !strings.Contains(string(data), line) {
+			t.Fatalf("missing %q in:\n%s", line, data)
+		}
+	}
+	var got config
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatalf("%v\n%s", err, data)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v\n%s", got, want, data)
+	}
+	var bad struct {
+		Codes map[int]bool `bcl:"codes"`
+	}
+	if err := Unmarshal([]byte("codes { x true }\n"), &bad); err == nil || !strings.Contains(err.Error(), `map key "x"`) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestUnmarshalDecodeHooks(t *testing.T) {
+	src := []byte(`
+endpoint "https://api.example.com/v1"
+subnet "10.0.0.0/24"
+mirrors ["https://a.example.com", "https://b.example.com"]
+`)
+	var cfg struct {
+		Endpoint url.URL    `bcl:"endpoint"`
+		Subnet   *net.IPNet `bcl:"subnet"`
+		Mirrors  []*url.URL `bcl:"mirrors"`
+		Backup   *url.URL   `bcl:"backup" env:"BACKUP_URL"`
+	}
+	opts := &Options{Env: func(key string) (string, bool) {
+		return "https://backup.example.com", key == "BACKUP_URL"
+	}, EnvFallback: true}
+	AddDecodeHook(opts, func(src any) (*url.URL, error) { return url.Parse(fmt.Sprint(src)) })
+	AddDecodeHook(opts, func(src any) (*net.IPNet, error) {
+		_, n, err := net.ParseCIDR(fmt.Sprint(src))
+		return n, err
+	})
+	if err := UnmarshalWithOptions(src, &cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Endpoint.Host != "api.example.com" || cfg.Subnet.String() != "10.0.0.0/24" || len(cfg.Mirrors) != 2 || cfg.Mirrors[1].Host != "b.example.com" || cfg.Backup == nil || cfg.Backup.Host != "backup.example.com" {
+		t.Fatalf("cfg = %+v", cfg)
+	}
+
+	rt := NewRuntime()
+	rt.RegisterDecodeHook(reflect.TypeFor[*net.IPNet](), func(any) (any, error) { return nil, errors.New("not a subnet") })
+	err := UnmarshalWithOptions([]byte("subnet \"x\"\n"), &cfg, rt.Options(nil))
+	if err == nil || err.Error() != `bcl: decode "subnet": not a subnet` {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestPathFunctionAndPathTag(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "tls"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tls", "tls.bcl"), []byte(`
+tls {
+  cert "server.pem"
+  key path("server.key")
+}
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "app.bcl")
+	if err := os.WriteFile(main, []byte(`
+import "./tls/tls.bcl"
+log_file "logs/app.log"
+data_dir path("data")
+roots ["ca.pem", "/etc/ssl/ca.pem"]
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var cfg struct {
+		LogFile string   `bcl:"log_file,path"`
+		DataDir string   `bcl:"data_dir"`
+		Roots   []string `bcl:"roots,path"`
+		TLS     struct {
+			Cert string `bcl:"cert,path"`
+			Key  string `bcl:"key"`
+		} `bcl:"tls"`
+	}
+	if err := DecodeFileWithOptions(main, &cfg, &Options{ResolveImports: true, ResolvePathFields: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"log_file": filepath.Join(dir, "logs", "app.log"),
+		"data_dir": filepath.Join(dir, "data"),
+		"roots[0]": filepath.Join(dir, "ca.pem"),
+		"roots[1]": "/etc/ssl/ca.pem",
+		"tls.cert": filepath.Join(dir, "tls", "server.pem"),
+		"tls.key":  filepath.Join(dir, "tls", "server.key"),
+	}
+	got := map[string]string{"log_file": cfg.LogFile, "data_dir": cfg.DataDir, "tls.cert": cfg.TLS.Cert, "tls.key": cfg.TLS.Key}
+	if len(cfg.Roots) == 2 {
+		got["roots[0]"], got["roots[1]"] = cfg.Roots[0], cfg.Roots[1]
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("paths = %v, want %v", got, want)
+	}
+	cfg.LogFile = ""
+	if err := DecodeFileWithOptions(main, &cfg, &Options{ResolveImports: true}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogFile != "logs/app.log" {
+		t.Fatalf("path tag without ResolvePathFields = %q", cfg.LogFile)
+	}
+}
+
+func TestFormatWithOptions(t *testing.T) {
+	src := []byte("name \"api\"\nreplicas 3\nserver web {\n  port 8080\n}\ntls {\n  enabled true\n}\ntags [\"alpha\", \"beta\", \"gamma\"]\n")
+	out, err := FormatWithOptions(src, &FormatOptions{Equals: true, Align: true, WrapColumn: 20, CompactBlocks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "name     = \"api\"\n\nreplicas = 3\n\nserver web { port = 8080 }\n\ntls { enabled = true }\n\ntags = [\n  \"alpha\",\n  \"beta\",\n  \"gamma\",\n]\n"
+	if string(out) != want {
+		t.Fatalf("styled output:\n%s", out)
+	}
+	canonical, err := Format(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, _ := Format(src); !bytes.Equal(canonical, plain) {
+		t.Fatalf("styled output does not round-trip:\n%s", canonical)
+	}
+}
+
+func TestFormatKeepsComments(t *testing.T) {
+	src := []byte(`# Service settings.
+name "api" # public name
+server web { // edge
+  # Port to bind.
+  port  8080
+  limits = {
+    rps 10 # per client
+    # Burst allowance.
+    burst 20
+  }
+  hosts = [
+    "a", # primary
+    "b",
+  ]
+} # end server
+if replicas > 1 {
+  # Spread replicas.
+  spread true
+} else {
+  spread false /* single */
+}
+/* trailing
+   notes */
+`)
+	want := `# Service settings.
+name "api" # public name
+
+server web { // edge
+  # Port to bind.
+  port 8080
+
+  limits {
+    # Burst allowance.
+    burst 20
+
+    rps 10 # per client
+  }
+
+  hosts ["a", "b"] # primary
+} # end server
+
+if replicas > 1 {
+  # Spread replicas.
+  spread true
+} else {
+  spread false /* single */
+}
+
+/* trailing
+   notes */
+`
+	out, err := Format(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != want {
+		t.Fatalf("formatted:\n%s", out)
+	}
+	if again, _ := Format(out); !bytes.Equal(again, out) {
+		t.Fatalf("not stable:\n%s", again)
+	}
+	// Edit the tree and write it back.
+	doc, err := ParseFileWithComments("svc.bcl", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := doc.Items[3].(*Block)
+	server.Body = append(server.Body, &Assignment{Name: "tls", Value: &Literal{Type: "bool", Data: true}})
+	out, err = FormatDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "  hosts [\"a\", \"b\"] # primary\n\n  tls true\n} # end server\n") {
+		t.Fatalf("edited:\n%s", out)
+	}
+	n, err := Compile(doc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["name"] != "api" || len(n.Blocks) != 1 {
+		t.Fatalf("compiled = %#v", n)
+	}
+}
+
+func TestMarshalJSONCanonical(t *testing.T) {
+	n, err := CompileBytes([]byte("zeta 1\nalpha {\n  ratio 2.0\n  half 0.5\n  tags [\"<b>\", \"a\"]\n}\nmid null\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := MarshalJSONCanonical(n.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"alpha":{"half":0.5,"ratio":2,"tags":["<b>","a"]},"mid":null,"zeta":1}`; string(out) != want {
+		t.Fatalf("canonical = %s", out)
+	}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	out, err = MarshalJSONCanonical(map[string]any{"at": at, "every": 90 * time.Second, "big": 1e300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"at":"2024-05-01T10:00:00Z","big":1e+300,"every":"1m30s"}`; string(out) != want {
+		t.Fatalf("canonical = %s", out)
+	}
+	if _, err := MarshalJSONCanonical(n); err != nil {
+		t.Fatalf("normalized: %v", err)
+	}
+	for path, bad := range map[string]any{
+		"server.weights[1]": map[string]any{"server": map[string]any{"weights": []any{1.0, math.NaN()}}},
+		"server":            map[string]any{"server": map[int]string{1: "x"}},
+		"hook":              map[string]any{"hook": func() {}},
+	} {
+		if _, err := MarshalJSONCanonical(bad); err == nil || !strings.Contains(err.Error(), ": "+path+": ") {
+			t.Fatalf("error for %s = %v", path, err)
+		}
+	}
+}
+
+func TestCSVDecode(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "seed"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "seed", "devices.csv"), []byte("id,site,ip\nr1,ams,10.0.0.1\nr2,\"fra, hall 2\",10.0.0.2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src := []byte(`
+devices csvdecode("seed/devices.csv")
+pairs tsvdecode("a\t1\nb\t2\n", false)
+`)
+	n, err := CompileBytes(src, &Options{BaseDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []any{
+		map[string]any{"id": "r1", "site": "ams", "ip": "10.0.0.1"},
+		map[string]any{"id": "r2", "site": "fra, hall 2", "ip": "10.0.0.2"},
+	}
+	if !reflect.DeepEqual(n.Body["devices"], want) {
+		t.Fatalf("devices = %#v", n.Body["devices"])
+	}
+	if !reflect.DeepEqual(n.Body["pairs"], []any{[]any{"a", "1"}, []any{"b", "2"}}) {
+		t.Fatalf("pairs = %#v", n.Body["pairs"])
+	}
+	if _, err := CompileBytes([]byte(`rows csvdecode("missing.csv")`), &Options{BaseDir: dir}); err == nil || !strings.Contains(err.Error(), "csvdecode") {
+		t.Fatalf("missing file error = %v", err)
+	}
+}
+
+func TestUnmarshalMapBlockLists(t *testing.T) {
+	src := []byte(`
+name "edge"
+server "web" {
+  port 80
+  route "/" {
+    upstream "app"
+  }
+}
+server "api" {
+  port 81
+}
+logging {
+  level "info"
+}
+`)
+	var dst map[string]any
+	if err := Unmarshal(src, &dst); err != nil {
+		t.Fatal(err)
+	}
+	servers, ok := dst["server"].([]any)
+	if !ok || len(servers) != 2 {
+		t.Fatalf("servers = %#v", dst)
+	}
+	web := servers[0].(map[string]any)
+	routes := web["route"].([]any)
+	if web["name"] != "web" || web["port"] != int64(80) || routes[0].(map[string]any)["name"] != "/" || servers[1].(map[string]any)["name"] != "api" {
+		t.Fatalf("servers = %#v", servers)
+	}
+	if logging, ok := dst["logging"].(map[string]any); !ok || logging["level"] != "info" {
+		t.Fatalf("logging = %#v", dst["logging"])
+	}
+	if _, ok := dst["$blocks"]; ok {
+		t.Fatal("$blocks leaked into list mode")
+	}
+
+	var legacy map[string]any
+	if err := UnmarshalWithOptions(src, &legacy, &Options{BlockShape: LegacyBlocks}); err != nil {
+		t.Fatal(err)
+	}
+	if blocks, ok := legacy["$blocks"].([]map[string]any); !ok || len(blocks) != 2 || blocks[0]["id"] != "web" {
+		t.Fatalf("legacy = %#v", legacy)
+	}
+}
@@ -154,6 +154,33 @@ func BenchmarkParseSmall(b *testing.B) {
 	}
 }
 
+func BenchmarkParserReuseSmall(b *testing.B) {
+	src := string(benchSmall)
+	p := NewParser(src)
+	for b.Loop() {
+		p.Reset(src)
+		doc, err := p.Parse()
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = doc
+	}
+}
+
+func BenchmarkParserArenaPolicy(b *testing.B) {
+	src := string(benchPolicy)
+	p := NewParser(src)
+	p.Arena = true
+	for b.Loop() {
+		p.Reset(src)
+		doc, err := p.Parse()
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = doc
+	}
+}
+
 func BenchmarkParsePolicy(b *testing.B) {
 	for b.Loop() {
 		doc, err := Parse(benchPolicy)
@@ -254,6 +281,18 @@ func BenchmarkEvalExpression(b *testing.B) {
 	}
 }
 
+func BenchmarkEvalArithmetic(b *testing.B) {
+	const expr = `(usage.count + 5) * 2 - usage.limit / 4 + 1.5 > usage.count % 3`
+	vars := map[string]any{"usage": map[string]any{"count": int64(40), "limit": 100.0}}
+	for b.Loop() {
+		v, err := Eval(expr, vars)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = v
+	}
+}
+
 func BenchmarkEvalCondition(b *testing.B) {
 	doc, err := Parse(benchPolicy)
 	if err != nil {
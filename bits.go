@@ -0,0 +1,186 @@
+package bcl
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Bit helpers work on declared-width integers: every operand is checked to
+// fit in width bits (8, 16, 32 or 64; 64 when omitted) and every result is
+// masked to it. Values travel as int64 bit patterns, so a 64-bit result
+// with the top bit set is negative but keeps all its bits; floats are only
+// accepted up to 2^53, where they are still exact, and larger operands
+// should be written as integers or strings such as "0xffff0000ffff0000".
+
+func evalBits(name string, args []any) (any, error) {
+	arity := 2
+	switch name {
+	case "bit_not":
+		arity = 1
+	}
+	if len(args) != arity && len(args) != arity+1 {
+		return nil, fmt.Errorf("%s requires %d or %d arguments", name, arity, arity+1)
+	}
+	width, err := bitsWidth(name, args, arity)
+	if err != nil {
+		return nil, err
+	}
+	a, err := bitsValue(name, args[0], width)
+	if err != nil {
+		return nil, err
+	}
+	if name == "bit_not" {
+		return bitsResult(^a, width), nil
+	}
+	if name == "bit_shl" || name == "bit_shr" {
+		n, err := toInt(args[1])
+		if err != nil || n < 0 || uint(n) > width {
+			return nil, fmt.Errorf("%s: shift %v is not between 0 and %d", name, args[1], width)
+		}
+		if name == "bit_shl" {
+			return bitsResult(a<<uint(n), width), nil
+		}
+		return bitsResult(a>>uint(n), width), nil
+	}
+	b, err := bitsValue(name, args[1], width)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "bit_and":
+		return bitsResult(a&b, width), nil
+	case "bit_or":
+		return bitsResult(a|b, width), nil
+	default:
+		return bitsResult(a^b, width), nil
+	}
+}
+
+// evalHex formats an integer as lowercase hex digits, zero-padded to
+// width/4 digits when a width is given.
+func evalHex(args []any) (any, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, fmt.Errorf("hex requires 1 or 2 arguments")
+	}
+	width, err := bitsWidth("hex", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	v, err := bitsValue("hex", args[0], width)
+	if err != nil {
+		return nil, err
+	}
+	s := strconv.FormatUint(v, 16)
+	if len(args) == 2 {
+		s = strings.Repeat("0", int(width/4)-len(s)) + s
+	}
+	return s, nil
+}
+
+// evalParseMAC returns a hardware address in canonical lowercase colon
+// form, accepting the colon, dash and Cisco dotted notations.
+func evalParseMAC(args []any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("parse_mac requires 1 argument")
+	}
+	mac, err := net.ParseMAC(strings.TrimSpace(fmt.Sprint(args[0])))
+	if err != nil {
+		return nil, fmt.Errorf("parse_mac: %w", err)
+	}
+	return mac.String(), nil
+}
+
+// evalMaskToPrefix returns the prefix length of a netmask such as
+// 255.255.255.0, rejecting masks whose ones are not contiguous.
+func evalMaskToPrefix(args []any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("mask_to_prefix requires 1 argument")
+	}
+	s := strings.TrimSpace(fmt.Sprint(args[0]))
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("mask_to_prefix: %q is not a netmask", s)
+	}
+	if v4 := ip.To4(); v4 != nil && !strings.Contains(s, ":") {
+		ip = v4
+	}
+	ones, bits := net.IPMask(ip).Size()
+	if ones == 0 && bits == 0 {
+		return nil, fmt.Errorf("mask_to_prefix: %q is not a contiguous netmask", s)
+	}
+	return int64(ones), nil
+}
+
+func bitsWidth(name string, args []any, at int) (uint, error) {
+	if len(args) <= at {
+		return 64, nil
+	}
+	w, err := toInt(args[at])
+	if err != nil {
+		return 0, fmt.Errorf("%s: width: %w", name, err)
+	}
+	switch w {
+	case 8, 16, 32, 64:
+		return uint(w), nil
+	}
+	return 0, fmt.Errorf("%s: width %d is not 8, 16, 32 or 64", name, w)
+}
+
+// bitsValue returns v as a width-bit pattern. Negative values are taken
+// as two's complement and must fit in width bits as a signed number.
+func bitsValue(name string, v any, width uint) (uint64, error) {
+	var i int64
+	switch x := v.(type) {
+	case int:
+		i = int64(x)
+	case int64:
+		i = x
+	case uint64:
+		return bitsFit(name, x, width)
+	case float64:
+		if x != math.Trunc(x) || math.Abs(x) > 1<<53 {
+			return 0, fmt.Errorf("%s: %v is not an exact integer; write large values as integers or hex strings", name, x)
+		}
+		i = int64(x)
+	case string:
+		s := strings.TrimSpace(x)
+		if u, err := strconv.ParseUint(s, 0, 64); err == nil {
+			return bitsFit(name, u, width)
+		}
+		n, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %q is not an integer", name, x)
+		}
+		i = n
+	default:
+		return 0, fmt.Errorf("%s: %v is not an integer", name, v)
+	}
+	if i >= 0 {
+		return bitsFit(name, uint64(i), width)
+	}
+	if width < 64 && i < -(1<<(width-1)) {
+		return 0, fmt.Errorf("%s: %d does not fit in %d bits", name, i, width)
+	}
+	return uint64(i) & bitsMask(width), nil
+}
+
+func bitsFit(name string, u uint64, width uint) (uint64, error) {
+	if u&^bitsMask(width) != 0 {
+		return 0, fmt.Errorf("%s: %d does not fit in %d bits", name, u, width)
+	}
+	return u, nil
+}
+
+func bitsMask(width uint) uint64 {
+	if width >= 64 {
+		return math.MaxUint64
+	}
+	return 1<<width - 1
+}
+
+func bitsResult(u uint64, width uint) int64 {
+	return int64(u & bitsMask(width))
+}
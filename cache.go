@@ -0,0 +1,189 @@
+package bcl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ResultCache stores the results of external fetches on disk so repeated
+// evaluations (watch mode, several processes sharing a directory) reuse them
+// until their TTL expires. Fills are serialized per key with a lock file.
+type ResultCache struct {
+	Dir string
+	// LockTimeout bounds how long Fetch waits for another process holding
+	// the fill lock for the same key; zero means 10s.
+	LockTimeout time.Duration
+	// StaleLockAge is how long a fill lock may go unrefreshed before a
+	// waiter takes it over as abandoned by a crashed process; zero means
+	// one minute. Holders refresh their lock while fill runs, so a slow
+	// fill keeps it however long it takes.
+	StaleLockAge time.Duration
+}
+
+func NewResultCache(dir string) *ResultCache {
+	if dir == "" {
+		if base, err := os.UserCacheDir(); err == nil {
+			dir = filepath.Join(base, "bcl", "results")
+		} else {
+			dir = filepath.Join(os.TempDir(), "bcl-results")
+		}
+	}
+	return &ResultCache{Dir: dir}
+}
+
+// Get returns the cached value for key if it was stored less than ttl ago.
+func (c *ResultCache) Get(key string, ttl time.Duration) ([]byte, bool) {
+	if c == nil || ttl <= 0 {
+		return nil, false
+	}
+	path := c.path(key)
+	st, err := os.Stat(path)
+	if err != nil || time.Since(st.ModTime()) >= ttl {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *ResultCache) Put(key string, data []byte) error {
+	if c == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(key))
+}
+
+// Fetch returns the cached value for key or calls fill and stores its
+// result. Only one caller across processes runs fill for a key at a time;
+// the others wait and then read the stored value.
+func (c *ResultCache) Fetch(key string, ttl time.Duration, fill func() ([]byte, error)) ([]byte, error) {
+	if c == nil || ttl <= 0 {
+		return fill()
+	}
+	if data, ok := c.Get(key, ttl); ok {
+		return data, nil
+	}
+	unlock, err := c.lock(key)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if data, ok := c.Get(key, ttl); ok {
+		return data, nil
+	}
+	data, err := fill()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Put(key, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (c *ResultCache) path(key string) string {
+	return filepath.Join(c.Dir, cacheKey(key))
+}
+
+func (c *ResultCache) lock(key string) (func(), error) {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	timeout := c.LockTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	stale := c.StaleLockAge
+	if stale <= 0 {
+		stale = time.Minute
+	}
+	path := c.path(key) + ".lock"
+	// The lock file holds a token of its owner, so a holder whose lock was
+	// taken over as stale does not remove the new owner's lock.
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return nil, err
+	}
+	token := fmt.Sprintf("%d-%x", os.Getpid(), buf)
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			_, werr := f.WriteString(token)
+			if cerr := f.Close(); werr == nil {
+				werr = cerr
+			}
+			if werr != nil {
+				_ = os.Remove(path)
+				return nil, werr
+			}
+			done := make(chan struct{})
+			go refreshLock(path, token, stale/4, done)
+			return func() {
+				close(done)
+				if owner, err := os.ReadFile(path); err == nil && string(owner) == token {
+					_ = os.Remove(path)
+				}
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		// A lock its holder stopped refreshing was left behind by a crashed
+		// process; take it over unless another waiter already has, which
+		// shows as a different owner token.
+		if owner, err := os.ReadFile(path); err == nil {
+			if st, err := os.Stat(path); err == nil && time.Since(st.ModTime()) > stale {
+				if again, err := os.ReadFile(path); err == nil && bytes.Equal(again, owner) {
+					_ = os.Remove(path)
+				}
+				continue
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for cache lock %s", path)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// refreshLock touches the lock file at path every interval while it still
+// holds token, until done is closed.
+func refreshLock(path, token string, interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			if owner, err := os.ReadFile(path); err == nil && string(owner) == token {
+				now := time.Now()
+				_ = os.Chtimes(path, now, now)
+			}
+		}
+	}
+}
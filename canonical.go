@@ -0,0 +1,184 @@
+package bcl
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// maxExactInt is the largest integer a float64 holds exactly.
+const maxExactInt = 1 << 53
+
+// MarshalJSONCanonical encodes v as compact JSON with a single byte form
+// for equal values, for signing and cache keys: object keys are sorted,
+// whole floats are written as integers, times are RFC 3339 in UTC and
+// durations use time.Duration's string form. Values JSON cannot represent
+// (NaN, infinities, channels, functions, complex numbers, maps with
+// non-string keys) are rejected with an error naming their path.
+// Structs and json.Marshalers, such as *Normalized, are encoded through
+// encoding/json first and then canonicalized.
+func MarshalJSONCanonical(v any) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeCanonical(&b, reflect.ValueOf(v), ""); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	durationType    = reflect.TypeOf(time.Duration(0))
+	jsonNumberType  = reflect.TypeOf(json.Number(""))
+	jsonMarshalType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func writeCanonical(b *bytes.Buffer, rv reflect.Value, path string) error {
+	for rv.IsValid() && (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface) {
+		if rv.IsNil() {
+			break
+		}
+		if rv.Kind() == reflect.Pointer && rv.Type().Implements(jsonMarshalType) && !rv.Type().Elem().Implements(jsonMarshalType) {
+			break
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface || rv.Kind() == reflect.Map || rv.Kind() == reflect.Slice) && rv.IsNil() {
+		b.WriteString("null")
+		return nil
+	}
+	switch rv.Type() {
+	case timeType:
+		writeCanonicalString(b, rv.Interface().(time.Time).UTC().Format(time.RFC3339Nano))
+		return nil
+	case durationType:
+		writeCanonicalString(b, rv.Interface().(time.Duration).String())
+		return nil
+	case jsonNumberType:
+		return writeCanonicalNumber(b, rv.Interface().(json.Number), path)
+	}
+	if rv.Type().Implements(jsonMarshalType) || rv.Kind() == reflect.Struct {
+		return writeCanonicalViaJSON(b, rv, path)
+	}
+	if rv.Kind() != reflect.String && rv.Type().Implements(textMarshalType) {
+		text, err := rv.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return canonicalError(path, err.Error())
+		}
+		writeCanonicalString(b, string(text))
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		b.WriteString(strconv.FormatBool(rv.Bool()))
+	case reflect.String:
+		writeCanonicalString(b, rv.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		b.WriteString(strconv.FormatInt(rv.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		b.WriteString(strconv.FormatUint(rv.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		return writeCanonicalFloat(b, rv.Float(), path)
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 && rv.Kind() == reflect.Slice {
+			writeCanonicalString(b, base64.StdEncoding.EncodeToString(rv.Bytes()))
+			return nil
+		}
+		b.WriteByte('[')
+		for i := 0; i < rv.Len(); i++ {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writeCanonical(b, rv.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return canonicalError(path, fmt.Sprintf("map key type %s is not a string", rv.Type().Key()))
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeCanonicalString(b, k)
+			b.WriteByte(':')
+			key := reflect.ValueOf(k).Convert(rv.Type().Key())
+			if err := writeCanonical(b, rv.MapIndex(key), joinPath(path, k)); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+	default:
+		return canonicalError(path, fmt.Sprintf("unsupported type %s", rv.Type()))
+	}
+	return nil
+}
+
+// writeCanonicalViaJSON encodes rv with encoding/json, then re-encodes the
+// generic result canonically.
+func writeCanonicalViaJSON(b *bytes.Buffer, rv reflect.Value, path string) error {
+	data, err := json.Marshal(rv.Interface())
+	if err != nil {
+		return canonicalError(path, err.Error())
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return canonicalError(path, err.Error())
+	}
+	return writeCanonical(b, reflect.ValueOf(generic), path)
+}
+
+func writeCanonicalNumber(b *bytes.Buffer, n json.Number, path string) error {
+	if i, err := n.Int64(); err == nil {
+		b.WriteString(strconv.FormatInt(i, 10))
+		return nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return canonicalError(path, err.Error())
+	}
+	return writeCanonicalFloat(b, f, path)
+}
+
+func writeCanonicalFloat(b *bytes.Buffer, f float64, path string) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return canonicalError(path, fmt.Sprintf("%v is not representable in JSON", f))
+	}
+	if f == math.Trunc(f) && math.Abs(f) <= maxExactInt {
+		b.WriteString(strconv.FormatInt(int64(f), 10))
+		return nil
+	}
+	b.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	return nil
+}
+
+func writeCanonicalString(b *bytes.Buffer, s string) {
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	b.Truncate(b.Len() - 1)
+}
+
+func canonicalError(path, msg string) error {
+	if path == "" {
+		path = "$"
+	}
+	return fmt.Errorf("bcl: canonical JSON: %s: %s", path, msg)
+}
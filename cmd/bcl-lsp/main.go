@@ -26,6 +26,7 @@ type server struct {
 	index                 map[string]*bcl.Analysis
 	recent                []string
 	rootURI               string
+	project               *bcl.Project
 	customHoverDetailMode bool
 }
 
@@ -88,6 +89,9 @@ func (s *server) handle(msg rpcMessage) {
 		}
 		_ = json.Unmarshal(msg.Params, &p)
 		s.rootURI = p.RootURI
+		if root := uriPath(p.RootURI); root != "" {
+			s.project, _ = bcl.LoadProject(root)
+		}
 		s.customHoverDetailMode = p.InitializationOptions.UseCustomHoverDetail
 		s.respond(msg.ID, map[string]any{
 			"capabilities": map[string]any{
@@ -259,13 +263,21 @@ func (s *server) analyzeURI(uri string) *bcl.Analysis {
 	if !samePath(analysisPath, path) {
 		partial = true
 	}
-	a, diags := bcl.AnalyzeFile(analysisPath, []byte(text), &bcl.Options{Strict: true, Partial: partial, ResolveImports: true, BaseDir: filepath.Dir(analysisPath)})
+	opts := &bcl.Options{Strict: true, Partial: partial, ResolveImports: true, BaseDir: filepath.Dir(analysisPath)}
+	if s.project != nil {
+		opts.IncludeRoots, opts.EnvFiles = s.project.IncludeRoots, s.project.VarFiles
+	}
+	a, diags := bcl.AnalyzeFile(analysisPath, []byte(text), opts)
 	includeDiags := missingIncludeDiagnostics(analysisPath, []byte(text))
 	if len(includeDiags) > 0 {
 		diags = replaceRawMissingFileDiagnostics(diags, includeDiags)
 		diags = append(diags, includeDiags...)
 		a.Diagnostics = diags
 	}
+	if s.project != nil {
+		diags = s.project.Lint.Apply(diags)
+		a.Diagnostics = diags
+	}
 	s.mu.Lock()
 	s.index[uri] = a
 	if analysisURI != uri {
@@ -323,6 +335,13 @@ func (s *server) ownerEntrypoint(path string) string {
 	if err == nil {
 		path = abs
 	}
+	if s.project != nil {
+		for _, entry := range s.project.Entrypoints {
+			if samePath(entry, path) || sourceGraphContains(entry, path) {
+				return entry
+			}
+		}
+	}
 	if filepath.Base(path) == "decision.bcl" || filepath.Base(path) == "main.bcl" {
 		return path
 	}
@@ -0,0 +1,21 @@
+// Thin wrapper around bcl.wasm. Load wasm_exec.js (from
+// $(go env GOROOT)/lib/wasm) first, then:
+//
+//   const bcl = await loadBCL("bcl.wasm");
+//   const { normalized, diagnostics } = bcl.compile(source, { profile: "prod" });
+//   const { diagnostics } = bcl.validate(source);
+//   const { formatted } = bcl.format(source);
+export async function loadBCL(url = "bcl.wasm") {
+  const go = new Go();
+  const response = fetch(url);
+  const { instance } = WebAssembly.instantiateStreaming
+    ? await WebAssembly.instantiateStreaming(response, go.importObject)
+    : await WebAssembly.instantiate(await (await response).arrayBuffer(), go.importObject);
+  go.run(instance);
+  const call = (fn, ...args) => JSON.parse(globalThis[fn](...args));
+  return {
+    compile: (source, options = {}) => call("bclCompile", source, JSON.stringify(options)),
+    validate: (source) => call("bclValidate", source),
+    format: (source) => call("bclFormat", source),
+  };
+}
@@ -0,0 +1,82 @@
+//go:build js && wasm
+
+// Command bcl-wasm exposes the BCL parser, compiler and formatter to
+// JavaScript. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o bcl.wasm ./cmd/bcl-wasm
+//
+// and load it through bcl.js next to wasm_exec.js from the Go distribution.
+// Each function takes BCL source and returns a JSON string.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"syscall/js"
+
+	"github.com/oarkflow/bcl"
+)
+
+type compileOptions struct {
+	Profile string         `json:"profile"`
+	Context map[string]any `json:"context"`
+	Strict  bool           `json:"strict"`
+}
+
+type response struct {
+	Normalized  *bcl.Normalized  `json:"normalized,omitempty"`
+	Formatted   string           `json:"formatted,omitempty"`
+	Diagnostics []bcl.Diagnostic `json:"diagnostics"`
+}
+
+func main() {
+	js.Global().Set("bclCompile", js.FuncOf(func(_ js.Value, args []js.Value) any {
+		var opts compileOptions
+		if len(args) > 1 && args[1].Type() == js.TypeString {
+			if err := json.Unmarshal([]byte(args[1].String()), &opts); err != nil {
+				return encode(response{Diagnostics: diagnostics(err)})
+			}
+		}
+		n, err := bcl.CompileBytes([]byte(source(args)), &bcl.Options{Profile: opts.Profile, Context: opts.Context, Strict: opts.Strict})
+		return encode(response{Normalized: n, Diagnostics: diagnostics(err)})
+	}))
+	js.Global().Set("bclValidate", js.FuncOf(func(_ js.Value, args []js.Value) any {
+		doc, err := bcl.Parse([]byte(source(args)))
+		if err != nil {
+			return encode(response{Diagnostics: diagnostics(err)})
+		}
+		opts := &bcl.Options{}
+		return encode(response{Diagnostics: append(bcl.Validate(doc, opts), bcl.Lint(doc, opts)...)})
+	}))
+	js.Global().Set("bclFormat", js.FuncOf(func(_ js.Value, args []js.Value) any {
+		out, err := bcl.Format([]byte(source(args)))
+		return encode(response{Formatted: string(out), Diagnostics: diagnostics(err)})
+	}))
+	select {}
+}
+
+func source(args []js.Value) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0].String()
+}
+
+func diagnostics(err error) []bcl.Diagnostic {
+	var list bcl.ErrorList
+	switch {
+	case err == nil:
+		return []bcl.Diagnostic{}
+	case errors.As(err, &list):
+		return list
+	}
+	return []bcl.Diagnostic{{Severity: "error", Message: err.Error()}}
+}
+
+func encode(r response) string {
+	b, err := json.Marshal(r)
+	if err != nil {
+		b, _ = json.Marshal(response{Diagnostics: diagnostics(err)})
+	}
+	return string(b)
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -40,10 +42,22 @@ func main() {
 		err = runCodegen(os.Args[2:])
 	case "docs":
 		err = runDocs(os.Args[2:])
+	case "docgen":
+		err = runDocgen(os.Args[2:])
 	case "migrate":
 		err = runMigrate(os.Args[2:])
+	case "erd":
+		err = runERD(os.Args[2:])
+	case "encrypt":
+		err = runCrypt("encrypt", os.Args[2:])
+	case "decrypt":
+		err = runCrypt("decrypt", os.Args[2:])
 	case "modules":
 		err = runModules(os.Args[2:])
+	case "grammar":
+		err = runGrammar(os.Args[2:])
+	case "schema":
+		err = runSchema(os.Args[2:])
 	default:
 		usage()
 		os.Exit(2)
@@ -57,28 +71,97 @@ func main() {
 func runFmt(args []string) error {
 	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
 	write := fs.Bool("w", false, "write result to source file")
+	list := fs.Bool("l", false, "list files whose formatting differs")
+	check := fs.Bool("check", false, "exit with status 1 if any file is not formatted")
+	var style bcl.FormatOptions
+	fs.BoolVar(&style.Equals, "equals", false, "write assignments as name = value")
+	fs.BoolVar(&style.Align, "align", false, "align values of consecutive assignments")
+	fs.IntVar(&style.WrapColumn, "wrap", 0, "wrap lists extending past this column")
+	fs.BoolVar(&style.CompactBlocks, "compact", false, "keep single-property blocks on one line")
 	fs.Parse(args)
-	for _, path := range fs.Args() {
-		src, err := os.ReadFile(path)
+	if fs.NArg() == 0 {
+		if *write {
+			return fmt.Errorf("fmt: cannot use -w with standard input")
+		}
+		src, err := io.ReadAll(os.Stdin)
 		if err != nil {
 			return err
 		}
-		out, err := bcl.Format(src)
+		out, err := bcl.FormatWithOptions(src, &style)
 		if err != nil {
 			return err
 		}
-		if *write {
-			if err := os.WriteFile(path, out, 0644); err != nil {
+		changed := !bytes.Equal(src, out)
+		switch {
+		case *list:
+			if changed {
+				fmt.Println("<standard input>")
+			}
+		case !*check:
+			os.Stdout.Write(out)
+		}
+		if *check && changed {
+			return fmt.Errorf("fmt: <standard input> is not formatted")
+		}
+		return nil
+	}
+	var paths []string
+	for _, arg := range fs.Args() {
+		if !isDir(arg) {
+			paths = append(paths, arg)
+			continue
+		}
+		err := filepath.WalkDir(arg, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
 				return err
 			}
-		} else {
+			if !d.IsDir() && filepath.Ext(path) == ".bcl" {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	unformatted := 0
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		out, err := bcl.FormatWithOptions(src, &style)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		changed := !bytes.Equal(src, out)
+		if changed {
+			unformatted++
+			if *list {
+				fmt.Println(path)
+			}
+		}
+		switch {
+		case *write:
+			if changed {
+				if err := os.WriteFile(path, out, 0644); err != nil {
+					return err
+				}
+			}
+		case !*list && !*check:
 			os.Stdout.Write(out)
 		}
 	}
+	if *check && !*write && unformatted > 0 {
+		return fmt.Errorf("fmt: %d file(s) not formatted; run bcl fmt -w", unformatted)
+	}
 	return nil
 }
 
 func runLint(args []string) error {
+	if len(args) == 0 {
+		return checkProject(true, false)
+	}
 	doc, err := oneDoc(args)
 	if err != nil {
 		return err
@@ -91,6 +174,7 @@ func runLint(args []string) error {
 func runValidate(args []string) error {
 	fs := flag.NewFlagSet("validate", flag.ExitOnError)
 	strict := fs.Bool("strict", false, "enable strict validation")
+	allowDestructive := fs.Bool("allow-destructive", false, "report destructive migration commands as warnings")
 	fs.Parse(args)
 	if fs.NArg() == 1 && isDir(fs.Arg(0)) {
 		prog, err := bcl.CompileDomainDir(fs.Arg(0), &bcl.Options{Strict: *strict, AllowEnv: true})
@@ -105,6 +189,9 @@ func runValidate(args []string) error {
 		}
 		return nil
 	}
+	if fs.NArg() == 0 {
+		return checkProject(false, *strict)
+	}
 	doc, err := oneDoc(fs.Args())
 	if err != nil {
 		return err
@@ -112,10 +199,37 @@ func runValidate(args []string) error {
 	opts := &bcl.Options{Strict: *strict, ResolveImports: true, ResolveModules: true, BaseDir: filepath.Dir(fs.Arg(0))}
 	resolved, resolveDiags := bcl.ResolveDocument(doc, opts)
 	diags := append(resolveDiags, bcl.Validate(resolved, opts)...)
+	diags = append(diags, bcl.DestructiveMigrations(resolved, *allowDestructive)...)
 	printDiags(diags)
 	return hasErrors(diags)
 }
 
+// checkProject validates, or lints, every entrypoint of ./bcl.project.
+func checkProject(lint, strict bool) error {
+	p, err := bcl.LoadProject(".")
+	if err != nil {
+		return err
+	}
+	var all []bcl.Diagnostic
+	for _, entry := range p.Entrypoints {
+		doc, err := p.Document(entry)
+		if err != nil {
+			return err
+		}
+		opts := p.Options()
+		opts.Strict = opts.Strict || strict
+		resolved, diags := bcl.ResolveDocument(doc, opts)
+		if lint {
+			diags = append(diags, bcl.Lint(resolved, opts)...)
+		} else {
+			diags = append(diags, bcl.Validate(resolved, opts)...)
+		}
+		all = append(all, p.Lint.Apply(diags)...)
+	}
+	printDiags(all)
+	return hasErrors(all)
+}
+
 func runCompile(args []string) error {
 	fs := flag.NewFlagSet("compile", flag.ExitOnError)
 	outPath := fs.String("out", "", "output JSON path")
@@ -123,20 +237,30 @@ func runCompile(args []string) error {
 	allowEnv := fs.Bool("allow-env", false, "allow env functions")
 	strict := fs.Bool("strict", false, "enable strict mode")
 	lockfile := fs.String("lockfile", "", "lockfile path")
+	seed := fs.Int64("seed", 0, "seed for random_int, random_string and sequence")
+	astCache := fs.String("ast-cache", "", "reuse parsed files from this cache directory (\"default\" for the user cache)")
 	envFiles := multiFlag{}
 	fs.Var(&envFiles, "env-file", "load KEY=VALUE entries from env file")
 	fs.Parse(args)
 	if fs.NArg() != 1 {
 		return fmt.Errorf("compile requires one file or directory")
 	}
+	var cache *bcl.ASTCache
+	switch *astCache {
+	case "":
+	case "default":
+		cache = bcl.NewASTCache("")
+	default:
+		cache = bcl.NewASTCache(*astCache)
+	}
 	var n any
 	var err error
 	if isDir(fs.Arg(0)) {
-		n, err = bcl.CompileDomainDir(fs.Arg(0), &bcl.Options{Profile: *profile, AllowEnv: true, AllowTime: true, Strict: *strict, EnvFiles: envFiles})
+		n, err = bcl.CompileDomainDir(fs.Arg(0), &bcl.Options{Profile: *profile, AllowEnv: true, AllowTime: true, Strict: *strict, EnvFiles: envFiles, Seed: *seed})
 	} else if *lockfile != "" {
-		n, err = bcl.CompileFileWithLock(fs.Arg(0), *lockfile, &bcl.Options{Profile: *profile, AllowEnv: *allowEnv, Strict: *strict, EnvFiles: envFiles})
+		n, err = bcl.CompileFileWithLock(fs.Arg(0), *lockfile, &bcl.Options{Profile: *profile, AllowEnv: *allowEnv, Strict: *strict, EnvFiles: envFiles, Seed: *seed, ASTCache: cache})
 	} else {
-		n, err = bcl.CompileFile(fs.Arg(0), &bcl.Options{Profile: *profile, AllowEnv: *allowEnv, ResolveImports: true, ResolveModules: true, Strict: *strict, EnvFiles: envFiles})
+		n, err = bcl.CompileFile(fs.Arg(0), &bcl.Options{Profile: *profile, AllowEnv: *allowEnv, ResolveImports: true, ResolveModules: true, Strict: *strict, EnvFiles: envFiles, Seed: *seed, ASTCache: cache})
 	}
 	if err != nil {
 		return err
@@ -273,27 +397,59 @@ func runTest(args []string) error {
 
 func runExport(args []string) error {
 	fs := flag.NewFlagSet("export", flag.ExitOnError)
-	format := fs.String("format", "json", "json or yaml")
+	format := fs.String("format", "json", "json, yaml, k8s, tfvars or tfvars-hcl")
 	outPath := fs.String("out", "", "output path")
 	fields := fs.String("fields", "", "comma-separated fields to include")
 	redact := fs.String("redact", "", "comma-separated paths to redact")
+	blocks := fs.String("blocks", "", "comma-separated blocks (type or type.id) for k8s export")
+	name := fs.String("name", "", "k8s manifest name")
+	namespace := fs.String("namespace", "", "k8s namespace")
+	secrets := fs.String("secrets", "", "comma-separated paths to place in the k8s Secret")
 	fs.Parse(args)
 	if fs.NArg() != 1 {
 		return fmt.Errorf("export requires one file")
 	}
-	n, err := bcl.CompileFile(fs.Arg(0), &bcl.Options{AllowEnv: true, ResolveImports: true, ResolveModules: true})
-	if err != nil {
-		return err
-	}
-	out, err := bcl.Export(n, bcl.ExportOptions{Format: *format, Fields: splitCSV(*fields), Redact: splitCSV(*redact)})
-	if err != nil {
-		return err
+	opts := &bcl.Options{AllowEnv: true, ResolveImports: true, ResolveModules: true}
+	var out []byte
+	switch *format {
+	case "k8s", "kubernetes", "tfvars", "tfvars-hcl":
+		doc, err := bcl.ParsePath(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		// These formats are deployed as-is and need the real values:
+		// Secrets are base64 encoded, not masked, and tfvars feed Terraform.
+		opts.RevealSensitive = true
+		result, err := bcl.CompileDetailed(doc, opts)
+		if err != nil {
+			return err
+		}
+		switch *format {
+		case "tfvars":
+			out, err = bcl.ToTFVars(result)
+		case "tfvars-hcl":
+			out, err = bcl.ToTFVarsHCL(result)
+		default:
+			out, err = bcl.ExportKubernetes(result, bcl.KubernetesOptions{Blocks: splitCSV(*blocks), Name: *name, Namespace: *namespace, Secrets: splitCSV(*secrets)})
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		n, err := bcl.CompileFile(fs.Arg(0), opts)
+		if err != nil {
+			return err
+		}
+		out, err = bcl.Export(n, bcl.ExportOptions{Format: *format, Fields: splitCSV(*fields), Redact: splitCSV(*redact)})
+		if err != nil {
+			return err
+		}
 	}
 	out = append(out, '\n')
 	if *outPath != "" {
 		return os.WriteFile(*outPath, out, 0644)
 	}
-	_, err = os.Stdout.Write(out)
+	_, err := os.Stdout.Write(out)
 	return err
 }
 
@@ -325,12 +481,22 @@ func (m *multiFlag) Set(v string) error {
 func runCodegen(args []string) error {
 	fs := flag.NewFlagSet("codegen", flag.ExitOnError)
 	pkg := fs.String("package", "config", "Go package name")
+	literal := fs.Bool("literal", false, "emit a typed Default<type> literal of the compiled config")
+	typeName := fs.String("type", "Config", "root type name for -literal")
 	fs.Parse(args)
 	doc, err := oneDoc(fs.Args())
 	if err != nil {
 		return err
 	}
-	out, err := bcl.GenerateGoTypes(doc, *pkg)
+	var out []byte
+	if *literal {
+		var n *bcl.Normalized
+		if n, err = bcl.Compile(doc, &bcl.Options{AllowEnv: true}); err == nil {
+			out, err = bcl.GenerateGoLiteral(n, *pkg, *typeName)
+		}
+	} else {
+		out, err = bcl.GenerateGoTypes(doc, *pkg)
+	}
 	if err != nil {
 		return err
 	}
@@ -347,6 +513,35 @@ func runDocs(args []string) error {
 	return err
 }
 
+func runDocgen(args []string) error {
+	fs := flag.NewFlagSet("docgen", flag.ExitOnError)
+	format := fs.String("format", "markdown", "markdown or html")
+	title := fs.String("title", "", "document title")
+	samplePath := fs.String("sample", "", "sample BCL file used for example values")
+	outPath := fs.String("out", "", "output path")
+	fs.Parse(args)
+	doc, err := oneDoc(fs.Args())
+	if err != nil {
+		return err
+	}
+	opts := bcl.DocgenOptions{Format: *format, Title: *title}
+	if *samplePath != "" {
+		opts.Sample, err = bcl.CompileFile(*samplePath, &bcl.Options{AllowEnv: true, ResolveImports: true, ResolveModules: true})
+		if err != nil {
+			return err
+		}
+	}
+	out, err := bcl.GenerateConfigDocs(doc, opts)
+	if err != nil {
+		return err
+	}
+	if *outPath != "" {
+		return os.WriteFile(*outPath, out, 0644)
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
 func runMigrate(args []string) error {
 	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
 	version := fs.String("version", "1.0", "target BCL version")
@@ -365,6 +560,75 @@ func runMigrate(args []string) error {
 	return err
 }
 
+func runERD(args []string) error {
+	fs := flag.NewFlagSet("erd", flag.ExitOnError)
+	format := fs.String("format", "mermaid", "mermaid or dot")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("requires one file")
+	}
+	n, err := bcl.CompileFile(fs.Arg(0), &bcl.Options{AllowEnv: true, ResolveImports: true})
+	if err != nil {
+		return err
+	}
+	out, err := bcl.ERDiagram(bcl.MigrationTables(n), *format)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+func runCrypt(mode string, args []string) error {
+	fs := flag.NewFlagSet(mode, flag.ExitOnError)
+	keyEnv := fs.String("key-env", "BCL_ENCRYPTION_KEY", "environment variable holding the base64 AES-256 key")
+	keyID := fs.String("key-id", "", "key id recorded in the encrypted header")
+	outPath := fs.String("out", "", "output path")
+	write := fs.Bool("w", false, "write result to source file")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%s requires one file", mode)
+	}
+	src, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	keys := bcl.EnvKey(*keyEnv)
+	var out []byte
+	if mode == "encrypt" {
+		if bcl.IsEncrypted(src) {
+			return fmt.Errorf("%s is already encrypted", fs.Arg(0))
+		}
+		if _, err := bcl.Parse(src); err != nil {
+			return err
+		}
+		key, err := keys.Key(*keyID)
+		if err != nil {
+			return err
+		}
+		out, err = bcl.Encrypt(src, *keyID, key)
+		if err != nil {
+			return err
+		}
+	} else {
+		if !bcl.IsEncrypted(src) {
+			return fmt.Errorf("%s is not encrypted", fs.Arg(0))
+		}
+		out, err = bcl.Decrypt(src, keys)
+		if err != nil {
+			return err
+		}
+	}
+	if *write {
+		return os.WriteFile(fs.Arg(0), out, 0600)
+	}
+	if *outPath != "" {
+		return os.WriteFile(*outPath, out, 0600)
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
 func runModules(args []string) error {
 	if len(args) < 1 {
 		return fmt.Errorf("expected modules <lock|fetch|verify>")
@@ -436,6 +700,56 @@ func hasErrors(diags []bcl.Diagnostic) error {
 	return nil
 }
 
+func runGrammar(args []string) error {
+	if len(args) < 1 || args[0] != "export" {
+		return fmt.Errorf("expected grammar export")
+	}
+	fs := flag.NewFlagSet("grammar export", flag.ExitOnError)
+	format := fs.String("format", "textmate", strings.Join(bcl.GrammarFormats, ", "))
+	outPath := fs.String("out", "", "output path")
+	fs.Parse(args[1:])
+	out, err := bcl.ExportGrammar(*format)
+	if err != nil {
+		return err
+	}
+	if *outPath != "" {
+		return os.WriteFile(*outPath, out, 0644)
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+func runSchema(args []string) error {
+	if len(args) < 1 || args[0] != "infer" {
+		return fmt.Errorf("expected schema infer")
+	}
+	fs := flag.NewFlagSet("schema infer", flag.ExitOnError)
+	name := fs.String("name", "inferred", "schema name")
+	fs.Parse(args[1:])
+	if fs.NArg() == 0 {
+		return fmt.Errorf("schema infer requires sample files")
+	}
+	var samples [][]byte
+	for _, path := range fs.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, src)
+	}
+	schema, err := bcl.InferSchema(samples...)
+	if err != nil {
+		return err
+	}
+	schema.Name = *name
+	out, err := bcl.FormatDocument(&bcl.Document{Items: []bcl.Node{schema}})
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
 func usage() {
-	fmt.Fprintln(os.Stderr, "usage: bcl <fmt|lint|validate|compile|domain|explain|simulate|test|export|codegen|docs|migrate|modules lock|modules fetch|modules verify> [args]")
+	fmt.Fprintln(os.Stderr, "usage: bcl <fmt|lint|validate|compile|domain|explain|simulate|test|export|codegen|docs|docgen|migrate|erd|encrypt|decrypt|modules lock|modules fetch|modules verify|grammar export|schema infer> [args]")
 }
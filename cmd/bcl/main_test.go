@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oarkflow/bcl"
+)
+
+// TestMain runs the CLI instead of the tests when BCL_RUN_MAIN is set, so
+// tests can exec the test binary as bcl and see its exit code and output.
+func TestMain(m *testing.M) {
+	if os.Getenv("BCL_RUN_MAIN") == "1" {
+		os.Args = append([]string{"bcl"}, strings.Split(os.Getenv("BCL_ARGS"), "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func runBCL(t *testing.T, stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "BCL_RUN_MAIN=1", "BCL_ARGS="+strings.Join(args, "\n"))
+	cmd.Stdin = strings.NewReader(stdin)
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		code = exit.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), errOut.String(), code
+}
+
+func TestFmtListCheckAndWriteWalkDirectories(t *testing.T) {
+	const messy = "name   \"x\"\nport    80\n"
+	tidy, err := bcl.Format([]byte(messy))
+	if err != nil || bytes.Equal(tidy, []byte(messy)) {
+		t.Fatalf("sample is already formatted: %q %v", tidy, err)
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.bcl":     string(tidy),
+		"sub/b.bcl": messy,
+		"notes.txt": messy,
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unformatted := filepath.Join(dir, "sub", "b.bcl")
+
+	if out, errOut, code := runBCL(t, "", "fmt", "-l", dir); code != 0 || out != unformatted+"\n" || errOut != "" {
+		t.Fatalf("fmt -l = %q %q exit %d", out, errOut, code)
+	}
+	if out, errOut, code := runBCL(t, "", "fmt", "-check", dir); code != 1 || out != "" || !strings.Contains(errOut, "fmt: 1 file(s) not formatted; run bcl fmt -w") {
+		t.Fatalf("fmt -check = %q %q exit %d", out, errOut, code)
+	}
+	if out, errOut, code := runBCL(t, "", "fmt", "-w", dir); code != 0 || out != "" || errOut != "" {
+		t.Fatalf("fmt -w = %q %q exit %d", out, errOut, code)
+	}
+	if got, _ := os.ReadFile(unformatted); !bytes.Equal(got, tidy) {
+		t.Fatalf("fmt -w wrote %q", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "notes.txt")); string(got) != messy {
+		t.Fatalf("fmt -w touched a non-.bcl file: %q", got)
+	}
+	if out, errOut, code := runBCL(t, "", "fmt", "-check", "-l", dir); code != 0 || out != "" || errOut != "" {
+		t.Fatalf("fmt -check after -w = %q %q exit %d", out, errOut, code)
+	}
+}
+
+func TestFmtStandardInput(t *testing.T) {
+	const messy = "name   \"x\"\n"
+	tidy, err := bcl.Format([]byte(messy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _, code := runBCL(t, messy, "fmt"); code != 0 || out != string(tidy) {
+		t.Fatalf("fmt < stdin = %q exit %d", out, code)
+	}
+	if out, _, code := runBCL(t, messy, "fmt", "-l"); code != 0 || out != "<standard input>\n" {
+		t.Fatalf("fmt -l < stdin = %q exit %d", out, code)
+	}
+	if out, _, code := runBCL(t, string(tidy), "fmt", "-l"); code != 0 || out != "" {
+		t.Fatalf("fmt -l < formatted stdin = %q exit %d", out, code)
+	}
+	if out, errOut, code := runBCL(t, messy, "fmt", "-check"); code != 1 || out != "" || !strings.Contains(errOut, "fmt: <standard input> is not formatted") {
+		t.Fatalf("fmt -check < stdin = %q %q exit %d", out, errOut, code)
+	}
+	if _, _, code := runBCL(t, string(tidy), "fmt", "-check"); code != 0 {
+		t.Fatalf("fmt -check < formatted stdin exit %d", code)
+	}
+	if _, errOut, code := runBCL(t, messy, "fmt", "-w"); code != 1 || !strings.Contains(errOut, "cannot use -w with standard input") {
+		t.Fatalf("fmt -w < stdin = %q exit %d", errOut, code)
+	}
+}
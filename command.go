@@ -0,0 +1,184 @@
+package bcl
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// CommandShells lists the values accepted by the shell field of a command
+// block. "none" (the default) runs exec directly without a shell.
+var CommandShells = []string{"none", "sh", "bash", "powershell", "pwsh", "cmd"}
+
+// CommandArgv builds the argv for a compiled command block from its exec,
+// args and shell fields. goos defaults to runtime.GOOS.
+//
+// With shell "none", the default, no shell runs. An exec list is used
+// verbatim. An exec string is split into words at spaces, tabs and
+// newlines: single quotes keep their text literally, double quotes allow
+// only \", \\, \$ and \` as escapes, and outside quotes a backslash escapes
+// the next character except on Windows, where it is a path separator. An
+// unquoted | & ; < > ( ) $ or `, or a $ or ` inside double quotes, is an
+// error, since only a shell could run it; nothing is globbed or expanded.
+// args are appended as they are, and on Windows slashes in the program
+// path become backslashes unless it is a URL.
+//
+// With shell sh, bash, powershell, pwsh or cmd, the argv runs that shell
+// on one script. An exec string is the script as written, so pipes, globs
+// and redirects work; an exec list is quoted word by word for that shell,
+// and args are quoted the same way and appended. Any other shell is an
+// error.
+func CommandArgv(command map[string]any, goos string) ([]string, error) {
+	if goos == "" {
+		goos = runtime.GOOS
+	}
+	shell := strings.ToLower(firstNonEmpty(scalarString(command["shell"]), "none"))
+	script, isScript := command["exec"].(string)
+	words := append(commandWords(command["exec"]), commandWords(command["args"])...)
+	if len(words) == 0 || words[0] == "" {
+		return nil, fmt.Errorf("command requires exec")
+	}
+	if !isScript {
+		script = quoteCommand(shell, words)
+	} else if args := commandWords(command["args"]); len(args) > 0 {
+		script += " " + strings.TrimPrefix(quoteCommand(shell, args), "& ")
+	}
+	switch shell {
+	case "none":
+		if isScript {
+			split, err := splitCommand(command["exec"].(string), goos == "windows")
+			if err != nil {
+				return nil, err
+			}
+			words = append(split, words[1:]...)
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("command requires exec")
+		}
+		if goos == "windows" {
+			words[0] = windowsPath(words[0])
+		}
+		return words, nil
+	case "sh", "bash":
+		return []string{shell, "-c", script}, nil
+	case "powershell", "pwsh":
+		return []string{shell, "-NoProfile", "-NonInteractive", "-Command", script}, nil
+	case "cmd":
+		return []string{"cmd", "/d", "/s", "/c", script}, nil
+	default:
+		return nil, fmt.Errorf("unsupported command shell %q (want one of %s)", shell, strings.Join(CommandShells, ", "))
+	}
+}
+
+func commandWords(v any) []string {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case []any:
+		out := make([]string, len(x))
+		for i, w := range x {
+			out[i], _ = toStringValue(w)
+		}
+		return out
+	case []string:
+		return append([]string(nil), x...)
+	default:
+		s, _ := toStringValue(x)
+		return []string{s}
+	}
+}
+
+// splitCommand splits s into words the way a POSIX shell would, for a
+// command run without one. On Windows a backslash is a path separator,
+// not an escape.
+func splitCommand(s string, windows bool) ([]string, error) {
+	var words []string
+	var w strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, w.String())
+				w.Reset()
+				inWord = false
+			}
+			continue
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("exec %q has an unterminated quote", s)
+			}
+			w.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+					i++
+				} else if s[i] == '$' || s[i] == '`' {
+					return nil, fmt.Errorf("exec %q uses shell expansion; set shell or use a list", s)
+				}
+				w.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, fmt.Errorf("exec %q has an unterminated quote", s)
+			}
+		case c == '\\' && !windows:
+			if i+1 < len(s) {
+				i++
+				w.WriteByte(s[i])
+			}
+		case strings.IndexByte("|&;<>()$`", c) >= 0:
+			return nil, fmt.Errorf("exec %q uses shell syntax %q; set shell or use a list", s, c)
+		default:
+			w.WriteByte(c)
+		}
+		inWord = true
+	}
+	if inWord {
+		words = append(words, w.String())
+	}
+	return words, nil
+}
+
+func quoteCommand(shell string, words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		switch shell {
+		case "powershell", "pwsh":
+			quoted[i] = "'" + strings.ReplaceAll(w, "'", "''") + "'"
+		case "cmd":
+			quoted[i] = cmdQuote(w)
+		default:
+			quoted[i] = posixQuote(w)
+		}
+	}
+	if shell == "powershell" || shell == "pwsh" {
+		// A quoted program name is a string expression in PowerShell; the
+		// call operator runs it.
+		return "& " + strings.Join(quoted, " ")
+	}
+	return strings.Join(quoted, " ")
+}
+
+func posixQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=,@%+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func cmdQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"&|<>^%()") {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func windowsPath(p string) string {
+	if !strings.Contains(p, "/") || strings.Contains(p, "://") {
+		return p
+	}
+	return strings.ReplaceAll(p, "/", `\`)
+}
@@ -0,0 +1,190 @@
+package bcl
+
+import (
+	"sort"
+	"strings"
+)
+
+// ParseFileWithComments parses src like ParseFile and keeps its comments as
+// Comment nodes in the node lists they appear in, so a tool can load a
+// config, edit the tree and write it back with FormatDocument without
+// losing its documentation. A comment inside the braces of a block,
+// object, spread, directive, loop or if body goes into that body; one
+// anywhere else, such as inside a multi-line list, follows the statement
+// that contains it.
+func ParseFileWithComments(name string, src []byte) (*Document, error) {
+	doc, err := ParseFile(name, src)
+	if err != nil {
+		return nil, err
+	}
+	if s := scanComments(name, string(src)); len(s.comments) > 0 {
+		doc.Items = s.attach(doc.Items, s.comments)
+	}
+	return doc, nil
+}
+
+// commentScan holds the comments of a source with the tokens around them,
+// which locate the braces of each body.
+type commentScan struct {
+	comments []*Comment
+	// toks holds every token but newlines, in source order, and closes
+	// maps the offset of each { to that of its }.
+	toks   []token
+	closes map[int]int
+}
+
+func scanComments(file, src string) *commentScan {
+	s := &commentScan{closes: map[int]int{}}
+	l := &lexer{file: file, src: src, line: 1, col: 1}
+	var open []int
+	for {
+		r := l.peek()
+		switch {
+		case r == ' ' || r == '\t' || r == '\r' || r == '\n':
+			l.advance()
+			continue
+		case r == '#' || r == '/' && l.peekN(1) == '/' || r == '/' && l.peekN(1) == '*':
+			sp := l.spanAt()
+			start := l.pos
+			if r == '/' && l.peekN(1) == '*' {
+				_ = l.skipBlockComment()
+			} else {
+				l.skipLine()
+			}
+			sp.End = l.posn()
+			lineStart := strings.LastIndexByte(src[:start], '\n') + 1
+			s.comments = append(s.comments, &Comment{
+				Text:     strings.TrimRight(src[start:l.pos], " \t\r"),
+				Trailing: strings.TrimSpace(src[lineStart:start]) != "",
+				Span:     sp,
+			})
+			continue
+		}
+		t, err := l.next()
+		if err != nil || t.kind == tokEOF {
+			return s
+		}
+		switch t.kind {
+		case tokNewline:
+			continue
+		case tokLBrace:
+			open = append(open, t.span.Start.Offset)
+		case tokRBrace:
+			if len(open) > 0 {
+				s.closes[open[len(open)-1]] = t.span.Start.Offset
+				open = open[:len(open)-1]
+			}
+		}
+		s.toks = append(s.toks, t)
+	}
+}
+
+// attach returns nodes with comments, which are in source order, inserted
+// after the last node starting before each, or into that node's body.
+func (s *commentScan) attach(nodes []Node, comments []*Comment) []Node {
+	out := make([]Node, 0, len(nodes)+len(comments))
+	next := 0
+	for i, n := range nodes {
+		start := n.GetSpan().Start.Offset
+		for next < len(comments) && commentOffset(comments[next]) < start {
+			out = append(out, comments[next])
+			next++
+		}
+		end := next
+		for end < len(comments) && (i+1 == len(nodes) || commentOffset(comments[end]) < nodes[i+1].GetSpan().Start.Offset) {
+			end++
+		}
+		out = append(out, n)
+		out = append(out, s.attachInto(n, comments[next:end])...)
+		next = end
+	}
+	for _, c := range comments[next:] {
+		out = append(out, c)
+	}
+	return out
+}
+
+// attachInto puts the comments inside the bodies of n there and returns
+// the rest.
+func (s *commentScan) attachInto(n Node, comments []*Comment) []Node {
+	if len(comments) == 0 {
+		return nil
+	}
+	var bodies []*[]Node
+	from := n.GetSpan().Start.Offset
+	switch x := n.(type) {
+	case *Block:
+		bodies = []*[]Node{&x.Body}
+	case *Assignment:
+		if o, ok := x.Value.(*Object); ok {
+			bodies = []*[]Node{&o.Fields}
+		}
+	case *Spread:
+		if len(x.Body) > 0 {
+			bodies = []*[]Node{&x.Body}
+		}
+	case *Directive:
+		if len(x.Body) > 0 {
+			bodies = []*[]Node{&x.Body}
+		}
+	case *ForLoop:
+		bodies = []*[]Node{&x.Body}
+		if x.In != nil {
+			from = x.In.Span.End.Offset
+		}
+	case *IfChain:
+		for i := range x.Branches {
+			bodies = append(bodies, &x.Branches[i].Body)
+		}
+		bodies = append(bodies, &x.Else)
+	}
+	placed := map[*Comment]bool{}
+	open := s.braceAfter(from)
+	for i, body := range bodies {
+		end, ok := s.closes[open]
+		if !ok {
+			break
+		}
+		var inside []*Comment
+		for _, c := range comments {
+			if off := commentOffset(c); off > open && off < end {
+				inside = append(inside, c)
+				placed[c] = true
+			}
+		}
+		if len(inside) > 0 {
+			*body = s.attach(*body, inside)
+		}
+		// Only an if chain has further bodies, each after an else.
+		if i+1 == len(bodies) || !s.elseAfter(end) {
+			break
+		}
+		open = s.braceAfter(end + 1)
+	}
+	var rest []Node
+	for _, c := range comments {
+		if !placed[c] {
+			rest = append(rest, c)
+		}
+	}
+	return rest
+}
+
+// braceAfter returns the offset of the first { at or after offset, or -1.
+func (s *commentScan) braceAfter(offset int) int {
+	i := sort.Search(len(s.toks), func(i int) bool { return s.toks[i].span.Start.Offset >= offset })
+	for ; i < len(s.toks); i++ {
+		if s.toks[i].kind == tokLBrace {
+			return s.toks[i].span.Start.Offset
+		}
+	}
+	return -1
+}
+
+// elseAfter reports whether the token after the } at offset is else.
+func (s *commentScan) elseAfter(offset int) bool {
+	i := sort.Search(len(s.toks), func(i int) bool { return s.toks[i].span.Start.Offset > offset })
+	return i < len(s.toks) && s.toks[i].kind == tokIdent && s.toks[i].text == "else"
+}
+
+func commentOffset(c *Comment) int { return c.Span.Start.Offset }
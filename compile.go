@@ -1,13 +1,17 @@
 package bcl
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
@@ -24,11 +28,16 @@ type Options struct {
 	Context                 map[string]any
 	Session                 map[string]any
 	AllowEnv                bool
+	AllowedEnvKeys          []string
 	AllowTime               bool
 	AllowHash               bool
 	AllowEncoding           bool
+	AllowPlaintext          bool // UnmarshalEncrypted accepts unencrypted files
 	ResolveImports          bool
 	ResolveModules          bool
+	DisableRemoteInclude    bool
+	DisableExec             bool
+	DisableLocalDataFiles   bool // csvdecode and tsvdecode read files only through FS or IncludeResolver
 	Interpolate             bool
 	DisableInterpolation    bool
 	Partial                 bool
@@ -36,11 +45,40 @@ type Options struct {
 	Verbose                 bool
 	LockfilePath            string
 	BaseDir                 string
+	FS                      fs.FS
+	IncludeResolver         IncludeResolver
+	IncludeRoots            []string
+	MaxIncludeDepth         int
+	MaxIncludeFileSize      int64
+	MaxIncludeBytes         int64
+	IncludeParallelism      int
+	SliceMerge              SliceMode
+	BlockShape              BlockShape
+	EnvFallback             bool
+	EnvPrefix               string
+	DisallowUnknownFields   bool
+	TrackProvenance         bool
+	Renames                 map[string]string
+	Only                    []string
+	Exclude                 []string
+	Normalizers             []Normalizer
+	DecodeHooks             map[reflect.Type]DecodeHook
+	ResolvePathFields       bool
 	Redact                  bool
+	RevealSensitive         bool // keep sensitive() values instead of "****"
+	Seed                    int64
+	Audit                   *AuditLog
+	Logger                  *slog.Logger
+	Cache                   *ResultCache
+	IncludeCacheTTL         time.Duration // keep remote includes in Cache this long
+	ASTCache                *ASTCache
+	GOOS                    string
+	GOARCH                  string
 	EvalFunctions           map[string]EvalFunction
 	DecisionActions         map[string]DecisionActionHandler
 	DecisionRankers         map[string]DecisionRankingScorer
 	DecisionDatasetAdapters map[string]DecisionDatasetAdapter
+	Directives              map[string]DirectiveFunc
 	HTTPClient              *http.Client
 	DecisionInputValidator  DecisionInputValidator
 	Now                     func() time.Time
@@ -48,6 +86,12 @@ type Options struct {
 	AllowedHTTPHosts        []string
 	AllowedHTTPMethods      []string
 	ExternalTimeout         time.Duration
+
+	// isolated is set by Runtime.Options to bypass the process-wide
+	// registries.
+	isolated bool
+	// ctx is set by the Context variants of Compile.
+	ctx context.Context
 }
 
 func Compile(doc *Document, opts *Options) (*Normalized, error) {
@@ -84,7 +128,12 @@ func Compile(doc *Document, opts *Options) (*Normalized, error) {
 		schemaDecls: map[string]*SchemaDecl{},
 		blockIndex:  map[string]*Block{},
 		spreadStack: map[string]bool{},
-		evalOpts:    EvalOptions{AllowEncoding: opts.AllowEncoding, AllowHash: opts.AllowHash, AllowTime: opts.AllowTime, Functions: opts.EvalFunctions, Now: opts.Now},
+		evalOpts:    EvalOptions{AllowEncoding: opts.AllowEncoding, AllowHash: opts.AllowHash, AllowTime: opts.AllowTime, Functions: opts.EvalFunctions, Now: opts.Now, Generator: optionsGenerator(opts), GOOS: opts.GOOS, GOARCH: opts.GOARCH, ctx: opts.ctx},
+	}
+	defer c.logCompile(time.Now())
+	c.file = doc.File
+	if opts.TrackProvenance {
+		c.out.provenance = map[string]ValueOrigin{}
 	}
 	c.loadEnvFiles(doc.Span, nil)
 	items := doc.Items
@@ -96,13 +145,35 @@ func Compile(doc *Document, opts *Options) (*Normalized, error) {
 		}
 	}
 	if opts.ResolveImports {
+		if opts.IncludeParallelism > 1 {
+			c.prefetchImports(items, opts.BaseDir)
+		}
 		items = c.resolveImports(items, opts.BaseDir, map[string]bool{})
 	}
 	if opts.ResolveModules {
 		items = c.resolveModules(items, opts.BaseDir, map[string]bool{})
 	}
+	items = c.expandDirectives(items)
 	c.indexBlocks(items)
+	c.indexDecls(items)
+	c.evalOpts.Functions = c.refFunctions(opts.EvalFunctions)
+	c.declareFuncs(items)
+	if hasLoop(items) {
+		// Loops may iterate over constants, which collect records later.
+		for _, n := range items {
+			if x, ok := n.(*ConstDecl); ok {
+				c.consts[x.Name] = x.Value
+				c.out.Constants[x.Name] = c.value(x.Value)
+			}
+		}
+		items = c.expandLoops(items, nil)
+		c.indexBlocks(items)
+		c.indexDecls(items)
+	}
 	c.loadEnvFiles(doc.Span, envFileDecls(items))
+	if c.canceled(doc.Span) {
+		return c.out, c.errs
+	}
 	c.collect(items)
 	c.emit(items, c.out.Body)
 	if opts.Profile == "" {
@@ -112,6 +183,8 @@ func Compile(doc *Document, opts *Options) (*Normalized, error) {
 	}
 	c.applyProfile()
 	c.applyOverrides()
+	c.applyRenames()
+	c.applySelection()
 	if len(c.errs) > 0 {
 		c.out.Diagnostics = append(c.out.Diagnostics, c.errs...)
 		return c.out, c.errs
@@ -128,12 +201,27 @@ type compiler struct {
 	schemaDecls map[string]*SchemaDecl
 	blockIndex  map[string]*Block
 	spreadStack map[string]bool
+	defaults    map[string][]Node
 	lock        *Lockfile
 	result      *CompileResult
 	errs        ErrorList
 	evalOpts    EvalOptions
 	configWrap  map[string]any
 	vars        map[string]any
+	envFunc     func(string) (string, bool)
+	exprSpan    Span
+	decls       map[string]*Assignment
+	declValues  map[*Assignment]any
+	metaValues  map[*Assignment]any
+	refStack    []string
+	file        string
+	blockSpans  []Span
+
+	includedBytes int64
+	prefetched    map[string]*prefetchedSource
+	funcs         map[string]*FuncDecl
+	funcDepth     int
+	ctxDone       bool
 }
 
 func (c *compiler) indexBlocks(nodes []Node) {
@@ -174,6 +262,9 @@ func (c *compiler) loadEnvFiles(sp Span, declared []string) {
 		paths = append(paths, path)
 	}
 	values, err := LoadEnvFiles(paths...)
+	for _, path := range paths {
+		c.opts.Audit.recordErr("env_file", path, err, sp)
+	}
 	if err != nil {
 		c.errs = append(c.errs, Diagnostic{Severity: "error", Message: err.Error(), Span: sp})
 		return
@@ -245,7 +336,7 @@ func (c *compiler) resolveImports(nodes []Node, baseDir string, seen map[string]
 				continue
 			}
 		}
-		matches, err := resolveSourceFiles(imp.Path, baseDir)
+		matches, err := c.sourceFiles(imp.Path, baseDir)
 		if err != nil {
 			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: err.Error(), Span: imp.Span})
 			continue
@@ -257,12 +348,13 @@ func (c *compiler) resolveImports(nodes []Node, baseDir string, seen map[string]
 				continue
 			}
 			seen[path] = true
-			doc, err := ParsePath(path)
+			doc, err := c.parseSource(path, len(seen))
+			c.opts.Audit.recordErr("include", path, err, imp.Span)
 			if err != nil {
 				c.errs = append(c.errs, Diagnostic{Severity: "error", Message: err.Error(), Span: imp.Span})
 				continue
 			}
-			imported = append(imported, c.resolveImports(doc.Items, filepath.Dir(path), seen)...)
+			imported = append(imported, c.resolveImports(doc.Items, c.sourceDir(path), seen)...)
 			delete(seen, path)
 		}
 		if imp.Alias != "" {
@@ -286,18 +378,20 @@ func (c *compiler) resolveModules(nodes []Node, baseDir string, seen map[string]
 		inputs := blockObject(b, "inputs", c)
 		mod := map[string]any{"id": b.ID, "source": src, "inputs": inputs}
 		c.out.Modules = append(c.out.Modules, mod)
-		if err := c.checkLock(src, baseDir, b.Span); err != nil {
+		// A module declared in an imported file is relative to that file.
+		dir := c.includeDir(b.Span, baseDir)
+		if err := c.checkLock(src, dir, b.Span); err != nil {
 			c.errs = append(c.errs, *err)
 			if c.opts.Strict {
 				out = append(out, n)
 				continue
 			}
 		}
-		if src == "" || isRemoteSource(src) {
+		if src == "" || isRemoteSource(src) && c.opts.IncludeResolver == nil {
 			out = append(out, n)
 			continue
 		}
-		matches, err := resolveModuleFiles(src, baseDir)
+		matches, err := c.moduleFiles(src, dir)
 		if err != nil {
 			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: err.Error(), Span: b.Span})
 			continue
@@ -310,13 +404,14 @@ func (c *compiler) resolveModules(nodes []Node, baseDir string, seen map[string]
 				continue
 			}
 			seen[path] = true
-			doc, err := ParsePath(path)
+			doc, err := c.parseSource(path, len(seen))
+			c.opts.Audit.recordErr("module", path, err, b.Span)
 			if err != nil {
 				c.errs = append(c.errs, Diagnostic{Severity: "error", Message: err.Error(), Span: b.Span})
 				continue
 			}
 			moduleParams = append(moduleParams, collectParamDecls(doc.Items)...)
-			imported = append(imported, c.resolveImports(doc.Items, filepath.Dir(path), seen)...)
+			imported = append(imported, c.resolveImports(doc.Items, c.sourceDir(path), seen)...)
 			delete(seen, path)
 		}
 		c.validateModuleInputs(b, moduleParams, inputs)
@@ -401,7 +496,10 @@ func valueMatchesParamType(v any, typ string) bool {
 
 func (c *compiler) checkLock(source, baseDir string, sp Span) *Diagnostic {
 	if source == "" || c.lock == nil {
-		if isRemoteSource(source) || c.opts.Strict && c.opts.LockfilePath != "" {
+		// Remote sources need a lock entry unless an IncludeResolver serves
+		// them, in which case the resolver is trusted to pin content.
+		remote := isRemoteSource(source) && c.opts.IncludeResolver == nil
+		if remote || c.opts.Strict && c.opts.LockfilePath != "" {
 			return &Diagnostic{Severity: "error", Message: fmt.Sprintf("missing lock entry for %q", source), Span: sp}
 		}
 		return nil
@@ -458,6 +556,10 @@ func (c *compiler) collect(nodes []Node) {
 			if x.Type == "test" && x.ID != "" {
 				c.out.Tests = append(c.out.Tests, c.block(x))
 			}
+			if x.Type == "defaults" && x.ID == "" {
+				c.collectDefaults(x.Body)
+				continue
+			}
 			if x.Type == "set" && x.ID != "" {
 				var vals []Value
 				for _, item := range x.Body {
@@ -495,30 +597,69 @@ func (c *compiler) collect(nodes []Node) {
 			if o, ok := x.Value.(*Object); ok {
 				c.collect(o.Fields)
 			}
+		case *IfChain:
+			// Declarations in the taken branch count; emit reports any
+			// error in the condition.
+			errs := len(c.errs)
+			body := c.selectIfBranch(x)
+			c.errs = c.errs[:errs]
+			c.collect(body)
 		}
 	}
 }
 
-func (c *compiler) emit(nodes []Node, body map[string]any) {
+func (c *compiler) collectDefaults(nodes []Node) {
+	if c.defaults == nil {
+		c.defaults = map[string][]Node{}
+	}
 	for _, n := range nodes {
+		switch x := n.(type) {
+		case *Assignment:
+			if o, ok := x.Value.(*Object); ok {
+				c.defaults[x.Name] = append(c.defaults[x.Name], o.Fields...)
+			}
+		case *Block:
+			c.defaults[x.Type] = append(c.defaults[x.Type], x.Body...)
+		}
+	}
+}
+
+func (c *compiler) emit(nodes []Node, body map[string]any) {
+	for _, n := range c.expandIf(nodes) {
+		if c.skipNode(n) {
+			continue
+		}
 		switch x := n.(type) {
 		case *Assignment:
 			if x.Name == "env_file" || x.Name == "env_files" {
 				continue
 			}
-			setNormalized(body, x.Name, c.assignmentValue(x))
+			var v any
+			if c.decls[x.Name] == x {
+				v = c.declValue(x)
+			} else {
+				v = c.assignmentValue(x)
+			}
+			setAssignment(body, x, v)
+			if x.Name != "_" {
+				c.traceSet(x.Name, v, c.origin("document", x.Span))
+			}
 		case *Block:
 			switch x.Type {
-			case "set", "bcl", "schema", "predicate", "test":
+			case "set", "bcl", "schema", "predicate", "test", "defaults":
 				continue
 			case "namespace":
 				continue
 			default:
-				c.out.Blocks = append(c.out.Blocks, c.block(x))
+				b := c.block(x)
+				c.out.Blocks = append(c.out.Blocks, b)
+				c.blockSpans = append(c.blockSpans, x.Span)
+				c.traceSet(blockPath(b), b["body"], c.origin("document", x.Span))
 			}
 		case *Spread:
 			if merged := c.spreadBody("", x); merged != nil {
 				mergeMap(body, merged)
+				c.traceMerge("", merged, c.origin("document", x.Span))
 			}
 		}
 	}
@@ -545,10 +686,20 @@ func (c *compiler) block(b *Block) map[string]any {
 		out["id"] = b.ID
 	}
 	body := make(map[string]any, len(b.Body))
-	for _, n := range b.Body {
+	for _, n := range c.expandIf(b.Body) {
+		if c.skipNode(n) {
+			continue
+		}
 		switch x := n.(type) {
 		case *Assignment:
-			setNormalized(body, x.Name, c.assignmentValue(x))
+			if _, meta := c.whenMeta(x); meta {
+				continue
+			}
+			if v, ok := c.metaValues[x]; ok {
+				setAssignment(body, x, v)
+				continue
+			}
+			setAssignment(body, x, c.assignmentValue(x))
 		case *Block:
 			key := c.blockCollectionKey(b.Type, x.Type)
 			body[key] = appendBlock(body[key], c.block(x))
@@ -559,10 +710,156 @@ func (c *compiler) block(b *Block) map[string]any {
 		}
 	}
 	out["body"] = body
+	c.applyRole(b.Type, body)
+	c.applyBlockDefaults(b.Type, body)
 	c.applySchemaDefaults(b.Type, body)
 	return out
 }
 
+// applyRole fills body from the role block its role attribute names, so
+// devices of a fleet can share `role "access-switch" { ... }` and keep only
+// their overrides. A role value naming no role block is left as is.
+func (c *compiler) applyRole(blockType string, body map[string]any) {
+	name, ok := body["role"].(string)
+	if !ok || blockType == "role" {
+		return
+	}
+	role := c.blockIndex["role."+name]
+	if role == nil {
+		return
+	}
+	if src, ok := cloneAny(c.block(role)["body"]).(map[string]any); ok {
+		fillDefaults(body, src)
+	}
+}
+
+func (c *compiler) applyBlockDefaults(blockType string, body map[string]any) {
+	nodes := c.defaults[blockType]
+	if len(nodes) == 0 {
+		return
+	}
+	fillDefaults(body, c.nodesToBody(nodes, blockType))
+}
+
+func fillDefaults(dst, src map[string]any) {
+	for k, v := range src {
+		existing, ok := dst[k]
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		if dm, ok := existing.(map[string]any); ok {
+			if sm, ok := v.(map[string]any); ok {
+				fillDefaults(dm, sm)
+			}
+		}
+	}
+}
+
+// expandIf replaces IF/ELSEIF/ELSE chains with the body of the first branch
+// whose condition holds, so callers only ever see plain nodes.
+func (c *compiler) expandIf(nodes []Node) []Node {
+	i := 0
+	for i < len(nodes) {
+		if _, ok := nodes[i].(*IfChain); ok {
+			break
+		}
+		i++
+	}
+	if i == len(nodes) {
+		return nodes
+	}
+	out := append(make([]Node, 0, len(nodes)), nodes[:i]...)
+	for _, n := range nodes[i:] {
+		chain, ok := n.(*IfChain)
+		if !ok {
+			out = append(out, n)
+			continue
+		}
+		out = append(out, c.expandIf(c.selectIfBranch(chain))...)
+	}
+	return out
+}
+
+func (c *compiler) selectIfBranch(chain *IfChain) []Node {
+	for _, branch := range chain.Branches {
+		c.evalOpts.Variables = c.scopeVars(branch.Cond.Raw, branch.Cond.scope, branch.Cond.Span)
+		v, err := EvalExpr(branch.Cond.Raw, &c.evalOpts)
+		if err != nil {
+			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: err.Error(), Span: branch.Cond.Span})
+			return nil
+		}
+		if truthy(v) {
+			return branch.Body
+		}
+	}
+	return chain.Else
+}
+
+// skipNode reports whether n is a block whose `when` or `enabled` is
+// false. A true `enabled` stays in the block as data; `when` never does.
+func (c *compiler) skipNode(n Node) bool {
+	b, ok := n.(*Block)
+	return ok && !c.nodesEnabled(b.Body)
+}
+
+func (c *compiler) nodesEnabled(nodes []Node) bool {
+	for _, n := range nodes {
+		a, ok := n.(*Assignment)
+		if !ok {
+			continue
+		}
+		switch a.Name {
+		case "when":
+			if on, ok := c.whenMeta(a); ok && !on {
+				return false
+			}
+		case "enabled":
+			if enabled, ok := c.metaValue(a).(bool); ok && !enabled {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// whenMeta reports the value of a block's `when` meta-attribute. Only a
+// bool literal or an expression giving a bool is meta; `when { ... }`
+// conditions and other values are block data.
+func (c *compiler) whenMeta(a *Assignment) (on, ok bool) {
+	if a.Name != "when" {
+		return false, false
+	}
+	if !isMetaCondition(a.Value) {
+		return false, false
+	}
+	on, ok = c.metaValue(a).(bool)
+	return on, ok
+}
+
+// metaValue evaluates a `when` or `enabled` attribute once, since both
+// skipNode and block need it.
+func (c *compiler) metaValue(a *Assignment) any {
+	if v, ok := c.metaValues[a]; ok {
+		return v
+	}
+	if c.metaValues == nil {
+		c.metaValues = map[*Assignment]any{}
+	}
+	v := c.value(a.Value)
+	c.metaValues[a] = v
+	return v
+}
+
+func isMetaCondition(v Value) bool {
+	switch v.(type) {
+	case *Condition, *Object, nil:
+		return false
+	default:
+		return true
+	}
+}
+
 func (c *compiler) blockCollectionKey(parentType, childType string) string {
 	if parentType == "" || childType == "" {
 		return childType
@@ -630,10 +927,13 @@ func (c *compiler) spreadBody(currentType string, s *Spread) map[string]any {
 
 func (c *compiler) nodesToBody(nodes []Node, currentType string) map[string]any {
 	body := make(map[string]any, len(nodes))
-	for _, n := range nodes {
+	for _, n := range c.expandIf(nodes) {
+		if c.skipNode(n) {
+			continue
+		}
 		switch x := n.(type) {
 		case *Assignment:
-			setNormalized(body, x.Name, c.assignmentValue(x))
+			setAssignment(body, x, c.assignmentValue(x))
 		case *Block:
 			key := c.blockCollectionKey(currentType, x.Type)
 			body[key] = appendBlock(body[key], c.block(x))
@@ -662,7 +962,8 @@ func (c *compiler) assignmentValue(a *Assignment) any {
 func (c *compiler) valueWithRedact(v Value, sensitive bool) any {
 	switch x := v.(type) {
 	case *Literal:
-		if sensitive || x.Sensitive {
+		if (sensitive || x.Sensitive) && !c.opts.RevealSensitive {
+			c.out.redacted = true
 			return "****"
 		}
 		if x.Type == "string" {
@@ -677,7 +978,7 @@ func (c *compiler) valueWithRedact(v Value, sensitive bool) any {
 		return x.Data
 	case *Reference:
 		if x.Path == "" {
-			return nil
+			return true
 		}
 		switch x.Path {
 		case "CURRENT_TIMESTAMP":
@@ -705,12 +1006,15 @@ func (c *compiler) valueWithRedact(v Value, sensitive bool) any {
 		return out
 	case *Object:
 		m := make(map[string]any, len(x.Fields))
-		for _, n := range x.Fields {
+		for _, n := range c.expandIf(x.Fields) {
+			if c.skipNode(n) {
+				continue
+			}
 			switch y := n.(type) {
 			case *Assignment:
 				// Fast-path common literal/reference assignments inline to avoid
 				// the extra function call and reduce allocations.
-				if strings.IndexByte(y.Name, '.') < 0 {
+				if y.Quoted || strings.IndexByte(y.Name, '.') < 0 {
 					if y.Name == "$expr" {
 						if expr, ok := y.Value.(*Expr); ok {
 							m[y.Name] = map[string]any{"$expr": expr.Raw}
@@ -719,7 +1023,8 @@ func (c *compiler) valueWithRedact(v Value, sensitive bool) any {
 					}
 					switch v := y.Value.(type) {
 					case *Literal:
-						if y.Sensitive || v.Sensitive {
+						if (y.Sensitive || v.Sensitive) && !c.opts.RevealSensitive {
+							c.out.redacted = true
 							m[y.Name] = "****"
 							continue
 						}
@@ -738,7 +1043,7 @@ func (c *compiler) valueWithRedact(v Value, sensitive bool) any {
 						continue
 					case *Reference:
 						if v.Path == "" {
-							m[y.Name] = nil
+							m[y.Name] = true
 							continue
 						}
 						switch v.Path {
@@ -767,9 +1072,11 @@ func (c *compiler) valueWithRedact(v Value, sensitive bool) any {
 						continue
 					}
 					// fallback to general path for complex values
-					m[y.Name] = c.assignmentValue(y)
+					if v := c.assignmentValue(y); y.Name != "_" {
+						m[y.Name] = v
+					}
 				} else {
-					setNormalized(m, y.Name, c.assignmentValue(y))
+					setAssignment(m, y, c.assignmentValue(y))
 				}
 			case *Block:
 				m[y.Type] = appendBlock(m[y.Type], c.block(y))
@@ -781,7 +1088,12 @@ func (c *compiler) valueWithRedact(v Value, sensitive bool) any {
 		}
 		return m
 	case *Expr:
-		c.evalOpts.Variables = c.evalVars()
+		if !c.opts.AllowEnv && exprReadsEnv(x.Raw) {
+			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: "env access requires AllowEnv capability", Span: x.Span})
+			return nil
+		}
+		c.exprSpan = x.Span
+		c.evalOpts.Variables = c.scopeVars(x.Raw, x.scope, x.Span)
 		v, err := EvalExpr(x.Raw, &c.evalOpts)
 		if err != nil {
 			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: err.Error(), Span: x.Span})
@@ -826,6 +1138,14 @@ func predicateRefName(raw string) (string, bool) {
 }
 
 func (c *compiler) call(x *Call) any {
+	if fn := c.funcs[x.Name]; fn != nil {
+		v, err := c.callFunc(fn, c.funcArgs(x))
+		if err != nil {
+			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: err.Error(), Span: x.Span})
+			return nil
+		}
+		return v
+	}
 	switch x.Name {
 	case "env", "env.required", "env.int", "env.bool", "env.float", "env.duration", "env.bytes", "env.list":
 		if !c.opts.AllowEnv {
@@ -848,8 +1168,31 @@ func (c *compiler) call(x *Call) any {
 				return out
 			}
 		}
+	case "path":
+		// path(p) resolves p against the directory of the file that
+		// declares it, so included files keep their own relative paths.
+		if len(x.Args) != 1 {
+			break
+		}
+		p, ok := c.value(x.Args[0]).(string)
+		if !ok {
+			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: "path() requires a string", Span: x.Span})
+			return nil
+		}
+		return absPath(c.declaringDir(x.Span), p)
+	case "csvdecode", "tsvdecode":
+		v, err := c.csvCall(x)
+		if err != nil {
+			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: err.Error(), Span: x.Span})
+			return nil
+		}
+		return v
 	case "sensitive":
 		if len(x.Args) == 1 {
+			if c.opts.RevealSensitive {
+				return c.value(x.Args[0])
+			}
+			c.out.redacted = true
 			return "****"
 		}
 	case "regex", "cidr", "duration", "ip", "url", "email", "bytes":
@@ -867,7 +1210,37 @@ func (c *compiler) call(x *Call) any {
 			return nil
 		}
 		return v
-	case "concat", "lower", "upper", "trim", "coalesce":
+	case "ref":
+		// ref(target) with a bare identifier stays an explicit reference
+		// marker; a string path is resolved against the document.
+		if len(x.Args) != 1 {
+			break
+		}
+		if _, ok := x.Args[0].(*Reference); ok {
+			break
+		}
+		if _, ok := c.opts.EvalFunctions["ref"]; ok {
+			break
+		}
+		path, _ := c.value(x.Args[0]).(string)
+		v, err := c.refValue(path)
+		if err != nil {
+			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: err.Error(), Span: x.Span})
+			return nil
+		}
+		return v
+	case "assert", "fail", "int", "to_int", "float", "to_float", "bool", "to_bool", "str", "string", "to_string", "tostring", "tonumber", "to_number", "range", "seq", "repeat_list", "os", "arch", "pathjoin", "on_windows", "on_linux", "on_darwin", "hex", "parse_mac", "mask_to_prefix", "bit_and", "bit_or", "bit_xor", "bit_not", "bit_shl", "bit_shr":
+		args := make([]any, 0, len(x.Args))
+		for _, a := range x.Args {
+			args = append(args, c.value(a))
+		}
+		v, err := evalCall(x.Name, args, &c.evalOpts)
+		if err != nil {
+			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: err.Error(), Span: x.Span})
+			return nil
+		}
+		return v
+	case "concat", "lower", "upper", "trim", "coalesce", "default", "title", "camel_case", "camelCase", "pascal_case", "PascalCase", "snake_case", "kebab_case", "kebabCase", "slugify", "truncate", "pad_left", "pad_right", "padLeft", "padRight", "random_int", "random_string", "sequence", "semver_parse", "semver_compare", "semver_satisfies":
 		args := make([]any, 0, len(x.Args))
 		for _, a := range x.Args {
 			args = append(args, c.value(a))
@@ -986,6 +1359,15 @@ func (c *compiler) generatedCall(x *Call) (any, error) {
 	}
 }
 
+// optionsGenerator seeds the compile-wide generator from Options.Seed; a zero
+// seed means non-reproducible values.
+func optionsGenerator(opts *Options) *Generator {
+	if opts.Seed != 0 {
+		return NewGenerator(opts.Seed)
+	}
+	return newRandomGenerator()
+}
+
 func randomUUID() (string, error) {
 	var b [16]byte
 	if _, err := rand.Read(b[:]); err != nil {
@@ -1062,10 +1444,53 @@ func (c *compiler) evalVars() map[string]any {
 	vars["sets"] = c.out.Sets
 	vars["context"] = c.opts.Context
 	vars["session"] = c.opts.Session
-	vars["env"] = c.opts.Env
+	if c.opts.AllowEnv {
+		vars["env"] = c.envLookup()
+	}
 	return vars
 }
 
+// envLookup exposes env.X to expressions, recording each read in the audit
+// log against the span of the expression being evaluated.
+func (c *compiler) envLookup() func(string) (string, bool) {
+	if c.opts.Audit == nil && len(c.opts.AllowedEnvKeys) == 0 {
+		return c.opts.Env
+	}
+	if c.envFunc == nil {
+		c.envFunc = func(key string) (string, bool) {
+			if !c.envAllowed(key, c.exprSpan) {
+				return "", false
+			}
+			v, ok := c.opts.Env(key)
+			c.opts.Audit.recordEnv(key, v, ok, c.exprSpan)
+			return v, ok
+		}
+	}
+	return c.envFunc
+}
+
+// envAllowed checks key against Options.AllowedEnvKeys, where a trailing *
+// matches any suffix, so one config can read APP_* but not AWS_SECRET_KEY.
+func (c *compiler) envAllowed(key string, sp Span) bool {
+	if envKeyAllowed(c.opts.AllowedEnvKeys, key) {
+		return true
+	}
+	c.errs = append(c.errs, Diagnostic{Severity: "error", Message: fmt.Sprintf("env %q is not in AllowedEnvKeys", key), Span: sp})
+	return false
+}
+
+func envKeyAllowed(allowlist []string, key string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+	for _, allowed := range allowlist {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok && strings.HasPrefix(key, prefix) || allowed == key {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *compiler) varsMap() map[string]any {
 	cap := len(c.out.Body) + len(c.out.Constants) + 6
 	if c.vars == nil {
@@ -1094,6 +1519,8 @@ func callToExpr(name string, args []any) string {
 		}
 		if s, ok := a.(string); ok {
 			b.WriteString(strconv.Quote(s))
+		} else if a == nil {
+			b.WriteString("null")
 		} else {
 			b.WriteString(sprintValue(a))
 		}
@@ -1112,7 +1539,11 @@ func (c *compiler) envCall(x *Call) any {
 		return nil
 	}
 	key, _ := c.value(x.Args[0]).(string)
+	if !c.envAllowed(key, x.Span) {
+		return nil
+	}
 	val, ok := c.opts.Env(key)
+	c.opts.Audit.recordEnv(key, val, ok, x.Span)
 	if !ok {
 		if x.Name == "env.required" {
 			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: fmt.Sprintf("required env %q is not set", key), Span: x.Span})
@@ -1217,33 +1648,50 @@ func (c *compiler) scopeCall(scopeName string, scope map[string]any, x *Call) an
 	}
 }
 
-func (c *compiler) applyProfile() {
-	if c.opts.Profile == "" {
-		return
+// profileActive reports whether a compiled profile block contributes to the
+// output: either it was selected by name or its active_when rule holds.
+// Several profiles may be active at once; they apply in document order.
+func (c *compiler) profileActive(b map[string]any) bool {
+	if b["type"] != "profile" {
+		return false
 	}
-	for _, b := range c.out.Blocks {
-		if b["type"] == "profile" && b["id"] == c.opts.Profile {
-			if body, ok := b["body"].(map[string]any); ok {
-				for k, v := range body {
-					if k == "override" {
-						continue
-					}
-					c.out.Body[k] = v
+	if c.opts.Profile != "" && b["id"] == c.opts.Profile {
+		return true
+	}
+	body, _ := b["body"].(map[string]any)
+	when, ok := body["active_when"]
+	return ok && truthy(when)
+}
+
+func (c *compiler) applyProfile() {
+	for i, b := range c.out.Blocks {
+		if !c.profileActive(b) {
+			continue
+		}
+		if body, ok := b["body"].(map[string]any); ok {
+			o := c.blockOrigin(i, "profile "+stringValue(b["id"]))
+			for k, v := range body {
+				if k == "override" || k == "active_when" {
+					continue
 				}
+				c.out.Body[k] = v
+				c.traceSet(k, v, o)
 			}
 		}
 	}
 }
 
 func (c *compiler) applyOverrides() {
-	var applyBlockOverride func(target string, body map[string]any)
-	applyBlockOverride = func(target string, body map[string]any) {
+	var applyBlockOverride func(target string, body map[string]any, o ValueOrigin)
+	applyBlockOverride = func(target string, body map[string]any, o ValueOrigin) {
 		parts := strings.SplitN(target, ".", 2)
 		if len(parts) != 2 {
 			if existing, ok := c.out.Body[target].(map[string]any); ok {
 				mergeMap(existing, body)
+				c.traceMerge(target, body, o)
 			} else {
 				c.out.Body[target] = body
+				c.traceSet(target, body, o)
 			}
 			return
 		}
@@ -1251,33 +1699,35 @@ func (c *compiler) applyOverrides() {
 			if block["type"] == parts[0] && block["id"] == parts[1] {
 				if dst, ok := block["body"].(map[string]any); ok {
 					mergeMap(dst, body)
+					c.traceMerge(target, body, o)
 				}
 			}
 		}
 	}
-	for _, block := range c.out.Blocks {
+	for i, block := range c.out.Blocks {
 		if block["type"] == "override" {
 			if body, ok := block["body"].(map[string]any); ok {
 				if id, ok := block["id"].(string); ok {
-					applyBlockOverride(id, body)
+					applyBlockOverride(id, body, c.blockOrigin(i, "override "+id))
 				} else {
 					mergeMap(c.out.Body, body)
+					c.traceMerge("", body, c.blockOrigin(i, "override"))
 				}
 			}
 		}
 	}
-	if c.opts.Profile != "" {
-		for _, block := range c.out.Blocks {
-			if block["type"] == "profile" && block["id"] == c.opts.Profile {
-				if body, ok := block["body"].(map[string]any); ok {
-					if overrides, ok := body["override"].([]any); ok {
-						for _, raw := range overrides {
-							if ov, ok := raw.(map[string]any); ok {
-								id, _ := ov["id"].(string)
-								b, _ := ov["body"].(map[string]any)
-								applyBlockOverride(id, b)
-							}
-						}
+	for i, block := range c.out.Blocks {
+		if !c.profileActive(block) {
+			continue
+		}
+		if body, ok := block["body"].(map[string]any); ok {
+			if overrides, ok := body["override"].([]any); ok {
+				o := c.blockOrigin(i, "profile "+stringValue(block["id"]))
+				for _, raw := range overrides {
+					if ov, ok := raw.(map[string]any); ok {
+						id, _ := ov["id"].(string)
+						b, _ := ov["body"].(map[string]any)
+						applyBlockOverride(id, b, o)
 					}
 				}
 			}
@@ -1287,6 +1737,8 @@ func (c *compiler) applyOverrides() {
 	for _, block := range c.out.Blocks {
 		if block["type"] != "override" {
 			filtered = append(filtered, block)
+		} else {
+			c.traceDrop(blockPath(block))
 		}
 	}
 	c.out.Blocks = filtered
@@ -1302,6 +1754,7 @@ func schemaToMap(s *SchemaDecl, c *compiler) any {
 	}
 	m := make(map[string]any, count)
 	m["fields"] = schemaFieldsToMaps(s.Fields, c)
+	schemaCompositionToMap(m, s)
 	if len(s.Options) > 0 {
 		options := make(map[string]any, len(s.Options))
 		for _, key := range sortedValueKeys(s.Options) {
@@ -1352,6 +1805,13 @@ func schemaFieldToMap(f SchemaField, c *compiler) map[string]any {
 		}
 		m["enum"] = vals
 	}
+	if len(f.SubsetOf) > 0 {
+		vals := make([]any, len(f.SubsetOf))
+		for i, v := range f.SubsetOf {
+			vals[i] = c.value(v)
+		}
+		m["subset_of"] = vals
+	}
 	if len(f.Fields) > 0 {
 		m["fields"] = schemaFieldsToMaps(f.Fields, c)
 	}
@@ -1441,6 +1901,9 @@ func schemaFieldToMap(f SchemaField, c *compiler) map[string]any {
 	if f.Format != "" {
 		m["format"] = f.Format
 	}
+	if len(f.Schemes) > 0 {
+		m["schemes"] = append([]string(nil), f.Schemes...)
+	}
 	if f.ContentEncoding != "" {
 		m["content_encoding"] = f.ContentEncoding
 	}
@@ -1457,6 +1920,9 @@ func schemaFieldToMap(f SchemaField, c *compiler) map[string]any {
 	if f.PatternProperties != nil {
 		m["pattern_properties"] = c.value(f.PatternProperties)
 	}
+	if f.KeyPattern != "" {
+		m["key_pattern"] = f.KeyPattern
+	}
 	if f.DependentRequired != nil {
 		m["dependent_required"] = c.value(f.DependentRequired)
 	}
@@ -1551,13 +2017,13 @@ func CompileBytes(src []byte, opts *Options) (*Normalized, error) {
 }
 
 func CompileFile(path string, opts *Options) (*Normalized, error) {
-	doc, err := ParsePath(path)
-	if err != nil {
-		return nil, err
-	}
 	if opts == nil {
 		opts = &Options{}
 	}
+	doc, err := opts.ASTCache.ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
 	opts.BaseDir = filepath.Dir(path)
 	return Compile(doc, opts)
 }
@@ -1571,6 +2037,7 @@ func ResolveDocument(doc *Document, opts *Options) (*Document, []Diagnostic) {
 	}
 	c := &compiler{
 		opts:        opts,
+		file:        doc.File,
 		out:         &Normalized{},
 		consts:      map[string]Value{},
 		sets:        map[string][]Value{},
@@ -1713,7 +2180,26 @@ func cloneAny(v any) any {
 	}
 }
 
+// setNormalized stores value under a possibly dotted key. The blank key "_"
+// is evaluated for its side effects, such as assert(), and then discarded.
+// setAssignment stores a's value in dst. Quoted names such as "10.0.0.1"
+// are one literal key; bare dotted names nest like setNormalized.
+func setAssignment(dst map[string]any, a *Assignment, value any) {
+	if !a.Quoted {
+		setNormalized(dst, a.Name, value)
+		return
+	}
+	if existing, ok := dst[a.Name]; ok {
+		dst[a.Name] = appendBlock(existing, value)
+		return
+	}
+	dst[a.Name] = value
+}
+
 func setNormalized(dst map[string]any, key string, value any) {
+	if key == "_" {
+		return
+	}
 	if dot := strings.IndexByte(key, '.'); dot >= 0 {
 		cur := dst
 		start := 0
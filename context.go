@@ -0,0 +1,83 @@
+package bcl
+
+import (
+	"context"
+	"fmt"
+)
+
+// CompileContext is Compile bounded by ctx. Imports, loops, function calls
+// and IncludeResolverContext fetches stop once ctx is done, and ctx.Err()
+// is returned in place of a partial result.
+func CompileContext(ctx context.Context, doc *Document, opts *Options) (*Normalized, error) {
+	n, err := Compile(doc, withContext(ctx, opts))
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return n, err
+}
+
+// CompileBytesContext is CompileBytes bounded by ctx.
+func CompileBytesContext(ctx context.Context, src []byte, opts *Options) (*Normalized, error) {
+	doc, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return CompileContext(ctx, doc, opts)
+}
+
+// CompileFileContext is CompileFile bounded by ctx.
+func CompileFileContext(ctx context.Context, path string, opts *Options) (*Normalized, error) {
+	n, err := CompileFile(path, withContext(ctx, opts))
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return n, err
+}
+
+// EvalExprContext is EvalExpr bounded by ctx: function calls fail with
+// ctx.Err() once ctx is done.
+func EvalExprContext(ctx context.Context, raw string, opts *EvalOptions) (any, error) {
+	o := EvalOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	o.ctx = ctx
+	return EvalExpr(raw, &o)
+}
+
+// withContext returns a copy of opts carrying ctx, leaving the caller's
+// options untouched.
+func withContext(ctx context.Context, opts *Options) *Options {
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	o.ctx = ctx
+	return &o
+}
+
+// context returns the context the options were compiled under, or
+// context.Background().
+func (o *Options) context() context.Context {
+	if o != nil && o.ctx != nil {
+		return o.ctx
+	}
+	return context.Background()
+}
+
+// canceled reports whether the compilation's context is done, recording
+// the error once.
+func (c *compiler) canceled(sp Span) bool {
+	if c.opts.ctx == nil {
+		return false
+	}
+	err := c.opts.ctx.Err()
+	if err == nil {
+		return false
+	}
+	if !c.ctxDone {
+		c.ctxDone = true
+		c.errs = append(c.errs, Diagnostic{Severity: "error", Message: fmt.Sprintf("compile stopped: %v", err), Span: sp})
+	}
+	return true
+}
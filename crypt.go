@@ -0,0 +1,159 @@
+package bcl
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// encryptedHeader starts every encrypted envelope. The full header line is
+// "BCL-ENCRYPTED v1 <key-id>" and is authenticated together with the
+// AES-256-GCM ciphertext, which follows as base64 (nonce || sealed data).
+const encryptedHeader = "BCL-ENCRYPTED v1"
+
+// KeyProvider returns the 32-byte AES-256 key for a key id recorded in an
+// encrypted envelope.
+type KeyProvider interface {
+	Key(keyID string) ([]byte, error)
+}
+
+type KeyProviderFunc func(keyID string) ([]byte, error)
+
+func (f KeyProviderFunc) Key(keyID string) ([]byte, error) { return f(keyID) }
+
+// StaticKey serves the same key for every key id.
+func StaticKey(key []byte) KeyProvider {
+	return KeyProviderFunc(func(string) ([]byte, error) { return key, nil })
+}
+
+// EnvKey reads a base64 encoded key from the named environment variable.
+func EnvKey(name string) KeyProvider {
+	return KeyProviderFunc(func(string) ([]byte, error) {
+		raw, ok := os.LookupEnv(name)
+		if !ok || raw == "" {
+			return nil, fmt.Errorf("encryption key variable %s is not set", name)
+		}
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(raw))
+	})
+}
+
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedHeader+" ")) || bytes.HasPrefix(data, []byte(encryptedHeader+"\n"))
+}
+
+func Encrypt(plaintext []byte, keyID string, key []byte) ([]byte, error) {
+	if strings.ContainsAny(keyID, " \r\n") {
+		return nil, fmt.Errorf("encryption key id %q must not contain whitespace", keyID)
+	}
+	gcm, err := newEnvelopeCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	header := encryptedHeader
+	if keyID != "" {
+		header += " " + keyID
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, []byte(header))
+	var b bytes.Buffer
+	b.WriteString(header)
+	b.WriteByte('\n')
+	enc := base64.StdEncoding.EncodeToString(sealed)
+	for len(enc) > 76 {
+		b.WriteString(enc[:76])
+		b.WriteByte('\n')
+		enc = enc[76:]
+	}
+	b.WriteString(enc)
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// ErrNotEncrypted is returned by Decrypt for input without an envelope.
+var ErrNotEncrypted = errors.New("bcl: config is not encrypted")
+
+// Decrypt opens an envelope produced by Encrypt. Plain input fails with
+// ErrNotEncrypted; callers that accept both forms check IsEncrypted first.
+func Decrypt(data []byte, keys KeyProvider) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, ErrNotEncrypted
+	}
+	if keys == nil {
+		return nil, fmt.Errorf("bcl: encrypted config requires a key provider")
+	}
+	header, body, _ := bytes.Cut(data, []byte("\n"))
+	header = bytes.TrimRight(header, "\r")
+	keyID := strings.TrimSpace(strings.TrimPrefix(string(header), encryptedHeader))
+	key, err := keys.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newEnvelopeCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		return nil, fmt.Errorf("bcl: malformed encrypted config: %w", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("bcl: malformed encrypted config: ciphertext too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], header)
+	if err != nil {
+		return nil, fmt.Errorf("bcl: cannot decrypt config with key %q: %w", keyID, err)
+	}
+	return plain, nil
+}
+
+func ReadEncryptedFile(path string, keys KeyProvider) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decrypt(data, keys)
+}
+
+// UnmarshalEncrypted decrypts the envelope at path and decodes the config
+// into v with opts, which may be nil. A plain file is refused unless
+// opts.AllowPlaintext is set. BaseDir defaults to the directory of path.
+func UnmarshalEncrypted(path string, v any, keys KeyProvider, opts *Options) error {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if IsEncrypted(data) || !o.AllowPlaintext {
+		if data, err = Decrypt(data, keys); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if o.BaseDir == "" {
+		o.BaseDir = filepath.Dir(path)
+	}
+	return UnmarshalWithOptions(data, v, &o)
+}
+
+func newEnvelopeCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,93 @@
+package bcl
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+)
+
+// declaringDir returns the directory of the file that declares sp, or BaseDir
+// for inline sources.
+func (c *compiler) declaringDir(sp Span) string {
+	if sp.File != "" && sp.File != "<input>" {
+		return c.sourceDir(sp.File)
+	}
+	return c.opts.BaseDir
+}
+
+// csvCall evaluates csvdecode(src, header?) and tsvdecode(src, header?).
+// src is CSV text when it contains a newline and otherwise a file path
+// relative to the declaring file. With a header row (the default) each
+// record becomes a map keyed by column name; header false returns lists.
+// Cells stay strings.
+func (c *compiler) csvCall(x *Call) (any, error) {
+	if len(x.Args) < 1 || len(x.Args) > 2 {
+		return nil, fmt.Errorf("%s requires 1 or 2 arguments", x.Name)
+	}
+	src, ok := c.value(x.Args[0]).(string)
+	if !ok {
+		return nil, fmt.Errorf("%s requires a string or file path", x.Name)
+	}
+	header := true
+	if len(x.Args) == 2 {
+		if header, ok = c.value(x.Args[1]).(bool); !ok {
+			return nil, fmt.Errorf("%s header argument must be a bool", x.Name)
+		}
+	}
+	data := []byte(src)
+	if !strings.Contains(src, "\n") {
+		if c.opts.DisableLocalDataFiles && c.opts.FS == nil && c.opts.IncludeResolver == nil {
+			return nil, fmt.Errorf("%s: reading local file %q is disabled", x.Name, src)
+		}
+		files, err := c.sourceFiles(src, c.declaringDir(x.Span))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", x.Name, err)
+		}
+		if data, err = c.readSource(files[0]); err != nil {
+			return nil, fmt.Errorf("%s: %w", x.Name, err)
+		}
+	}
+	comma := ','
+	if x.Name == "tsvdecode" {
+		comma = '\t'
+	}
+	rows, err := decodeCSV(data, comma, header)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", x.Name, err)
+	}
+	return rows, nil
+}
+
+func decodeCSV(data []byte, comma rune, header bool) ([]any, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	r.Comma = comma
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]any, 0, len(records))
+	if !header {
+		for _, rec := range records {
+			row := make([]any, len(rec))
+			for i, cell := range rec {
+				row[i] = cell
+			}
+			out = append(out, row)
+		}
+		return out, nil
+	}
+	if len(records) == 0 {
+		return out, nil
+	}
+	cols := records[0]
+	for _, rec := range records[1:] {
+		row := make(map[string]any, len(cols))
+		for i, col := range cols {
+			row[col] = rec[i]
+		}
+		out = append(out, row)
+	}
+	return out, nil
+}
@@ -1920,14 +1920,16 @@ func decisionEvalOptions(opts *Options, program *DecisionProgram, input map[stri
 
 func decisionEvalFunctions(opts *Options) map[string]EvalFunction {
 	var funcs map[string]EvalFunction
-	decisionFunctions.RLock()
-	if len(decisionFunctions.m) > 0 {
-		funcs = make(map[string]EvalFunction, len(decisionFunctions.m))
-		for k, v := range decisionFunctions.m {
-			funcs[k] = v
+	if opts == nil || !opts.isolated {
+		decisionFunctions.RLock()
+		if len(decisionFunctions.m) > 0 {
+			funcs = make(map[string]EvalFunction, len(decisionFunctions.m))
+			for k, v := range decisionFunctions.m {
+				funcs[k] = v
+			}
 		}
+		decisionFunctions.RUnlock()
 	}
-	decisionFunctions.RUnlock()
 	if opts != nil && len(opts.EvalFunctions) > 0 {
 		if funcs == nil {
 			funcs = make(map[string]EvalFunction, len(opts.EvalFunctions))
@@ -2471,7 +2473,7 @@ func evaluateDecisionRank(program *DecisionProgram, decision string, vars, input
 	if ranking == nil {
 		return nil
 	}
-	ctx := context.Background()
+	ctx := opts.context()
 	candidates, iterator, err := candidateSourceFor(ctx, program, ranking, input, opts)
 	if err != nil {
 		result.Diagnostics = append(result.Diagnostics, Diagnostic{Severity: "error", Message: err.Error(), Span: ranking.Span})
@@ -3612,7 +3614,7 @@ func EvaluateDecisionDataset(program *DecisionProgram, decisionID, datasetID str
 	if decisionID == "" {
 		return nil, fmt.Errorf("missing decision id")
 	}
-	ctx := context.Background()
+	ctx := opts.context()
 	it, err := OpenDecisionDataset(ctx, program, datasetID, opts)
 	if err != nil {
 		return nil, err
@@ -3738,7 +3740,7 @@ func CompareDecisionDataset(base, candidate *DecisionProgram, decisionID, datase
 	if base.Datasets[datasetID] == nil && candidate != nil {
 		sourceProgram = candidate
 	}
-	ctx := context.Background()
+	ctx := opts.context()
 	it, err := OpenDecisionDataset(ctx, sourceProgram, datasetID, opts)
 	if err != nil {
 		return nil, err
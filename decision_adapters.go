@@ -91,11 +91,13 @@ func decisionDatasetAdapterFor(kind string, opts *Options) (DecisionDatasetAdapt
 			return adapter, true
 		}
 	}
-	decisionDatasetAdapters.RLock()
-	adapter := decisionDatasetAdapters.m[kind]
-	decisionDatasetAdapters.RUnlock()
-	if adapter != nil {
-		return adapter, true
+	if opts == nil || !opts.isolated {
+		decisionDatasetAdapters.RLock()
+		adapter := decisionDatasetAdapters.m[kind]
+		decisionDatasetAdapters.RUnlock()
+		if adapter != nil {
+			return adapter, true
+		}
 	}
 	switch kind {
 	case "inline":
@@ -145,6 +147,9 @@ func openFileDecisionDataset(ctx context.Context, source DatasetSource, opts *Op
 		path = filepath.Join(opts.BaseDir, path)
 	}
 	f, err := os.Open(path)
+	if opts != nil {
+		opts.Audit.recordErr("file", path, err, Span{})
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -197,39 +202,75 @@ func openHTTPDecisionDataset(ctx context.Context, source DatasetSource, opts *Op
 	} else if timeout := durationValue(source.Config["timeout"]); timeout > 0 {
 		client = &http.Client{Timeout: timeout}
 	}
-	resp, err := client.Do(req)
-	if err != nil {
+	fetch := func() (io.ReadCloser, error) {
+		resp, err := client.Do(req)
+		if opts != nil && opts.Audit != nil {
+			ev := AuditEvent{Kind: "http", Target: url, Args: []any{method}}
+			if err != nil {
+				ev.Error = err.Error()
+			} else {
+				ev.Result = resp.Status
+			}
+			opts.Audit.Record(ev)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !statusAllowed(resp.StatusCode, intListAny(source.Config["expect_status"])) {
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("http dataset source returned %s", resp.Status)
+		}
+		return resp.Body, nil
+	}
+	var respBody io.ReadCloser
+	if ttl := durationValue(source.Config["cache"]); ttl > 0 && opts != nil && opts.Cache != nil {
+		key := method + " " + url + "\n" + fmt.Sprint(source.Config["headers"]) + "\n" + fmt.Sprint(source.Config["body"])
+		data, err := opts.Cache.Fetch(key, ttl, func() ([]byte, error) {
+			rc, err := fetch()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return io.ReadAll(rc)
+		})
+		if err != nil {
+			return nil, err
+		}
+		respBody = io.NopCloser(bytes.NewReader(data))
+	} else if respBody, err = fetch(); err != nil {
 		return nil, err
 	}
-	if !statusAllowed(resp.StatusCode, intListAny(source.Config["expect_status"])) {
-		_ = resp.Body.Close()
-		return nil, fmt.Errorf("http dataset source returned %s", resp.Status)
-	}
 	format := strings.ToLower(firstNonEmpty(scalarString(source.Config["format"]), "json"))
 	if responsePath := scalarString(firstNonNil(source.Config["response_path"], source.Config["records_path"])); responsePath != "" {
-		defer resp.Body.Close()
+		defer respBody.Close()
 		var payload any
-		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		if err := json.NewDecoder(respBody).Decode(&payload); err != nil {
 			return nil, err
 		}
 		return &sliceDecisionIterator{records: candidatesFromAny(lookupAny(payload, responsePath), source)}, nil
 	}
 	switch format {
 	case "jsonl", "ndjson":
-		return newJSONLDecisionIterator(resp.Body, source), nil
+		return newJSONLDecisionIterator(respBody, source), nil
 	case "json", "":
-		return newJSONDecisionIterator(resp.Body, source)
+		return newJSONDecisionIterator(respBody, source)
 	default:
-		_ = resp.Body.Close()
+		_ = respBody.Close()
 		return nil, fmt.Errorf("unsupported http dataset format %q", format)
 	}
 }
 
 func datasetAdapterAllowed(adapter string, opts *Options) bool {
-	if opts == nil || len(opts.AllowedDatasetAdapters) == 0 {
+	if opts == nil {
 		return true
 	}
 	adapter = strings.ToLower(strings.TrimSpace(adapter))
+	if opts.DisableRemoteInclude && (adapter == "http" || adapter == "https") {
+		return false
+	}
+	if len(opts.AllowedDatasetAdapters) == 0 {
+		return true
+	}
 	for _, allowed := range opts.AllowedDatasetAdapters {
 		if strings.EqualFold(strings.TrimSpace(allowed), adapter) {
 			return true
@@ -278,6 +278,52 @@ func TestDecisionDatasetHTTPAdapter(t *testing.T) {
 	}
 }
 
+func TestDecisionDatasetHTTPAdapterCachesResponses(t *testing.T) {
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		fmt.Fprint(w, `[{"id":"high","request":{"amount":20}}]`)
+	}))
+	defer server.Close()
+	doc, err := Parse([]byte(fmt.Sprintf(`module "http-cache-test" {
+  decision_table "demo" {
+    default deny
+    hit_policy first
+    row "allow-high" { when { request.amount > 10 } then { decision allow } }
+  }
+  dataset "http_batch" {
+    source {
+      adapter http
+      url "%s"
+      cache "10m"
+    }
+  }
+}`, server.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := CompileDecisionDocument(doc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{Cache: NewResultCache(t.TempDir())}
+	for i := 0; i < 3; i++ {
+		report, err := EvaluateDecisionDataset(prog, "demo", "http_batch", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.EffectCounts["allow"] != 1 {
+			t.Fatalf("run %d report = %#v", i, report.EffectCounts)
+		}
+	}
+	if hits != 1 {
+		t.Fatalf("expected one upstream request, got %d", hits)
+	}
+	if _, err := EvaluateDecisionDataset(prog, "demo", "http_batch", nil); err != nil || hits != 2 {
+		t.Fatalf("uncached run: hits=%d err=%v", hits, err)
+	}
+}
+
 func TestDecisionDatasetCustomAdapterAndRanking(t *testing.T) {
 	doc, err := Parse([]byte(`module "custom-adapter-test" {
   decision_table "route" {
@@ -388,6 +434,54 @@ func TestDecisionRegisteredExternalFunctionInRankingCondition(t *testing.T) {
 	}
 }
 
+func TestRuntimeScopesRegistrations(t *testing.T) {
+	RegisterDecisionFunction("runtime_scope_global_for_test", func(args []any, _ *EvalOptions) (any, error) {
+		return "ok", nil
+	})
+	rt := NewRuntime()
+	rt.RegisterFunction("runtime_scope_ready_for_test", func(args []any, _ *EvalOptions) (any, error) {
+		if args[0] == "yes" {
+			return "ok", nil
+		}
+		return "no", nil
+	})
+	src := `module "runtime-scope-test" {
+  decision_table "gate" {
+    default deny
+    hit_policy first
+    row "allow" { when { %s(request.ready) == "ok" } then { decision allow } }
+  }
+}`
+	decide := func(fn string, rt *Runtime) (*DecisionResult, error) {
+		doc, err := Parse([]byte(fmt.Sprintf(src, fn)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := &Options{}
+		if rt != nil {
+			opts = rt.Options(opts)
+		}
+		prog, err := CompileDecisionDocument(doc, opts)
+		if err != nil {
+			return nil, err
+		}
+		return EvaluateDecision(prog, "gate", map[string]any{"request": map[string]any{"ready": "yes"}}, opts)
+	}
+	if res, err := decide("runtime_scope_ready_for_test", rt); err != nil || res.Effect != "allow" {
+		t.Fatalf("runtime function: %#v, %v", res, err)
+	}
+	if res, err := decide("runtime_scope_ready_for_test", nil); err == nil && res.Effect == "allow" {
+		t.Fatal("runtime function leaked into the global registry")
+	}
+	if res, err := decide("runtime_scope_global_for_test", rt); err == nil && res.Effect == "allow" {
+		t.Fatal("runtime saw a global registration")
+	}
+	n, err := rt.CompileBytes([]byte("ready = runtime_scope_ready_for_test(\"yes\") + \"!\"\n"), nil)
+	if err != nil || n.Body["ready"] != "ok!" {
+		t.Fatalf("compile with runtime: %#v, %v", n, err)
+	}
+}
+
 func TestDecisionPlatformReport(t *testing.T) {
 	prog, err := CompileDecisionFile("examples/bcl_decision_platform/use_cases/iam-access/decision.bcl", &Options{AllowTime: true})
 	if err != nil {
@@ -0,0 +1,77 @@
+package bcl
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A DecodeHook converts a compiled value (a string, number, bool, list or
+// map) into a Go type Unmarshal does not decode on its own, such as
+// url.URL or net.IPNet. Hooks are keyed by target type in
+// Options.DecodeHooks and run before the built-in rules, including
+// encoding.TextUnmarshaler, so a hook can also change how a type decodes.
+// The value returned must be assignable or convertible to the type; a hook
+// for *T also serves fields of type T.
+type DecodeHook func(src any) (any, error)
+
+// AddDecodeHook registers fn on opts for targets of type T:
+//
+//	bcl.AddDecodeHook(opts, func(src any) (*url.URL, error) {
+//		return url.Parse(fmt.Sprint(src))
+//	})
+func AddDecodeHook[T any](opts *Options, fn func(src any) (T, error)) {
+	if opts.DecodeHooks == nil {
+		opts.DecodeHooks = map[reflect.Type]DecodeHook{}
+	}
+	opts.DecodeHooks[reflect.TypeFor[T]()] = func(src any) (any, error) { return fn(src) }
+}
+
+// hook returns the hook for t, and whether it was registered for *t and so
+// returns a pointer to dereference.
+func (d goDecoder) hook(t reflect.Type) (DecodeHook, bool, bool) {
+	if h, ok := d.hooks[t]; ok {
+		return h, false, true
+	}
+	if t.Kind() != reflect.Pointer {
+		if h, ok := d.hooks[reflect.PointerTo(t)]; ok {
+			return h, true, true
+		}
+	}
+	return nil, false, false
+}
+
+func (d goDecoder) applyHook(dst reflect.Value, h DecodeHook, deref bool, src any) error {
+	out, err := h(unwrapTypedScalar(src))
+	if err != nil {
+		return fmt.Errorf("bcl: decode %s: %w", d.describe(dst.Type()), err)
+	}
+	rv := reflect.ValueOf(out)
+	if deref && rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			dst.SetZero()
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	switch {
+	case !rv.IsValid():
+		dst.SetZero()
+	case rv.Type().AssignableTo(dst.Type()):
+		dst.Set(rv)
+	case rv.Type().ConvertibleTo(dst.Type()):
+		dst.Set(rv.Convert(dst.Type()))
+	default:
+		return fmt.Errorf("bcl: decode %s: hook returned %T", d.describe(dst.Type()), out)
+	}
+	return nil
+}
+
+// describe names the field being decoded for errors, or its type at the top
+// level.
+func (d goDecoder) describe(t reflect.Type) string {
+	if len(d.path) == 0 {
+		return t.String()
+	}
+	return fmt.Sprintf("%q", strings.Join(d.path, "."))
+}
@@ -0,0 +1,148 @@
+package bcl
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ConfigChange is one dotted key that differs between two compilations. Op
+// is "added", "removed" or "modified"; lists are compared as a whole.
+type ConfigChange struct {
+	Op  string `json:"op"`
+	Key string `json:"key"`
+	Old any    `json:"old,omitempty"`
+	New any    `json:"new,omitempty"`
+}
+
+// ConfigChanges is a key-ordered set of changes.
+type ConfigChanges []ConfigChange
+
+// DiffConfigs reports the leaf keys that differ between old and new. Blocks
+// are keyed as type.id ("server.web.port"), like Provenance. A nil old
+// reports every key of new as added.
+func DiffConfigs(old, new *Normalized) ConfigChanges {
+	return diffFlattened(flattenConfig(old), flattenConfig(new))
+}
+
+// DiffBodies is DiffConfigs for two block bodies or other plain maps, with
+// keys relative to the maps.
+func DiffBodies(old, new map[string]any) ConfigChanges {
+	before, after := map[string]any{}, map[string]any{}
+	flattenConfigMap(before, "", old)
+	flattenConfigMap(after, "", new)
+	return diffFlattened(before, after)
+}
+
+// CompareBound reports how running, a config struct (or pointer to one)
+// bound by a live process, differs from the file at path decoded into a
+// fresh value of the same type with opts. Both sides go through Marshal
+// and Compile, so only the fields the type binds are compared and keys
+// the struct does not declare never show as drift. Old holds the file's
+// values and New the running ones.
+func CompareBound(running any, path string, opts *Options) (ConfigChanges, error) {
+	rv := reflect.ValueOf(running)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("bcl: CompareBound needs a non-nil value")
+		}
+		rv = rv.Elem()
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	disk := reflect.New(rv.Type())
+	if err := UnmarshalWithOptions(src, disk.Interface(), opts); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	before, err := compileBound(disk.Elem())
+	if err != nil {
+		return nil, err
+	}
+	after, err := compileBound(rv)
+	if err != nil {
+		return nil, err
+	}
+	return DiffConfigs(before, after), nil
+}
+
+func compileBound(v reflect.Value) (*Normalized, error) {
+	data, err := Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	doc, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(doc, nil)
+}
+
+func diffFlattened(before, after map[string]any) ConfigChanges {
+	var out ConfigChanges
+	for k, nv := range after {
+		ov, ok := before[k]
+		switch {
+		case !ok:
+			out = append(out, ConfigChange{Op: "added", Key: k, New: nv})
+		case !reflect.DeepEqual(ov, nv):
+			out = append(out, ConfigChange{Op: "modified", Key: k, Old: ov, New: nv})
+		}
+	}
+	for k, ov := range before {
+		if _, ok := after[k]; !ok {
+			out = append(out, ConfigChange{Op: "removed", Key: k, Old: ov})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// Keys returns the changed keys in order.
+func (cs ConfigChanges) Keys() []string {
+	keys := make([]string, len(cs))
+	for i, c := range cs {
+		keys[i] = c.Key
+	}
+	return keys
+}
+
+// Matches reports whether a change falls under one of patterns. Patterns
+// use the Options.Only syntax, so "database" and "database.*" both match
+// "database.pool.size".
+func (cs ConfigChanges) Matches(patterns ...string) bool {
+	pats := splitPatterns(patterns)
+	for _, c := range cs {
+		if patternsCover(pats, strings.Split(c.Key, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+func flattenConfig(n *Normalized) map[string]any {
+	out := map[string]any{}
+	if n == nil {
+		return out
+	}
+	flattenConfigMap(out, "", n.Body)
+	for _, b := range n.Blocks {
+		body, _ := b["body"].(map[string]any)
+		flattenConfigMap(out, blockPath(b), body)
+	}
+	return out
+}
+
+func flattenConfigMap(out map[string]any, prefix string, m map[string]any) {
+	for k, v := range m {
+		key := joinPath(prefix, k)
+		if child, ok := v.(map[string]any); ok && len(child) > 0 {
+			flattenConfigMap(out, key, child)
+			continue
+		}
+		out[key] = v
+	}
+}
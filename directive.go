@@ -0,0 +1,170 @@
+package bcl
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DirectiveFunc expands a statement-level directive such as
+//
+//	@vault("secret/db") {
+//	  field "password"
+//	}
+//
+// into the nodes that take its place, letting domain packages add their
+// own forms without changing the parser. args are d.Args compiled as
+// values; directives expand before consts and blocks are collected, so
+// arguments should be literals or calls such as env().
+type DirectiveFunc func(d *Directive, args []any, opts *Options) ([]Node, error)
+
+var directives = struct {
+	sync.RWMutex
+	m map[string]DirectiveFunc
+}{m: map[string]DirectiveFunc{}}
+
+// RegisterDirective makes @name(...) available to every compilation that
+// does not use a Runtime.
+func RegisterDirective(name string, fn DirectiveFunc) {
+	if name == "" || fn == nil {
+		return
+	}
+	directives.Lock()
+	defer directives.Unlock()
+	directives.m[name] = fn
+}
+
+// CacheDirective wraps fn so its expansion is kept in Options.Cache for
+// ttl, keyed by the directive's name, arguments and body. Directives that
+// run commands or fetch data, such as a host's @exec, can be registered
+// wrapped so watch mode and concurrent processes reuse one result instead
+// of running them again. Without Options.Cache fn runs every time.
+func CacheDirective(fn DirectiveFunc, ttl time.Duration) DirectiveFunc {
+	return func(d *Directive, args []any, opts *Options) ([]Node, error) {
+		if opts == nil || opts.Cache == nil || ttl <= 0 {
+			return fn(d, args, opts)
+		}
+		body, err := FormatDocument(&Document{Items: d.Body})
+		if err != nil {
+			return fn(d, args, opts)
+		}
+		key := fmt.Sprintf("directive %s @%s %#v\n%s", astCacheFormat, d.Name, args, body)
+		data, err := opts.Cache.Fetch(key, ttl, func() ([]byte, error) {
+			nodes, err := fn(d, args, opts)
+			if err != nil {
+				return nil, err
+			}
+			var buf bytes.Buffer
+			err = gob.NewEncoder(&buf).Encode(nodes)
+			return buf.Bytes(), err
+		})
+		if err != nil {
+			return nil, err
+		}
+		var nodes []Node
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&nodes); err != nil {
+			return nil, err
+		}
+		return nodes, nil
+	}
+}
+
+func directiveFor(name string, opts *Options) DirectiveFunc {
+	if fn := opts.Directives[name]; fn != nil {
+		return fn
+	}
+	if opts.isolated {
+		return nil
+	}
+	directives.RLock()
+	defer directives.RUnlock()
+	return directives.m[name]
+}
+
+// expandDirectives replaces the directives in nodes, at any depth, with
+// their expansions. Blocks and objects holding one are copied so the
+// caller's document is left as parsed.
+func (c *compiler) expandDirectives(nodes []Node) []Node {
+	if !hasDirective(nodes) {
+		return nodes
+	}
+	out := make([]Node, 0, len(nodes))
+	for _, n := range nodes {
+		switch x := n.(type) {
+		case *Directive:
+			if c.opts.DisableExec {
+				c.errs = append(c.errs, Diagnostic{Severity: "error", Message: fmt.Sprintf("directive @%s is disabled", x.Name), Span: x.Span})
+				continue
+			}
+			fn := directiveFor(x.Name, c.opts)
+			if fn == nil {
+				c.errs = append(c.errs, Diagnostic{Severity: "error", Message: fmt.Sprintf("unknown directive @%s", x.Name), Span: x.Span})
+				continue
+			}
+			args := make([]any, len(x.Args))
+			for i, a := range x.Args {
+				args[i] = c.value(a)
+			}
+			expanded, err := fn(x, args, c.opts)
+			if err != nil {
+				c.errs = append(c.errs, Diagnostic{Severity: "error", Message: fmt.Sprintf("@%s: %v", x.Name, err), Span: x.Span})
+				continue
+			}
+			out = append(out, c.expandDirectives(expanded)...)
+		case *Block:
+			cp := *x
+			cp.Body = c.expandDirectives(x.Body)
+			out = append(out, &cp)
+		case *Assignment:
+			if o, ok := x.Value.(*Object); ok && hasDirective(o.Fields) {
+				cp, obj := *x, *o
+				obj.Fields = c.expandDirectives(o.Fields)
+				cp.Value = &obj
+				out = append(out, &cp)
+				continue
+			}
+			out = append(out, x)
+		case *IfChain:
+			cp := *x
+			cp.Branches = make([]IfBranch, len(x.Branches))
+			for i, br := range x.Branches {
+				br.Body = c.expandDirectives(br.Body)
+				cp.Branches[i] = br
+			}
+			cp.Else = c.expandDirectives(x.Else)
+			out = append(out, &cp)
+		default:
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func hasDirective(nodes []Node) bool {
+	for _, n := range nodes {
+		switch x := n.(type) {
+		case *Directive:
+			return true
+		case *Block:
+			if hasDirective(x.Body) {
+				return true
+			}
+		case *Assignment:
+			if o, ok := x.Value.(*Object); ok && hasDirective(o.Fields) {
+				return true
+			}
+		case *IfChain:
+			for _, br := range x.Branches {
+				if hasDirective(br.Body) {
+					return true
+				}
+			}
+			if hasDirective(x.Else) {
+				return true
+			}
+		}
+	}
+	return false
+}
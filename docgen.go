@@ -0,0 +1,278 @@
+package bcl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type DocgenOptions struct {
+	Format string
+	Title  string
+	Sample *Normalized
+}
+
+type DocSection struct {
+	Name    string     `json:"name"`
+	Entries []DocEntry `json:"entries"`
+}
+
+type DocEntry struct {
+	Path        string `json:"path"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required,omitempty"`
+	Default     any    `json:"default,omitempty"`
+	Description string `json:"description,omitempty"`
+	Deprecated  string `json:"deprecated,omitempty"`
+	Sensitive   bool   `json:"sensitive,omitempty"`
+	Example     any    `json:"example,omitempty"`
+}
+
+// GenerateConfigDocs documents every schema declared in doc. When a sample
+// document is supplied, values from blocks of the schema's type are shown as
+// examples.
+func GenerateConfigDocs(doc *Document, opts DocgenOptions) ([]byte, error) {
+	if doc == nil {
+		return nil, fmt.Errorf("document is nil")
+	}
+	var sections []DocSection
+	for _, n := range doc.Items {
+		s, ok := n.(*SchemaDecl)
+		if !ok {
+			continue
+		}
+		section := DocSection{Name: s.Name}
+		sample := docSampleForSchema(opts.Sample, s.Name)
+		appendSchemaDocEntries(&section.Entries, "", s.Fields, sample)
+		sections = append(sections, section)
+	}
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("no schemas to document")
+	}
+	return renderDocSections(sections, opts), nil
+}
+
+// GenerateStructDocs documents a Go config struct using its bcl/json tags.
+// Descriptions come from the `doc` tag and defaults from the `default` tag.
+func GenerateStructDocs(v any, opts DocgenOptions) ([]byte, error) {
+	rt := reflect.TypeOf(v)
+	for rt != nil && rt.Kind() == reflect.Pointer {
+		rt = rt.Elem()
+	}
+	if rt == nil || rt.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("docgen requires a struct, got %T", v)
+	}
+	section := DocSection{Name: rt.Name()}
+	var sample map[string]any
+	if opts.Sample != nil {
+		sample = opts.Sample.Body
+	}
+	appendStructDocEntries(&section.Entries, "", rt, sample, map[reflect.Type]bool{})
+	return renderDocSections([]DocSection{section}, opts), nil
+}
+
+func appendSchemaDocEntries(out *[]DocEntry, prefix string, fields []SchemaField, sample map[string]any) {
+	for _, f := range fields {
+		entry := DocEntry{
+			Path:        joinPath(prefix, f.Name),
+			Type:        f.Type,
+			Required:    f.Required,
+			Description: firstNonEmpty(f.Description, f.Title),
+			Deprecated:  f.Deprecated,
+			Sensitive:   f.Sensitive,
+		}
+		if f.Default != nil {
+			entry.Default = f.Default.ToInterface(true)
+		}
+		if value, ok := sample[f.Name]; ok {
+			entry.Example = docExample(value, f.Sensitive)
+		}
+		*out = append(*out, entry)
+		child, _ := sample[f.Name].(map[string]any)
+		appendSchemaDocEntries(out, entry.Path, f.Fields, child)
+	}
+}
+
+func appendStructDocEntries(out *[]DocEntry, prefix string, rt reflect.Type, sample map[string]any, seen map[reflect.Type]bool) {
+	if seen[rt] {
+		return
+	}
+	seen[rt] = true
+	defer delete(seen, rt)
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := parseTag(sf.Tag.Get("bcl"))
+		if tag.skip || tag.id {
+			continue
+		}
+		ft := sf.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if tag.inline && ft.Kind() == reflect.Struct {
+			appendStructDocEntries(out, prefix, ft, sample, seen)
+			continue
+		}
+		name := tag.name
+		if name == "" {
+			name = parseJSONName(sf.Tag.Get("json"))
+		}
+		if name == "" {
+			name = lowerFirst(sf.Name)
+		}
+		entry := DocEntry{
+			Path:        joinPath(prefix, name),
+			Type:        docTypeName(ft),
+			Required:    !tag.omitEmpty && sf.Type.Kind() != reflect.Pointer,
+			Description: sf.Tag.Get("doc"),
+			Sensitive:   tag.sensitive,
+		}
+		if def, ok := sf.Tag.Lookup("default"); ok {
+			entry.Default = def
+			entry.Required = false
+		}
+		if value, ok := sample[name]; ok {
+			entry.Example = docExample(value, tag.sensitive)
+		}
+		*out = append(*out, entry)
+		if ft.Kind() == reflect.Struct && ft != reflect.TypeOf(time.Time{}) {
+			child, _ := sample[name].(map[string]any)
+			appendStructDocEntries(out, entry.Path, ft, child, seen)
+		}
+	}
+}
+
+func docTypeName(rt reflect.Type) string {
+	if rt == reflect.TypeOf(time.Duration(0)) {
+		return "duration"
+	}
+	if rt == reflect.TypeOf(time.Time{}) {
+		return "datetime"
+	}
+	switch rt.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Slice, reflect.Array:
+		return "list<" + docTypeName(rt.Elem()) + ">"
+	case reflect.Map:
+		return "map<" + docTypeName(rt.Elem()) + ">"
+	case reflect.Struct:
+		return "object"
+	default:
+		return "any"
+	}
+}
+
+func docSampleForSchema(sample *Normalized, name string) map[string]any {
+	if sample == nil {
+		return nil
+	}
+	for _, block := range sample.Blocks {
+		if block["type"] == name {
+			body, _ := block["body"].(map[string]any)
+			return body
+		}
+	}
+	body, _ := sample.Body[name].(map[string]any)
+	return body
+}
+
+func docExample(v any, sensitive bool) any {
+	if sensitive {
+		return "****"
+	}
+	if _, ok := v.(map[string]any); ok {
+		return nil
+	}
+	return unwrapTypedScalar(v)
+}
+
+func renderDocSections(sections []DocSection, opts DocgenOptions) []byte {
+	title := firstNonEmpty(opts.Title, "Configuration Reference")
+	var b bytes.Buffer
+	switch strings.ToLower(opts.Format) {
+	case "html":
+		fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n<h1>%s</h1>\n", html.EscapeString(title), html.EscapeString(title))
+		for _, s := range sections {
+			fmt.Fprintf(&b, "<h2 id=%q>%s</h2>\n<table>\n<tr><th>Key</th><th>Type</th><th>Required</th><th>Default</th><th>Description</th><th>Example</th></tr>\n", s.Name, html.EscapeString(s.Name))
+			for _, e := range s.Entries {
+				fmt.Fprintf(&b, "<tr><td><code>%s</code></td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+					html.EscapeString(e.Path), html.EscapeString(e.Type), docRequired(e.Required),
+					html.EscapeString(docCell(e.Default)), html.EscapeString(docDescription(e)), html.EscapeString(docCell(e.Example)))
+			}
+			b.WriteString("</table>\n")
+		}
+		b.WriteString("</body>\n</html>\n")
+	default:
+		fmt.Fprintf(&b, "# %s\n\n", title)
+		for _, s := range sections {
+			fmt.Fprintf(&b, "## `%s`\n\n", s.Name)
+			b.WriteString("| Key | Type | Required | Default | Description | Example |\n")
+			b.WriteString("| --- | --- | --- | --- | --- | --- |\n")
+			for _, e := range s.Entries {
+				fmt.Fprintf(&b, "| `%s` | `%s` | %s | %s | %s | %s |\n",
+					e.Path, e.Type, docRequired(e.Required), docCode(e.Default), docMarkdown(docDescription(e)), docCode(e.Example))
+			}
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes()
+}
+
+func docRequired(required bool) string {
+	if required {
+		return "yes"
+	}
+	return "no"
+}
+
+func docDescription(e DocEntry) string {
+	desc := e.Description
+	if e.Deprecated != "" {
+		desc = strings.TrimSpace(desc + " Deprecated: " + e.Deprecated)
+	}
+	if e.Sensitive {
+		desc = strings.TrimSpace(desc + " (sensitive)")
+	}
+	return desc
+}
+
+func docCell(v any) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func docCode(v any) string {
+	s := docCell(v)
+	if s == "" {
+		return ""
+	}
+	return "`" + docMarkdown(s) + "`"
+}
+
+func docMarkdown(s string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(s)
+}
@@ -537,6 +537,9 @@ func pathString(input map[string]any, path string) string {
 }
 
 func stringList(v any) []string {
+	if xs, ok := v.([]string); ok {
+		return xs
+	}
 	xs, ok := v.([]any)
 	if !ok {
 		if s := stringValue(v); s != "" {
@@ -6,15 +6,29 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/oarkflow/convert"
 )
 
+// Marshal writes v as a BCL document that Unmarshal reads back into an
+// equal value. Nil slices and maps are written as null, floats always
+// with a fraction, and keys that are keywords or block names are quoted.
+// Map keys containing a dot cannot round-trip, since dotted keys nest,
+// and a "_" key is discarded.
+//
+// Values and map keys whose type implements encoding.TextMarshaler are
+// written as strings, which Unmarshal hands to UnmarshalText. Other named
+// types are written as their underlying kind: fmt.Stringer is not used,
+// since nothing would read the name back into the value.
 func Marshal(v any) ([]byte, error) {
 	var b bytes.Buffer
 	if err := writeGoValue(&b, reflect.ValueOf(v), 0, ""); err != nil {
@@ -27,11 +41,67 @@ func Unmarshal(data []byte, v any) error {
 	return UnmarshalWithOptions(data, v, &Options{AllowEnv: true})
 }
 
+// UnmarshalTyped is Unmarshal returning the decoded value, along with the
+// parsed document nodes for callers that also inspect the source:
+//
+//	cfg, _, err := bcl.UnmarshalTyped[AppConfig](src)
+func UnmarshalTyped[T any](data []byte) (T, []Node, error) {
+	var v T
+	doc, err := Parse(data)
+	if err != nil {
+		return v, nil, err
+	}
+	if err := unmarshalDocument(doc, &v, &Options{AllowEnv: true}); err != nil {
+		return v, doc.Items, err
+	}
+	return v, doc.Items, nil
+}
+
+// SliceMode controls how lists in a document combine with slices already
+// held by the decode target.
+type SliceMode int
+
+const (
+	ClearSlices SliceMode = iota
+	AppendSlices
+)
+
+// BlockShape controls how blocks reach map and interface targets of
+// UnmarshalWithOptions; struct targets use block tags either way.
+type BlockShape int
+
+const (
+	// BlockLists delivers the labeled blocks of each type as an ordered
+	// list under the type name, each entry holding its body fields and the
+	// block label as "name" (unless the body sets name itself). Unlabeled
+	// blocks such as `logging { ... }` are objects and keep their shape.
+	BlockLists BlockShape = iota
+	// LegacyBlocks keeps the compiled shape: top-level blocks under
+	// "$blocks" and nested blocks as {type, id, body} maps.
+	LegacyBlocks
+)
+
+// UnmarshalWithOptions compiles data with opts and decodes it into v. With
+// DisallowUnknownFields, a key or block type no struct field decodes is an
+// error, so a typo does not silently drop a setting.
 func UnmarshalWithOptions(data []byte, v any, opts *Options) error {
+	doc, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	return unmarshalDocument(doc, v, opts)
+}
+
+func unmarshalDocument(doc *Document, v any, opts *Options) error {
 	if opts == nil {
 		opts = &Options{}
 	}
-	n, err := CompileBytes(data, opts)
+	if opts.ResolvePathFields && !opts.TrackProvenance {
+		withProvenance := *opts
+		withProvenance.TrackProvenance = true
+		opts = &withProvenance
+	}
+	n, err := Compile(doc, opts)
 	if err != nil {
 		return err
 	}
@@ -39,14 +109,47 @@ func UnmarshalWithOptions(data []byte, v any, opts *Options) error {
 	if rv.Kind() != reflect.Pointer || rv.IsNil() {
 		return fmt.Errorf("bcl: Unmarshal target must be a non-nil pointer")
 	}
-	src := make(map[string]any, len(n.Body)+1)
-	for k, v := range n.Body {
+	body := n.Body
+	for _, normalize := range opts.Normalizers {
+		if body, err = normalize(body, opts.BaseDir); err != nil {
+			return err
+		}
+	}
+	src := make(map[string]any, len(body)+1)
+	for k, v := range body {
 		src[k] = v
 	}
 	if len(n.Blocks) > 0 {
 		src["$blocks"] = n.Blocks
 	}
-	return assignGoValue(rv.Elem(), src)
+	d := goDecoder{appendSlices: opts.SliceMerge == AppendSlices, blocksListed: opts.BlockShape == LegacyBlocks, strict: opts.DisallowUnknownFields, hooks: opts.DecodeHooks}
+	if opts.ResolvePathFields {
+		d.pathDir = sourceDirs(n, opts.BaseDir)
+	}
+	if opts.EnvFallback || opts.EnvPrefix != "" {
+		d.env, d.envPrefix = fallbackEnv(opts), opts.EnvPrefix
+	}
+	return d.assign(rv.Elem(), src)
+}
+
+// fallbackEnv is the env lookup of field fallbacks. Keys outside
+// Options.AllowedEnvKeys read as unset, and reads go to Options.Audit like
+// env() calls in the document.
+func fallbackEnv(opts *Options) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		if !envKeyAllowed(opts.AllowedEnvKeys, key) {
+			return "", false
+		}
+		v, ok := opts.Env(key)
+		opts.Audit.recordEnv(key, v, ok, Span{})
+		return v, ok
+	}
+}
+
+// UnmarshalEnv fills v from the environment alone, using env and envDefault
+// tags and prefix_FIELD lookups, for deployments without a config file.
+func UnmarshalEnv(v any, prefix string) error {
+	return UnmarshalWithOptions(nil, v, &Options{EnvFallback: true, EnvPrefix: prefix})
 }
 
 type Encoder struct {
@@ -126,19 +229,29 @@ func writeGoValue(b *bytes.Buffer, rv reflect.Value, indent int, name string) er
 		return nil
 	}
 	if text, ok := textMarshaler(rv); ok {
+		raw, err := text.MarshalText()
+		if err != nil {
+			return fmt.Errorf("bcl: marshal %s: %w", rv.Type(), err)
+		}
 		if name != "" {
 			fmt.Fprintf(b, "%s%s ", pad(indent), name)
 		}
-		writeTextMarshaler(b, text)
+		b.WriteString(quoteBCLString(string(raw)))
 		if name != "" {
 			b.WriteByte('\n')
 		}
 		return nil
 	}
+	if isNilCollection(rv) {
+		if name != "" {
+			fmt.Fprintf(b, "%s%s null\n", pad(indent), name)
+		}
+		return nil
+	}
 	switch rv.Kind() {
 	case reflect.Struct:
 		if name != "" {
-			fmt.Fprintf(b, "%s%s {\n", pad(indent), name)
+			fmt.Fprintf(b, "%s%s {\n", pad(indent), objectName(name))
 			indent++
 		}
 		if err := writeStructFields(b, rv, indent); err != nil {
@@ -152,11 +265,15 @@ func writeGoValue(b *bytes.Buffer, rv reflect.Value, indent int, name string) er
 			return nil
 		}
 		if name != "" {
-			fmt.Fprintf(b, "%s%s {\n", pad(indent), name)
+			fmt.Fprintf(b, "%s%s {\n", pad(indent), objectName(name))
 			indent++
 		}
 		for _, k := range sortedReflectMapKeys(rv) {
-			if err := writeGoValue(b, rv.MapIndex(k), indent, formatBCLName(fmt.Sprint(k.Interface()))); err != nil {
+			key, err := mapKeyName(k)
+			if err != nil {
+				return err
+			}
+			if err := writeGoValue(b, rv.MapIndex(k), indent, formatBCLName(key)); err != nil {
 				return err
 			}
 		}
@@ -212,16 +329,25 @@ func writeStructFields(b *bytes.Buffer, rv reflect.Value, indent int) error {
 			continue
 		}
 		if tag.sensitive {
-			fmt.Fprintf(b, "%s%s sensitive(", pad(indent), fieldName)
+			fmt.Fprintf(b, "%s%s sensitive(", pad(indent), formatBCLName(fieldName))
 			writeScalar(b, fv)
 			b.WriteString(")\n")
 			continue
 		}
 		if tag.ident {
-			fmt.Fprintf(b, "%s%s %s\n", pad(indent), fieldName, identValue(fv))
+			fmt.Fprintf(b, "%s%s %s\n", pad(indent), formatBCLName(fieldName), identValue(fv))
+			continue
+		}
+		if bv := indirectValue(fv); tag.flag && bv.IsValid() && bv.Kind() == reflect.Bool {
+			// Flags are written `name` or `!name`; both read back as bools.
+			bang := ""
+			if !bv.Bool() {
+				bang = "!"
+			}
+			fmt.Fprintf(b, "%s%s%s\n", pad(indent), bang, fieldName)
 			continue
 		}
-		if err := writeGoValue(b, fv, indent, fieldName); err != nil {
+		if err := writeGoValue(b, fv, indent, formatBCLName(fieldName)); err != nil {
 			return err
 		}
 	}
@@ -294,7 +420,7 @@ func blockStructID(rv reflect.Value) string {
 
 func writeInlineValue(b *bytes.Buffer, rv reflect.Value, indent int) {
 	rv = indirectValue(rv)
-	if !rv.IsValid() {
+	if !rv.IsValid() || isNilCollection(rv) {
 		b.WriteString("null")
 		return
 	}
@@ -328,12 +454,12 @@ func writeInlineValue(b *bytes.Buffer, rv reflect.Value, indent int) {
 				continue
 			}
 			if tag.sensitive {
-				fmt.Fprintf(b, "%s%s sensitive(", pad(indent+1), fieldName)
+				fmt.Fprintf(b, "%s%s sensitive(", pad(indent+1), formatBCLName(fieldName))
 				writeScalar(b, fv)
 				b.WriteString(")\n")
 				continue
 			}
-			_ = writeGoValue(b, fv, indent+1, fieldName)
+			_ = writeGoValue(b, fv, indent+1, formatBCLName(fieldName))
 		}
 		b.WriteString(pad(indent))
 		b.WriteByte('}')
@@ -343,7 +469,8 @@ func writeInlineValue(b *bytes.Buffer, rv reflect.Value, indent int) {
 		}
 		b.WriteString("{\n")
 		for _, k := range sortedReflectMapKeys(rv) {
-			_ = writeGoValue(b, rv.MapIndex(k), indent+1, formatBCLName(fmt.Sprint(k.Interface())))
+			key, _ := mapKeyName(k)
+			_ = writeGoValue(b, rv.MapIndex(k), indent+1, formatBCLName(key))
 		}
 		b.WriteString(pad(indent))
 		b.WriteByte('}')
@@ -439,7 +566,7 @@ func reflectMapToStringAny(rv reflect.Value) (map[string]any, bool) {
 func hasCompositeListItem(rv reflect.Value) bool {
 	for i := 0; i < rv.Len(); i++ {
 		item := indirectValue(rv.Index(i))
-		if !item.IsValid() {
+		if _, ok := textMarshaler(item); !item.IsValid() || ok {
 			continue
 		}
 		switch item.Kind() {
@@ -460,6 +587,10 @@ func writeScalar(b *bytes.Buffer, rv reflect.Value) {
 		writeTextMarshaler(b, text)
 		return
 	}
+	if rv.Type() == durationType {
+		writeDuration(b, time.Duration(rv.Int()))
+		return
+	}
 	switch rv.Kind() {
 	case reflect.String:
 		b.WriteString(quoteBCLString(rv.String()))
@@ -470,13 +601,40 @@ func writeScalar(b *bytes.Buffer, rv reflect.Value) {
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		fmt.Fprintf(b, "%d", rv.Uint())
 	case reflect.Float32, reflect.Float64:
-		fmt.Fprintf(b, "%g", rv.Float())
+		b.WriteString(formatBCLFloat(rv.Float(), rv.Type().Bits()))
 	default:
 		j, _ := json.Marshal(rv.Interface())
 		b.Write(j)
 	}
 }
 
+// formatBCLFloat writes f in plain decimal notation with a fraction, since
+// the lexer reads neither exponents nor integral floats as floats.
+func formatBCLFloat(f float64, bits int) string {
+	s := strconv.FormatFloat(f, 'f', -1, bits)
+	if !strings.ContainsAny(s, ".NI") {
+		s += ".0"
+	}
+	return s
+}
+
+// writeDuration writes d as a duration literal such as 1h30m0s, quoting
+// the forms the lexer does not read as one.
+func writeDuration(b *bytes.Buffer, d time.Duration) {
+	s := d.String()
+	if d < 0 || strings.ContainsRune(s, 'µ') {
+		b.WriteString(strconv.Quote(s))
+		return
+	}
+	b.WriteString(s)
+}
+
+// isNilCollection reports a nil slice or map, which Marshal writes as null
+// so it decodes back to nil rather than to an empty value.
+func isNilCollection(rv reflect.Value) bool {
+	return (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map) && rv.IsNil()
+}
+
 func indirectValue(rv reflect.Value) reflect.Value {
 	for rv.IsValid() && (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface) {
 		if rv.IsNil() {
@@ -500,6 +658,11 @@ func textMarshaler(rv reflect.Value) (encoding.TextMarshaler, bool) {
 		if text, ok := rv.Addr().Interface().(encoding.TextMarshaler); ok {
 			return text, true
 		}
+	} else if rv.CanInterface() && reflect.PointerTo(rv.Type()).Implements(reflect.TypeFor[encoding.TextMarshaler]()) {
+		// Map values are not addressable; a pointer method needs a copy.
+		p := reflect.New(rv.Type())
+		p.Elem().Set(rv)
+		return p.Interface().(encoding.TextMarshaler), true
 	}
 	return nil, false
 }
@@ -513,6 +676,24 @@ func writeTextMarshaler(b *bytes.Buffer, text encoding.TextMarshaler) {
 	b.WriteString(quoteBCLString(string(raw)))
 }
 
+// flagSet reads a body made only of bare names, such as `logging {
+// enabled }`, which compiles to a list of the names, as those flags set.
+func flagSet(src any) (map[string]any, bool) {
+	xs, ok := src.([]any)
+	if !ok || len(xs) == 0 {
+		return nil, false
+	}
+	m := make(map[string]any, len(xs))
+	for _, x := range xs {
+		name, ok := x.(string)
+		if !ok {
+			return nil, false
+		}
+		m[name] = true
+	}
+	return m, true
+}
+
 type tagInfo struct {
 	name      string
 	skip      bool
@@ -522,6 +703,8 @@ type tagInfo struct {
 	block     bool
 	id        bool
 	ident     bool
+	flag      bool
+	path      bool
 }
 
 func parseTag(s string) tagInfo {
@@ -544,6 +727,10 @@ func parseTag(s string) tagInfo {
 			t.id = true
 		case "ident":
 			t.ident = true
+		case "flag":
+			t.flag = true
+		case "path":
+			t.path = true
 		}
 	}
 	return t
@@ -571,10 +758,36 @@ func parseJSONName(s string) string {
 	return s
 }
 
-func assignGoValue(dst reflect.Value, src any) error {
+// goDecoder assigns compiled values onto Go values. Only keys present in the
+// source are written, so decoding onto a populated struct layers the
+// document over the existing values.
+//
+// With an env lookup set, struct fields missing from the document fall back
+// to the variable named by their env tag, then to <envPrefix>_<FIELD_PATH>,
+// then to their envDefault tag.
+type goDecoder struct {
+	appendSlices bool
+	// blocksListed is set once blockListView has been applied, or with
+	// LegacyBlocks.
+	blocksListed bool
+	env          func(string) (string, bool)
+	envPrefix    string
+	path         []string
+	pathDir      func(path []string) string
+	// strict rejects keys no struct field decodes; inlined is set while
+	// an inline field decodes its parent's keys, which the parent checks.
+	strict  bool
+	inlined bool
+	hooks   map[reflect.Type]DecodeHook
+}
+
+func (d goDecoder) assign(dst reflect.Value, src any) error {
 	if !dst.CanSet() {
 		return nil
 	}
+	if h, deref, ok := d.hook(dst.Type()); ok && src != nil {
+		return d.applyHook(dst, h, deref, src)
+	}
 	if dst.Kind() == reflect.Pointer {
 		if src == nil {
 			dst.SetZero()
@@ -583,7 +796,7 @@ func assignGoValue(dst reflect.Value, src any) error {
 		if dst.IsNil() {
 			dst.Set(reflect.New(dst.Type().Elem()))
 		}
-		return assignGoValue(dst.Elem(), src)
+		return d.assign(dst.Elem(), src)
 	}
 	if src == nil {
 		dst.SetZero()
@@ -592,11 +805,16 @@ func assignGoValue(dst reflect.Value, src any) error {
 	if text, ok := textUnmarshaler(dst); ok {
 		return text.UnmarshalText([]byte(fmt.Sprint(unwrapTypedScalar(src))))
 	}
+	if dst.Type() == durationType {
+		return d.assignDuration(dst, src)
+	}
 	switch dst.Kind() {
 	case reflect.Struct:
 		m, ok := src.(map[string]any)
 		if !ok {
-			return nil
+			if m, ok = flagSet(src); !ok {
+				return nil
+			}
 		}
 		rt := dst.Type()
 		for i := 0; i < dst.NumField(); i++ {
@@ -610,14 +828,16 @@ func assignGoValue(dst reflect.Value, src any) error {
 			}
 			if tag.id {
 				if value, ok := m["$id"]; ok {
-					if err := assignGoValue(dst.Field(i), value); err != nil {
+					if err := d.assign(dst.Field(i), value); err != nil {
 						return err
 					}
 				}
 				continue
 			}
 			if tag.inline {
-				if err := assignGoValue(dst.Field(i), src); err != nil {
+				inline := d
+				inline.inlined = true
+				if err := inline.assign(dst.Field(i), src); err != nil {
 					return err
 				}
 				continue
@@ -630,32 +850,54 @@ func assignGoValue(dst reflect.Value, src any) error {
 				name = lowerFirst(sf.Name)
 			}
 			if tag.block {
-				if err := assignGoValue(dst.Field(i), blockValues(m, name)); err != nil {
-					return err
+				if blocks := blockValues(m, name); len(blocks) > 0 {
+					if err := d.field(name).assign(dst.Field(i), blocks); err != nil {
+						return err
+					}
 				}
 				continue
 			}
 			if value, ok := m[name]; ok {
-				if err := assignGoValue(dst.Field(i), value); err != nil {
+				if tag.path && d.pathDir != nil {
+					value = d.field(name).resolvePaths(value)
+				}
+				if err := d.field(name).assign(dst.Field(i), value); err != nil {
+					return err
+				}
+			} else if d.env != nil {
+				if err := d.field(name).envFallback(dst.Field(i), sf); err != nil {
 					return err
 				}
 			}
 		}
+		if d.strict && !d.inlined {
+			return d.unknownField(rt, m)
+		}
 	case reflect.Map:
+		if !d.blocksListed {
+			src, d.blocksListed = blockListView(src), true
+		}
 		m, ok := src.(map[string]any)
+		if !ok && dst.Type().Elem().Kind() == reflect.Bool {
+			m, ok = flagSet(src)
+		}
 		if !ok {
 			return nil
 		}
+		d.env = nil
 		if dst.IsNil() {
 			dst.Set(reflect.MakeMapWithSize(dst.Type(), len(m)))
 		}
 		for k, v := range m {
 			key := reflect.New(dst.Type().Key()).Elem()
-			if err := assignGoValue(key, k); err != nil {
+			if err := d.mapKey(key, k); err != nil {
 				return err
 			}
 			val := reflect.New(dst.Type().Elem()).Elem()
-			if err := assignGoValue(val, v); err != nil {
+			if existing := dst.MapIndex(key); existing.IsValid() {
+				val.Set(existing)
+			}
+			if err := d.field(k).assign(val, v); err != nil {
 				return err
 			}
 			dst.SetMapIndex(key, val)
@@ -665,13 +907,34 @@ func assignGoValue(dst reflect.Value, src any) error {
 		if !ok {
 			return nil
 		}
-		out := reflect.MakeSlice(dst.Type(), len(xs), len(xs))
+		d.env = nil
+		base := 0
+		if d.appendSlices {
+			base = dst.Len()
+		}
+		out := reflect.MakeSlice(dst.Type(), base+len(xs), base+len(xs))
+		reflect.Copy(out, dst.Slice(0, base))
 		for i, item := range xs {
-			if err := assignGoValue(out.Index(i), item); err != nil {
+			if err := d.assign(out.Index(base+i), item); err != nil {
 				return err
 			}
 		}
 		dst.Set(out)
+	case reflect.Array:
+		xs, ok := src.([]any)
+		if !ok {
+			return nil
+		}
+		d.env = nil
+		for i := 0; i < dst.Len(); i++ {
+			var item any
+			if i < len(xs) {
+				item = xs[i]
+			}
+			if err := d.assign(dst.Index(i), item); err != nil {
+				return err
+			}
+		}
 	case reflect.String:
 		src = unwrapTypedScalar(src)
 		dst.SetString(fmt.Sprint(src))
@@ -682,12 +945,14 @@ func assignGoValue(dst reflect.Value, src any) error {
 		}
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		src = unwrapTypedScalar(src)
-		if n, ok := numericInt(src); ok {
+		if n, ok := decodeInt(src); ok {
 			dst.SetInt(n)
 		}
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		src = unwrapTypedScalar(src)
-		if n, ok := numericInt(src); ok && n >= 0 {
+		if u, ok := src.(uint64); ok {
+			dst.SetUint(u)
+		} else if n, ok := decodeInt(src); ok && n >= 0 {
 			dst.SetUint(uint64(n))
 		}
 	case reflect.Float32, reflect.Float64:
@@ -696,11 +961,230 @@ func assignGoValue(dst reflect.Value, src any) error {
 			dst.SetFloat(n)
 		}
 	case reflect.Interface:
+		if !d.blocksListed {
+			src = blockListView(src)
+		}
 		dst.Set(reflect.ValueOf(src))
 	}
 	return nil
 }
 
+// assignDuration decodes duration literals (5s, 1h30m) and strings in
+// time.ParseDuration syntax; plain numbers are nanoseconds, as in JSON.
+func (d goDecoder) assignDuration(dst reflect.Value, src any) error {
+	switch x := unwrapTypedScalar(src).(type) {
+	case time.Duration:
+		dst.SetInt(int64(x))
+	case string:
+		v, err := time.ParseDuration(strings.TrimSpace(x))
+		if err != nil {
+			return fmt.Errorf("bcl: decode %s: %w", d.describe(dst.Type()), err)
+		}
+		dst.SetInt(int64(v))
+	default:
+		if n, ok := numericInt(x); ok {
+			dst.SetInt(n)
+		}
+	}
+	return nil
+}
+
+// mapKey decodes the key k, always a string in the document, into dst:
+// through a decode hook or UnmarshalText when the key type has one, and
+// otherwise parsed as the key's kind, so map[int]T reads back its keys.
+func (d goDecoder) mapKey(dst reflect.Value, k string) error {
+	_, _, hooked := d.hook(dst.Type())
+	if _, text := textUnmarshaler(dst); hooked || text || dst.Type() == durationType {
+		return d.assign(dst, k)
+	}
+	v, err := envFieldValue(dst.Type(), k)
+	if err != nil {
+		return fmt.Errorf("bcl: decode map key %q as %s: %w", k, dst.Type(), err)
+	}
+	return d.assign(dst, v)
+}
+
+func (d goDecoder) field(name string) goDecoder {
+	if d.env != nil || d.pathDir != nil || d.strict || d.hooks != nil {
+		d.path = append(d.path[:len(d.path):len(d.path)], name)
+	}
+	d.inlined = false
+	return d
+}
+
+// unknownField returns an error for the first key of m, in sorted order,
+// that no field of struct type rt decodes.
+func (d goDecoder) unknownField(rt reflect.Type, m map[string]any) error {
+	fields, blocks, all := structKeys(rt)
+	if all {
+		return nil
+	}
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		if k == "$blocks" {
+			for _, item := range listFromAny(m[k]) {
+				typ := stringValue(mapFromAny(item)["type"])
+				if !slices.ContainsFunc(blocks, func(name string) bool { return sameCollectionName(name, typ) }) {
+					return fmt.Errorf("bcl: unknown block %q", strings.Join(append(slices.Clone(d.path), typ), "."))
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(k, "$") || slices.Contains(fields, k) || slices.ContainsFunc(blocks, func(name string) bool { return sameCollectionName(name, k) }) {
+			continue
+		}
+		return fmt.Errorf("bcl: unknown field %q", strings.Join(append(slices.Clone(d.path), k), "."))
+	}
+	return nil
+}
+
+// structKeys lists the keys and block types the fields of rt decode,
+// including those of inline fields. all is set when an inline map takes
+// every key.
+func structKeys(rt reflect.Type) (fields, blocks []string, all bool) {
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := parseTag(sf.Tag.Get("bcl"))
+		switch {
+		case tag.skip || tag.id:
+			continue
+		case tag.inline:
+			ft := sf.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() != reflect.Struct {
+				return nil, nil, true
+			}
+			f, b, a := structKeys(ft)
+			if a {
+				return nil, nil, true
+			}
+			fields, blocks = append(fields, f...), append(blocks, b...)
+			continue
+		}
+		name := tag.name
+		if name == "" {
+			name = parseJSONName(sf.Tag.Get("json"))
+		}
+		if name == "" {
+			name = lowerFirst(sf.Name)
+		}
+		if tag.block {
+			blocks = append(blocks, name)
+		} else {
+			fields = append(fields, name)
+		}
+	}
+	return fields, blocks, false
+}
+
+// resolvePaths makes the relative path strings of a `path` field absolute
+// against the directory of the file that set it.
+func (d goDecoder) resolvePaths(v any) any {
+	switch x := v.(type) {
+	case string:
+		return absPath(d.pathDir(d.path), x)
+	case []any:
+		out := make([]any, len(x))
+		for i, item := range x {
+			out[i] = d.resolvePaths(item)
+		}
+		return out
+	}
+	return v
+}
+
+func (d goDecoder) envFallback(dst reflect.Value, sf reflect.StructField) error {
+	var keys []string
+	if key := sf.Tag.Get("env"); key != "" {
+		keys = append(keys, key)
+	}
+	if d.envPrefix != "" {
+		keys = append(keys, envKey(append([]string{d.envPrefix}, d.path...)))
+	}
+	for _, key := range keys {
+		if raw, ok := d.env(key); ok {
+			if _, _, hooked := d.hook(dst.Type()); hooked {
+				return d.assign(dst, raw)
+			}
+			v, err := envFieldValue(dst.Type(), raw)
+			if err != nil {
+				return fmt.Errorf("bcl: env %s: %w", key, err)
+			}
+			return d.assign(dst, v)
+		}
+	}
+	if def, ok := sf.Tag.Lookup("envDefault"); ok {
+		v, err := envFieldValue(dst.Type(), def)
+		if err != nil {
+			return fmt.Errorf("bcl: envDefault of %s: %w", sf.Name, err)
+		}
+		return d.assign(dst, v)
+	}
+	if _, ok := textUnmarshaler(dst); !ok && dst.Kind() == reflect.Struct {
+		return d.assign(dst, map[string]any{})
+	}
+	return nil
+}
+
+func envKey(parts []string) string {
+	var b strings.Builder
+	for i, part := range parts {
+		if i > 0 {
+			b.WriteByte('_')
+		}
+		for _, r := range part {
+			if r >= 'a' && r <= 'z' {
+				r -= 'a' - 'A'
+			} else if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+				r = '_'
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// envFieldValue parses an env string into a value assign accepts for t.
+// Slices are comma separated.
+func envFieldValue(t reflect.Type, raw string) (any, error) {
+	if reflect.PointerTo(t).Implements(reflect.TypeFor[encoding.TextUnmarshaler]()) {
+		return raw, nil
+	}
+	if t == reflect.TypeFor[time.Duration]() {
+		d, err := time.ParseDuration(raw)
+		return int64(d), err
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return envFieldValue(t.Elem(), raw)
+	case reflect.String, reflect.Interface:
+		return raw, nil
+	case reflect.Bool:
+		return strconv.ParseBool(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(strings.TrimSpace(raw), 10, t.Bits())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(strings.TrimSpace(raw), 10, t.Bits())
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(strings.TrimSpace(raw), t.Bits())
+	case reflect.Slice:
+		var out []any
+		for _, part := range strings.Split(raw, ",") {
+			v, err := envFieldValue(t.Elem(), strings.TrimSpace(part))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
 func blockValues(m map[string]any, name string) []any {
 	var out []any
 	if blocks, ok := m["$blocks"].([]map[string]any); ok {
@@ -729,6 +1213,69 @@ func blockValues(m map[string]any, name string) []any {
 	return out
 }
 
+// blockListView rewrites the compiled block shapes in v for BlockLists.
+func blockListView(v any) any {
+	switch x := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(x))
+		for k, item := range x {
+			if k != "$blocks" {
+				out[k] = blockListView(item)
+			}
+		}
+		for _, item := range listFromAny(x["$blocks"]) {
+			block := mapFromAny(item)
+			typ := stringValue(block["type"])
+			list, ok := out[typ].([]any)
+			if !ok && out[typ] != nil {
+				list = []any{out[typ]}
+			}
+			out[typ] = append(list, blockListEntry(block))
+		}
+		return out
+	case []any:
+		out := make([]any, len(x))
+		for i, item := range x {
+			if block := mapFromAny(item); isCompiledBlock(block) {
+				out[i] = blockListEntry(block)
+			} else {
+				out[i] = blockListView(item)
+			}
+		}
+		return out
+	}
+	return v
+}
+
+func blockListEntry(block map[string]any) map[string]any {
+	body, _ := blockListView(mapFromAny(block["body"])).(map[string]any)
+	if body == nil {
+		body = map[string]any{}
+	}
+	if id, ok := block["id"]; ok {
+		if _, taken := body["name"]; !taken {
+			body["name"] = id
+		}
+	}
+	return body
+}
+
+// isCompiledBlock reports whether m is a block as the compiler emits it.
+func isCompiledBlock(m map[string]any) bool {
+	if _, ok := m["type"].(string); !ok {
+		return false
+	}
+	if _, ok := m["body"].(map[string]any); !ok {
+		return false
+	}
+	for k := range m {
+		if k != "type" && k != "id" && k != "body" {
+			return false
+		}
+	}
+	return true
+}
+
 func mapFromAny(v any) map[string]any {
 	m, _ := v.(map[string]any)
 	return m
@@ -790,6 +1337,15 @@ func unwrapTypedScalar(v any) any {
 	return v
 }
 
+// decodeInt is numericInt that also takes byte sizes such as 10MB, which
+// compile to $bytes values.
+func decodeInt(v any) (int64, bool) {
+	if s, ok := v.(string); ok {
+		return byteSize(s)
+	}
+	return numericInt(v)
+}
+
 func numericInt(v any) (int64, bool) {
 	i, err := convert.ToInt64(v)
 	return i, err == nil
@@ -817,19 +1373,69 @@ func pad(n int) string {
 
 func sortedReflectMapKeys(rv reflect.Value) []reflect.Value {
 	keys := rv.MapKeys()
-	sort.Slice(keys, func(i, j int) bool {
-		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
-	})
+	names := make(map[reflect.Value]string, len(keys))
+	for _, k := range keys {
+		names[k], _ = mapKeyName(k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return names[keys[i]] < names[keys[j]] })
 	return keys
 }
 
+// mapKeyName is the text of a map key: MarshalText for types that have
+// it, and otherwise the key's underlying value, so an int key with a
+// String method is written as its number, which mapKey reads back.
+func mapKeyName(k reflect.Value) (string, error) {
+	if text, ok := textMarshaler(k); ok {
+		raw, err := text.MarshalText()
+		if err != nil {
+			return "", fmt.Errorf("bcl: marshal map key %s: %w", k.Type(), err)
+		}
+		return string(raw), nil
+	}
+	switch k.Kind() {
+	case reflect.String:
+		return k.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(k.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(k.Float(), 'g', -1, k.Type().Bits()), nil
+	}
+	return fmt.Sprint(k.Interface()), nil
+}
+
+// formatBCLName writes s bare when it reads back as a plain key, and
+// quoted when it is not an identifier or is a keyword: a key named `if` or
+// `import` would otherwise start a statement instead of naming a value.
 func formatBCLName(s string) string {
-	if isBCLIdent(s) {
+	if isBCLIdent(s) && !strings.HasSuffix(s, ":") && !statementName(s) {
 		return s
 	}
 	return quoteBCLString(s)
 }
 
+func statementName(s string) bool {
+	switch s {
+	case "import", "param", "const", "schema", "type", "override", "use", "when", "if", "IF", "for", "func",
+		"else", "ELSE", "elif", "elseif", "ELSEIF":
+		return true
+	}
+	return isCommandStatement(s)
+}
+
+// objectName is the name written before an object value. Known block
+// names and capitalized ones are quoted there, since `module { ... }`
+// or `Server { ... }` parse as blocks.
+func objectName(name string) string {
+	if isKnownBlock(name) || isCapitalizedBlockName(name) {
+		return quoteBCLString(name)
+	}
+	return name
+}
+
 func isBCLIdent(s string) bool {
 	if s == "" {
 		return false
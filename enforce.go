@@ -0,0 +1,262 @@
+package bcl
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// EnforceAdapter connects an Enforcer to the systems a config describes,
+// such as the devices of a network block. Observe returns the live state
+// of target in the shape of its block body; values should use the types
+// the compiler produces (int64, float64, []any) so equal state is not
+// reported as drift.
+type EnforceAdapter interface {
+	Observe(ctx context.Context, target string) (map[string]any, error)
+	Apply(ctx context.Context, target string, desired map[string]any) error
+}
+
+// EnforceResult is the outcome of reconciling one target.
+type EnforceResult struct {
+	Target   string        `json:"target"`
+	Drift    ConfigChanges `json:"drift,omitempty"`
+	Applied  bool          `json:"applied"`
+	Err      error         `json:"-"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Enforcer keeps the blocks of type BlockType in the config bound by
+// Binder applied: each pass reloads the config, observes every block by
+// id through Adapter, and re-applies the blocks that drifted, or with
+// AlertOnly only reports them. Run repeats passes every Interval, and
+// Handler lets a webhook trigger one. Desired state is compiled with
+// Options.RevealSensitive, so adapters see real values rather than "****"
+// while the Binder's own config stays redacted.
+type Enforcer struct {
+	Binder    *Binder
+	BlockType string
+	Adapter   EnforceAdapter
+	Interval  time.Duration
+	AlertOnly bool
+	// OnPass receives the results of every pass, drifted or not.
+	OnPass func([]EnforceResult)
+	// OnError receives reload failures; the pass then enforces the last
+	// good config.
+	OnError func(error)
+
+	mu   sync.Mutex
+	last *Normalized
+}
+
+// Enforce runs one pass and returns a result per target. The error is only
+// set when no config has ever loaded; per-target failures are in Err.
+func (e *Enforcer) Enforce(ctx context.Context) ([]EnforceResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	log := e.Binder.Options.logger()
+	n, err := e.desired()
+	if err != nil {
+		if e.OnError != nil {
+			e.OnError(err)
+		}
+		if n = e.last; n == nil {
+			if n = e.Binder.Current(); n == nil || n.redacted {
+				return nil, err
+			}
+		}
+	}
+	e.last = n
+	var results []EnforceResult
+	for _, b := range blocksOfType(n.Blocks, e.BlockType) {
+		id := fmt.Sprint(b["id"])
+		desired := blockBody(b)
+		start := time.Now()
+		r := EnforceResult{Target: id}
+		live, err := e.Adapter.Observe(ctx, id)
+		if err == nil {
+			r.Drift = DiffBodies(live, desired)
+			if len(r.Drift) > 0 && !e.AlertOnly {
+				err = e.Adapter.Apply(ctx, id, desired)
+				r.Applied = err == nil
+			}
+		}
+		r.Err = err
+		r.Duration = time.Since(start)
+		switch {
+		case err != nil:
+			log.ErrorContext(ctx, "enforce failed", "target", id, "error", err)
+		case r.Applied:
+			log.InfoContext(ctx, "applied drift", "target", id, "changes", len(r.Drift))
+		case len(r.Drift) > 0:
+			log.WarnContext(ctx, "drift detected", "target", id, "changes", len(r.Drift))
+		}
+		results = append(results, r)
+	}
+	if e.OnPass != nil {
+		e.OnPass(results)
+	}
+	return results, nil
+}
+
+// desired reloads the Binder and returns the config to enforce. When the
+// Binder redacts sensitive values the file is compiled again revealing
+// them; that copy is never stored in the Binder.
+func (e *Enforcer) desired() (*Normalized, error) {
+	n, _, err := e.Binder.Reload()
+	if err != nil || !n.redacted {
+		return n, err
+	}
+	opts := cloneOptions(e.Binder.Options)
+	if opts == nil {
+		opts = &Options{}
+	}
+	opts.RevealSensitive = true
+	n, _, err = (&Binder{Path: e.Binder.Path, Options: opts}).compile()
+	return n, err
+}
+
+// Run calls Enforce every Interval (one minute when zero) until ctx is
+// done. Failed passes are reported through OnError.
+func (e *Enforcer) Run(ctx context.Context) error {
+	interval := e.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		_, _ = e.Enforce(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
+
+// Handler serves POST /-/enforce, running a pass and returning its
+// EnforceReport as JSON.
+func (e *Enforcer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/-/enforce", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		results, err := e.Enforce(r.Context())
+		if err != nil {
+			writeReloadJSON(w, http.StatusUnprocessableEntity, map[string]any{"status": "error", "error": err.Error()})
+			return
+		}
+		writeReloadJSON(w, http.StatusOK, NewEnforceReport(results))
+	})
+	return mux
+}
+
+// Enforce target statuses reported by EnforceReport.
+const (
+	EnforceInSync  = "in_sync"
+	EnforceApplied = "applied"
+	EnforceDrifted = "drifted"
+	EnforceFailed  = "failed"
+)
+
+// EnforceReport is the machine-readable summary of an enforcement pass,
+// written with JSON or Marshal for CI pipelines and dashboards.
+type EnforceReport struct {
+	Status     string                `json:"status" bcl:"status"`
+	DurationMS int64                 `json:"duration_ms" bcl:"duration_ms"`
+	Failed     int                   `json:"failed" bcl:"failed"`
+	Drifted    int                   `json:"drifted" bcl:"drifted"`
+	Targets    []EnforceTargetReport `json:"targets" bcl:"targets,block"`
+}
+
+// EnforceTargetReport is one target of an EnforceReport; Changes are the
+// drifted keys, applied unless Status is "drifted".
+type EnforceTargetReport struct {
+	Name       string        `json:"name" bcl:",id"`
+	Status     string        `json:"status" bcl:"status"`
+	DurationMS int64         `json:"duration_ms" bcl:"duration_ms"`
+	Changes    ConfigChanges `json:"changes,omitempty" bcl:"changes,omitempty"`
+	Error      string        `json:"error,omitempty" bcl:"error,omitempty"`
+}
+
+// NewEnforceReport summarizes the results of one Enforce pass. The report
+// status is the worst target status.
+func NewEnforceReport(results []EnforceResult) EnforceReport {
+	r := EnforceReport{Status: EnforceInSync, Targets: make([]EnforceTargetReport, 0, len(results))}
+	for _, res := range results {
+		t := EnforceTargetReport{Name: res.Target, Status: EnforceInSync, DurationMS: res.Duration.Milliseconds(), Changes: res.Drift}
+		switch {
+		case res.Err != nil:
+			t.Status, t.Error = EnforceFailed, res.Err.Error()
+			r.Failed++
+		case res.Applied:
+			t.Status = EnforceApplied
+		case len(res.Drift) > 0:
+			t.Status = EnforceDrifted
+			r.Drifted++
+		}
+		r.DurationMS += t.DurationMS
+		r.Targets = append(r.Targets, t)
+	}
+	switch {
+	case r.Failed > 0:
+		r.Status = EnforceFailed
+	case r.Drifted > 0:
+		r.Status = EnforceDrifted
+	case slices.ContainsFunc(r.Targets, func(t EnforceTargetReport) bool { return t.Status == EnforceApplied }):
+		r.Status = EnforceApplied
+	}
+	return r
+}
+
+// ExitCode is the process exit status for r: 1 when a target failed, 2
+// when drift was left unapplied (AlertOnly), and 0 otherwise, so an apply
+// or check step can gate a pipeline.
+func (r EnforceReport) ExitCode() int {
+	switch {
+	case r.Failed > 0:
+		return 1
+	case r.Drifted > 0:
+		return 2
+	}
+	return 0
+}
+
+// blocksOfType returns the blocks of type typ among blocks and, at any
+// depth, in their bodies.
+func blocksOfType(blocks []map[string]any, typ string) []map[string]any {
+	var out []map[string]any
+	for _, b := range blocks {
+		if b["type"] == typ {
+			out = append(out, b)
+		}
+		out = append(out, blocksOfType(nestedBlocks(b), typ)...)
+	}
+	return out
+}
+
+// nestedBlocks returns the blocks directly inside b, ordered by body key.
+func nestedBlocks(b map[string]any) []map[string]any {
+	var out []map[string]any
+	body := blockBody(b)
+	for _, k := range slices.Sorted(maps.Keys(body)) {
+		switch xs := body[k].(type) {
+		case []map[string]any:
+			out = append(out, xs...)
+		case []any:
+			for _, x := range xs {
+				if m, ok := x.(map[string]any); ok && m["type"] != nil {
+					out = append(out, m)
+				}
+			}
+		}
+	}
+	return out
+}
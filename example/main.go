@@ -53,8 +53,8 @@ func main() {
 		log.Fatal(err)
 	}
 
-	var app AppConfig
-	if err := bcl.Unmarshal(appSource, &app); err != nil {
+	app, _, err := bcl.UnmarshalTyped[AppConfig](appSource)
+	if err != nil {
 		log.Fatal(err)
 	}
 
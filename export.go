@@ -2,14 +2,15 @@ package bcl
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"math"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -403,6 +404,9 @@ func MigrateDocument(doc *Document, targetVersion string) (*Document, []Diagnost
 type FetchOptions struct {
 	CacheDir string
 	Offline  bool
+	// Context bounds git clones and registry downloads; nil means
+	// context.Background().
+	Context context.Context
 }
 
 func FetchRemoteModules(lock *Lockfile, opts FetchOptions) error {
@@ -412,6 +416,10 @@ func FetchRemoteModules(lock *Lockfile, opts FetchOptions) error {
 	if opts.CacheDir == "" {
 		opts.CacheDir = filepath.Join(os.TempDir(), "bcl-mod-cache")
 	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	for _, entry := range lock.Modules {
 		switch entry.Kind {
 		case "git":
@@ -429,19 +437,18 @@ func FetchRemoteModules(lock *Lockfile, opts FetchOptions) error {
 			if source == "" {
 				source = strings.TrimPrefix(entry.Source, "git::")
 			}
-			if err := exec.Command("git", "clone", source, target).Run(); err != nil {
+			if err := gitFetch(ctx, source, target, entry.Revision); err != nil {
 				return err
 			}
-			if entry.Revision != "" {
-				if err := exec.Command("git", "-C", target, "checkout", entry.Revision).Run(); err != nil {
-					return err
-				}
-			}
 		case "registry":
 			if opts.Offline {
 				return fmt.Errorf("offline mode: cannot fetch registry module %s", entry.Source)
 			}
-			resp, err := http.Get(entry.Source)
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, entry.Source, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
 			if err != nil {
 				return err
 			}
@@ -587,6 +594,9 @@ func needsYAMLQuote(s string) bool {
 	case "null", "true", "false", "~":
 		return true
 	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return true
+	}
 	for i, r := range s {
 		switch r {
 		case ':', '#', '\n', '\r', '\t', '"', '\'', '[', ']', '{', '}', ',', '&', '*', '!', '|', '>', '@', '`':
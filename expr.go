@@ -1,6 +1,7 @@
 package bcl
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
@@ -8,13 +9,17 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"path"
 	"reflect"
 	"regexp"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/oarkflow/convert"
 )
@@ -26,6 +31,18 @@ type EvalOptions struct {
 	Variables     map[string]any
 	Functions     map[string]EvalFunction
 	Now           func() time.Time
+	Generator     *Generator
+	GOOS          string
+	GOARCH        string
+
+	// sandbox is set by ExprSandbox.Eval.
+	sandbox *ExprSandbox
+	// ctx is set by EvalExprContext and by compilations with a context.
+	ctx context.Context
+}
+
+func (o *EvalOptions) goos() string {
+	return firstNonEmpty(o.GOOS, runtime.GOOS)
 }
 
 type EvalFunction func(args []any, opts *EvalOptions) (any, error)
@@ -191,7 +208,7 @@ func (p *ExpressionProgram) Eval(vars map[string]any, opts *EvalOptions) (any, e
 	if len(p.Instr) == 0 {
 		return evalProgramRaw(p.Raw, vars, opts)
 	}
-	if p.fast.kind != exprFastNone {
+	if p.fast.kind != exprFastNone && opts.sandbox == nil {
 		return p.evalFast(vars)
 	}
 	var stack [2]any
@@ -234,7 +251,7 @@ func (p *ExpressionProgram) Eval(vars map[string]any, opts *EvalOptions) (any, e
 			stack[sp-1] = nil
 			stack[sp-2] = nil
 			sp -= 2
-			v, err := evalOp(in.text, a, b)
+			v, err := evalBinary(in.text, a, b, opts)
 			if err != nil {
 				return nil, err
 			}
@@ -311,7 +328,7 @@ func (p *ExpressionProgram) evalHeap(vars map[string]any, opts *EvalOptions) (an
 			b := stack[len(stack)-1]
 			a := stack[len(stack)-2]
 			stack = stack[:len(stack)-2]
-			v, err := evalOp(in.text, a, b)
+			v, err := evalBinary(in.text, a, b, opts)
 			if err != nil {
 				return nil, err
 			}
@@ -347,7 +364,7 @@ func (c *exprCompiler) primary() ([]exprInstr, any, bool, error) {
 	case tokString:
 		return nil, t.text, true, nil
 	case tokNumber:
-		return nil, parseNumber(t).ToInterface(false), true, nil
+		return nil, exprNumber(t), true, nil
 	case tokLBracket:
 		var code []exprInstr
 		var consts []any
@@ -454,7 +471,7 @@ func (c *exprCompiler) call(name string) ([]exprInstr, any, bool, error) {
 
 func pureConstCall(name string) bool {
 	switch name {
-	case "abs", "acos", "append", "asin", "atan", "at", "avg", "bool", "ceil", "clamp", "coalesce", "compact", "concat", "contains", "cos", "difference", "duration", "empty", "ends_with", "entries", "exists", "exp", "first", "flatten", "float", "floor", "get", "has_key", "has_path", "index_of", "int", "intersect", "intersection", "join", "json", "keys", "last", "last_index_of", "ln", "log", "log10", "max", "median", "merge", "min", "not_empty", "omit", "pad_left", "pad_right", "pick", "product", "pow", "prepend", "push", "range", "regex", "regex_match", "regex_replace", "repeat", "reverse", "round", "sin", "sign", "slice", "sort", "split", "sqrt", "starts_with", "str", "string", "substr", "substring", "sum", "tan", "title", "to_bool", "to_float", "to_int", "to_string", "trim", "trim_prefix", "trim_suffix", "union", "unique", "values", "without":
+	case "abs", "acos", "append", "asin", "atan", "at", "avg", "bit_and", "bit_not", "bit_or", "bit_shl", "bit_shr", "bit_xor", "bool", "camelCase", "camel_case", "ceil", "clamp", "coalesce", "compact", "concat", "contains", "cos", "default", "difference", "duration", "empty", "ends_with", "entries", "exists", "exp", "first", "flatten", "float", "floor", "format", "get", "has_key", "has_path", "hex", "index_of", "int", "intersect", "intersection", "join", "json", "kebabCase", "kebab_case", "keys", "last", "last_index_of", "ln", "log", "log10", "mask_to_prefix", "max", "median", "merge", "min", "not_empty", "omit", "PascalCase", "padLeft", "padRight", "pad_left", "pad_right", "parse_mac", "pascal_case", "pick", "product", "pow", "prepend", "push", "range", "regex", "regex_match", "regex_replace", "repeat", "repeat_list", "reverse", "rollout", "round", "safediv", "semver_compare", "semver_parse", "semver_satisfies", "sin", "seq", "sign", "slice", "slugify", "snake_case", "sort", "split", "sqrt", "starts_with", "str", "string", "substr", "substring", "sum", "tan", "title", "to_bool", "to_float", "to_int", "to_number", "to_string", "tonumber", "tostring", "trim", "trim_prefix", "trim_suffix", "truncate", "union", "unique", "values", "without":
 		return true
 	default:
 		return false
@@ -495,23 +512,66 @@ func (c *exprCompiler) peek() token {
 	return c.toks[c.pos]
 }
 
+// exprSource is the cached lexed form of an expression. nums holds the
+// value of each number token, resolved once so evaluation neither re-parses
+// nor re-boxes literals.
+type exprSource struct {
+	toks []token
+	nums []any
+}
+
 func exprTokens(raw string) ([]token, error) {
+	src, err := exprSourceFor(raw)
+	if err != nil {
+		return nil, err
+	}
+	return src.toks, nil
+}
+
+func exprSourceFor(raw string) (*exprSource, error) {
 	if cached, ok := exprTokenCache.Load(raw); ok {
-		return cached.([]token), nil
+		return cached.(*exprSource), nil
 	}
-	toks, errs := lexString("<expr>", raw)
+	toks, errs := lexExpr(raw)
 	if len(errs) > 0 {
 		return nil, errs
 	}
-	exprTokenCache.Store(raw, toks)
-	return toks, nil
+	src := &exprSource{toks: toks}
+	for i, t := range toks {
+		if t.kind != tokNumber {
+			continue
+		}
+		if src.nums == nil {
+			src.nums = make([]any, len(toks))
+		}
+		src.nums[i] = exprNumber(t)
+	}
+	exprTokenCache.Store(raw, src)
+	return src, nil
+}
+
+// exprNumber is the value of a number token in an expression. Byte sizes
+// such as 10MB are integer byte counts there, so they can be compared and
+// computed with.
+func exprNumber(t token) any {
+	l := numberLiteral(t)
+	if l.Type == "bytes" {
+		if n, ok := byteSize(l.Data.(string)); ok {
+			return n
+		}
+	}
+	return l.ToInterface(false)
 }
 
 type exprParser struct {
 	toks []token
+	nums []any
 	pos  int
 	vars map[string]any
 	opts *EvalOptions
+	// skip is non-zero while parsing the untaken branch of a ternary, so
+	// calls such as fail() and operator errors there have no effect.
+	skip int
 }
 
 func evalProgramRaw(raw string, vars map[string]any, opts *EvalOptions) (any, error) {
@@ -522,11 +582,11 @@ func evalProgramRaw(raw string, vars map[string]any, opts *EvalOptions) (any, er
 	if strings.HasPrefix(raw, "match ") || strings.HasPrefix(raw, "match(") {
 		return evalMatchRaw(raw, vars, opts)
 	}
-	toks, err := exprTokens(raw)
+	src, err := exprSourceFor(raw)
 	if err != nil {
 		return nil, err
 	}
-	return (&exprParser{toks: toks, vars: vars, opts: opts}).parse()
+	return (&exprParser{toks: src.toks, nums: src.nums, vars: vars, opts: opts}).parse()
 }
 
 func (e *exprParser) parse() (any, error) {
@@ -534,67 +594,167 @@ func (e *exprParser) parse() (any, error) {
 }
 
 func (e *exprParser) parseExpr(minPrec int) (any, error) {
-	left, err := e.prefix()
+	left, err := e.parseTerm(minPrec)
 	if err != nil {
 		return nil, err
 	}
+	return left.value(), nil
+}
+
+// exprTerm is a value in the middle of an expression. Arithmetic on
+// numbers keeps its result unboxed in f, so a chain such as
+// (a + 5) * 2 - b / 4 boxes only the value it finally returns.
+type exprTerm struct {
+	v   any
+	f   float64
+	num bool
+}
+
+func (o exprTerm) value() any {
+	if o.num {
+		return o.f
+	}
+	return o.v
+}
+
+// float resolves the operand as a number once, for both the unboxed and
+// the boxed case.
+func (o exprTerm) float() (float64, bool) {
+	if o.num {
+		return o.f, true
+	}
+	return num(o.v)
+}
+
+// arith applies the numeric operators that yield a float64 to two
+// numeric operands without boxing. It reports false for anything else,
+// which evalOp then handles.
+func arith(op string, a, b exprTerm) (exprTerm, bool) {
+	af, aok := a.float()
+	if !aok {
+		return exprTerm{}, false
+	}
+	bf, bok := b.float()
+	if !bok {
+		return exprTerm{}, false
+	}
+	switch op {
+	case "+":
+		return exprTerm{f: af + bf, num: true}, true
+	case "-":
+		return exprTerm{f: af - bf, num: true}, true
+	case "*":
+		return exprTerm{f: af * bf, num: true}, true
+	case "/":
+		if bf == 0 {
+			return exprTerm{}, false
+		}
+		return exprTerm{f: af / bf, num: true}, true
+	case ">":
+		return exprTerm{v: compareFloat(af, bf) > 0}, true
+	case ">=":
+		return exprTerm{v: compareFloat(af, bf) >= 0}, true
+	case "<":
+		return exprTerm{v: compareFloat(af, bf) < 0}, true
+	case "<=":
+		return exprTerm{v: compareFloat(af, bf) <= 0}, true
+	}
+	return exprTerm{}, false
+}
+
+func (e *exprParser) parseTerm(minPrec int) (exprTerm, error) {
+	var left exprTerm
+	if e.peek().kind == tokLParen {
+		e.next()
+		inner, err := e.parseTerm(0)
+		if err != nil {
+			return exprTerm{}, err
+		}
+		if e.peek().kind == tokRParen {
+			e.next()
+		}
+		left = inner
+	} else {
+		v, err := e.prefix()
+		if err != nil {
+			return exprTerm{}, err
+		}
+		left.v = v
+	}
 	for {
 		t := e.peek()
 		if t.kind == tokEOF || t.kind == tokNewline || t.kind == tokRBrace || t.kind == tokRParen || t.kind == tokRBracket || t.kind == tokComma {
 			return left, nil
 		}
 		if t.text == "exists" || t.text == "empty" {
-			if 8 < minPrec {
+			if precUnary < minPrec {
 				return left, nil
 			}
 			e.next()
 			if t.text == "exists" {
-				left = left != nil
+				left = exprTerm{v: left.num || left.v != nil}
 			} else {
-				left = isEmpty(left)
+				left = exprTerm{v: isEmpty(left.value())}
 			}
 			continue
 		}
 		if t.text == "between" {
-			if 4 < minPrec {
+			if precCompare < minPrec {
 				return left, nil
 			}
 			e.next()
-			lo, err := e.parseExpr(5)
+			lo, err := e.parseExpr(precCompare + 1)
 			if err != nil {
-				return nil, err
+				return exprTerm{}, err
 			}
 			if e.peek().text == "and" {
 				e.next()
 			}
-			hi, err := e.parseExpr(5)
+			hi, err := e.parseExpr(precCompare + 1)
 			if err != nil {
-				return nil, err
+				return exprTerm{}, err
+			}
+			v := left.value()
+			left = exprTerm{v: compare(v, lo) >= 0 && compare(v, hi) <= 0}
+			continue
+		}
+		if t.text == "??" {
+			if precCoalesce < minPrec {
+				return left, nil
+			}
+			e.next()
+			isNil := !left.num && left.v == nil
+			right, err := e.parseBranch(isNil, precCoalesce)
+			if err != nil {
+				return exprTerm{}, err
+			}
+			if isNil {
+				left = exprTerm{v: right}
 			}
-			left = compare(left, lo) >= 0 && compare(left, hi) <= 0
 			continue
 		}
 		if t.text == "?" {
-			if 1 < minPrec {
+			if precTernary < minPrec {
 				return left, nil
 			}
 			e.next()
-			thenVal, err := e.parseExpr(0)
+			cond := truthy(left.value())
+			thenVal, err := e.parseBranch(cond, 0)
 			if err != nil {
-				return nil, err
+				return exprTerm{}, err
 			}
 			if e.peek().text != ":" {
-				return nil, fmt.Errorf("expected ':' in ternary expression")
+				return exprTerm{}, fmt.Errorf("expected ':' in ternary expression")
 			}
 			e.next()
-			elseVal, err := e.parseExpr(1)
+			elseVal, err := e.parseBranch(!cond, precTernary)
 			if err != nil {
-				return nil, err
+				return exprTerm{}, err
 			}
-			if truthy(left) {
-				left = thenVal
+			if cond {
+				left = exprTerm{v: thenVal}
 			} else {
-				left = elseVal
+				left = exprTerm{v: elseVal}
 			}
 			continue
 		}
@@ -604,31 +764,70 @@ func (e *exprParser) parseExpr(minPrec int) (any, error) {
 			return left, nil
 		}
 		e.next()
-		right, err := e.parseExpr(prec + 1)
+		right, err := e.parseTerm(prec + 1)
 		if err != nil {
-			return nil, err
+			return exprTerm{}, err
 		}
-		left, err = evalOp(op, left, right)
-		if err != nil {
-			return nil, err
+		if e.skip > 0 {
+			continue
+		}
+		result, ok := arith(op, left, right)
+		if !ok {
+			v, err := evalBinary(op, left.value(), right.value(), e.opts)
+			if err != nil {
+				return exprTerm{}, err
+			}
+			result = exprTerm{v: v}
+		}
+		left = result
+		// 1 <= x <= 10 reads as 1 <= x and x <= 10; x is evaluated once.
+		for orderingOp(op) && orderingOp(e.peek().text) {
+			op = e.next().text
+			ok := truthy(left.v)
+			next, err := e.parseBranch(ok, prec+1)
+			if err != nil {
+				return exprTerm{}, err
+			}
+			if ok {
+				if left.v, err = evalOp(op, right.value(), next); err != nil {
+					return exprTerm{}, err
+				}
+			}
+			right = exprTerm{v: next}
 		}
 	}
 }
 
+func orderingOp(op string) bool {
+	return op == "<" || op == "<=" || op == ">" || op == ">="
+}
+
+func (e *exprParser) parseBranch(taken bool, minPrec int) (any, error) {
+	if taken {
+		return e.parseExpr(minPrec)
+	}
+	e.skip++
+	defer func() { e.skip-- }()
+	return e.parseExpr(minPrec)
+}
+
 func (e *exprParser) prefix() (any, error) {
 	t := e.next()
 	switch t.kind {
 	case tokString:
 		return t.text, nil
 	case tokNumber:
-		return parseNumber(t).ToInterface(false), nil
+		if e.nums != nil {
+			return e.nums[e.pos-1], nil
+		}
+		return exprNumber(t), nil
 	case tokOperator:
 		switch t.text {
 		case "!":
-			v, err := e.parseExpr(8)
+			v, err := e.parseExpr(precUnary)
 			return !truthy(v), err
 		case "-":
-			v, err := e.parseExpr(8)
+			v, err := e.parseExpr(precUnary)
 			if err != nil {
 				return nil, err
 			}
@@ -701,6 +900,14 @@ func (e *exprParser) prefix() (any, error) {
 
 func (e *exprParser) call(name string) (any, error) {
 	e.next()
+	switch name {
+	case "cond":
+		return e.condCall()
+	case "try":
+		return e.tryCall()
+	case "coalesce":
+		return e.coalesceCall()
+	}
 	var args []any
 	for e.peek().kind != tokRParen && e.peek().kind != tokEOF {
 		v, err := e.parseExpr(0)
@@ -715,21 +922,130 @@ func (e *exprParser) call(name string) (any, error) {
 	if e.peek().kind == tokRParen {
 		e.next()
 	}
+	if e.skip > 0 {
+		return nil, nil
+	}
 	return evalCall(name, args, e.opts)
 }
 
+// condCall evaluates cond(test, then, else) lazily so only the selected
+// argument runs.
+func (e *exprParser) condCall() (any, error) {
+	test, err := e.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	ok := truthy(test)
+	if !e.expectArgSeparator() {
+		return nil, fmt.Errorf("cond requires 3 arguments")
+	}
+	thenVal, err := e.parseBranch(ok, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !e.expectArgSeparator() {
+		return nil, fmt.Errorf("cond requires 3 arguments")
+	}
+	elseVal, err := e.parseBranch(!ok, 0)
+	if err != nil {
+		return nil, err
+	}
+	if e.peek().kind != tokRParen {
+		return nil, fmt.Errorf("cond requires 3 arguments")
+	}
+	e.next()
+	if ok {
+		return thenVal, nil
+	}
+	return elseVal, nil
+}
+
+// tryCall evaluates try(expr, fallback): the fallback is used when expr fails
+// or yields no value.
+func (e *exprParser) tryCall() (any, error) {
+	start := e.pos
+	v, err := e.parseExpr(0)
+	failed := err != nil || v == nil
+	if err != nil {
+		e.pos = start
+		if _, err := e.parseBranch(false, 0); err != nil {
+			return nil, err
+		}
+	}
+	if !e.expectArgSeparator() {
+		return nil, fmt.Errorf("try requires 2 arguments")
+	}
+	fallback, err := e.parseBranch(failed, 0)
+	if err != nil {
+		return nil, err
+	}
+	if e.peek().kind != tokRParen {
+		return nil, fmt.Errorf("try requires 2 arguments")
+	}
+	e.next()
+	if failed {
+		return fallback, nil
+	}
+	return v, nil
+}
+
+// coalesceCall returns the first non-empty argument without evaluating the
+// ones after it.
+func (e *exprParser) coalesceCall() (any, error) {
+	var out any
+	found := false
+	for e.peek().kind != tokRParen && e.peek().kind != tokEOF {
+		v, err := e.parseBranch(!found, 0)
+		if err != nil {
+			return nil, err
+		}
+		if !found && v != nil && !isEmpty(v) {
+			out, found = v, true
+		}
+		if e.peek().kind == tokComma {
+			e.next()
+		}
+	}
+	if e.peek().kind == tokRParen {
+		e.next()
+	}
+	return out, nil
+}
+
+func (e *exprParser) expectArgSeparator() bool {
+	if e.peek().kind != tokComma {
+		return false
+	}
+	e.next()
+	return true
+}
+
+// Operator precedence, from loosest to tightest binding. The ternary and
+// ?? group to the right, the others to the left, except that ordering
+// comparisons chain: a < b <= c is a < b and b <= c.
+const (
+	precTernary  = 1 // c ? a : b
+	precCoalesce = 2 // a ?? b
+	precOr       = 3 // or ||
+	precAnd      = 4 // and &&
+	precCompare  = 5 // == != < <= > >= in not_in contains matches between ...
+	precAdd      = 6 // + -
+	precMul      = 7 // * / // %
+	precUnary    = 8 // !x -x, x exists, x empty
+)
+
 func infixPrecedence(op string) (int, bool) {
 	switch op {
-	case "or":
-		return 2, true
-	case "and":
-		return 3, true
+	case "or", "||":
+		return precOr, true
+	case "and", "&&":
+		return precAnd, true
 	case "==", "!=", ">", ">=", "<", "<=", "in", "not_in", "contains", "starts_with", "ends_with", "matches", "has", "has_any", "has_all", "equals", "greater_than", "less_than", "greater_or_equal", "less_or_equal":
-		return 4, true
+		return precCompare, true
 	case "+", "-":
-		return 5, true
-	case "*", "/", "%":
-		return 6, true
+		return precAdd, true
+	case "*", "/", "//", "%":
+		return precMul, true
 	default:
 		return 0, false
 	}
@@ -739,11 +1055,23 @@ func evalCall(name string, args []any, opts *EvalOptions) (any, error) {
 	if opts == nil {
 		opts = defaultEvalOptions()
 	}
+	if opts.ctx != nil {
+		if err := opts.ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.sandbox != nil {
+		return opts.sandbox.call(name, args, opts)
+	}
 	if opts.Functions != nil {
 		if fn := opts.Functions[name]; fn != nil {
 			return fn(args, opts)
 		}
 	}
+	return evalBuiltin(name, args, opts)
+}
+
+func evalBuiltin(name string, args []any, opts *EvalOptions) (any, error) {
 	switch name {
 	case "SOME":
 		if len(args) != 1 {
@@ -779,7 +1107,25 @@ func evalCall(name string, args []any, opts *EvalOptions) (any, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("title requires 1 argument")
 		}
-		return strings.Title(fmt.Sprint(args[0])), nil
+		return titleCase(fmt.Sprint(args[0])), nil
+	case "camel_case", "camelCase", "pascal_case", "PascalCase", "snake_case", "kebab_case", "kebabCase", "slugify":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s requires 1 argument", name)
+		}
+		return convertCase(name, fmt.Sprint(args[0])), nil
+	case "truncate":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("truncate requires 2 or 3 arguments")
+		}
+		width, ok := intScalarValue(args[1])
+		if !ok {
+			return nil, fmt.Errorf("truncate width must be an integer")
+		}
+		suffix := ""
+		if len(args) == 3 {
+			suffix = fmt.Sprint(args[2])
+		}
+		return truncateRunes(fmt.Sprint(args[0]), width, suffix), nil
 	case "starts_with":
 		if len(args) != 2 {
 			return nil, fmt.Errorf("starts_with requires 2 arguments")
@@ -800,6 +1146,15 @@ func evalCall(name string, args []any, opts *EvalOptions) (any, error) {
 			return nil, fmt.Errorf("trim_suffix requires 2 arguments")
 		}
 		return strings.TrimSuffix(fmt.Sprint(args[0]), fmt.Sprint(args[1])), nil
+	case "format":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("format requires a format string")
+		}
+		format, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("format requires a string format")
+		}
+		return formatString(format, args[1:]), nil
 	case "repeat":
 		if len(args) != 2 {
 			return nil, fmt.Errorf("repeat requires 2 arguments")
@@ -808,11 +1163,8 @@ func evalCall(name string, args []any, opts *EvalOptions) (any, error) {
 		if !ok {
 			return nil, fmt.Errorf("repeat count must be an integer")
 		}
-		if count < 0 {
-			return nil, fmt.Errorf("repeat count must be non-negative")
-		}
-		return strings.Repeat(fmt.Sprint(args[0]), count), nil
-	case "pad_left", "pad_right":
+		return repeatString(fmt.Sprint(args[0]), count)
+	case "pad_left", "pad_right", "padLeft", "padRight":
 		if len(args) != 2 && len(args) != 3 {
 			return nil, fmt.Errorf("%s requires 2 or 3 arguments", name)
 		}
@@ -824,7 +1176,7 @@ func evalCall(name string, args []any, opts *EvalOptions) (any, error) {
 		if len(args) == 3 {
 			pad = fmt.Sprint(args[2])
 		}
-		return padString(fmt.Sprint(args[0]), width, pad, name == "pad_left"), nil
+		return padString(fmt.Sprint(args[0]), width, pad, name == "pad_left" || name == "padLeft"), nil
 	case "substr", "substring":
 		if len(args) != 2 && len(args) != 3 {
 			return nil, fmt.Errorf("%s requires 2 or 3 arguments", name)
@@ -1072,7 +1424,46 @@ func evalCall(name string, args []any, opts *EvalOptions) (any, error) {
 				return nil, fmt.Errorf("range step must be an integer")
 			}
 		}
-		return rangeValues(start, end, step)
+		return rangeValues(start, end, step, false)
+	case "seq":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("seq requires 2 or 3 arguments")
+		}
+		start, ok1 := intScalarValue(args[0])
+		end, ok2 := intScalarValue(args[1])
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("seq bounds must be integers")
+		}
+		step := 1
+		if end < start {
+			step = -1
+		}
+		if len(args) == 3 {
+			var ok bool
+			if step, ok = intScalarValue(args[2]); !ok {
+				return nil, fmt.Errorf("seq step must be an integer")
+			}
+			if step == 0 || (start != end && (step > 0) != (end > start)) {
+				return nil, fmt.Errorf("seq step %d never reaches %d from %d", step, end, start)
+			}
+		}
+		return rangeValues(start, end, step, true)
+	case "repeat_list":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("repeat_list requires 2 arguments")
+		}
+		count, ok := intScalarValue(args[1])
+		if !ok || count < 0 {
+			return nil, fmt.Errorf("repeat_list count must be a non-negative integer")
+		}
+		if count > maxGeneratedItems {
+			return nil, fmt.Errorf("repeat_list count %d exceeds limit of %d", count, maxGeneratedItems)
+		}
+		out := make([]any, count)
+		for i := range out {
+			out[i] = cloneAny(args[0])
+		}
+		return out, nil
 	case "keys":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("keys requires 1 argument")
@@ -1147,11 +1538,16 @@ func evalCall(name string, args []any, opts *EvalOptions) (any, error) {
 			}
 		}
 		return out, nil
-	case "str", "string", "to_string":
+	case "str", "string", "to_string", "tostring":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("%s requires 1 argument", name)
 		}
-		return fmt.Sprint(args[0]), nil
+		return toStringValue(args[0])
+	case "tonumber", "to_number":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s requires 1 argument", name)
+		}
+		return toNumber(args[0])
 	case "int", "to_int":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("%s requires 1 argument", name)
@@ -1176,6 +1572,19 @@ func evalCall(name string, args []any, opts *EvalOptions) (any, error) {
 			return nil, fmt.Errorf("abs requires a numeric value")
 		}
 		return math.Abs(f), nil
+	case "safediv":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("safediv requires 3 arguments")
+		}
+		af, aok := num(args[0])
+		bf, bok := num(args[1])
+		if !aok || !bok {
+			return nil, fmt.Errorf("safediv requires numeric values")
+		}
+		if bf == 0 {
+			return args[2], nil
+		}
+		return af / bf, nil
 	case "floor":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("floor requires 1 argument")
@@ -1377,6 +1786,16 @@ func evalCall(name string, args []any, opts *EvalOptions) (any, error) {
 			return nil, fmt.Errorf("ip requires 1 argument")
 		}
 		return net.ParseIP(fmt.Sprint(args[0])), nil
+	case "parse_mac":
+		return evalParseMAC(args)
+	case "mask_to_prefix":
+		return evalMaskToPrefix(args)
+	case "hex":
+		return evalHex(args)
+	case "rollout":
+		return evalRollout(args)
+	case "bit_and", "bit_or", "bit_xor", "bit_not", "bit_shl", "bit_shr":
+		return evalBits(name, args)
 	case "time":
 		if len(args) == 0 {
 			if !opts.AllowTime {
@@ -1460,6 +1879,130 @@ func evalCall(name string, args []any, opts *EvalOptions) (any, error) {
 			return nil, fmt.Errorf("%s requires 0 arguments", name)
 		}
 		return randomUUID()
+	case "assert":
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("assert requires 1 or 2 arguments")
+		}
+		if !truthy(args[0]) {
+			if len(args) == 2 {
+				return nil, fmt.Errorf("assertion failed: %v", args[1])
+			}
+			return nil, fmt.Errorf("assertion failed")
+		}
+		return true, nil
+	case "fail":
+		if len(args) > 1 {
+			return nil, fmt.Errorf("fail requires 0 or 1 arguments")
+		}
+		if len(args) == 1 {
+			return nil, fmt.Errorf("%v", args[0])
+		}
+		return nil, fmt.Errorf("evaluation failed")
+	case "semver_parse":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("semver_parse requires 1 argument")
+		}
+		v, err := parseSemver(fmt.Sprint(args[0]))
+		if err != nil {
+			return nil, err
+		}
+		return v.toMap(), nil
+	case "semver_compare":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("semver_compare requires 2 arguments")
+		}
+		a, err := parseSemver(fmt.Sprint(args[0]))
+		if err != nil {
+			return nil, err
+		}
+		b, err := parseSemver(fmt.Sprint(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		return compareSemver(a, b), nil
+	case "semver_satisfies":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("semver_satisfies requires 2 arguments")
+		}
+		return semverSatisfies(fmt.Sprint(args[0]), fmt.Sprint(args[1]))
+	case "os":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("os takes no arguments")
+		}
+		return opts.goos(), nil
+	case "arch":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("arch takes no arguments")
+		}
+		return firstNonEmpty(opts.GOARCH, runtime.GOARCH), nil
+	case "pathjoin":
+		var parts []string
+		for _, a := range args {
+			items, ok := a.([]any)
+			if !ok {
+				items = []any{a}
+			}
+			for _, item := range items {
+				parts = append(parts, strings.ReplaceAll(fmt.Sprint(item), "\\", "/"))
+			}
+		}
+		joined := path.Join(parts...)
+		if opts.goos() == "windows" {
+			joined = strings.ReplaceAll(joined, "/", "\\")
+		}
+		return joined, nil
+	case "on_windows", "on_linux", "on_darwin":
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("%s requires 1 or 2 arguments", name)
+		}
+		if opts.goos() == strings.TrimPrefix(name, "on_") {
+			return args[0], nil
+		}
+		if len(args) == 2 {
+			return args[1], nil
+		}
+		return nil, nil
+	case "random_int":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("random_int requires 2 arguments")
+		}
+		lo, ok1 := intScalarValue(args[0])
+		hi, ok2 := intScalarValue(args[1])
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("random_int bounds must be integers")
+		}
+		return opts.Generator.Int(int64(lo), int64(hi))
+	case "random_string":
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("random_string requires 1 or 2 arguments")
+		}
+		n, ok := intScalarValue(args[0])
+		if !ok {
+			return nil, fmt.Errorf("random_string length must be an integer")
+		}
+		charset := ""
+		if len(args) == 2 {
+			charset = fmt.Sprint(args[1])
+		}
+		return opts.Generator.String(n, charset)
+	case "sequence":
+		if len(args) > 2 {
+			return nil, fmt.Errorf("sequence requires 0 to 2 arguments")
+		}
+		start, step := 1, 1
+		if len(args) > 0 {
+			var ok bool
+			if start, ok = intScalarValue(args[0]); !ok {
+				return nil, fmt.Errorf("sequence start must be an integer")
+			}
+		}
+		if len(args) > 1 {
+			var ok bool
+			if step, ok = intScalarValue(args[1]); !ok {
+				return nil, fmt.Errorf("sequence step must be an integer")
+			}
+		}
+		return opts.Generator.Next(int64(start), int64(step)), nil
 	case "unique_id", "uid":
 		prefix := "id"
 		if len(args) > 0 && fmt.Sprint(args[0]) != "" {
@@ -1492,6 +2035,30 @@ func evalCall(name string, args []any, opts *EvalOptions) (any, error) {
 			}
 		}
 		return nil, nil
+	case "cond":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("cond requires 3 arguments")
+		}
+		if truthy(args[0]) {
+			return args[1], nil
+		}
+		return args[2], nil
+	case "try":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("try requires 2 arguments")
+		}
+		if args[0] == nil {
+			return args[1], nil
+		}
+		return args[0], nil
+	case "default":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("default requires 2 arguments")
+		}
+		if args[0] == nil || args[0] == "" {
+			return args[1], nil
+		}
+		return args[0], nil
 	case "base64":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("base64 requires 1 argument")
@@ -1517,6 +2084,22 @@ func evalCall(name string, args []any, opts *EvalOptions) (any, error) {
 	}
 }
 
+// evalBinary is evalOp under the size limits of the sandbox, if any, which
+// apply to every intermediate value and not just the final one.
+func evalBinary(op string, a, b any, opts *EvalOptions) (any, error) {
+	if opts == nil || opts.sandbox == nil {
+		return evalOp(op, a, b)
+	}
+	if err := opts.sandbox.checkOp(op, a, b); err != nil {
+		return nil, err
+	}
+	v, err := evalOp(op, a, b)
+	if err != nil {
+		return nil, err
+	}
+	return v, opts.sandbox.checkSize(v)
+}
+
 func evalOp(op string, a, b any) (any, error) {
 	switch op {
 	case "equals":
@@ -1548,6 +2131,12 @@ func evalOp(op string, a, b any) (any, error) {
 		}
 		return af - bf, nil
 	case "*":
+		if s, ok := a.(string); ok {
+			return repeatString(s, b)
+		}
+		if s, ok := b.(string); ok {
+			return repeatString(s, a)
+		}
 		af, aok := num(a)
 		bf, bok := num(b)
 		if !aok || !bok {
@@ -1564,16 +2153,57 @@ func evalOp(op string, a, b any) (any, error) {
 			return nil, fmt.Errorf("division by zero")
 		}
 		return af / bf, nil
+	case "//":
+		// Integer division rounds toward negative infinity, like
+		// floor(a / b).
+		af, aok := num(a)
+		bf, bok := num(b)
+		if !aok || !bok {
+			return nil, fmt.Errorf("// requires numeric values")
+		}
+		if bf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		ai, aInt := intScalarValue(a)
+		bi, bInt := intScalarValue(b)
+		if aInt && bInt {
+			q := ai / bi
+			if ai%bi != 0 && (ai < 0) != (bi < 0) {
+				q--
+			}
+			return int64(q), nil
+		}
+		q := math.Floor(af / bf)
+		if q >= math.MinInt64 && q <= math.MaxInt64 {
+			return int64(q), nil
+		}
+		return q, nil
 	case "%":
-		ai, aok := intScalarValue(a)
-		bi, bok := intScalarValue(b)
+		if format, ok := a.(string); ok {
+			if args, ok := b.([]any); ok {
+				return formatString(format, args), nil
+			}
+			return formatString(format, []any{b}), nil
+		}
+		if ai, aok := intScalarValue(a); aok {
+			if bi, bok := intScalarValue(b); bok {
+				if bi == 0 {
+					return nil, fmt.Errorf("modulo by zero")
+				}
+				return ai % bi, nil
+			}
+		}
+		// Fractional operands use math.Mod: the result has the sign of a,
+		// as integer % does.
+		af, aok := num(a)
+		bf, bok := num(b)
 		if !aok || !bok {
-			return nil, fmt.Errorf("%% requires integer values")
+			return nil, fmt.Errorf("%% requires numeric values")
 		}
-		if bi == 0 {
+		if bf == 0 {
 			return nil, fmt.Errorf("modulo by zero")
 		}
-		return ai % bi, nil
+		return math.Mod(af, bf), nil
 	case ">", ">=", "<", "<=":
 		c := compare(a, b)
 		switch op {
@@ -1586,14 +2216,19 @@ func evalOp(op string, a, b any) (any, error) {
 		default:
 			return c <= 0, nil
 		}
-	case "in", "contains", "has":
+	case "in":
+		return member(a, b)
+	case "contains", "has":
 		if ipnet, ok := b.(*net.IPNet); ok {
 			return ipnet.Contains(net.ParseIP(fmt.Sprint(a))), nil
 		}
 		return contains(b, a) || contains(a, b), nil
 	case "not_in":
-		ok, _ := evalOp("in", a, b)
-		return !ok.(bool), nil
+		ok, err := member(a, b)
+		if err != nil {
+			return nil, err
+		}
+		return !ok, nil
 	case "starts_with":
 		return strings.HasPrefix(fmt.Sprint(a), fmt.Sprint(b)), nil
 	case "ends_with":
@@ -1616,9 +2251,9 @@ func evalOp(op string, a, b any) (any, error) {
 		return hasAny(a, b), nil
 	case "has_all":
 		return hasAll(a, b), nil
-	case "and":
+	case "and", "&&":
 		return truthy(a) && truthy(b), nil
-	case "or":
+	case "or", "||":
 		return truthy(a) || truthy(b), nil
 	default:
 		return nil, fmt.Errorf("unsupported operator %q", op)
@@ -2733,6 +3368,12 @@ func lookupPart(cur any, part string) any {
 	if m, ok := cur.(map[string]any); ok {
 		return m[part]
 	}
+	if env, ok := cur.(func(string) (string, bool)); ok {
+		if v, ok := env(part); ok {
+			return v
+		}
+		return nil
+	}
 	rv := reflect.ValueOf(cur)
 	if rv.Kind() == reflect.Struct {
 		f := rv.FieldByName(part)
@@ -2758,6 +3399,26 @@ func lookupPartPresence(cur any, part string) (any, bool) {
 	return nil, false
 }
 
+// exprReadsEnv reports whether raw reads an environment variable through
+// member access such as env.APP_ENV.
+func exprReadsEnv(raw string) bool {
+	if !strings.Contains(raw, "env") {
+		return false
+	}
+	toks, err := exprTokens(raw)
+	if err != nil {
+		return false
+	}
+	for i := 0; i+2 < len(toks); i++ {
+		if toks[i].kind == tokIdent && toks[i].text == "env" && toks[i+1].kind == tokDot && toks[i+2].kind == tokIdent {
+			if (i == 0 || toks[i-1].kind != tokDot) && (i+3 >= len(toks) || toks[i+3].kind != tokLParen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func lookupParts(vars map[string]any, parts []string) any {
 	var cur any = vars
 	for _, part := range parts {
@@ -2769,6 +3430,43 @@ func lookupParts(vars map[string]any, parts []string) any {
 	return cur
 }
 
+// member implements x in container: an element of a list, a key of a map,
+// a substring of a string, or an address of a network. Unlike contains it
+// never looks the other way, so ["a"] in "a" is an error, not true.
+func member(x, container any) (bool, error) {
+	switch c := container.(type) {
+	case nil:
+		return false, nil
+	case string:
+		s, ok := x.(string)
+		if !ok {
+			return false, fmt.Errorf("in: a string only contains strings, not %T", x)
+		}
+		return strings.Contains(c, s), nil
+	case map[string]any:
+		key, ok := x.(string)
+		if !ok {
+			return false, fmt.Errorf("in: map keys are strings, not %T", x)
+		}
+		_, found := c[key]
+		return found, nil
+	case *net.IPNet:
+		return c.Contains(net.ParseIP(fmt.Sprint(x))), nil
+	}
+	switch reflect.ValueOf(container).Kind() {
+	case reflect.Slice, reflect.Array:
+		return containsValue(container, x), nil
+	case reflect.Map:
+		rv := reflect.ValueOf(container)
+		key := reflect.ValueOf(x)
+		if !key.IsValid() || !key.Type().AssignableTo(rv.Type().Key()) {
+			return false, nil
+		}
+		return rv.MapIndex(key).IsValid(), nil
+	}
+	return false, fmt.Errorf("in: cannot look for a value in %T", container)
+}
+
 func contains(container, value any) bool {
 	if s, ok := container.(string); ok {
 		return strings.Contains(s, fmt.Sprint(value))
@@ -2862,6 +3560,95 @@ func padString(s string, width int, pad string, left bool) string {
 	return s + prefix
 }
 
+func truncateRunes(s string, width int, suffix string) string {
+	rs := []rune(s)
+	if width < 0 || len(rs) <= width {
+		return s
+	}
+	keep := width - len([]rune(suffix))
+	if keep <= 0 {
+		return string([]rune(suffix)[:width])
+	}
+	return string(rs[:keep]) + suffix
+}
+
+// splitWords breaks a label into words on punctuation, whitespace and case
+// boundaries, so "HTTPServer-name_v2" yields HTTP, Server, name, v2.
+func splitWords(s string) []string {
+	var words []string
+	var cur []rune
+	rs := []rune(s)
+	flush := func() {
+		if len(cur) > 0 {
+			words = append(words, string(cur))
+			cur = cur[:0]
+		}
+	}
+	for i, r := range rs {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if unicode.IsUpper(r) && len(cur) > 0 {
+			prev := cur[len(cur)-1]
+			nextLower := i+1 < len(rs) && unicode.IsLower(rs[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				flush()
+			}
+		}
+		cur = append(cur, r)
+	}
+	flush()
+	return words
+}
+
+func capitalize(s string) string {
+	rs := []rune(strings.ToLower(s))
+	if len(rs) == 0 {
+		return ""
+	}
+	rs[0] = unicode.ToUpper(rs[0])
+	return string(rs)
+}
+
+func titleCase(s string) string {
+	rs := []rune(s)
+	start := true
+	for i, r := range rs {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\''
+		if word && start {
+			rs[i] = unicode.ToTitle(r)
+		}
+		start = !word
+	}
+	return string(rs)
+}
+
+func convertCase(name, s string) string {
+	if name == "slugify" {
+		var words []string
+		for _, w := range strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+			words = append(words, strings.ToLower(w))
+		}
+		return strings.Join(words, "-")
+	}
+	words := splitWords(s)
+	switch name {
+	case "snake_case":
+		return strings.ToLower(strings.Join(words, "_"))
+	case "kebab_case", "kebabCase":
+		return strings.ToLower(strings.Join(words, "-"))
+	}
+	for i, w := range words {
+		if i == 0 && (name == "camel_case" || name == "camelCase") {
+			words[i] = strings.ToLower(w)
+			continue
+		}
+		words[i] = capitalize(w)
+	}
+	return strings.Join(words, "")
+}
+
 func repeatRunes(s string, n int) string {
 	rs := []rune(s)
 	if len(rs) == 0 || n <= 0 {
@@ -3084,19 +3871,40 @@ func uniqueList(xs []any) []any {
 	return out
 }
 
-func rangeValues(start, end, step int) ([]any, error) {
+// maxGeneratedItems bounds lists built by range, seq and repeat_list so a typo
+// cannot exhaust memory.
+const maxGeneratedItems = 100000
+
+// maxGeneratedBytes bounds strings built by repetition in the same way.
+const maxGeneratedBytes = 16 << 20
+
+// rangeValues returns start, start+step, ... up to end, which is included
+// only when inclusive. The item count is worked out in unsigned arithmetic,
+// so bounds near the int limits neither overflow nor slip past
+// maxGeneratedItems.
+func rangeValues(start, end, step int, inclusive bool) ([]any, error) {
 	if step == 0 {
 		return nil, fmt.Errorf("range step must not be zero")
 	}
-	var out []any
-	if step > 0 {
-		for i := start; i < end; i += step {
-			out = append(out, i)
-		}
-		return out, nil
+	var diff, stride uint64
+	switch {
+	case step > 0 && (end > start || inclusive && end == start):
+		diff, stride = uint64(end)-uint64(start), uint64(step)
+	case step < 0 && (end < start || inclusive && end == start):
+		diff, stride = uint64(start)-uint64(end), -uint64(step)
+	default:
+		return nil, nil
 	}
-	for i := start; i > end; i += step {
-		out = append(out, i)
+	n := diff / stride
+	if inclusive || diff%stride != 0 {
+		n++
+	}
+	if n > maxGeneratedItems {
+		return nil, fmt.Errorf("range of %d items exceeds limit of %d", n, maxGeneratedItems)
+	}
+	out := make([]any, n)
+	for i := range out {
+		out[i] = start + i*step
 	}
 	return out, nil
 }
@@ -3238,16 +4046,133 @@ func median(values []float64) float64 {
 	return (xs[mid-1] + xs[mid]) / 2
 }
 
+// The conversion built-ins are strict: they accept the obvious textual forms
+// (as read from env vars) and reject anything that would silently lose
+// information, such as int("12abc"), int(3.7) or bool(2).
+
 func toInt(v any) (int, error) {
-	return convert.ToInt(v)
+	switch x := v.(type) {
+	case int:
+		return x, nil
+	case int64:
+		return int(x), nil
+	case uint64:
+		if x > math.MaxInt {
+			return 0, fmt.Errorf("int: %d overflows int", x)
+		}
+		return int(x), nil
+	case float64:
+		if x != math.Trunc(x) || math.IsInf(x, 0) || math.IsNaN(x) {
+			return 0, fmt.Errorf("int: %v is not a whole number", x)
+		}
+		return int(x), nil
+	case string:
+		s := strings.TrimSpace(x)
+		if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return int(i), nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil && f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return int(f), nil
+		}
+		return 0, fmt.Errorf("int: cannot convert %q to int", x)
+	}
+	return 0, conversionError("int", v)
 }
 
 func toFloat(v any) (float64, error) {
-	return convert.ToFloat64(v)
+	if f, ok := num(v); ok {
+		return f, nil
+	}
+	if x, ok := v.(string); ok {
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err != nil {
+			return 0, fmt.Errorf("float: cannot convert %q to float", x)
+		}
+		return f, nil
+	}
+	return 0, conversionError("float", v)
+}
+
+// toNumber converts to int64 when the value is integral and float64 otherwise.
+func toNumber(v any) (any, error) {
+	switch x := v.(type) {
+	case int:
+		return int64(x), nil
+	case int64, uint64, float64:
+		return x, nil
+	case string:
+		s := strings.TrimSpace(x)
+		if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, nil
+		}
+		return nil, fmt.Errorf("tonumber: cannot convert %q to number", x)
+	}
+	return nil, conversionError("tonumber", v)
 }
 
 func toBool(v any) (bool, error) {
-	return convert.ToBool(v)
+	switch x := v.(type) {
+	case bool:
+		return x, nil
+	case int, int64, uint64, float64:
+		f, _ := num(x)
+		if f == 0 || f == 1 {
+			return f == 1, nil
+		}
+		return false, fmt.Errorf("bool: cannot convert %v to bool", x)
+	case string:
+		switch strings.ToLower(strings.TrimSpace(x)) {
+		case "true", "yes", "on", "1":
+			return true, nil
+		case "false", "no", "off", "0":
+			return false, nil
+		}
+		return false, fmt.Errorf("bool: cannot convert %q to bool", x)
+	}
+	return false, conversionError("bool", v)
+}
+
+func toStringValue(v any) (string, error) {
+	switch x := v.(type) {
+	case nil:
+		return "", conversionError("string", v)
+	case string:
+		return x, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(x), nil
+	case time.Duration:
+		return x.String(), nil
+	case map[string]any, []any:
+		return "", fmt.Errorf("string: cannot convert %s; use json() for structured values", valueTypeName(v))
+	}
+	return fmt.Sprint(v), nil
+}
+
+func conversionError(fn string, v any) error {
+	return fmt.Errorf("%s: cannot convert %s", fn, valueTypeName(v))
+}
+
+func valueTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case int, int64, uint64:
+		return "int"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
 }
 
 func equalLoose(a, b any) bool {
@@ -3404,6 +4329,8 @@ func isEmpty(v any) bool {
 		return x == 0
 	case int64:
 		return x == 0
+	case uint64:
+		return x == 0
 	case float64:
 		return x == 0
 	case float32:
@@ -3432,13 +4359,7 @@ func compare(a, b any) int {
 	af, aok := num(a)
 	bf, bok := num(b)
 	if aok && bok {
-		if af < bf {
-			return -1
-		}
-		if af > bf {
-			return 1
-		}
-		return 0
+		return compareFloat(af, bf)
 	}
 	as, aIsStr := a.(string)
 	bs, bIsStr := b.(string)
@@ -3448,12 +4369,27 @@ func compare(a, b any) int {
 	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
 }
 
+// compareFloat orders numbers for compare; NaN compares equal to anything.
+func compareFloat(a, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
 func num(v any) (float64, bool) {
 	switch x := v.(type) {
 	case int:
 		return float64(x), true
 	case int64:
 		return float64(x), true
+	case uint64:
+		// Integer literals past the int64 range; arithmetic on them yields
+		// floats, as it does for other integers.
+		return float64(x), true
 	case float64:
 		return x, true
 	case float32:
@@ -3508,35 +4444,81 @@ func intToStr(x int) string {
 }
 
 func floatToStr(f float64) string {
-	var buf [32]byte
-	i := len(buf)
-	if f < 0 {
-		i--
-		buf[i] = '-'
-		f = -f
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// repeatString implements string * count.
+func repeatString(s string, count any) (string, error) {
+	n, ok := intScalarValue(count)
+	if !ok {
+		return "", fmt.Errorf("* repeats a string by an integer count")
 	}
-	intPart := int64(f)
-	fracPart := f - float64(intPart)
-	for {
-		i--
-		buf[i] = byte('0' + intPart%10)
-		intPart /= 10
-		if intPart == 0 {
+	if n < 0 {
+		return "", fmt.Errorf("repeat count must be non-negative")
+	}
+	if n > 0 && len(s) > maxGeneratedBytes/n {
+		return "", fmt.Errorf("repeated string exceeds limit of %d bytes", maxGeneratedBytes)
+	}
+	return strings.Repeat(s, n), nil
+}
+
+// formatString is fmt.Sprintf for format() and string % args. Arithmetic
+// yields floats, so whole floats given to integer verbs such as %d are
+// passed as integers, and integers given to float verbs as floats.
+func formatString(format string, args []any) string {
+	args = slices.Clone(args)
+	arg := 0
+	for i := 0; i < len(format) && arg < len(args); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		j := i + 1
+		for j < len(format) && strings.IndexByte("+-# 0123456789.", format[j]) >= 0 {
+			j++
+		}
+		if j == len(format) {
 			break
 		}
+		switch verb := format[j]; {
+		case verb == '%':
+		case strings.IndexByte("dboxXcU", verb) >= 0:
+			if f, ok := args[arg].(float64); ok && f == math.Trunc(f) {
+				args[arg] = int64(f)
+			}
+			arg++
+		case strings.IndexByte("eEfFgG", verb) >= 0:
+			if n, ok := args[arg].(int64); ok {
+				args[arg] = float64(n)
+			}
+			arg++
+		default:
+			arg++
+		}
+		i = j
 	}
-	if fracPart > 0 {
-		i--
-		buf[i] = '.'
-		for j := 0; j < 6; j++ {
-			fracPart *= 10
-			digit := int(fracPart)
-			i--
-			buf[i] = byte('0' + digit)
-			fracPart -= float64(digit)
+	return fmt.Sprintf(format, args...)
+}
+
+// formatFieldBytes returns the sum of the widths and precisions in format:
+// the padding fmt adds beyond the arguments themselves.
+func formatFieldBytes(format string) int {
+	total, n := 0, 0
+	inVerb := false
+	for i := 0; i < len(format); i++ {
+		ch := format[i]
+		switch {
+		case !inVerb:
+			inVerb = ch == '%'
+		case ch >= '0' && ch <= '9':
+			n = min(n*10+int(ch-'0'), math.MaxInt32)
+		default:
+			total, n = min(total+n, math.MaxInt32), 0
+			// Flags and the precision dot continue the verb; anything else
+			// is the verb letter.
+			inVerb = strings.IndexByte("+-# .", ch) >= 0
 		}
 	}
-	return string(buf[i:])
+	return min(total+n, math.MaxInt32)
 }
 
 func intScalarValue(v any) (int, bool) {
@@ -3545,6 +4527,8 @@ func intScalarValue(v any) (int, bool) {
 		return x, true
 	case int64:
 		return int(x), true
+	case uint64:
+		return int(x), x <= math.MaxInt
 	case float64:
 		i := int(x)
 		return i, float64(i) == x
@@ -1,7 +1,9 @@
 package bcl
 
 import (
+	"math"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -60,6 +62,49 @@ func TestCompileExpressionCapabilities(t *testing.T) {
 	}
 }
 
+func TestExprSandbox(t *testing.T) {
+	sb := &ExprSandbox{
+		Functions:    []string{"lower", "repeat", "tier", "len", "format", "pad_left", "join", "replace", "repeat_list"},
+		Custom:       map[string]EvalFunction{"tier": func(args []any, _ *EvalOptions) (any, error) { return "gold", nil }},
+		MaxStringLen: 16,
+	}
+	vars := map[string]any{"user": map[string]any{"country": "DE", "spend": 120}}
+	for expr, want := range map[string]any{
+		`lower(user.country) == "de" && user.spend > 100`: true,
+		`tier() == "gold"`:                 true,
+		`cond(user.spend > 500, "a", "b")`: "b",
+		`len("ab" * 3) > 5`:                true,
+	} {
+		got, err := sb.Eval(expr, vars)
+		if err != nil || got != want {
+			t.Fatalf("%s = %v, %v; want %v", expr, got, err, want)
+		}
+	}
+	for expr, want := range map[string]string{
+		`upper(user.country)`:                   `function "upper" is not allowed`,
+		`sha256("x")`:                           `function "sha256" is not allowed`,
+		`env.HOME`:                              "environment variables are not available",
+		`repeat("ab", 100)`:                     "limit of 16",
+		`len("ab" * 4000000000)`:                "limit of 16",
+		`len("ab" * 1000000)`:                   "limit of 16",
+		`len("abcdef" + "abcdef" + "abcdef")`:   "string of 18 bytes exceeds the limit of 16",
+		`len("%99999999d" % 1)`:                 "limit of 16",
+		`len(format("%9999d", 1))`:              "limit of 16",
+		`len(pad_left("x", 1000000000))`:        "limit of 16",
+		`len(join(repeat_list("abcd", 5), ""))`: "limit of 16",
+		`len(replace("aaaa", "a", "bbbbbbbb"))`: "limit of 16",
+		`time.now`:                              "time capability",
+		`"` + strings.Repeat("x", 5000) + `"`:   "expression of 5002 bytes",
+	} {
+		if _, err := sb.Eval(expr, vars); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%.40s: expected %q, got %v", expr, want, err)
+		}
+	}
+	if got, err := EvalExpr(`upper("x")`, &EvalOptions{}); err != nil || got != "X" {
+		t.Fatalf("unsandboxed upper = %v, %v", got, err)
+	}
+}
+
 func TestEvalBuiltinStringFunctions(t *testing.T) {
 	tests := []struct {
 		expr string
@@ -75,6 +120,17 @@ func TestEvalBuiltinStringFunctions(t *testing.T) {
 		{`repeat("ha", 3)`, "hahaha"},
 		{`pad_left("7", 3, "0")`, "007"},
 		{`pad_right("go", 4, ".")`, "go.."},
+		{`padLeft("7", 3, "0")`, "007"},
+		{`title("hello wide-world")`, "Hello Wide-World"},
+		{`camel_case("user_id")`, "userId"},
+		{`camelCase("HTTP server")`, "httpServer"},
+		{`pascal_case("order items")`, "OrderItems"},
+		{`snake_case("HTTPServer Name2")`, "http_server_name2"},
+		{`kebab_case("userAccountID")`, "user-account-id"},
+		{`slugify("  Orders API (EU) ")`, "orders-api-eu"},
+		{`truncate("resource-name", 8)`, "resource"},
+		{`truncate("resource-name", 8, "...")`, "resou..."},
+		{`truncate("short", 8, "...")`, "short"},
 		{`index_of("gateway", "way")`, 4},
 		{`last_index_of("bananas", "na")`, 4},
 		{`regex_match("svc-42", "^svc-[0-9]+$")`, true},
@@ -181,3 +237,544 @@ func TestEvalBuiltinMathAndConversionFunctions(t *testing.T) {
 		})
 	}
 }
+
+func TestEvalSemverFunctions(t *testing.T) {
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{`semver_compare("1.10.0", "1.9.3")`, 1},
+		{`semver_compare("v2.0.0", "2.0.0+build.7")`, 0},
+		{`semver_compare("1.0.0-alpha.2", "1.0.0-alpha.10")`, -1},
+		{`semver_compare("1.0.0-rc.1", "1.0.0")`, -1},
+		{`semver_satisfies("1.4.2", ">=1.2 <2")`, true},
+		{`semver_satisfies("2.0.0", ">=1.2 <2")`, false},
+		{`semver_satisfies("1.9.0", "^1.4")`, true},
+		{`semver_satisfies("0.3.1", "^0.2")`, false},
+		{`semver_satisfies("2.1.9", "~2.1")`, true},
+		{`semver_satisfies("2.2.0", "~2.1")`, false},
+		{`semver_satisfies("3.7.0", "~2.1 || 3.x")`, true},
+		{`semver_satisfies("1.2.5", "<= 1.2")`, true},
+		{`get(semver_parse("v1.2.3-beta.1+sha.5"), "minor")`, int64(2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := EvalExpr(tt.expr, nil)
+			if err != nil {
+				t.Fatalf("eval: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+	if _, err := EvalExpr(`semver_satisfies("1.x.y", ">=1")`, nil); err == nil {
+		t.Fatal("expected error for invalid version")
+	}
+}
+
+func TestEvalCoalesceCondAndTry(t *testing.T) {
+	vars := map[string]any{"port": "80", "empty": ""}
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{`coalesce(missing, empty, "z", fail("not reached"))`, "z"},
+		{`cond(port == "80", "http", fail("not reached"))`, "http"},
+		{`cond(missing, 1, 2)`, int64(2)},
+		{`try(int("abc"), 0)`, int64(0)},
+		{`try(missing.key, "fallback")`, "fallback"},
+		{`try(int(port), 0) + 1`, float64(81)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := EvalExpr(tt.expr, &EvalOptions{Variables: vars})
+			if err != nil {
+				t.Fatalf("eval: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+	if _, err := EvalExpr(`cond(true, 1)`, nil); err == nil {
+		t.Fatal("expected arity error for cond")
+	}
+}
+
+func TestEvalStrictConversionFunctions(t *testing.T) {
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{`int(" 42 ")`, 42},
+		{`int("0x1F")`, 31},
+		{`int(4.0)`, 4},
+		{`float("1e3")`, float64(1000)},
+		{`bool("off")`, false},
+		{`bool(1)`, true},
+		{`tostring(8080)`, "8080"},
+		{`tonumber("3")`, int64(3)},
+		{`tonumber("2.5")`, 2.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := EvalExpr(tt.expr, nil)
+			if err != nil {
+				t.Fatalf("eval: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+	for _, expr := range []string{`int("12abc")`, `int(3.7)`, `int(true)`, `float("")`, `bool("maybe")`, `bool(2)`, `tostring(null)`, `tostring([1])`, `tonumber("x")`} {
+		if _, err := EvalExpr(expr, nil); err == nil {
+			t.Fatalf("%s: expected conversion error", expr)
+		}
+	}
+}
+
+func TestEvalRangeSeqAndRepeatList(t *testing.T) {
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{`range(3)`, []any{0, 1, 2}},
+		{`seq(100, 103)`, []any{100, 101, 102, 103}},
+		{`seq(10, 0, -5)`, []any{10, 5, 0}},
+		{`seq(3, 1)`, []any{3, 2, 1}},
+		{`repeat_list("worker", 2)`, []any{"worker", "worker"}},
+		{`seq(9223372036854775806, 9223372036854775807)`, []any{math.MaxInt64 - 1, math.MaxInt64}},
+		{`seq(-9223372036854775806, -9223372036854775807)`, []any{math.MinInt64 + 2, math.MinInt64 + 1}},
+		{`range(0, 9223372036854775807, 4611686018427387904)`, []any{0, 1 << 62}},
+		{`range(2, 2)`, []any(nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := EvalExpr(tt.expr, nil)
+			if err != nil {
+				t.Fatalf("eval: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+	for _, expr := range []string{`seq(1, 5, -1)`, `range(1000000000)`, `repeat_list("x", -1)`, `range(-9e18, 9e18, 1)`, `range(9e18, -9e18, -1)`, `seq(-9223372036854775807, 9223372036854775807, 3)`} {
+		if _, err := EvalExpr(expr, nil); err == nil {
+			t.Fatalf("%s: expected error", expr)
+		}
+	}
+	n, err := CompileBytes([]byte("disks repeat_list({ size \"10Gi\" }, 2)\nstep = seq(5, 1, 2 - 4)\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disks, _ := n.Body["disks"].([]any)
+	if len(disks) != 2 || !reflect.DeepEqual(n.Body["step"], []any{5, 3, 1}) {
+		t.Fatalf("body = %#v", n.Body)
+	}
+}
+
+func TestEvalStringRepeatAndFormat(t *testing.T) {
+	vars := map[string]any{"host": "db", "port": int64(5432), "width": int64(4)}
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{`"-" * 5`, "-----"},
+		{`3 * "ab"`, "ababab"},
+		{`"=" * (width + 1)`, "====="},
+		{`format("%s:%d", host, port)`, "db:5432"},
+		{`format("%d-%.1f", port + 1, 2)`, "5433-2.0"},
+		{`"%s:%d" % [host, port]`, "db:5432"},
+		{`"v%d" % 2`, "v2"},
+		{`"%d%%" % 50`, "50%"},
+		{`7 % 3`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := Eval(tt.expr, vars)
+			if err != nil {
+				t.Fatalf("eval: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+	for _, expr := range []string{`"-" * -1`, `"-" * 1.5`, `"a" * "b"`, `format(1)`, `len("ab" * 4000000000)`, `9223372036854775807 * "ab"`, `repeat("ab", 4000000000)`} {
+		if _, err := Eval(expr, vars); err == nil {
+			t.Fatalf("%s: expected error", expr)
+		}
+	}
+	n, err := CompileBytes([]byte("host = \"db\"\nport = 5432\nrule = \"-\" * 8\naddr = format(\"%s:%d\", host, port)\nurl \"%s:%d\" % [host, port + 1]\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["rule"] != "--------" || n.Body["addr"] != "db:5432" || n.Body["url"] != "db:5433" {
+		t.Fatalf("body = %#v", n.Body)
+	}
+}
+
+func TestEvalIntegerDivisionAndModulo(t *testing.T) {
+	vars := map[string]any{"total": int64(7), "zero": int64(0)}
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{`7 // 2`, int64(3)},
+		{`-7 // 2`, int64(-4)},
+		{`total // -2`, int64(-4)},
+		{`7.5 // 2`, int64(3)},
+		{`1 + 9 // 4`, float64(3)},
+		{`7 % 3`, 1},
+		{`7.5 % 2`, 1.5},
+		{`-7.5 % 2`, -1.5},
+		{`safediv(total, 2, 0)`, 3.5},
+		{`safediv(total, zero, -1)`, int64(-1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := Eval(tt.expr, vars)
+			if err != nil {
+				t.Fatalf("eval: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+	for _, expr := range []string{`total // zero`, `total % 0.0`, `"a" // 2`} {
+		if _, err := Eval(expr, vars); err == nil {
+			t.Fatalf("%s: expected error", expr)
+		}
+	}
+	// In documents // still starts a comment; expressions inside ${...}
+	// can use it.
+	n, err := CompileBytes([]byte("half = \"${7 // 2}\" // trailing comment\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["half"] != "3" {
+		t.Fatalf("body = %#v", n.Body)
+	}
+}
+
+func TestEvalBitsAndNetworkHelpers(t *testing.T) {
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{`hex(255)`, "ff"},
+		{`hex(10, 16)`, "000a"},
+		{`hex(-1, 32)`, "ffffffff"},
+		{`bit_and(0xf0, 0x3c)`, int64(0x30)},
+		{`bit_or(1, 4, 8)`, int64(5)},
+		{`bit_xor("0xff", 0x0f, 8)`, int64(0xf0)},
+		{`bit_not(0, 8)`, int64(0xff)},
+		{`bit_shl(0x81, 1, 8)`, int64(0x02)},
+		{`bit_shr(0x80, 7)`, int64(1)},
+		// Beyond 2^53 a float64 would round; hex strings keep every bit.
+		{`hex(bit_or("0xffff000000000000", "0x0000000000000001"))`, "ffff000000000001"},
+		{`hex(bit_and("0x20000000000001", "0x3fffffffffffff"))`, "20000000000001"},
+		{`parse_mac("AA-BB-CC-00-11-22")`, "aa:bb:cc:00:11:22"},
+		{`parse_mac("aabb.cc00.1122")`, "aa:bb:cc:00:11:22"},
+		{`mask_to_prefix("255.255.255.0")`, int64(24)},
+		{`mask_to_prefix("255.255.240.0")`, int64(20)},
+		{`mask_to_prefix("ffff:ffff:ffff:ffff::")`, int64(64)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := Eval(tt.expr, nil)
+			if err != nil {
+				t.Fatalf("eval: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+	n, err := CompileBytes([]byte("prefix = mask_to_prefix(\"255.255.0.0\")\nflags = hex(bit_or(1, 8), 8)\nmac = parse_mac(\"AA:BB:CC:DD:EE:FF\")\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["prefix"] != int64(16) || n.Body["flags"] != "09" || n.Body["mac"] != "aa:bb:cc:dd:ee:ff" {
+		t.Fatalf("body = %#v", n.Body)
+	}
+	for _, expr := range []string{`bit_and(256, 1, 8)`, `bit_not(1, 12)`, `bit_shl(1, 9, 8)`, `bit_or(1.5, 1)`, `hex(1e17)`, `parse_mac("aa:bb")`, `mask_to_prefix("255.0.255.0")`, `mask_to_prefix("mask")`} {
+		if _, err := Eval(expr, nil); err == nil {
+			t.Fatalf("%s: expected error", expr)
+		}
+	}
+}
+
+func TestEvalInOperator(t *testing.T) {
+	vars := map[string]any{"region": "eu-west-1", "limits": map[string]any{"cpu": int64(2)}, "ports": []any{int64(80), int64(443)}}
+	tests := map[string]bool{
+		`region in ["us-east-1", "eu-west-1"]`: true,
+		`"ap-south-1" in ["us-east-1"]`:        false,
+		`443 in ports`:                         true,
+		`"cpu" in limits`:                      true,
+		`"memory" in limits`:                   false,
+		`"west" in region`:                     true,
+		`"east" not_in region`:                 true,
+		`region in missing`:                    false,
+	}
+	for expr, want := range tests {
+		got, err := Eval(expr, vars)
+		if err != nil || got != want {
+			t.Fatalf("%s = %v, %v; want %v", expr, got, err, want)
+		}
+	}
+	for _, expr := range []string{`ports in "80"`, `80 in region`, `1 in limits`, `"a" in 3`} {
+		if _, err := Eval(expr, vars); err == nil {
+			t.Fatalf("%s: expected error", expr)
+		}
+	}
+}
+
+func TestEvalOperatorPrecedence(t *testing.T) {
+	vars := map[string]any{"x": int64(5), "name": "api", "unset": nil}
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{`2 + 3 * 4`, float64(14)},
+		{`(2 + 3) * 4`, float64(20)},
+		{`10 - 4 - 3`, float64(3)},
+		{`-x * 2`, float64(-10)},
+		{`!false == true`, true},
+		{`1 + 1 > 1 and 3 > 2`, true},
+		{`true or false and false`, true},
+		{`true || false && false`, true},
+		{`(true || false) && false`, false},
+		{`unset ?? "d"`, "d"},
+		{`name ?? "d"`, "api"},
+		{`unset ?? unset ?? "last"`, "last"},
+		{`unset ?? 1 + 2`, float64(3)},
+		{`unset ?? false or true`, true},
+		{`x > 3 ? "big" : "small"`, "big"},
+		{`x > 9 ? "a" : x > 3 ? "b" : "c"`, "b"},
+		{`unset ?? x > 3 ? "set" : "unset"`, "set"},
+		{`x > 3 ? 1 : 2 + 10`, int64(1)},
+		{`(x > 9 ? 1 : 2) + 10`, float64(12)},
+		{`1 <= x <= 10`, true},
+		{`1 <= x < 5`, false},
+		{`10 > x > 1`, true},
+		{`6 <= x <= 10`, false},
+		{`1 < x <= 10 == true`, true},
+		{`x between 1 and 10 and name == "api"`, true},
+		{`"a" + "b" in ["ab"]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := Eval(tt.expr, vars)
+			if err != nil {
+				t.Fatalf("eval: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+	// A failed link short-circuits the rest of the chain.
+	if got, err := Eval(`3 < 2 < fail("not evaluated")`, nil); err != nil || got != false {
+		t.Fatalf("short circuit = %v, %v", got, err)
+	}
+	if got, err := Eval(`name ?? fail("not evaluated")`, vars); err != nil || got != "api" {
+		t.Fatalf("?? short circuit = %v, %v", got, err)
+	}
+}
+
+func TestEvalPlatformFunctions(t *testing.T) {
+	windows := &EvalOptions{GOOS: "windows", GOARCH: "arm64"}
+	linux := &EvalOptions{GOOS: "linux", GOARCH: "amd64"}
+	tests := []struct {
+		expr string
+		opts *EvalOptions
+		want any
+	}{
+		{`os()`, windows, "windows"},
+		{`arch()`, windows, "arm64"},
+		{`pathjoin("etc", "app", "../app.bcl")`, linux, "etc/app.bcl"},
+		{`pathjoin(["C:\\tools", "bin"], "app.exe")`, windows, `C:\tools\bin\app.exe`},
+		{`on_windows("app.exe", "app")`, windows, "app.exe"},
+		{`on_windows("app.exe", "app")`, linux, "app"},
+		{`on_linux("/etc/app")`, windows, nil},
+	}
+	for _, tt := range tests {
+		got, err := EvalExpr(tt.expr, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s = %#v, want %#v", tt.expr, got, tt.want)
+		}
+	}
+	n, err := CompileBytes([]byte("bin on_windows(\"tool.exe\", \"tool\")\ndir = pathjoin(\"var\", os())\n"), &Options{GOOS: "darwin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["bin"] != "tool" || n.Body["dir"] != "var/darwin" {
+		t.Fatalf("body = %#v", n.Body)
+	}
+}
+
+func TestByteSizeLiterals(t *testing.T) {
+	for expr, want := range map[string]any{
+		`10MB`:            int64(10 << 20),
+		`2GiB`:            int64(2 << 30),
+		`1.5KB`:           int64(1536),
+		`body > 1MB`:      true,
+		`body <= 512KB`:   false,
+		`512B == 0.5KiB`:  true,
+		`max(1MB, 900KB)`: float64(1 << 20),
+	} {
+		got, err := Eval(expr, map[string]any{"body": int64(2 << 20)})
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s = %#v, %v; want %#v", expr, got, err, want)
+		}
+	}
+	n, err := CompileBytes([]byte("body_size = 10MB\nmax_upload = 2 * 512MB\ncache = 2GiB\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, ok := numericInt(n.Body["max_upload"]); !ok || size != 1<<30 {
+		t.Fatalf("body = %#v", n.Body)
+	}
+	var cfg struct {
+		BodySize  int64  `bcl:"body_size"`
+		MaxUpload int    `bcl:"max_upload"`
+		Cache     uint64 `bcl:"cache"`
+	}
+	if err := Unmarshal([]byte("body_size = 10MB\nmax_upload = 2 * 512MB\ncache = 2GiB\n"), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BodySize != 10485760 || cfg.MaxUpload != 1<<30 || cfg.Cache != 2<<30 {
+		t.Fatalf("cfg = %+v", cfg)
+	}
+}
+
+func TestIntegerLiteralsPastInt64(t *testing.T) {
+	for expr, want := range map[string]any{
+		`18446744073709551615`:        uint64(math.MaxUint64),
+		`9223372036854775808 - 1`:     float64(1 << 63),
+		`18446744073709551615 * 2`:    float64(1<<64) * 2,
+		`9223372036854775808 > 1`:     true,
+		`float(9223372036854775808)`:  float64(1 << 63),
+		`string(9223372036854775808)`: "9223372036854775808",
+	} {
+		got, err := Eval(expr, nil)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %#v, %v; want %#v", expr, got, err, want)
+		}
+	}
+	if _, err := Eval(`int(9223372036854775808)`, nil); err == nil || !strings.Contains(err.Error(), "overflows int") {
+		t.Errorf("int overflow err = %v", err)
+	}
+	n, err := CompileBytes([]byte("max = 18446744073709551615\nhalf = 18446744073709551615 / 2\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["max"] != uint64(math.MaxUint64) || n.Body["half"] != float64(1<<63) {
+		t.Fatalf("body = %#v", n.Body)
+	}
+}
+
+func TestRadixAndSeparatedNumberLiterals(t *testing.T) {
+	for expr, want := range map[string]any{
+		`0xFF`:                              int64(255),
+		`-0x10`:                             int64(-16),
+		`0o755`:                             int64(0o755),
+		`0b1010`:                            int64(10),
+		`1_000_000`:                         int64(1000000),
+		`0xFF_FF`:                           int64(0xFFFF),
+		`bit_and(mode, 0o777)`:              int64(0o644),
+		`1_000.5 > 1000`:                    true,
+		`0b`:                                int64(0),
+		`0xFFFFFFFFFFFFFFFF`:                uint64(1<<64 - 1),
+		`1_000ms`:                           "1000ms",
+		`0xFFFFFFFFFFFFFFFF + 1`:            float64(1 << 64),
+		`0x8000000000000000 - 1`:            float64(1 << 63),
+		`0x8000000000000000 % 3`:            float64(2),
+		`int(0x7FFFFFFFFFFFFFFF)`:           math.MaxInt64,
+		`bit_and(0xFFFFFFFFFFFFFFFF, 0xF0)`: int64(0xF0),
+	} {
+		got, err := Eval(expr, map[string]any{"mode": int64(0o100644)})
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %#v, %v; want %#v", expr, got, err, want)
+		}
+	}
+	if _, err := CompileBytes([]byte("x = int(0xFFFFFFFFFFFFFFFF)\n"), nil); err == nil || !strings.Contains(err.Error(), "overflows int") {
+		t.Errorf("int(0xFFFFFFFFFFFFFFFF) err = %v", err)
+	}
+	n, err := CompileBytes([]byte("mode = 0o644\nflags = 0b0110\nlimit = 10_000\nbuffer = 64_000KB\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["mode"] != int64(0o644) || n.Body["flags"] != int64(6) || n.Body["limit"] != int64(10000) {
+		t.Fatalf("body = %#v", n.Body)
+	}
+	for _, src := range []string{"x = 0xZZ\n", "x = 1__000\n", "x = 1_\n", "x = 0o8\n"} {
+		if _, err := CompileBytes([]byte(src), nil); err == nil || !strings.Contains(err.Error(), "invalid number literal") {
+			t.Errorf("%q: err = %v", src, err)
+		}
+	}
+}
+
+func TestNullSafeNavigation(t *testing.T) {
+	vars := map[string]any{"db": map[string]any{"tls": nil, "pool": map[string]any{"size": int64(4)}}, "n": int64(5)}
+	for expr, want := range map[string]any{
+		`db?.pool?.size`:              int64(4),
+		`db?.tls?.cert`:               nil,
+		`cache?.redis?.host`:          nil,
+		`db?.tls?.cert ?? "none"`:     "none",
+		`db?.pool?.size * 2`:          float64(8),
+		`n > 1 ?"big":"small"`:        "big",
+		`[db?.pool.size, missing?.x]`: []any{int64(4), nil},
+	} {
+		got, err := Eval(expr, vars)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %#v, %v; want %#v", expr, got, err, want)
+		}
+	}
+	src := "db { pool { size 4 } }\nsize = db?.pool?.size\ncert = db?.tls?.cert\nhost = cache?.redis?.host ?? \"localhost\"\n"
+	n, err := CompileBytes([]byte(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["size"] != int64(4) || n.Body["cert"] != nil || n.Body["host"] != "localhost" {
+		t.Fatalf("body = %#v", n.Body)
+	}
+	if out, err := Format([]byte(src)); err != nil || !strings.Contains(string(out), "db?.tls?.cert") {
+		t.Fatalf("format = %s, %v", out, err)
+	}
+}
+
+func TestEvalArithmeticKeepsNumbersUnboxed(t *testing.T) {
+	vars := map[string]any{"usage": map[string]any{"count": int64(40), "limit": 100.0}}
+	const expr = `(usage.count + 5) * 2 - usage.limit / 4 + 1.5 > usage.count % 3`
+	if got, err := Eval(expr, vars); err != nil || got != true {
+		t.Fatalf("%s = %#v, %v", expr, got, err)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { _, _ = Eval(expr, vars) }); allocs > 0 {
+		t.Fatalf("%v allocs per run", allocs)
+	}
+	for expr, want := range map[string]any{
+		`(2 + 3) * 4`:    float64(20),
+		`1 + "a"`:        "1a",
+		`1 < 2 < 3`:      true,
+		`3 > 2 > 2`:      false,
+		`7 // 2 + 0.5`:   float64(3.5),
+		`(1 + 1) == 2`:   false, // == compares types: 2.0 is not int 2
+		`(1 + 1) == 2.0`: true,
+	} {
+		if got, err := Eval(expr, nil); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %#v, %v; want %#v", expr, got, err, want)
+		}
+	}
+	if _, err := Eval(`(1 + 1) / 0`, nil); err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Fatalf("err = %v", err)
+	}
+}
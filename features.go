@@ -0,0 +1,255 @@
+package bcl
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Feature flags live in the top-level `features` object. A flag is a bool,
+// a plain value, or an object with any of enabled, rollout, when and value:
+//
+//	features {
+//	  audit true
+//	  page_size 50
+//	  checkout {
+//	    enabled true                 # false turns it off for everyone
+//	    rollout 25                   # percent of subjects, bucketed by key
+//	    when { user.tier == "beta" } # evaluated against the subject
+//	    value "v2"                   # what String and friends return
+//	  }
+//	}
+//
+// The same bucketing is available in expressions as rollout(key, percent,
+// salt), so a `when` can roll out by any attribute.
+
+// Feature is one flag of a FeatureSet. Rollout is a percentage between 0
+// and 100.
+type Feature struct {
+	Name    string         `json:"name"`
+	Enabled bool           `json:"enabled"`
+	Rollout float64        `json:"rollout"`
+	When    map[string]any `json:"when,omitempty"`
+	Value   any            `json:"value,omitempty"`
+}
+
+// FeatureSet is a typed view of the flags of a compiled config. It is safe
+// for concurrent use and can follow the file it came from with Watch.
+type FeatureSet struct {
+	// Options are used to evaluate `when` conditions.
+	Options *Options
+
+	mu    sync.RWMutex
+	flags map[string]Feature
+	subs  []func(*FeatureSet, []string)
+}
+
+// Features returns the flags of n's `features` object. A nil n, or one
+// without features, gives an empty set on which every accessor returns its
+// default.
+func Features(n *Normalized) *FeatureSet {
+	return &FeatureSet{flags: parseFeatures(n)}
+}
+
+func parseFeatures(n *Normalized) map[string]Feature {
+	out := map[string]Feature{}
+	if n == nil {
+		return out
+	}
+	raw, _ := n.Body["features"].(map[string]any)
+	for name, v := range raw {
+		f := Feature{Name: name, Enabled: true, Rollout: 100, Value: v}
+		switch x := v.(type) {
+		case bool:
+			f.Enabled = x
+		case map[string]any:
+			if !isFeatureObject(x) {
+				break
+			}
+			f.Value = x["value"]
+			if b, ok := x["enabled"].(bool); ok {
+				f.Enabled = b
+			}
+			if p, ok := numericFloat(x["rollout"]); ok {
+				f.Rollout = min(max(p, 0), 100)
+			}
+			f.When, _ = x["when"].(map[string]any)
+		}
+		out[name] = f
+	}
+	return out
+}
+
+// isFeatureObject reports whether m describes a flag rather than being the
+// flag's value.
+func isFeatureObject(m map[string]any) bool {
+	for _, k := range []string{"enabled", "rollout", "when", "value"} {
+		if _, ok := m[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Names returns the flag names in order.
+func (f *FeatureSet) Names() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	names := make([]string, 0, len(f.flags))
+	for name := range f.flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *FeatureSet) Lookup(name string) (Feature, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	flag, ok := f.flags[name]
+	return flag, ok
+}
+
+// Enabled reports whether a flag is on for everyone: enabled, fully rolled
+// out and without a condition. Use EnabledFor for the others.
+func (f *FeatureSet) Enabled(name string) bool {
+	flag, ok := f.Lookup(name)
+	return ok && flag.Enabled && flag.Rollout >= 100 && flag.When == nil
+}
+
+// EnabledFor reports whether a flag is on for the subject identified by
+// key, whose attributes are input. The subject is in the rollout when its
+// bucket of name and key falls under the percentage, so it stays in as the
+// percentage grows. A condition that fails to evaluate counts as false.
+func (f *FeatureSet) EnabledFor(name, key string, input map[string]any) bool {
+	flag, ok := f.Lookup(name)
+	if !ok || !flag.Enabled || !inRollout(key, name, flag.Rollout) {
+		return false
+	}
+	if flag.When == nil {
+		return true
+	}
+	on, err := evalNormalizedCondition(flag.When, input, f.Options)
+	return err == nil && on
+}
+
+// Bool is Enabled, or def for an unknown flag.
+func (f *FeatureSet) Bool(name string, def bool) bool {
+	if _, ok := f.Lookup(name); !ok {
+		return def
+	}
+	return f.Enabled(name)
+}
+
+// String returns the value of an enabled flag, or def when the flag is
+// unknown, off or has no string value.
+func (f *FeatureSet) String(name, def string) string {
+	if s, ok := f.value(name).(string); ok {
+		return s
+	}
+	return def
+}
+
+// Int is String for integer values.
+func (f *FeatureSet) Int(name string, def int64) int64 {
+	if n, ok := numericInt(f.value(name)); ok {
+		return n
+	}
+	return def
+}
+
+// Float is String for numbers.
+func (f *FeatureSet) Float(name string, def float64) float64 {
+	if n, ok := numericFloat(f.value(name)); ok {
+		return n
+	}
+	return def
+}
+
+func (f *FeatureSet) value(name string) any {
+	flag, ok := f.Lookup(name)
+	if !ok || !flag.Enabled {
+		return nil
+	}
+	return flag.Value
+}
+
+// OnChange registers fn to receive the names of the flags that change on
+// each Update.
+func (f *FeatureSet) OnChange(fn func(*FeatureSet, []string)) {
+	f.mu.Lock()
+	f.subs = append(f.subs, fn)
+	f.mu.Unlock()
+}
+
+// Update replaces the flags with those of n and returns the names of the
+// flags added, removed or changed, notifying OnChange subscribers when
+// there are any.
+func (f *FeatureSet) Update(n *Normalized) []string {
+	next := parseFeatures(n)
+	f.mu.Lock()
+	var changed []string
+	for name, flag := range next {
+		if old, ok := f.flags[name]; !ok || !reflect.DeepEqual(old, flag) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range f.flags {
+		if _, ok := next[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	f.flags = next
+	subs := slices.Clone(f.subs)
+	f.mu.Unlock()
+	if len(changed) == 0 {
+		return nil
+	}
+	sort.Strings(changed)
+	for _, fn := range subs {
+		fn(f, changed)
+	}
+	return changed
+}
+
+// Watch updates the set whenever the file at path compiles to new flags,
+// until the returned channel is closed. Failed compilations keep the
+// current flags.
+func (f *FeatureSet) Watch(path string, opts *Options) chan struct{} {
+	return Watch(path, opts, func(ev WatchEvent) {
+		if ev.Error == nil && ev.Normalized != nil {
+			f.Update(ev.Normalized)
+		}
+	})
+}
+
+// inRollout reports whether key is among the first percent of subjects
+// for salt, in steps of 0.01%.
+func inRollout(key, salt string, percent float64) bool {
+	if percent >= 100 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(salt))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return float64(h.Sum32()%10000) < percent*100
+}
+
+func evalRollout(args []any) (any, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("rollout requires 2 or 3 arguments")
+	}
+	percent, ok := numericFloat(args[1])
+	if !ok {
+		return nil, fmt.Errorf("rollout: percent %v is not a number", args[1])
+	}
+	salt := ""
+	if len(args) == 3 {
+		salt = fmt.Sprint(args[2])
+	}
+	return inRollout(fmt.Sprint(args[0]), salt, percent), nil
+}
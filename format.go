@@ -6,33 +6,58 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+// FormatOptions controls house style for Format and FormatDocument. The
+// zero value is the canonical style.
+type FormatOptions struct {
+	// Equals writes assignments other than nested blocks as `name = value`.
+	Equals bool
+	// Align pads the names of consecutive single-line assignments in a
+	// block so their values (and = signs) line up.
+	Align bool
+	// WrapColumn puts list items on their own lines when the list would
+	// extend past this column. Zero never wraps.
+	WrapColumn int
+	// CompactBlocks keeps blocks and objects holding a single one-line
+	// property on one line: `tls { enabled true }`.
+	CompactBlocks bool
+}
+
 func Format(src []byte) ([]byte, error) {
+	return FormatWithOptions(src, nil)
+}
+
+// FormatWithOptions formats src in the style set by o. Comments are kept
+// where ParseFileWithComments places them.
+func FormatWithOptions(src []byte, o *FormatOptions) ([]byte, error) {
+	parse := Parse
 	if hasComments(src) {
-		out := append([]byte(nil), src...)
-		if len(out) == 0 || out[len(out)-1] != '\n' {
-			out = append(out, '\n')
-		}
-		return out, nil
+		parse = func(src []byte) (*Document, error) { return ParseFileWithComments("<input>", src) }
 	}
-	doc, err := Parse(src)
+	doc, err := parse(src)
 	if err != nil {
 		return nil, err
 	}
-	return formatDocument(doc, len(src)+len(src)/8)
+	return formatDocument(doc, len(src)+len(src)/8, o)
 }
 
 func FormatDocument(doc *Document) ([]byte, error) {
-	return formatDocument(doc, 0)
+	return formatDocument(doc, 0, nil)
+}
+
+func FormatDocumentWithOptions(doc *Document, o *FormatOptions) ([]byte, error) {
+	return formatDocument(doc, 0, o)
 }
 
-func formatDocument(doc *Document, capacity int) ([]byte, error) {
+func formatDocument(doc *Document, capacity int, o *FormatOptions) ([]byte, error) {
 	var b bytes.Buffer
 	if capacity > 0 {
 		b.Grow(capacity)
 	}
-	writeNodes(&b, doc.Items, 0)
+	writeNodes(&b, doc.Items, 0, o)
 	return b.Bytes(), nil
 }
 
@@ -42,17 +67,145 @@ func writeIndent(b *bytes.Buffer, indent int) {
 	}
 }
 
-func writeNodes(b *bytes.Buffer, nodes []Node, indent int) {
+func writeNodes(b *bytes.Buffer, nodes []Node, indent int, o *FormatOptions) {
+	var widths []int
+	if o != nil && o.Align {
+		widths = alignedNameWidths(nodes)
+	}
+	started := false
 	for i, n := range nodes {
-		if i > 0 {
+		c, isComment := n.(*Comment)
+		if isComment && c.Trailing && bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+			b.Truncate(b.Len() - 1)
+			b.WriteByte(' ')
+			b.WriteString(c.Text)
 			b.WriteByte('\n')
+			continue
+		}
+		// A leading comment sits directly above the node it documents.
+		if started && !isLeadingComment(nodes[i-1]) {
+			b.WriteByte('\n')
+		}
+		started = started || !isComment
+		if a, ok := n.(*Assignment); ok && widths != nil && widths[i] > 0 {
+			writeIndent(b, indent)
+			writeAssignment(b, a, indent, widths[i], o)
+			continue
+		}
+		writeNode(b, n, indent, o)
+	}
+}
+
+func isLeadingComment(n Node) bool {
+	c, ok := n.(*Comment)
+	return ok && !c.Trailing
+}
+
+// alignedNameWidths returns, for each node in a run of consecutive
+// single-line assignments, the name width of the longest name in its run.
+func alignedNameWidths(nodes []Node) []int {
+	widths := make([]int, len(nodes))
+	for start := 0; start < len(nodes); {
+		end, width := start, 0
+		for ; end < len(nodes); end++ {
+			if c, ok := nodes[end].(*Comment); ok && c.Trailing && end > start {
+				continue
+			}
+			a, ok := nodes[end].(*Assignment)
+			if !ok || !alignable(a) {
+				break
+			}
+			width = max(width, len(assignmentName(a)))
+		}
+		for i := start; i < end; i++ {
+			widths[i] = width
 		}
-		writeNode(b, n, indent)
+		start = max(end, start+1)
+	}
+	return widths
+}
+
+// assignmentName is a's name as written in source; quoted names keep
+// their quotes so "10.0.0.1" does not turn into a dotted path.
+func assignmentName(a *Assignment) string {
+	if a.Quoted {
+		return quoteBCLString(a.Name)
 	}
+	return a.Name
 }
 
-func writeNode(b *bytes.Buffer, n Node, indent int) {
+func alignable(a *Assignment) bool {
+	if _, ok := flagKey(a); ok {
+		return false
+	}
+	switch a.Value.(type) {
+	case *Object, *Condition:
+		return false
+	}
+	return a.Name != "when"
+}
+
+// flagKey reports whether a is written as a flag: a bare `name`, which is
+// true, or `!name`, which is false.
+func flagKey(a *Assignment) (on, ok bool) {
+	switch v := a.Value.(type) {
+	case *Reference:
+		return true, v.Path == ""
+	case *Literal:
+		return false, v.Type == "bool" && v.Raw == "!"
+	}
+	return false, false
+}
+
+// writeAssignment writes `name value` without indentation, padding the name
+// to width when aligning.
+func writeAssignment(b *bytes.Buffer, x *Assignment, indent, width int, o *FormatOptions) {
+	name := assignmentName(x)
+	b.WriteString(name)
+	if pad := width - len(name); pad > 0 {
+		b.WriteString(strings.Repeat(" ", pad))
+	}
+	obj, isObject := x.Value.(*Object)
+	if x.Name == "when" && isMetaCondition(x.Value) || o != nil && o.Equals && !isObject {
+		b.WriteString(" =")
+	}
+	b.WriteByte(' ')
+	if isObject && writeCompactBody(b, obj.Fields, o) {
+		b.WriteByte('\n')
+		return
+	}
+	writeValue(b, x.Value, indent, o)
+	b.WriteByte('\n')
+}
+
+// writeCompactBody writes `{ name value }` when CompactBlocks is set and
+// body is a single property that fits on one line.
+func writeCompactBody(b *bytes.Buffer, body []Node, o *FormatOptions) bool {
+	if o == nil || !o.CompactBlocks || len(body) != 1 {
+		return false
+	}
+	a, ok := body[0].(*Assignment)
+	if !ok {
+		return false
+	}
+	var line bytes.Buffer
+	writeNode(&line, a, 0, o)
+	text := strings.TrimSuffix(line.String(), "\n")
+	if strings.Contains(text, "\n") {
+		return false
+	}
+	b.WriteString("{ ")
+	b.WriteString(text)
+	b.WriteString(" }")
+	return true
+}
+
+func writeNode(b *bytes.Buffer, n Node, indent int, o *FormatOptions) {
 	switch x := n.(type) {
+	case *Comment:
+		writeIndent(b, indent)
+		b.WriteString(x.Text)
+		b.WriteByte('\n')
 	case *ImportDecl:
 		writeIndent(b, indent)
 		b.WriteString("import ")
@@ -77,7 +230,7 @@ func writeNode(b *bytes.Buffer, n Node, indent int) {
 			if x.Default != nil {
 				writeIndent(b, indent+1)
 				b.WriteString("default ")
-				writeValue(b, x.Default, indent+1)
+				writeValue(b, x.Default, indent+1, o)
 				b.WriteByte('\n')
 			}
 			if x.Description != "" {
@@ -96,7 +249,7 @@ func writeNode(b *bytes.Buffer, n Node, indent int) {
 		b.WriteString("const ")
 		b.WriteString(x.Name)
 		b.WriteString(" = ")
-		writeValue(b, x.Value, indent)
+		writeValue(b, x.Value, indent, o)
 		b.WriteByte('\n')
 	case *TypeDecl:
 		writeIndent(b, indent)
@@ -133,17 +286,17 @@ func writeNode(b *bytes.Buffer, n Node, indent int) {
 		writeIndent(b, indent)
 		b.WriteString("}\n")
 	case *Assignment:
-		if ref, ok := x.Value.(*Reference); ok && ref.Path == "" {
+		if on, ok := flagKey(x); ok {
 			writeIndent(b, indent)
+			if !on {
+				b.WriteByte('!')
+			}
 			b.WriteString(x.Name)
 			b.WriteByte('\n')
 			return
 		}
 		writeIndent(b, indent)
-		b.WriteString(x.Name)
-		b.WriteByte(' ')
-		writeValue(b, x.Value, indent)
-		b.WriteByte('\n')
+		writeAssignment(b, x, indent, 0, o)
 	case *Spread:
 		writeIndent(b, indent)
 		b.WriteByte('&')
@@ -153,7 +306,19 @@ func writeNode(b *bytes.Buffer, n Node, indent int) {
 			return
 		}
 		b.WriteString(" {\n")
-		writeNodes(b, x.Body, indent+1)
+		writeNodes(b, x.Body, indent+1, o)
+		writeIndent(b, indent)
+		b.WriteString("}\n")
+	case *Directive:
+		writeIndent(b, indent)
+		b.WriteByte('@')
+		writeValue(b, &Call{Name: x.Name, Args: x.Args}, indent, o)
+		if len(x.Body) == 0 {
+			b.WriteByte('\n')
+			return
+		}
+		b.WriteString(" {\n")
+		writeNodes(b, x.Body, indent+1, o)
 		writeIndent(b, indent)
 		b.WriteString("}\n")
 	case *Block:
@@ -162,25 +327,70 @@ func writeNode(b *bytes.Buffer, n Node, indent int) {
 			if x.Type == "when" {
 				b.WriteString("when ")
 				b.WriteString(x.ID)
-				b.WriteString(" {\n")
 			} else if isBareBlockID(x.ID) {
 				b.WriteString(x.Type)
 				b.WriteByte(' ')
 				b.WriteString(x.ID)
-				b.WriteString(" {\n")
 			} else {
 				b.WriteString(x.Type)
 				b.WriteByte(' ')
 				b.WriteString(strconv.Quote(x.ID))
-				b.WriteString(" {\n")
 			}
 		} else {
 			b.WriteString(x.Type)
-			b.WriteString(" {\n")
 		}
-		writeNodes(b, x.Body, indent+1)
+		b.WriteByte(' ')
+		if writeCompactBody(b, x.Body, o) {
+			b.WriteByte('\n')
+			return
+		}
+		b.WriteString("{\n")
+		writeNodes(b, x.Body, indent+1, o)
+		writeIndent(b, indent)
+		b.WriteString("}\n")
+	case *ForLoop:
+		writeIndent(b, indent)
+		b.WriteString("for ")
+		if x.Key != "" {
+			b.WriteString(x.Key)
+			b.WriteString(", ")
+		}
+		b.WriteString(x.Value)
+		b.WriteString(" in ")
+		b.WriteString(x.In.Raw)
+		b.WriteString(" {\n")
+		writeNodes(b, x.Body, indent+1, o)
 		writeIndent(b, indent)
 		b.WriteString("}\n")
+	case *FuncDecl:
+		writeIndent(b, indent)
+		fmt.Fprintf(b, "func %s(%s) {\n", x.Name, strings.Join(x.Params, ", "))
+		writeIndent(b, indent+1)
+		b.WriteString("return ")
+		b.WriteString(x.Body.Raw)
+		b.WriteByte('\n')
+		writeIndent(b, indent)
+		b.WriteString("}\n")
+	case *IfChain:
+		writeIndent(b, indent)
+		for i, branch := range x.Branches {
+			if i > 0 {
+				b.WriteString(" else ")
+			}
+			b.WriteString("if ")
+			b.WriteString(branch.Cond.Raw)
+			b.WriteString(" {\n")
+			writeNodes(b, branch.Body, indent+1, o)
+			writeIndent(b, indent)
+			b.WriteByte('}')
+		}
+		if len(x.Else) > 0 {
+			b.WriteString(" else {\n")
+			writeNodes(b, x.Else, indent+1, o)
+			writeIndent(b, indent)
+			b.WriteByte('}')
+		}
+		b.WriteByte('\n')
 	}
 }
 
@@ -244,7 +454,7 @@ func writeSchemaOptions(b *bytes.Buffer, options map[string]Value, indent int) {
 		writeIndent(b, indent+1)
 		b.WriteString(key)
 		b.WriteByte(' ')
-		writeValue(b, options[key], indent+1)
+		writeValue(b, options[key], indent+1, nil)
 		b.WriteByte('\n')
 	}
 	writeIndent(b, indent)
@@ -256,14 +466,14 @@ func writeSchemaSection(b *bytes.Buffer, name string, value Value, indent int) {
 	if obj, ok := value.(*Object); ok {
 		b.WriteString(name)
 		b.WriteString(" {\n")
-		writeNodes(b, obj.Fields, indent+1)
+		writeNodes(b, obj.Fields, indent+1, nil)
 		writeIndent(b, indent)
 		b.WriteString("}\n")
 		return
 	}
 	b.WriteString(name)
 	b.WriteByte(' ')
-	writeValue(b, value, indent)
+	writeValue(b, value, indent, nil)
 	b.WriteByte('\n')
 }
 
@@ -482,7 +692,7 @@ func writeSchemaClauseValue(b *bytes.Buffer, indent int, name string, value Valu
 	writeIndent(b, indent)
 	b.WriteString(name)
 	b.WriteByte(' ')
-	writeValue(b, value, indent)
+	writeValue(b, value, indent, nil)
 	b.WriteByte('\n')
 }
 
@@ -549,7 +759,7 @@ func writeSchemaInlineValue(b *bytes.Buffer, name string, value Value, indent in
 	b.WriteByte(' ')
 	b.WriteString(name)
 	b.WriteByte(' ')
-	writeValue(b, value, indent)
+	writeValue(b, value, indent, nil)
 }
 
 func writeSchemaInlineString(b *bytes.Buffer, name, value string) {
@@ -609,7 +819,7 @@ func sortedValueKeys(m map[string]Value) []string {
 	return keys
 }
 
-func writeValue(b *bytes.Buffer, v Value, indent int) {
+func writeValue(b *bytes.Buffer, v Value, indent int, o *FormatOptions) {
 	switch x := v.(type) {
 	case *Literal:
 		switch x.Type {
@@ -639,21 +849,36 @@ func writeValue(b *bytes.Buffer, v Value, indent int) {
 			if i > 0 {
 				b.WriteString(", ")
 			}
-			writeValue(b, a, indent)
+			writeValue(b, a, indent, o)
 		}
 		b.WriteByte(')')
 	case *List:
+		if o != nil && o.WrapColumn > 0 && len(x.Items) > 0 {
+			var line bytes.Buffer
+			writeValue(&line, x, indent, nil)
+			if currentColumn(b)+line.Len() > o.WrapColumn || bytes.IndexByte(line.Bytes(), '\n') >= 0 {
+				b.WriteString("[\n")
+				for _, item := range x.Items {
+					writeIndent(b, indent+1)
+					writeValue(b, item, indent+1, o)
+					b.WriteString(",\n")
+				}
+				writeIndent(b, indent)
+				b.WriteByte(']')
+				return
+			}
+		}
 		b.WriteByte('[')
 		for i, item := range x.Items {
 			if i > 0 {
 				b.WriteString(", ")
 			}
-			writeValue(b, item, indent)
+			writeValue(b, item, indent, o)
 		}
 		b.WriteByte(']')
 	case *Object:
 		b.WriteString("{\n")
-		writeNodes(b, sortNodes(x.Fields), indent+1)
+		writeNodes(b, sortNodes(x.Fields), indent+1, o)
 		writeIndent(b, indent)
 		b.WriteByte('}')
 	case *Condition:
@@ -666,7 +891,7 @@ func writeValue(b *bytes.Buffer, v Value, indent int) {
 		for _, child := range x.Children {
 			b.WriteByte('\n')
 			writeIndent(b, indent+1)
-			writeValue(b, child, indent+1)
+			writeValue(b, child, indent+1, o)
 		}
 		b.WriteByte('\n')
 		writeIndent(b, indent)
@@ -674,25 +899,64 @@ func writeValue(b *bytes.Buffer, v Value, indent int) {
 	}
 }
 
+// currentColumn returns the width of the line being written to b.
+func currentColumn(b *bytes.Buffer) int {
+	data := b.Bytes()
+	return len(data) - bytes.LastIndexByte(data, '\n') - 1
+}
+
 func quoteBCLString(s string) string {
-	if strings.Contains(s, "\n") && !strings.Contains(s, `"""`) {
+	// Raw forms only hold text they can spell out: no control characters
+	// but newlines and tabs, and no quote where the closing one goes.
+	raw := utf8.ValidString(s) && !strings.ContainsFunc(s, func(r rune) bool {
+		return r != '\n' && r != '\t' && unicode.IsControl(r)
+	})
+	if raw && strings.Contains(s, "\n") && !strings.Contains(s, `"""`) && !strings.HasSuffix(s, `"`) {
 		return `"""` + s + `"""`
 	}
-	if strings.Contains(s, `"`) && !strings.Contains(s, "`") {
+	if raw && strings.Contains(s, `"`) && !strings.Contains(s, "`") {
 		return "`" + s + "`"
 	}
-	if strings.Contains(s, `"`) && !strings.Contains(s, `'`) {
+	if raw && strings.Contains(s, `"`) && !strings.ContainsAny(s, "'\n") {
 		return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `'`, `\'`) + "'"
 	}
 	return strconv.Quote(s)
 }
 
+// sortNodes orders nodes by name. Comments move with the node they
+// document: leading ones with the node after them, trailing ones with the
+// node before.
 func sortNodes(nodes []Node) []Node {
-	cp := append([]Node(nil), nodes...)
-	sort.SliceStable(cp, func(i, j int) bool {
-		return nodeName(cp[i]) < nodeName(cp[j])
-	})
-	return cp
+	var head, pending []Node
+	var groups [][]Node
+	for _, n := range nodes {
+		c, isComment := n.(*Comment)
+		switch {
+		case !isComment:
+			groups = append(groups, append(pending, n))
+			pending = nil
+		case !c.Trailing || len(pending) > 0:
+			pending = append(pending, n)
+		case len(groups) == 0:
+			head = append(head, n)
+		default:
+			groups[len(groups)-1] = append(groups[len(groups)-1], n)
+		}
+	}
+	key := func(g []Node) string {
+		for _, n := range g {
+			if _, ok := n.(*Comment); !ok {
+				return nodeName(n)
+			}
+		}
+		return ""
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return key(groups[i]) < key(groups[j]) })
+	out := append(make([]Node, 0, len(nodes)), head...)
+	for _, g := range groups {
+		out = append(out, g...)
+	}
+	return append(out, pending...)
 }
 
 func nodeName(n Node) string {
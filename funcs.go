@@ -0,0 +1,85 @@
+package bcl
+
+import "fmt"
+
+// maxFuncDepth bounds nested calls of document functions, so recursion
+// without a base case fails instead of exhausting the stack.
+const maxFuncDepth = 64
+
+// declareFuncs makes the top-level func declarations of nodes callable
+// from expressions. A declaration may not replace a function registered
+// through EvalFunctions, ref, or another declaration.
+func (c *compiler) declareFuncs(nodes []Node) {
+	for _, n := range nodes {
+		fn, ok := n.(*FuncDecl)
+		if !ok {
+			continue
+		}
+		if _, taken := c.evalOpts.Functions[fn.Name]; taken {
+			what := "a registered function"
+			if c.funcs[fn.Name] != nil {
+				what = "declared twice"
+			}
+			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: fmt.Sprintf("func %s: %s", fn.Name, what), Span: fn.Span})
+			continue
+		}
+		if c.funcs == nil {
+			c.funcs = map[string]*FuncDecl{}
+		}
+		c.funcs[fn.Name] = fn
+		c.evalOpts.Functions[fn.Name] = func(args []any, _ *EvalOptions) (any, error) {
+			return c.callFunc(fn, args)
+		}
+	}
+}
+
+// callFunc evaluates the body of fn with its parameters bound to args.
+// The body also sees constants and top-level values, like any expression.
+func (c *compiler) callFunc(fn *FuncDecl, args []any) (any, error) {
+	if len(args) != len(fn.Params) {
+		return nil, fmt.Errorf("%s requires %d arguments, got %d", fn.Name, len(fn.Params), len(args))
+	}
+	if c.funcDepth >= maxFuncDepth {
+		return nil, fmt.Errorf("%s: calls nested deeper than %d", fn.Name, maxFuncDepth)
+	}
+	c.funcDepth++
+	defer func() { c.funcDepth-- }()
+	params := make(map[string]any, len(args))
+	for i, name := range fn.Params {
+		params[name] = args[i]
+	}
+	opts := c.evalOpts
+	opts.Variables = c.scopeVars(fn.Body.Raw, params, fn.Body.Span)
+	v, err := EvalExpr(fn.Body.Raw, &opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name, err)
+	}
+	return v, nil
+}
+
+// funcArgs compiles the arguments of a document function call. Outside
+// expressions a bare name is an identifier string; here it names a value,
+// as it would inside one.
+func (c *compiler) funcArgs(x *Call) []any {
+	args := make([]any, len(x.Args))
+	for i, a := range x.Args {
+		lit, ok := a.(*Literal)
+		if !ok || lit.Type != "identifier" {
+			args[i] = c.value(a)
+			continue
+		}
+		raw, _ := lit.Data.(string)
+		if raw == "" {
+			args[i] = c.value(a)
+			continue
+		}
+		opts := c.evalOpts
+		opts.Variables = c.scopeVars(raw, nil, a.GetSpan())
+		v, err := EvalExpr(raw, &opts)
+		if err != nil {
+			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: err.Error(), Span: a.GetSpan()})
+		}
+		args[i] = v
+	}
+	return args
+}
@@ -0,0 +1,18 @@
+//go:build !js && !wasip1
+
+package bcl
+
+import (
+	"context"
+	"os/exec"
+)
+
+func gitFetch(ctx context.Context, source, target, revision string) error {
+	if err := exec.CommandContext(ctx, "git", "clone", source, target).Run(); err != nil {
+		return err
+	}
+	if revision != "" {
+		return exec.CommandContext(ctx, "git", "-C", target, "checkout", revision).Run()
+	}
+	return nil
+}
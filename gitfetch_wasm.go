@@ -0,0 +1,14 @@
+//go:build js || wasip1
+
+package bcl
+
+import (
+	"context"
+	"fmt"
+)
+
+// WebAssembly builds cannot run git; git modules must be vendored or served
+// through an IncludeResolver.
+func gitFetch(_ context.Context, source, _, _ string) error {
+	return fmt.Errorf("git module %s cannot be fetched in a WebAssembly build", source)
+}
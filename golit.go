@@ -0,0 +1,349 @@
+package bcl
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// GenerateGoLiteral renders a compiled config as Go source: struct types
+// inferred from the values plus a Default<typeName> variable holding them,
+// so defaults can be embedded in a binary while the BCL file stays the
+// single source of truth. Labeled blocks become slices tagged ",block" with
+// an ",id" field, so the generated types also decode the same file with
+// Unmarshal.
+func GenerateGoLiteral(n *Normalized, packageName, typeName string) ([]byte, error) {
+	if packageName == "" {
+		packageName = "config"
+	}
+	if typeName == "" {
+		typeName = "Config"
+	}
+	root := make(map[string]any, len(n.Body))
+	for k, v := range n.Body {
+		if k != "_" {
+			root[k] = v
+		}
+	}
+	blocks := map[string]bool{}
+	for _, block := range n.Blocks {
+		kind := stringValue(block["type"])
+		if kind == "" {
+			continue
+		}
+		body := blockBodyWithID(block)
+		if id, ok := body["$id"]; ok {
+			delete(body, "$id")
+			body[goLiteralIDKey] = id
+		}
+		list, _ := root[kind].([]any)
+		root[kind] = append(list, body)
+		blocks[kind] = true
+	}
+	g := &goLiteralGen{}
+	shape := g.infer(root)
+	g.name(shape, exportName(typeName), blocks)
+	var body bytes.Buffer
+	for _, s := range g.structs {
+		fmt.Fprintf(&body, "type %s struct {\n", s.typ)
+		for _, f := range s.order {
+			fmt.Fprintf(&body, "\t%s %s `%s`\n", f.name, g.typeOf(f.shape), f.tag)
+		}
+		body.WriteString("}\n\n")
+	}
+	fmt.Fprintf(&body, "var Default%s = ", exportName(typeName))
+	g.literal(&body, shape, root)
+	body.WriteByte('\n')
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by bcl codegen -literal. DO NOT EDIT.\n\npackage %s\n\n", packageName)
+	if g.usesTime {
+		b.WriteString("import \"time\"\n\n")
+	}
+	b.Write(body.Bytes())
+	return format.Source(b.Bytes())
+}
+
+// goLiteralIDKey carries a block label through inference; it cannot clash
+// with a BCL key.
+const goLiteralIDKey = "\x00id"
+
+type goShape struct {
+	kind   string // "string", "int64", "float64", "bool", "duration", "struct", "slice" or "any"
+	elem   *goShape
+	fields map[string]*goShape
+	typ    string
+	order  []goField
+}
+
+type goField struct {
+	key   string
+	name  string
+	tag   string
+	shape *goShape
+}
+
+type goLiteralGen struct {
+	structs  []*goShape
+	usesTime bool
+}
+
+func (g *goLiteralGen) infer(v any) *goShape {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return &goShape{kind: "string"}
+	case bool:
+		return &goShape{kind: "bool"}
+	case int, int64:
+		return &goShape{kind: "int64"}
+	case float64:
+		return &goShape{kind: "float64"}
+	case map[string]any:
+		if d, ok := x["$duration"].(string); ok && len(x) == 1 {
+			if _, err := time.ParseDuration(d); err == nil {
+				return &goShape{kind: "duration"}
+			}
+		}
+		s := &goShape{kind: "struct", fields: map[string]*goShape{}}
+		for k, fv := range x {
+			if strings.HasPrefix(k, "$") {
+				return &goShape{kind: "any"}
+			}
+			s.fields[k] = g.infer(fv)
+		}
+		return s
+	case []any:
+		s := &goShape{kind: "slice"}
+		for i, item := range x {
+			// A nil element has no typed zero value in a slice literal.
+			elem := g.infer(item)
+			if elem == nil {
+				elem = &goShape{kind: "any"}
+			}
+			if i == 0 {
+				s.elem = elem
+			} else {
+				s.elem = mergeGoShape(s.elem, elem)
+			}
+		}
+		return s
+	}
+	return &goShape{kind: "any"}
+}
+
+func mergeGoShape(a, b *goShape) *goShape {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.kind == b.kind && a.kind == "struct":
+		for k, f := range b.fields {
+			a.fields[k] = mergeGoShape(a.fields[k], f)
+		}
+		return a
+	case a.kind == b.kind && a.kind == "slice":
+		a.elem = mergeGoShape(a.elem, b.elem)
+		return a
+	case a.kind == b.kind:
+		return a
+	case a.kind == "int64" && b.kind == "float64", a.kind == "float64" && b.kind == "int64":
+		return &goShape{kind: "float64"}
+	}
+	return &goShape{kind: "any"}
+}
+
+// name assigns struct type names depth first and fixes field order, so the
+// output is stable across runs.
+func (g *goLiteralGen) name(s *goShape, typ string, blocks map[string]bool) {
+	if s == nil {
+		return
+	}
+	switch s.kind {
+	case "slice":
+		g.name(s.elem, typ, nil)
+	case "struct":
+		s.typ = typ
+		g.structs = append(g.structs, s)
+		keys := make([]string, 0, len(s.fields))
+		for k := range s.fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		used := map[string]bool{}
+		for _, k := range keys {
+			f := goField{key: k, shape: s.fields[k], name: goFieldName(k), tag: fmt.Sprintf("json:%q bcl:%q", k, k)}
+			if k == goLiteralIDKey {
+				f.name, f.tag = "ID", `bcl:",id"`
+			} else if blocks[k] {
+				f.tag = fmt.Sprintf("json:%q bcl:%q", k, k+",block")
+			}
+			for base, i := f.name, 2; used[f.name]; i++ {
+				f.name = base + strconv.Itoa(i)
+			}
+			used[f.name] = true
+			s.order = append(s.order, f)
+			g.name(f.shape, typ+f.name, nil)
+		}
+	}
+}
+
+func (g *goLiteralGen) typeOf(s *goShape) string {
+	if s == nil {
+		return "any"
+	}
+	switch s.kind {
+	case "struct":
+		return s.typ
+	case "slice":
+		return "[]" + g.typeOf(s.elem)
+	case "duration":
+		g.usesTime = true
+		return "time.Duration"
+	}
+	return s.kind
+}
+
+func (g *goLiteralGen) literal(b *bytes.Buffer, s *goShape, v any) {
+	if s == nil || s.kind == "any" {
+		goAnyLiteral(b, v)
+		return
+	}
+	switch s.kind {
+	case "struct":
+		m, _ := v.(map[string]any)
+		fmt.Fprintf(b, "%s{\n", s.typ)
+		for _, f := range s.order {
+			fv, ok := m[f.key]
+			if !ok || fv == nil {
+				continue
+			}
+			fmt.Fprintf(b, "%s: ", f.name)
+			g.literal(b, f.shape, fv)
+			b.WriteString(",\n")
+		}
+		b.WriteString("}")
+	case "slice":
+		fmt.Fprintf(b, "%s{", g.typeOf(s))
+		for i, item := range v.([]any) {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if s.elem != nil && s.elem.kind == "struct" {
+				b.WriteString("\n")
+			}
+			g.literal(b, s.elem, item)
+		}
+		if s.elem != nil && s.elem.kind == "struct" {
+			b.WriteString(",\n")
+		}
+		b.WriteString("}")
+	case "duration":
+		d, _ := time.ParseDuration(v.(map[string]any)["$duration"].(string))
+		b.WriteString(goDurationLiteral(d))
+	case "float64":
+		f, _ := toFloat(v)
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEn") {
+			s += ".0"
+		}
+		b.WriteString(s)
+	default:
+		goAnyScalar(b, v, false)
+	}
+}
+
+func goAnyLiteral(b *bytes.Buffer, v any) {
+	switch x := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteString("map[string]any{")
+		for _, k := range keys {
+			fmt.Fprintf(b, "\n%s: ", strconv.Quote(k))
+			goAnyLiteral(b, x[k])
+			b.WriteString(",")
+		}
+		if len(keys) > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("}")
+	case []any:
+		b.WriteString("[]any{")
+		for i, item := range x {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			goAnyLiteral(b, item)
+		}
+		b.WriteString("}")
+	default:
+		goAnyScalar(b, v, true)
+	}
+}
+
+func goAnyScalar(b *bytes.Buffer, v any, typed bool) {
+	switch x := v.(type) {
+	case nil:
+		b.WriteString("nil")
+	case string:
+		b.WriteString(strconv.Quote(x))
+	case int64:
+		if typed {
+			fmt.Fprintf(b, "int64(%d)", x)
+		} else {
+			fmt.Fprintf(b, "%d", x)
+		}
+	case int:
+		if typed {
+			fmt.Fprintf(b, "int64(%d)", x)
+		} else {
+			fmt.Fprintf(b, "%d", x)
+		}
+	case float64:
+		fmt.Fprintf(b, "float64(%s)", strconv.FormatFloat(x, 'g', -1, 64))
+	case bool:
+		fmt.Fprintf(b, "%t", x)
+	default:
+		b.WriteString(strconv.Quote(fmt.Sprint(x)))
+	}
+}
+
+func goDurationLiteral(d time.Duration) string {
+	if d == 0 {
+		return "0"
+	}
+	for _, u := range []struct {
+		unit time.Duration
+		name string
+	}{{time.Hour, "Hour"}, {time.Minute, "Minute"}, {time.Second, "Second"}, {time.Millisecond, "Millisecond"}, {time.Microsecond, "Microsecond"}} {
+		if d%u.unit == 0 {
+			return fmt.Sprintf("%d * time.%s", d/u.unit, u.name)
+		}
+	}
+	return fmt.Sprintf("time.Duration(%d)", int64(d))
+}
+
+func goFieldName(key string) string {
+	name := exportName(key)
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	name = b.String()
+	if name == "" || !unicode.IsUpper([]rune(name)[0]) {
+		name = "F" + name
+	}
+	return name
+}
@@ -0,0 +1,313 @@
+package bcl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// GrammarFormats lists the formats accepted by ExportGrammar.
+var GrammarFormats = []string{"textmate", "tree-sitter", "tree-sitter-highlights"}
+
+// ExportGrammar renders editor syntax definitions from the keyword,
+// operator, block, type and function tables used by the parser and the
+// language server, so highlighting stays in sync with the language:
+//
+//   - "textmate": a tmLanguage JSON grammar (VS Code, Sublime, GitHub)
+//   - "tree-sitter": a grammar.js for tree-sitter generate
+//   - "tree-sitter-highlights": the matching queries/highlights.scm
+func ExportGrammar(format string) ([]byte, error) {
+	switch format {
+	case "", "textmate":
+		return textMateGrammar()
+	case "tree-sitter":
+		return []byte(treeSitterGrammar), nil
+	case "tree-sitter-highlights":
+		return treeSitterHighlights(), nil
+	default:
+		return nil, fmt.Errorf("unknown grammar format %q (want one of %s)", format, strings.Join(GrammarFormats, ", "))
+	}
+}
+
+type tmGrammar struct {
+	Schema     string            `json:"$schema"`
+	Name       string            `json:"name"`
+	ScopeName  string            `json:"scopeName"`
+	Patterns   []tmRule          `json:"patterns"`
+	Repository map[string]tmRule `json:"repository"`
+}
+
+type tmRule struct {
+	Include  string            `json:"include,omitempty"`
+	Name     string            `json:"name,omitempty"`
+	Match    string            `json:"match,omitempty"`
+	Begin    string            `json:"begin,omitempty"`
+	End      string            `json:"end,omitempty"`
+	Captures map[string]tmRule `json:"captures,omitempty"`
+	Patterns []tmRule          `json:"patterns,omitempty"`
+}
+
+func textMateGrammar() ([]byte, error) {
+	var keywords, literals []string
+	for _, kw := range languageKeywords {
+		switch kw {
+		case "true", "false", "null":
+			literals = append(literals, kw)
+		default:
+			keywords = append(keywords, kw)
+		}
+	}
+	escapes := []tmRule{{Name: "constant.character.escape.bcl", Match: `\\.`}}
+	g := tmGrammar{
+		Schema:    "https://raw.githubusercontent.com/martinring/tmlanguage/master/tmlanguage.json",
+		Name:      "BCL",
+		ScopeName: "source.bcl",
+		Repository: map[string]tmRule{
+			"comments": {Patterns: []tmRule{
+				{Name: "comment.line.number-sign.bcl", Match: "#.*$"},
+				{Name: "comment.line.double-slash.bcl", Match: "//.*$"},
+				{Name: "comment.block.bcl", Begin: `/\*`, End: `\*/`},
+			}},
+			"strings": {Patterns: []tmRule{
+				{Name: "string.quoted.double.bcl", Begin: `"`, End: `"`, Patterns: escapes},
+				{Name: "string.quoted.raw.bcl", Begin: "`", End: "`"},
+				{Name: "string.quoted.single.bcl", Begin: `'`, End: `'`, Patterns: escapes},
+				{Name: "string.unquoted.heredoc.bcl", Begin: `<<\s*([A-Za-z_][A-Za-z0-9_-]*)`, End: `^\s*\1\s*$`},
+			}},
+			"numbers": {Patterns: []tmRule{
+				{Name: "constant.numeric.bcl", Match: `\b-?0[xX][\dA-Fa-f_]+\b|\b-?\d[\d_]*(?:\.\d[\d_]*)?(?:[A-Za-z]+)?\b|\b\d{4}-\d{2}-\d{2}(?:T\d{2}:\d{2}:\d{2}Z)?\b`},
+			}},
+			"keywords": {Patterns: []tmRule{
+				{Name: "keyword.control.bcl", Match: wordsPattern(keywords) + `|\bx_[A-Za-z0-9_]+\b`},
+				{Name: "constant.language.bcl", Match: wordsPattern(literals)},
+				{Name: "keyword.control.block.bcl", Match: wordsPattern(grammarBlockNames()) + `(?=\s*(?:"|[A-Za-z0-9_.-]+)?\s*\{)`},
+			}},
+			"declarations": {Patterns: []tmRule{
+				{Match: `\b(const)\s+([A-Z_][A-Z0-9_]*)`, Captures: map[string]tmRule{"1": {Name: "keyword.control.bcl"}, "2": {Name: "entity.name.constant.bcl"}}},
+				{Match: wordsPattern(append([]string{"schema", "type", "param"}, decisionBlockNames...)) + `\s+([A-Za-z_][A-Za-z0-9_-]*|"[^"]+")`, Captures: map[string]tmRule{"1": {Name: "keyword.control.bcl"}, "2": {Name: "entity.name.type.bcl"}}},
+			}},
+			"functions": {Patterns: []tmRule{
+				{Name: "entity.name.function.bcl", Match: wordsPattern(grammarFunctionNames()) + `(?=\s*\()`},
+				{Name: "entity.name.function.bcl", Match: `\b[A-Za-z_][A-Za-z0-9_.]*(?=\s*\()`},
+				{Name: "support.function.pattern.bcl", Match: `\b(MISSING|NULL|EXISTS|ANY)\b`},
+			}},
+			"types": {Patterns: []tmRule{
+				{Name: "support.type.bcl", Match: wordsPattern(schemaPrimitiveNames)},
+				{Name: "constant.other.schema-format.bcl", Match: `\b(public|internal|confidential|restricted|operational|low|medium|high|critical|contact|phone|name|address|identifier|decision_input|customer_segment)\b`},
+			}},
+			"blocks": {Patterns: []tmRule{
+				{Match: `^\s*([A-Za-z_][A-Za-z0-9_-]*)(?=\s+(?:"|[A-Za-z0-9_-]+)\s*\{)`, Captures: map[string]tmRule{"1": {Name: "entity.name.type.block.bcl"}}},
+				{Match: `\b([A-Za-z_][A-Za-z0-9_-]*)\s+([A-Za-z0-9_.-]+)(?=\s*\{)`, Captures: map[string]tmRule{"1": {Name: "entity.name.type.block.bcl"}, "2": {Name: "string.unquoted.label.bcl"}}},
+				{Name: "entity.name.tag.id.bcl", Match: `\b[A-Za-z_][A-Za-z0-9_.-]*\b(?=\s*\{)`},
+			}},
+			"properties": {Patterns: []tmRule{
+				{Match: `^\s*([A-Za-z_][A-Za-z0-9_.-]*|"[^"]+")(?=\s+(?:[^\s#/{]|$))`, Captures: map[string]tmRule{"1": {Name: "variable.other.property.bcl"}}},
+			}},
+			"conditionExpressions": {Patterns: []tmRule{
+				{Name: "variable.other.reference.bcl", Match: `\b[A-Za-z_][A-Za-z0-9_]*(?:\.[A-Za-z_][A-Za-z0-9_]*)+\b`},
+				{Name: "constant.language.effect.bcl", Match: `\b(allow|deny|require_review|review|block|suspend|ban|notify|escalate|warning|rate_limit|healthy|failed_login|successful_login|admin_denied|unexpected_4xx|endpoint_5xx|app_5xx)\b`},
+			}},
+			"operators": {Patterns: []tmRule{
+				{Name: "keyword.operator.bcl", Match: `\+=|==|!=|<=|>=|&&|\|\||\?\?|\?\.|=>|\.\.|[=<>!+*/%-]|` + wordsPattern(append([]string{"not", "as"}, exprOperatorWords...))},
+			}},
+			"punctuation": {Patterns: []tmRule{
+				{Name: "punctuation.section.braces.bcl", Match: `[{}]`},
+				{Name: "punctuation.section.brackets.bcl", Match: `[\[\]]`},
+				{Name: "punctuation.section.parens.bcl", Match: `[()]`},
+				{Name: "punctuation.separator.comma.bcl", Match: `,`},
+				{Name: "punctuation.accessor.dot.bcl", Match: `\.`},
+			}},
+		},
+	}
+	for _, name := range []string{"comments", "strings", "declarations", "numbers", "keywords", "functions", "types", "blocks", "conditionExpressions", "properties", "operators", "punctuation"} {
+		g.Patterns = append(g.Patterns, tmRule{Include: "#" + name})
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(g); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// wordsPattern matches any of names as a whole word, longest first so that
+// dotted names such as env.required win over their prefix.
+func wordsPattern(names []string) string {
+	names = grammarSorted(names)
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+	return `\b(` + strings.Join(quoted, "|") + `)\b`
+}
+
+func grammarSorted(names []string) []string {
+	out := uniqueStrings(append([]string(nil), names...))
+	sort.Slice(out, func(i, j int) bool {
+		if len(out[i]) != len(out[j]) {
+			return len(out[i]) > len(out[j])
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+func grammarBlockNames() []string {
+	names := append(append([]string(nil), knownBlockNames...), decisionBlockNames...)
+	for _, h := range keywordHints {
+		names = append(names, h.Name)
+	}
+	return names
+}
+
+func grammarFunctionNames() []string {
+	var names []string
+	for _, h := range builtinFunctionHints {
+		names = append(names, h.Name)
+	}
+	for _, name := range patternHelperNames {
+		if strings.ToLower(name) == name {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func treeSitterHighlights() []byte {
+	var b strings.Builder
+	b.WriteString("; Generated by bcl grammar export -format tree-sitter-highlights. DO NOT EDIT.\n\n")
+	b.WriteString("(comment) @comment\n(string) @string\n(escape_sequence) @string.escape\n(number) @number\n(boolean) @boolean\n(null) @constant.builtin\n\n")
+	b.WriteString("(block type: (identifier) @type)\n(block label: (_) @label)\n(attribute key: (_) @property)\n(call function: (identifier) @function)\n(reference) @variable\n\n")
+	writeAnyOf := func(node, capture string, names []string) {
+		fmt.Fprintf(&b, "((%s) @%s\n (#any-of? @%s", node, capture, capture)
+		for _, name := range uniqueStrings(append([]string(nil), names...)) {
+			fmt.Fprintf(&b, " %q", name)
+		}
+		b.WriteString("))\n\n")
+	}
+	writeAnyOf("identifier", "keyword", append(grammarBlockNames(), languageKeywords...))
+	writeAnyOf("identifier", "type.builtin", schemaPrimitiveNames)
+	writeAnyOf("identifier", "function.builtin", grammarFunctionNames())
+	writeAnyOf("identifier", "keyword.operator", append([]string{"not", "as"}, exprOperatorWords...))
+	b.WriteString("[\"=\" \"+=\" \"==\" \"!=\" \"<\" \"<=\" \">\" \">=\" \"&&\" \"||\" \"+\" \"-\" \"*\" \"/\" \"%\" \"!\"] @operator\n")
+	b.WriteString("[\"{\" \"}\" \"[\" \"]\" \"(\" \")\"] @punctuation.bracket\n[\",\" \".\"] @punctuation.delimiter\n")
+	return []byte(b.String())
+}
+
+const treeSitterGrammar = `// Generated by bcl grammar export -format tree-sitter. DO NOT EDIT.
+// Keywords, types and built-in functions are highlighted by name in
+// queries/highlights.scm (bcl grammar export -format tree-sitter-highlights).
+// Heredocs need an external scanner and are not covered.
+
+const PREC = { ternary: 1, coalesce: 2, or: 3, and: 4, compare: 5, add: 6, mul: 7, unary: 8, call: 9 };
+
+module.exports = grammar({
+  name: "bcl",
+
+  extras: ($) => [/[ \t\r]/, $.comment],
+
+  word: ($) => $.identifier,
+
+  conflicts: ($) => [[$.block, $._expression]],
+
+  rules: {
+    source_file: ($) => repeat(choice($._statement, $._newline)),
+
+    _statement: ($) => choice($.block, $.attribute),
+
+    _newline: (_) => /\n/,
+
+    block: ($) =>
+      seq(
+        field("type", $.identifier),
+        optional(field("label", choice($.string, $.identifier))),
+        "{",
+        repeat(choice($._statement, $._newline, ",")),
+        "}",
+      ),
+
+    attribute: ($) =>
+      seq(
+        field("key", choice($.identifier, $.dotted_key, $.string)),
+        optional(choice("=", "+=")),
+        field("value", $._expression),
+      ),
+
+    dotted_key: ($) => seq($.identifier, repeat1(seq(".", $.identifier))),
+
+    _expression: ($) =>
+      choice(
+        $.string,
+        $.number,
+        $.boolean,
+        $.null,
+        $.list,
+        $.object,
+        $.call,
+        $.reference,
+        $.identifier,
+        $.unary_expression,
+        $.binary_expression,
+        $.ternary_expression,
+        $.parenthesized_expression,
+      ),
+
+    list: ($) => seq("[", repeat(choice($._expression, ",", $._newline)), "]"),
+
+    object: ($) => seq("{", repeat(choice($._statement, ",", $._newline)), "}"),
+
+    call: ($) =>
+      prec(
+        PREC.call,
+        seq(
+          field("function", choice($.identifier, $.reference)),
+          "(",
+          optional(seq($._expression, repeat(seq(",", $._expression)), optional(","))),
+          ")",
+        ),
+      ),
+
+    reference: ($) => prec.left(seq($.identifier, repeat1(seq(choice(".", "?."), choice($.identifier, /[0-9]+/))))),
+
+    unary_expression: ($) => prec(PREC.unary, seq(choice("!", "-"), $._expression)),
+
+    binary_expression: ($) =>
+      choice(
+        prec.right(PREC.coalesce, seq($._expression, "??", $._expression)),
+        prec.left(PREC.or, seq($._expression, choice("||", "or"), $._expression)),
+        prec.left(PREC.and, seq($._expression, choice("&&", "and"), $._expression)),
+        prec.left(PREC.compare, seq($._expression, choice("==", "!=", "<", "<=", ">", ">=", "in", "not_in"), $._expression)),
+        prec.left(PREC.add, seq($._expression, choice("+", "-"), $._expression)),
+        prec.left(PREC.mul, seq($._expression, choice("*", "/", "%"), $._expression)),
+      ),
+
+    ternary_expression: ($) => prec.right(PREC.ternary, seq($._expression, "?", $._expression, ":", $._expression)),
+
+    parenthesized_expression: ($) => seq("(", $._expression, ")"),
+
+    identifier: (_) => /[A-Za-z_][A-Za-z0-9_-]*/,
+
+    number: (_) => choice(/-?0[xXoObB][0-9A-Fa-f_]+/, /-?\d[\d_]*(\.\d[\d_]*)?([eE][+-]?\d+)?[A-Za-z]*/),
+
+    boolean: (_) => choice("true", "false"),
+
+    null: (_) => "null",
+
+    string: ($) =>
+      choice(
+        seq('"', repeat(choice(/[^"\\\n]+/, $.escape_sequence)), '"'),
+        seq("'", repeat(choice(/[^'\\\n]+/, $.escape_sequence)), "'"),
+        /` + "`" + `[^` + "`" + `]*` + "`" + `/,
+      ),
+
+    escape_sequence: (_) => token.immediate(/\\./),
+
+    comment: (_) => token(choice(seq("#", /.*/), seq("//", /.*/), seq("/*", /[^*]*\*+([^/*][^*]*\*+)*/, "/"))),
+  },
+});
+`
@@ -0,0 +1,124 @@
+package bcl
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oarkflow/convert"
+)
+
+// GraphEdge is a connection block of a pipeline or network, with its
+// from/to endpoints resolved and its control properties typed.
+// Connections whose `when` is false are already dropped by the compiler.
+//
+//	connection "plan-to-risk" {
+//	  from step.plan
+//	  to step.risk
+//	  on "done"
+//	  weight 2
+//	  latency 15ms
+//	}
+type GraphEdge struct {
+	// Graph is the enclosing block as type.id, such as "pipeline.orders".
+	Graph   string
+	ID      string
+	From    string
+	To      string
+	On      string
+	Weight  float64
+	Latency time.Duration
+	// Props holds every attribute of the connection as compiled.
+	Props map[string]any
+}
+
+// GraphEdges returns the connection blocks found at any depth in n, in
+// block order. Weight defaults to 1.
+func GraphEdges(n *Normalized) ([]GraphEdge, error) {
+	var out []GraphEdge
+	var walk func(blocks []map[string]any, parent string) error
+	walk = func(blocks []map[string]any, parent string) error {
+		for _, b := range blocks {
+			if b["type"] != "connection" {
+				if err := walk(nestedBlocks(b), blockPath(b)); err != nil {
+					return err
+				}
+				continue
+			}
+			e, err := graphEdge(parent, b)
+			if err != nil {
+				return err
+			}
+			out = append(out, e)
+		}
+		return nil
+	}
+	if err := walk(n.Blocks, ""); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FilterEdges returns the edges for which keep is true, such as those with
+// a latency budget: FilterEdges(edges, func(e GraphEdge) bool { return
+// e.Latency <= 20*time.Millisecond }).
+func FilterEdges(edges []GraphEdge, keep func(GraphEdge) bool) []GraphEdge {
+	var out []GraphEdge
+	for _, e := range edges {
+		if keep(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func graphEdge(graph string, b map[string]any) (GraphEdge, error) {
+	body := blockBody(b)
+	e := GraphEdge{Graph: graph, ID: fmt.Sprint(b["id"]), From: edgeEndpoint(body["from"]), To: edgeEndpoint(body["to"]), Weight: 1, Props: body}
+	e.On, _ = body["on"].(string)
+	if w, ok := body["weight"]; ok {
+		f, err := convert.ToFloat64(w)
+		if err != nil {
+			return e, fmt.Errorf("connection %q: weight %v is not a number", e.ID, w)
+		}
+		e.Weight = f
+	}
+	switch l := body["latency"].(type) {
+	case nil:
+	case map[string]any:
+		d, err := time.ParseDuration(fmt.Sprint(l["$duration"]))
+		if err != nil {
+			return e, fmt.Errorf("connection %q: latency: %w", e.ID, err)
+		}
+		e.Latency = d
+	case string:
+		d, err := time.ParseDuration(l)
+		if err != nil {
+			return e, fmt.Errorf("connection %q: latency: %w", e.ID, err)
+		}
+		e.Latency = d
+	default:
+		return e, fmt.Errorf("connection %q: latency %v is not a duration", e.ID, l)
+	}
+	return e, nil
+}
+
+// edgeEndpoint returns what a from/to value points at: the id of a
+// type.id reference such as step.plan, the whole path of a deeper one such
+// as device.r1.interfaces.eth0, or the string itself.
+func edgeEndpoint(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case map[string]any:
+		if ref, ok := x["$ref"].(string); ok {
+			if strings.Count(ref, ".") == 1 {
+				return refTargetID(ref)
+			}
+			return ref
+		}
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,385 @@
+package bcl
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IncludeResolver serves the content of imported files and module sources
+// from somewhere other than the local filesystem, e.g. S3, a git repository
+// or a database. Names are the import path joined with the importing file's
+// directory; remote sources such as "https://..." are passed through as
+// written, and relative imports inside them resolve against their URL.
+// Resolvers do not glob, so each import names one document.
+type IncludeResolver interface {
+	Resolve(name string) ([]byte, error)
+}
+
+type IncludeResolverFunc func(name string) ([]byte, error)
+
+func (f IncludeResolverFunc) Resolve(name string) ([]byte, error) { return f(name) }
+
+// IncludeResolverContext is implemented by resolvers that fetch over the
+// network; the Context variants of Compile pass their ctx so a fetch stops
+// at its deadline.
+type IncludeResolverContext interface {
+	IncludeResolver
+	ResolveContext(ctx context.Context, name string) ([]byte, error)
+}
+
+// CompileFS compiles name from fsys and resolves its imports and modules
+// against the same filesystem, so configs bundled with go:embed can still
+// import their fragments.
+func CompileFS(fsys fs.FS, name string, opts *Options) (*Normalized, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := ParseFile(name, data)
+	if err != nil {
+		return nil, err
+	}
+	var cp Options
+	if opts != nil {
+		cp = *opts
+	}
+	cp.FS = fsys
+	cp.ResolveImports = true
+	cp.BaseDir = path.Dir(name)
+	return Compile(doc, &cp)
+}
+
+// The helpers below route import and module resolution through
+// Options.IncludeResolver or Options.FS when set, in that order. Paths are
+// then slash separated; for an FS they are relative to its root, as io/fs
+// requires. Every included file counts against Options.MaxIncludeDepth,
+// MaxIncludeFileSize and MaxIncludeBytes; zero leaves a limit off. On the
+// local filesystem, relative imports missing next to the importing file are
+// looked up in Options.IncludeRoots, in order. With
+// Options.DisableRemoteInclude, remote names are refused before any
+// resolver sees them; with Options.IncludeCacheTTL and Options.Cache, what
+// the resolver returns for them is cached.
+
+func (c *compiler) sourceFiles(pattern, baseDir string) ([]string, error) {
+	if err := c.checkRemote(pattern); err != nil {
+		return nil, err
+	}
+	if c.opts.IncludeResolver != nil {
+		return []string{resolverJoin(baseDir, pattern)}, nil
+	}
+	if c.opts.FS == nil {
+		files, err := resolveSourceFiles(pattern, baseDir)
+		if err != nil && !filepath.IsAbs(pattern) && !isRemoteSource(pattern) {
+			for _, root := range c.opts.IncludeRoots {
+				if rootFiles, rootErr := resolveSourceFiles(pattern, root); rootErr == nil {
+					return rootFiles, nil
+				}
+			}
+		}
+		return files, err
+	}
+	if isRemoteSource(pattern) {
+		return nil, fmt.Errorf("remote source %q requires module lock/fetch integration", pattern)
+	}
+	pattern = fsJoin(baseDir, pattern)
+	matches, err := fs.Glob(c.opts.FS, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		if _, err := fs.Stat(c.opts.FS, pattern); err != nil {
+			return nil, err
+		}
+		matches = []string{pattern}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func (c *compiler) moduleFiles(source, baseDir string) ([]string, error) {
+	if err := c.checkRemote(source); err != nil {
+		return nil, err
+	}
+	if c.opts.IncludeResolver != nil {
+		return []string{resolverJoin(baseDir, source)}, nil
+	}
+	if c.opts.FS == nil {
+		return resolveModuleFiles(source, baseDir)
+	}
+	source = fsJoin(baseDir, source)
+	st, err := fs.Stat(c.opts.FS, source)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return []string{source}, nil
+	}
+	var matches []string
+	for _, ext := range []string{"*.bcl", "*.schema"} {
+		files, err := fs.Glob(c.opts.FS, path.Join(source, ext))
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, files...)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func (c *compiler) parseSource(name string, depth int) (*Document, error) {
+	if limit := c.opts.MaxIncludeDepth; limit > 0 && depth > limit {
+		return nil, fmt.Errorf("include %q: depth %d exceeds the limit of %d", name, depth, limit)
+	}
+	if p := c.prefetched[name]; p != nil && p.err == nil {
+		if err := c.countInclude(name, int64(len(p.data))); err != nil {
+			return nil, err
+		}
+		if p.used {
+			// A file imported again gets its own tree, as without prefetching.
+			return c.opts.ASTCache.Parse(name, p.data)
+		}
+		p.used = true
+		return p.doc, nil
+	}
+	data, err := c.readSource(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.opts.ASTCache.Parse(name, data)
+}
+
+// readSource loads an included file, or a data file read by a function
+// such as csvdecode, under the same resolver and size limits.
+func (c *compiler) readSource(name string) ([]byte, error) {
+	data, err := c.fetchSource(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.countInclude(name, int64(len(data))); err != nil {
+		return nil, err
+	}
+	c.opts.logger().DebugContext(c.opts.context(), "loaded include", "name", name, "bytes", len(data))
+	return data, nil
+}
+
+// fetchSource reads name without counting it toward MaxIncludeBytes. It is
+// safe for concurrent use when the IncludeResolver or FS is.
+func (c *compiler) fetchSource(name string) ([]byte, error) {
+	if c.opts.ctx != nil {
+		if err := c.opts.ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.checkRemote(name); err != nil {
+		return nil, err
+	}
+	if c.opts.IncludeResolver == nil {
+		return c.readLocalSource(name)
+	}
+	resolve := func() ([]byte, error) {
+		if r, ok := c.opts.IncludeResolver.(IncludeResolverContext); ok {
+			return r.ResolveContext(c.opts.context(), name)
+		}
+		return c.opts.IncludeResolver.Resolve(name)
+	}
+	if isRemoteSource(name) && c.opts.IncludeCacheTTL > 0 {
+		return c.opts.Cache.Fetch("include "+name, c.opts.IncludeCacheTTL, resolve)
+	}
+	return resolve()
+}
+
+func (c *compiler) checkRemote(name string) error {
+	if c.opts.DisableRemoteInclude && isRemoteSource(name) {
+		return fmt.Errorf("remote source %q is disabled", name)
+	}
+	return nil
+}
+
+func (c *compiler) readLocalSource(name string) (data []byte, err error) {
+	switch {
+	case c.opts.FS != nil:
+		var st fs.FileInfo
+		if st, err = fs.Stat(c.opts.FS, name); err == nil {
+			err = c.checkIncludeSize(name, st.Size())
+		}
+		if err == nil {
+			data, err = fs.ReadFile(c.opts.FS, name)
+		}
+	default:
+		var st os.FileInfo
+		if st, err = os.Stat(name); err == nil {
+			err = c.checkIncludeSize(name, st.Size())
+		}
+		if err == nil {
+			data, err = os.ReadFile(name)
+		}
+		if err == nil && IsEncrypted(data) {
+			err = fmt.Errorf("%s is encrypted; decrypt it with a key provider first", name)
+		}
+	}
+	return data, err
+}
+
+// prefetchedSource is an import read and parsed by prefetchImports.
+type prefetchedSource struct {
+	data []byte
+	doc  *Document
+	err  error
+	used bool
+}
+
+// prefetchImports reads and parses the import graph below nodes a level at
+// a time with up to IncludeParallelism concurrent fetches, fetching each
+// file once however often it is imported. It stops below MaxIncludeDepth
+// and once MaxIncludeBytes have been read. resolveImports then takes the
+// files from c.prefetched in document order, counting and checking them as
+// if it had read them, and loads anything missing or failed itself, so
+// results and errors match sequential loading.
+func (c *compiler) prefetchImports(nodes []Node, baseDir string) {
+	type job struct {
+		name string
+		dir  string
+	}
+	c.prefetched = map[string]*prefetchedSource{}
+	var level []job
+	queue := func(nodes []Node, dir string) {
+		for _, n := range nodes {
+			imp, ok := n.(*ImportDecl)
+			if !ok {
+				continue
+			}
+			matches, err := c.sourceFiles(imp.Path, dir)
+			if err != nil {
+				continue
+			}
+			for _, m := range matches {
+				if _, ok := c.prefetched[m]; !ok {
+					c.prefetched[m] = nil
+					level = append(level, job{name: m})
+				}
+			}
+		}
+	}
+	queue(nodes, baseDir)
+	var total int64
+	for depth := 1; len(level) > 0; depth++ {
+		if limit := c.opts.MaxIncludeDepth; limit > 0 && depth > limit {
+			return
+		}
+		jobs := level
+		level = nil
+		results := make([]*prefetchedSource, len(jobs))
+		sem := make(chan struct{}, c.opts.IncludeParallelism)
+		var wg sync.WaitGroup
+		for i, j := range jobs {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				p := &prefetchedSource{}
+				if p.data, p.err = c.fetchSource(j.name); p.err == nil {
+					p.doc, p.err = c.opts.ASTCache.Parse(j.name, p.data)
+				}
+				results[i] = p
+			}()
+		}
+		wg.Wait()
+		for i, j := range jobs {
+			p := results[i]
+			c.prefetched[j.name] = p
+			total += int64(len(p.data))
+			if limit := c.opts.MaxIncludeBytes; limit > 0 && total > limit {
+				return
+			}
+			if p.err == nil {
+				queue(p.doc.Items, c.sourceDir(j.name))
+			}
+		}
+	}
+}
+
+// checkIncludeSize is called with the stat size before reading, so an
+// oversized file is rejected without loading it, and again with the size
+// actually read.
+func (c *compiler) checkIncludeSize(name string, size int64) error {
+	if limit := c.opts.MaxIncludeFileSize; limit > 0 && size > limit {
+		return fmt.Errorf("include %q: %d bytes exceeds the per-file limit of %d", name, size, limit)
+	}
+	return nil
+}
+
+func (c *compiler) countInclude(name string, size int64) error {
+	if err := c.checkIncludeSize(name, size); err != nil {
+		return err
+	}
+	c.includedBytes += size
+	if limit := c.opts.MaxIncludeBytes; limit > 0 && c.includedBytes > limit {
+		return fmt.Errorf("include %q: %d included bytes exceed the total limit of %d", name, c.includedBytes, limit)
+	}
+	return nil
+}
+
+func (c *compiler) sourceDir(name string) string {
+	switch {
+	case c.opts.IncludeResolver != nil && strings.Contains(name, "://"):
+		if u, err := url.Parse(name); err == nil && u.Opaque == "" {
+			// The query and fragment belong to the file, not its siblings.
+			u.Path, u.RawPath, u.RawQuery, u.Fragment = path.Dir(u.Path), "", "", ""
+			return u.String()
+		}
+		return name[:strings.LastIndex(name, "/")]
+	case c.opts.IncludeResolver != nil, c.opts.FS != nil:
+		return path.Dir(name)
+	}
+	return filepath.Dir(name)
+}
+
+// fsJoin resolves name against dir inside an fs.FS; a leading slash means
+// the FS root.
+func fsJoin(dir, name string) string {
+	name = filepath.ToSlash(name)
+	if path.IsAbs(name) {
+		return path.Clean(name[1:])
+	}
+	return path.Join(filepath.ToSlash(dir), name)
+}
+
+// resolverJoin resolves name against dir for an IncludeResolver. Remote
+// names are kept. Under a URL directory, names resolve as links do, so
+// "../x.bcl" climbs the URL path and "/x.bcl" starts at the host; elsewhere
+// absolute names are kept.
+func resolverJoin(dir, name string) string {
+	name = filepath.ToSlash(name)
+	switch {
+	case isRemoteSource(name):
+		return name
+	case strings.Contains(dir, "://"):
+		base, err := url.Parse(strings.TrimSuffix(dir, "/") + "/")
+		ref, refErr := url.Parse(name)
+		if err != nil || refErr != nil || base.Opaque != "" {
+			return strings.TrimSuffix(dir, "/") + "/" + strings.TrimPrefix(path.Clean(name), "./")
+		}
+		return base.ResolveReference(ref).String()
+	case path.IsAbs(name):
+		return name
+	}
+	return path.Join(filepath.ToSlash(dir), name)
+}
+
+// includeDir returns the directory relative sources in the node at sp
+// resolve against: that of the included file declaring it, or baseDir for
+// the document being compiled.
+func (c *compiler) includeDir(sp Span, baseDir string) string {
+	if sp.File == "" || sp.File == "<input>" || sp.File == c.file {
+		return baseDir
+	}
+	return c.sourceDir(sp.File)
+}
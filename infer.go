@@ -0,0 +1,175 @@
+package bcl
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// maxInferredEnum is the most distinct string values a field may take and
+// still be proposed as an enum.
+const maxInferredEnum = 5
+
+// InferSchema derives a schema named "inferred" from sample documents. Each
+// sample is compiled and its top-level values are merged: a field is
+// required when every sample (or every occurrence of its parent object) sets
+// it, conflicting types widen to number or any, and a string field that
+// repeats a handful of values becomes an enum. The result is a starting
+// point for legacy configs; format it with FormatDocument and edit.
+func InferSchema(samples ...[]byte) (*SchemaDecl, error) {
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("infer schema: no samples")
+	}
+	root := &inferObject{fields: map[string]*inferField{}}
+	for i, src := range samples {
+		n, err := CompileBytes(src, nil)
+		if err != nil {
+			return nil, fmt.Errorf("infer schema: sample %d: %w", i+1, err)
+		}
+		root.add(n.Body)
+	}
+	return &SchemaDecl{Name: "inferred", Fields: root.schemaFields()}, nil
+}
+
+type inferObject struct {
+	seen   int
+	fields map[string]*inferField
+}
+
+type inferField struct {
+	count  int
+	types  []string
+	items  []string
+	values map[string]int
+	object *inferObject
+}
+
+func (o *inferObject) add(m map[string]any) {
+	o.seen++
+	for k, v := range m {
+		f := o.fields[k]
+		if f == nil {
+			f = &inferField{values: map[string]int{}}
+			o.fields[k] = f
+		}
+		f.add(v)
+	}
+}
+
+func (f *inferField) add(v any) {
+	f.count++
+	typ := inferType(v)
+	f.types = appendUnique(f.types, typ)
+	switch x := v.(type) {
+	case string:
+		f.values[x]++
+	case map[string]any:
+		if typ == "object" {
+			if f.object == nil {
+				f.object = &inferObject{fields: map[string]*inferField{}}
+			}
+			f.object.add(x)
+		}
+	case []any:
+		for _, item := range x {
+			f.items = appendUnique(f.items, inferType(item))
+		}
+	}
+}
+
+func (o *inferObject) schemaFields() []SchemaField {
+	names := make([]string, 0, len(o.fields))
+	for k := range o.fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	out := make([]SchemaField, 0, len(names))
+	for _, name := range names {
+		f := o.fields[name]
+		sf := SchemaField{Name: name, Type: widenTypes(f.types), Required: f.count == o.seen}
+		if strings.Contains(sf.Type, "null") {
+			sf.Type, sf.Nullable = strings.TrimSuffix(sf.Type, "|null"), true
+		}
+		switch sf.Type {
+		case "object":
+			sf.Fields = f.object.schemaFields()
+		case "list":
+			if len(f.items) > 0 {
+				sf.Items = strings.TrimSuffix(widenTypes(f.items), "|null")
+			}
+		case "string":
+			if len(f.values) <= maxInferredEnum && len(f.values) < f.count {
+				values := make([]string, 0, len(f.values))
+				for v := range f.values {
+					values = append(values, v)
+				}
+				sort.Strings(values)
+				for _, v := range values {
+					sf.Enum = append(sf.Enum, &Literal{Type: "string", Data: v})
+				}
+			}
+		}
+		out = append(out, sf)
+	}
+	return out
+}
+
+func inferType(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int, int64:
+		return "int"
+	case float64:
+		return "float"
+	case []any:
+		return "list"
+	case map[string]any:
+		if len(x) == 1 {
+			for k := range x {
+				if strings.HasPrefix(k, "$") && isSchemaPrimitiveOrFormat(k[1:]) {
+					return k[1:]
+				}
+			}
+		}
+		return "object"
+	}
+	return "any"
+}
+
+// widenTypes merges the types observed for one field; null is kept as a
+// "|null" suffix so the caller can mark the field nullable.
+func widenTypes(types []string) string {
+	var kinds []string
+	nullable := false
+	for _, t := range types {
+		if t == "null" {
+			nullable = true
+			continue
+		}
+		kinds = appendUnique(kinds, t)
+	}
+	typ := "any"
+	switch {
+	case len(kinds) == 1:
+		typ = kinds[0]
+	case len(kinds) == 2 && slices.Contains(kinds, "int") && slices.Contains(kinds, "float"):
+		typ = "number"
+	}
+	if nullable && typ != "any" {
+		typ += "|null"
+	}
+	return typ
+}
+
+func appendUnique(xs []string, s string) []string {
+	if slices.Contains(xs, s) {
+		return xs
+	}
+	return append(xs, s)
+}
@@ -0,0 +1,1041 @@
+package bcl
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestExportKubernetesConfigMapAndSecret(t *testing.T) {
+	doc, err := Parse([]byte(`
+service "api" {
+  port 8080
+  timeout 5s
+  database {
+    host "db.local"
+    password "hunter2"
+  }
+  token sensitive("abc")
+}
+
+service "worker" {
+  port 9090
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	redacted, err := CompileDetailed(doc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExportKubernetes(redacted, KubernetesOptions{Blocks: []string{"service.api"}}); err == nil || !strings.Contains(err.Error(), "service.api.token") {
+		t.Fatalf("expected redacted sensitive value to be refused, got %v", err)
+	}
+	if _, err := ExportKubernetes(redacted, KubernetesOptions{Blocks: []string{"service.worker"}}); err != nil {
+		t.Fatalf("blocks without sensitive values should export from a redacted result: %v", err)
+	}
+	result, err := CompileDetailed(doc, &Options{RevealSensitive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ExportKubernetes(result, KubernetesOptions{
+		Blocks:    []string{"service.api"},
+		Namespace: "prod",
+		Secrets:   []string{"service.api.database.password"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(out)
+	for _, want := range []string{
+		"kind: ConfigMap",
+		"kind: Secret",
+		"name: service-api",
+		"namespace: prod",
+		`port: "8080"`,
+		"timeout: 5s",
+		"database.host: db.local",
+		"database.password: " + base64.StdEncoding.EncodeToString([]byte("hunter2")),
+		"token: " + base64.StdEncoding.EncodeToString([]byte("abc")),
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("kubernetes export missing %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "worker") || strings.Contains(text, "hunter2") || strings.Contains(text, "abc") || strings.Contains(text, "****") {
+		t.Fatalf("kubernetes export leaked unselected or secret data:\n%s", text)
+	}
+	if _, err := ExportKubernetes(result, KubernetesOptions{Blocks: []string{"missing"}}); err == nil {
+		t.Fatal("expected error for unmatched block selection")
+	}
+}
+
+func TestToTFVarsJSONAndHCL(t *testing.T) {
+	doc, err := Parse([]byte(`
+region "eu-west-1"
+instance-count 3
+timeout 30s
+subnet "a" {
+  cidr "10.0.1.0/24"
+}
+subnet "b" {
+  cidr "10.0.2.0/24"
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := CompileDetailed(doc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ToTFVars(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vars map[string]any
+	if err := json.Unmarshal(out, &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars["region"] != "eu-west-1" || vars["instance_count"] != float64(3) || vars["timeout"] != "30s" {
+		t.Fatalf("tfvars = %#v", vars)
+	}
+	subnets, _ := vars["subnet"].(map[string]any)
+	if b, _ := subnets["b"].(map[string]any); b["cidr"] != "10.0.2.0/24" {
+		t.Fatalf("subnet tfvars = %#v", vars["subnet"])
+	}
+	hcl, err := ToTFVarsHCL(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`region = "eu-west-1"`, "instance_count = 3", `"cidr" = "10.0.1.0/24"`} {
+		if !strings.Contains(string(hcl), want) {
+			t.Fatalf("hcl tfvars missing %q:\n%s", want, hcl)
+		}
+	}
+
+	doc, err = Parse([]byte("db { password sensitive(\"s3cret\") }\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err = CompileDetailed(doc, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ToTFVars(result); err == nil || !strings.Contains(err.Error(), "db.password is sensitive") {
+		t.Fatalf("redacted tfvars err = %v", err)
+	}
+	if result, err = CompileDetailed(doc, &Options{RevealSensitive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := ToTFVars(result); err != nil || !strings.Contains(string(out), "s3cret") {
+		t.Fatalf("revealed tfvars = %s, %v", out, err)
+	}
+}
+
+func TestToTFVarsCollisions(t *testing.T) {
+	tfvars := func(src string) (map[string]any, error) {
+		t.Helper()
+		doc, err := Parse([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := CompileDetailed(doc, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tfvarsValues(result)
+	}
+	vars, err := tfvars("Rule {\n  port 80\n}\nRule {\n  port 443\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules, _ := vars["Rule"].([]any); len(rules) != 2 || rules[1].(map[string]any)["port"] != int64(443) {
+		t.Fatalf("unnamed blocks = %#v", vars["Rule"])
+	}
+	for src, want := range map[string]string{
+		"a-b 1\na_b 2\n": `key "a-b" and key "a_b"`,
+		"subnet 1\nsubnet \"a\" {\n  cidr \"x\"\n}\n":       `key "subnet" and labeled block "subnet"`,
+		"Rule {\n  port 80\n}\nRule \"r\" {\n  port 1\n}\n": `block "Rule" and labeled block "Rule"`,
+	} {
+		if _, err := tfvars(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%q: err = %v, want %s", src, err, want)
+		}
+	}
+}
+
+func TestBinderReportsChangedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.bcl")
+	write := func(src string) {
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("database {\n  host \"db1\"\n  pool 4\n}\nlog_level \"info\"\nserver web {\n  port 80\n}\n")
+	binder, err := NewBinder(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ConfigChanges
+	calls := 0
+	binder.OnChange = func(_ *Normalized, changes ConfigChanges) {
+		calls++
+		got = changes
+	}
+	write("database {\n  host \"db2\"\n  pool 4\n}\ncache true\nserver web {\n  port 8080\n}\n")
+	if _, _, err := binder.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	want := ConfigChanges{
+		{Op: "added", Key: "cache", New: true},
+		{Op: "modified", Key: "database.host", Old: "db1", New: "db2"},
+		{Op: "removed", Key: "log_level", Old: "info"},
+		{Op: "modified", Key: "server.web.port", Old: int64(80), New: int64(8080)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes = %#v", got)
+	}
+	if !got.Matches("database.*") || got.Matches("metrics") {
+		t.Fatalf("matches on %v", got.Keys())
+	}
+	if _, _, err := binder.Reload(); err != nil || calls != 1 {
+		t.Fatalf("unchanged reload: err=%v calls=%d", err, calls)
+	}
+}
+
+func TestReloadHandlerSwapsOnlyValidConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.bcl")
+	if err := os.WriteFile(path, []byte("port 8080\ntoken sensitive(\"abc\")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	binder, err := NewBinder(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(ReloadHandler(binder))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"port": 8080`) || strings.Contains(string(body), "abc") {
+		t.Fatalf("config response %d: %s", resp.StatusCode, body)
+	}
+
+	if err := os.WriteFile(path, []byte("port 9090\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Post(srv.URL+"/-/reload", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || binder.Current().Body["port"] != int64(9090) {
+		t.Fatalf("reload status %d body=%#v", resp.StatusCode, binder.Current().Body)
+	}
+
+	if err := os.WriteFile(path, []byte("const A = 1\nconst A = 2\nport 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Post(srv.URL+"/-/reload", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity || binder.Current().Body["port"] != int64(9090) {
+		t.Fatalf("invalid reload status %d body=%#v", resp.StatusCode, binder.Current().Body)
+	}
+}
+
+func TestCompareBoundAndBinderDrift(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.bcl")
+	if err := os.WriteFile(path, []byte("name \"api\"\nport 8080\nextra true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	type config struct {
+		Name string `bcl:"name"`
+		Port int    `bcl:"port"`
+	}
+	running := config{Name: "api", Port: 8080}
+	changes, err := CompareBound(&running, path, nil)
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("in sync: changes=%v err=%v", changes, err)
+	}
+	running.Port = 9090
+	changes, err = CompareBound(running, path, nil)
+	want := ConfigChanges{{Op: "modified", Key: "port", Old: int64(8080), New: int64(9090)}}
+	if err != nil || !reflect.DeepEqual(changes, want) {
+		t.Fatalf("drifted: changes=%#v err=%v", changes, err)
+	}
+
+	binder, err := NewBinder(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("name \"api\"\nport 8081\nextra true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(ReloadHandler(binder))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/config/drift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"status":"drifted"`) || !strings.Contains(string(body), `"keys":["port"]`) {
+		t.Fatalf("drift response %d: %s", resp.StatusCode, body)
+	}
+	if binder.Current().Body["port"] != int64(8080) {
+		t.Fatalf("drift swapped the config: %#v", binder.Current().Body)
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	n, err := CompileBytes([]byte(`features {
+  audit true
+  hot_reload false
+  page_size 50
+  checkout {
+    rollout 30
+    when { user.tier == "beta" }
+    value "v2"
+  }
+  search {
+    enabled false
+    value "fast"
+  }
+}
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := Features(n)
+	if !flags.Enabled("audit") || flags.Enabled("hot_reload") || flags.Enabled("checkout") || !flags.Bool("missing", true) {
+		t.Fatalf("flags = %v", flags.Names())
+	}
+	if flags.Int("page_size", 10) != 50 || flags.String("search", "slow") != "slow" || flags.String("checkout", "v1") != "v2" {
+		t.Fatal("typed accessors")
+	}
+	beta := map[string]any{"user": map[string]any{"tier": "beta"}}
+	in := 0
+	for i := range 1000 {
+		if flags.EnabledFor("checkout", fmt.Sprint("user-", i), beta) {
+			in++
+		}
+	}
+	if in < 250 || in > 350 {
+		t.Fatalf("%d of 1000 subjects in a 30%% rollout", in)
+	}
+	for i := range 1000 {
+		if flags.EnabledFor("checkout", fmt.Sprint("user-", i), map[string]any{"user": map[string]any{"tier": "ga"}}) {
+			t.Fatal("condition ignored")
+		}
+	}
+	if v, err := Eval(`rollout("user-1", 100)`, nil); err != nil || v != true {
+		t.Fatalf("rollout() = %v, %v", v, err)
+	}
+
+	var changed []string
+	flags.OnChange(func(_ *FeatureSet, names []string) { changed = names })
+	next, err := CompileBytes([]byte("features {\n  audit true\n  hot_reload true\n}\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags.Update(next)
+	if want := []string{"checkout", "hot_reload", "page_size", "search"}; !reflect.DeepEqual(changed, want) {
+		t.Fatalf("changed = %v", changed)
+	}
+}
+
+func TestUnmarshalEncryptedRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	plain := []byte("name \"api\"\nport 8080\n")
+	sealed, err := Encrypt(plain, "ops-2024", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(sealed) || bytes.Contains(sealed, []byte("8080")) {
+		t.Fatalf("unexpected envelope:\n%s", sealed)
+	}
+	path := filepath.Join(t.TempDir(), "app.bcl.enc")
+	if err := os.WriteFile(path, sealed, 0600); err != nil {
+		t.Fatal(err)
+	}
+	var requested string
+	keys := KeyProviderFunc(func(id string) ([]byte, error) {
+		requested = id
+		return key, nil
+	})
+	var cfg struct {
+		Name string `bcl:"name"`
+		Port int    `bcl:"port"`
+	}
+	if err := UnmarshalEncrypted(path, &cfg, keys, nil); err != nil {
+		t.Fatal(err)
+	}
+	if requested != "ops-2024" || cfg.Name != "api" || cfg.Port != 8080 {
+		t.Fatalf("key=%q cfg=%#v", requested, cfg)
+	}
+	if _, err := Decrypt(sealed, StaticKey(bytes.Repeat([]byte{8}, 32))); err == nil {
+		t.Fatal("expected wrong key to fail")
+	}
+	tampered := bytes.Replace(sealed, []byte("ops-2024"), []byte("ops-2025"), 1)
+	if _, err := Decrypt(tampered, StaticKey(key)); err == nil {
+		t.Fatal("expected tampered header to fail authentication")
+	}
+	if _, err := ParsePath(path); err == nil {
+		t.Fatal("expected ParsePath to refuse encrypted input")
+	}
+	if _, err := Decrypt(plain, StaticKey(key)); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("Decrypt(plaintext) error = %v", err)
+	}
+	plainPath := filepath.Join(t.TempDir(), "app.bcl")
+	if err := os.WriteFile(plainPath, []byte("name env(\"HOME\", \"none\")\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnmarshalEncrypted(plainPath, &cfg, keys, nil); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("UnmarshalEncrypted(plaintext) error = %v", err)
+	}
+	if err := UnmarshalEncrypted(plainPath, &cfg, keys, &Options{AllowPlaintext: true}); err == nil {
+		t.Fatal("expected env() to need AllowEnv")
+	}
+	if err := UnmarshalEncrypted(plainPath, &cfg, keys, &Options{AllowPlaintext: true, AllowEnv: true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditLogRecordsSideEffects(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte("REGION=eu\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "common.bcl"), []byte("shared true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := []byte(`import "./common.bcl"
+env_file ".env"
+token env("TOKEN", "none")
+region = env.REGION == "eu"
+`)
+	doc, err := ParseFile(filepath.Join(dir, "app.bcl"), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := &AuditLog{}
+	env := func(key string) (string, bool) {
+		if key == "TOKEN" {
+			return "s3cr3t", true
+		}
+		return os.LookupEnv(key)
+	}
+	if _, err := Compile(doc, &Options{AllowEnv: true, Env: env, Audit: log, ResolveImports: true, BaseDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]AuditEvent{}
+	for _, ev := range log.Events() {
+		got[ev.Kind+":"+filepath.Base(ev.Target)] = ev
+	}
+	if ev, ok := got["include:common.bcl"]; !ok || ev.Result != "ok" {
+		t.Fatalf("include event = %#v in %#v", ev, got)
+	}
+	if _, ok := got["env_file:.env"]; !ok {
+		t.Fatalf("missing env_file event: %#v", got)
+	}
+	if ev := got["env:TOKEN"]; ev.Result != "****" || ev.Span.Start.Line != 3 {
+		t.Fatalf("env event should be redacted and positioned: %#v", ev)
+	}
+	if _, ok := got["env:REGION"]; !ok {
+		t.Fatalf("missing env.X event: %#v", got)
+	}
+	log.Reset()
+	log.IncludeValues = true
+	if _, err := Compile(doc, &Options{AllowEnv: true, Env: env, Audit: log, ResolveImports: true, BaseDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range log.Events() {
+		if ev.Kind == "env" && ev.Target == "TOKEN" && ev.Result != "s3cr3t" {
+			t.Fatalf("IncludeValues should keep env values: %#v", ev)
+		}
+	}
+}
+
+func TestAllowedEnvKeys(t *testing.T) {
+	env := func(key string) (string, bool) {
+		return map[string]string{"MIGRATE_SCHEMA": "tenant_a", "DB_PASSWORD": "pw"}[key], key != "MISSING"
+	}
+	log := &AuditLog{IncludeValues: true}
+	opts := &Options{AllowEnv: true, Env: env, Audit: log, AllowedEnvKeys: []string{"MIGRATE_*"}}
+	doc, err := Parse([]byte(`schema_name env("MIGRATE_SCHEMA")
+tablespace = "ts_" + env.MIGRATE_SCHEMA
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := Compile(doc, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["schema_name"] != "tenant_a" || n.Body["tablespace"] != "ts_tenant_a" {
+		t.Fatalf("body = %#v", n.Body)
+	}
+	if got := log.EnvValues(); !reflect.DeepEqual(got, map[string]string{"MIGRATE_SCHEMA": "tenant_a"}) {
+		t.Fatalf("env values = %#v", got)
+	}
+	for _, src := range []string{`pw env("DB_PASSWORD")`, `pw = env.DB_PASSWORD + ""`} {
+		doc, err := Parse([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Compile(doc, opts); err == nil || !strings.Contains(err.Error(), "AllowedEnvKeys") {
+			t.Fatalf("%s: err = %v", src, err)
+		}
+	}
+}
+
+func TestCommandArgvShellSelectionAndQuoting(t *testing.T) {
+	cases := []struct {
+		command map[string]any
+		goos    string
+		want    []string
+	}{
+		{map[string]any{"exec": "scripts/build.sh", "args": []any{"--out", "dist dir"}}, "linux", []string{"scripts/build.sh", "--out", "dist dir"}},
+		{map[string]any{"exec": []any{"scripts/build.sh", "test"}}, "windows", []string{`scripts\build.sh`, "test"}},
+		{map[string]any{"exec": "ls", "args": []any{"*.go"}}, "windows", []string{"ls", "*.go"}},
+		{map[string]any{"exec": "go list ./... | grep -v vendor > pkgs.txt", "shell": "bash"}, "linux", []string{"bash", "-c", "go list ./... | grep -v vendor > pkgs.txt"}},
+		{map[string]any{"exec": []any{"echo", "it's", "$HOME"}, "shell": "sh"}, "linux", []string{"sh", "-c", `echo 'it'\''s' '$HOME'`}},
+		{map[string]any{"exec": []any{"Write-Output", "it's"}, "shell": "powershell"}, "windows", []string{"powershell", "-NoProfile", "-NonInteractive", "-Command", `& 'Write-Output' 'it''s'`}},
+		{map[string]any{"exec": []any{"echo", "a & b"}, "shell": "cmd"}, "windows", []string{"cmd", "/d", "/s", "/c", `echo "a & b"`}},
+		{map[string]any{"exec": "make build", "args": []any{"OUT=dist dir"}, "shell": "sh"}, "linux", []string{"sh", "-c", "make build 'OUT=dist dir'"}},
+		{map[string]any{"exec": "Get-Item x", "args": []any{"it's"}, "shell": "pwsh"}, "windows", []string{"pwsh", "-NoProfile", "-NonInteractive", "-Command", "Get-Item x 'it''s'"}},
+	}
+	for _, tc := range cases {
+		got, err := CommandArgv(tc.command, tc.goos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, "\x00") != strings.Join(tc.want, "\x00") {
+			t.Fatalf("CommandArgv(%v, %s) = %q, want %q", tc.command, tc.goos, got, tc.want)
+		}
+	}
+	if _, err := CommandArgv(map[string]any{"exec": "x", "shell": "fish"}, "linux"); err == nil {
+		t.Fatal("expected unsupported shell error")
+	}
+	split := []struct {
+		exec, goos string
+		want       []string
+	}{
+		{`go test -run 'Test A' ./...`, "linux", []string{"go", "test", "-run", "Test A", "./..."}},
+		{`echo "say \"hi\"" a\ b`, "linux", []string{"echo", `say "hi"`, "a b"}},
+		{`C:\tools\lint.exe "C:\My Files"`, "windows", []string{`C:\tools\lint.exe`, `C:\My Files`}},
+	}
+	for _, tc := range split {
+		got, err := CommandArgv(map[string]any{"exec": tc.exec, "args": []any{"x"}}, tc.goos)
+		if want := append(tc.want, "x"); err != nil || strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+			t.Fatalf("CommandArgv(%s) = %q, %v; want %q", tc.exec, got, err, want)
+		}
+	}
+	for _, exec := range []string{"go list ./... | grep x", "echo $HOME", `echo "$HOME"`, "echo 'open"} {
+		if _, err := CommandArgv(map[string]any{"exec": exec}, "linux"); err == nil {
+			t.Fatalf("CommandArgv(%s) ran shell syntax without a shell", exec)
+		}
+	}
+	doc, err := Parse([]byte(`
+command "list" {
+  exec ["scripts/prepare.sh", "test"]
+}
+
+command "bad" {
+  exec "tool"
+  shell "fish"
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diags := Validate(doc, nil)
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, `unsupported shell "fish"`) {
+		t.Fatalf("diagnostics = %#v", diags)
+	}
+}
+
+func TestCompileFSResolvesImportsAndModules(t *testing.T) {
+	fsys := fstest.MapFS{
+		"conf/app.bcl":            {Data: []byte("import \"./shared/*.bcl\"\nmodule \"db\" {\n  source \"/modules/db\"\n}\nname \"api\"\n")},
+		"conf/shared/limits.bcl":  {Data: []byte("max_conns 10\n")},
+		"modules/db/database.bcl": {Data: []byte("database {\n  driver \"postgres\"\n}\n")},
+		"modules/db/README.md":    {Data: []byte("not bcl")},
+	}
+	opts := &Options{ResolveModules: true}
+	n, err := CompileFS(fsys, "conf/app.bcl", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["name"] != "api" || n.Body["max_conns"] != int64(10) {
+		t.Fatalf("body = %#v", n.Body)
+	}
+	if opts.FS != nil || opts.ResolveImports || opts.BaseDir != "" {
+		t.Fatalf("CompileFS changed the caller's options: %#v", opts)
+	}
+	if n.Namespaces["db"] == nil || !strings.Contains(fmt.Sprint(n.Namespaces["db"]), "postgres") {
+		t.Fatalf("module namespace = %#v", n.Namespaces)
+	}
+	if _, err := CompileFS(fsys, "conf/missing.bcl", nil); err == nil {
+		t.Fatal("expected missing file error")
+	}
+}
+
+func TestIncludeLimits(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.bcl":   {Data: []byte("import \"./a.bcl\"\nname \"api\"\n")},
+		"a.bcl":     {Data: []byte("import \"./b.bcl\"\na 1\n")},
+		"b.bcl":     {Data: []byte("b 2\n")},
+		"big.bcl":   {Data: []byte("blob \"" + strings.Repeat("x", 4096) + "\"\n")},
+		"bomb.bcl":  {Data: []byte("import \"./a.bcl\"\nimport \"./b.bcl\"\nimport \"./b.bcl\"\n")},
+		"large.bcl": {Data: []byte("import \"./big.bcl\"\n")},
+	}
+	if _, err := CompileFS(fsys, "app.bcl", &Options{MaxIncludeDepth: 2, MaxIncludeFileSize: 1024, MaxIncludeBytes: 1024}); err != nil {
+		t.Fatalf("within limits: %v", err)
+	}
+	for name, tc := range map[string]struct {
+		file string
+		opts *Options
+		want string
+	}{
+		"depth":    {"app.bcl", &Options{MaxIncludeDepth: 1}, `include "b.bcl": depth 2 exceeds the limit of 1`},
+		"file":     {"large.bcl", &Options{MaxIncludeFileSize: 1024}, `include "big.bcl": 4104 bytes exceeds the per-file limit of 1024`},
+		"total":    {"bomb.bcl", &Options{MaxIncludeBytes: 24}, "included bytes exceed the total limit of 24"},
+		"resolver": {"large.bcl", &Options{MaxIncludeFileSize: 1024, IncludeResolver: IncludeResolverFunc(func(name string) ([]byte, error) { return fsys[name].Data, nil })}, "exceeds the per-file limit"},
+	} {
+		if _, err := CompileFS(fsys, tc.file, tc.opts); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected %q, got %v", name, tc.want, err)
+		}
+	}
+}
+
+func TestIncludeResolverServesImportsAndModules(t *testing.T) {
+	files := map[string]string{
+		"conf/app.bcl":                          "import \"./limits.bcl\"\nmodule \"db\" {\n  source \"https://cfg.example.com/db/main.bcl\"\n}\nname \"api\"\n",
+		"conf/limits.bcl":                       "max_conns 10\n",
+		"https://cfg.example.com/db/main.bcl":   "import \"./driver.bcl\"\n",
+		"https://cfg.example.com/db/driver.bcl": "driver \"postgres\"\n",
+	}
+	var requested []string
+	resolver := IncludeResolverFunc(func(name string) ([]byte, error) {
+		requested = append(requested, name)
+		src, ok := files[name]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return []byte(src), nil
+	})
+	doc, err := ParseFile("conf/app.bcl", []byte(files["conf/app.bcl"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := Compile(doc, &Options{ResolveImports: true, ResolveModules: true, IncludeResolver: resolver})
+	if err != nil {
+		t.Fatalf("%v (requested %v)", err, requested)
+	}
+	if n.Body["max_conns"] != int64(10) || !strings.Contains(fmt.Sprint(n.Namespaces["db"]), "postgres") {
+		t.Fatalf("body = %#v namespaces = %#v", n.Body, n.Namespaces)
+	}
+	want := "conf/limits.bcl https://cfg.example.com/db/main.bcl https://cfg.example.com/db/driver.bcl"
+	if strings.Join(requested, " ") != want {
+		t.Fatalf("requested %v", requested)
+	}
+}
+
+func TestNestedIncludesResolveAgainstIncludingFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"conf/app.bcl":                {Data: []byte("import \"./shared/net.bcl\"\nname \"api\"\n")},
+		"conf/shared/net.bcl":         {Data: []byte("import \"./ports.bcl\"\nmodule \"cache\" {\n  source \"./cache\"\n}\n")},
+		"conf/shared/ports.bcl":       {Data: []byte("port 8080\n")},
+		"conf/shared/cache/redis.bcl": {Data: []byte("engine \"redis\"\n")},
+		"conf/cache/wrong.bcl":        {Data: []byte("engine \"wrong\"\n")},
+	}
+	n, err := CompileFS(fsys, "conf/app.bcl", &Options{ResolveModules: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["port"] != int64(8080) || !strings.Contains(fmt.Sprint(n.Namespaces["cache"]), "redis") {
+		t.Fatalf("body = %#v namespaces = %#v", n.Body, n.Namespaces)
+	}
+
+	files := map[string]string{
+		"app.bcl": "import \"https://cfg.example.com/db/v1/main.bcl?ref=main\"\n",
+		"https://cfg.example.com/db/v1/main.bcl?ref=main": "import \"./driver.bcl\"\nimport \"../common/tls.bcl\"\nimport \"/org.bcl\"\n",
+		"https://cfg.example.com/db/v1/driver.bcl":        "driver \"postgres\"\n",
+		"https://cfg.example.com/db/common/tls.bcl":       "tls true\n",
+		"https://cfg.example.com/org.bcl":                 "org \"acme\"\n",
+	}
+	var requested []string
+	resolver := IncludeResolverFunc(func(name string) ([]byte, error) {
+		requested = append(requested, name)
+		src, ok := files[name]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return []byte(src), nil
+	})
+	doc, err := ParseFile("app.bcl", []byte(files["app.bcl"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = Compile(doc, &Options{ResolveImports: true, IncludeResolver: resolver})
+	if err != nil {
+		t.Fatalf("%v (requested %v)", err, requested)
+	}
+	if n.Body["driver"] != "postgres" || n.Body["tls"] != true || n.Body["org"] != "acme" {
+		t.Fatalf("body = %#v (requested %v)", n.Body, requested)
+	}
+}
+
+func TestIncludeParallelismPrefetchesEachFileOnce(t *testing.T) {
+	files := map[string]string{"app.bcl": "", "common.bcl": "region \"eu\"\n"}
+	for i := range 12 {
+		name := fmt.Sprintf("part%d.bcl", i)
+		files["app.bcl"] += fmt.Sprintf("import \"./%s\"\n", name)
+		files[name] = fmt.Sprintf("import \"./common.bcl\"\npart%d %d\n", i, i)
+	}
+	var mu sync.Mutex
+	fetched := map[string]int{}
+	inFlight, maxInFlight := 0, 0
+	resolver := IncludeResolverFunc(func(name string) ([]byte, error) {
+		mu.Lock()
+		fetched[name]++
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return []byte(files[name]), nil
+	})
+	doc, err := ParseFile("app.bcl", []byte(files["app.bcl"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Compile(doc, &Options{ResolveImports: true, IncludeResolver: IncludeResolverFunc(func(name string) ([]byte, error) { return []byte(files[name]), nil })})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Compile(doc, &Options{ResolveImports: true, IncludeResolver: resolver, IncludeParallelism: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Body, want.Body) {
+		t.Fatalf("body = %#v, want %#v", got.Body, want.Body)
+	}
+	if fetched["common.bcl"] != 1 || fetched["part7.bcl"] != 1 {
+		t.Fatalf("fetched = %v", fetched)
+	}
+	if maxInFlight < 2 || maxInFlight > 4 {
+		t.Fatalf("max concurrent fetches = %d", maxInFlight)
+	}
+	_, err = Compile(doc, &Options{ResolveImports: true, IncludeResolver: resolver, IncludeParallelism: 4, MaxIncludeBytes: 64})
+	if err == nil || !strings.Contains(err.Error(), "exceed the total limit of 64") {
+		t.Fatalf("expected byte budget error, got %v", err)
+	}
+}
+
+func TestLoadProject(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		ProjectFile: `
+entrypoints ["app/main.bcl"]
+include_roots ["shared"]
+var_files ["app.env"]
+schema_files ["schemas/server.schema"]
+lint {
+  ignore ["missing bcl version declaration"]
+  warnings_as_errors true
+}
+`,
+		"app/main.bcl":          "import \"./common.bcl\"\nserver web {\n  port env(\"PORT\")\n}\n",
+		"shared/common.bcl":     "region \"eu\"\n",
+		"app.env":               "PORT=8080\n",
+		"schemas/server.schema": "schema server {\n  required port string\n  required host string\n}\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := LoadProject(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Entrypoints) != 1 || p.Entrypoints[0] != filepath.Join(dir, "app", "main.bcl") || p.IncludeRoots[0] != filepath.Join(dir, "shared") {
+		t.Fatalf("project = %+v", p)
+	}
+	opts := p.Options()
+	opts.AllowEnv = true
+	n, err := CompileFile(p.Entrypoints[0], opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["region"] != "eu" || len(n.Blocks) != 1 || n.Blocks[0]["body"].(map[string]any)["port"] != "8080" {
+		t.Fatalf("compiled = %#v %#v", n.Body, n.Blocks)
+	}
+	doc, err := p.Document(p.Entrypoints[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	diags := p.Lint.Apply(Lint(doc, p.Options()))
+	found := false
+	for _, d := range diags {
+		if strings.Contains(d.Message, "missing bcl version declaration") {
+			t.Fatalf("ignored diagnostic reported: %v", d)
+		}
+		found = found || strings.Contains(d.Message, "host") && d.Severity == "error"
+	}
+	if !found {
+		t.Fatalf("schema file not applied: %v", diags)
+	}
+	if _, err := LoadProject(t.TempDir()); err == nil {
+		t.Fatal("expected missing project error")
+	}
+}
+
+type fakeEnforceAdapter struct {
+	live    map[string]map[string]any
+	applied []string
+}
+
+func (f *fakeEnforceAdapter) Observe(_ context.Context, target string) (map[string]any, error) {
+	if live, ok := f.live[target]; ok {
+		return live, nil
+	}
+	return nil, fmt.Errorf("%s unreachable", target)
+}
+
+func (f *fakeEnforceAdapter) Apply(_ context.Context, target string, desired map[string]any) error {
+	f.applied = append(f.applied, target)
+	f.live[target] = desired
+	return nil
+}
+
+func TestEnforcerReappliesDriftedBlocks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "network.bcl")
+	src := "network \"campus\" {\n  device sw1 {\n    vlan 10\n  }\n  device sw2 {\n    vlan 20\n  }\n  device sw3 {\n    vlan 30\n  }\n}\n"
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	binder, err := NewBinder(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := &fakeEnforceAdapter{live: map[string]map[string]any{
+		"sw1": {"vlan": int64(10)},
+		"sw2": {"vlan": int64(99)},
+	}}
+	e := &Enforcer{Binder: binder, BlockType: "device", Adapter: adapter, AlertOnly: true}
+	results, err := e.Enforce(context.Background())
+	if err != nil || len(results) != 3 || len(results[0].Drift) != 0 || len(results[1].Drift) != 1 || results[1].Applied || results[2].Err == nil || len(adapter.applied) != 0 {
+		t.Fatalf("alert results = %#v %v", results, err)
+	}
+	e.AlertOnly = false
+	srv := httptest.NewServer(e.Handler())
+	defer srv.Close()
+	resp, err := http.Post(srv.URL+"/-/enforce", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report EnforceReport
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || strings.Join(adapter.applied, ",") != "sw2" || adapter.live["sw2"]["vlan"] != int64(20) {
+		t.Fatalf("status %d applied %v err %v", resp.StatusCode, adapter.applied, err)
+	}
+	if report.Status != EnforceFailed || report.Failed != 1 || report.ExitCode() != 1 || report.Targets[1].Status != EnforceApplied || !strings.Contains(report.Targets[2].Error, "unreachable") {
+		t.Fatalf("report = %#v", report)
+	}
+}
+
+func TestEnforcerAppliesRealSensitiveValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "network.bcl")
+	if err := os.WriteFile(path, []byte("device sw1 {\n  community sensitive(\"s3cret\")\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	binder, err := NewBinder(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := &fakeEnforceAdapter{live: map[string]map[string]any{"sw1": {"community": "old"}}}
+	e := &Enforcer{Binder: binder, BlockType: "device", Adapter: adapter}
+	if _, err := e.Enforce(context.Background()); err != nil || adapter.live["sw1"]["community"] != "s3cret" {
+		t.Fatalf("applied %#v, %v", adapter.live["sw1"], err)
+	}
+	if got := blocksOfType(binder.Current().Blocks, "device"); len(got) != 1 || blockBody(got[0])["community"] != "****" {
+		t.Fatalf("binder config = %#v", got)
+	}
+}
+
+func TestEnforceReportStatusAndBCL(t *testing.T) {
+	drift := ConfigChanges{{Op: "modified", Key: "vlan", Old: int64(99), New: int64(20)}}
+	report := NewEnforceReport([]EnforceResult{{Target: "sw1"}, {Target: "sw2", Drift: drift}})
+	if report.Status != EnforceDrifted || report.ExitCode() != 2 {
+		t.Fatalf("report = %#v", report)
+	}
+	if NewEnforceReport([]EnforceResult{{Target: "sw1"}}).ExitCode() != 0 {
+		t.Fatal("in-sync report should exit 0")
+	}
+	out, err := Marshal(report)
+	if err != nil || !strings.Contains(string(out), `target "sw2" {`) || !strings.Contains(string(out), `status "drifted"`) {
+		t.Fatalf("bcl = %s %v", out, err)
+	}
+	if _, err := CompileBytes(out, nil); err != nil {
+		t.Fatalf("report does not compile: %v\n%s", err, out)
+	}
+}
+
+func TestASTCacheSkipsParsingUnchangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	cache := &ASTCache{Dir: filepath.Join(dir, "cache")}
+	src := []byte("import \"./lib.bcl\"\nservice \"api\" {\n  port = base + 1\n  tags [\"a\", \"b\"]\n  when = env == \"prod\"\n}\n")
+	if err := os.WriteFile(filepath.Join(dir, "lib.bcl"), []byte("base = 8000\nenv = \"prod\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "main.bcl")
+	if err := os.WriteFile(main, src, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := CompileFile(main, &Options{ResolveImports: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		got, err := CompileFile(main, &Options{ResolveImports: true, ASTCache: cache})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Blocks, want.Blocks) || !reflect.DeepEqual(got.Body, want.Body) {
+			t.Fatalf("cached compile = %#v, want %#v", got, want)
+		}
+	}
+	entries, err := filepath.Glob(filepath.Join(cache.Dir, "[^.]*"))
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("cache entries = %v, %v", entries, err)
+	}
+	parsed, err := ParseFile(main, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := cache.Parse(main, src)
+	if err != nil || !reflect.DeepEqual(cached, parsed) {
+		t.Fatalf("cached document differs: %v", err)
+	}
+	cache.MaxBytes = 1
+	if _, err := cache.Parse(main, []byte("a 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := filepath.Glob(filepath.Join(cache.Dir, "[^.]*")); len(entries) != 3 {
+		t.Fatalf("a miss within the eviction interval evicted: %v", entries)
+	}
+	old := time.Now().Add(-2 * astCacheEvictInterval)
+	if err := os.Chtimes(filepath.Join(cache.Dir, ".evicted"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Parse(main, []byte("a 2\n")); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := filepath.Glob(filepath.Join(cache.Dir, "[^.]*")); len(entries) != 0 {
+		t.Fatalf("entries after eviction = %v", entries)
+	}
+
+	shape := func(v any) string {
+		var b strings.Builder
+		writeTypeShape(&b, reflect.TypeOf(v), map[reflect.Type]bool{})
+		return b.String()
+	}
+	if got := shape(&ForLoop{}); !strings.Contains(got, "In:ptr;bcl.Expr;Raw:string;") || !strings.Contains(got, "Body:slice;bcl.Node;") {
+		t.Fatalf("ForLoop shape = %s", got)
+	}
+}
+
+func TestResultCacheUnlockKeepsTakenOverLock(t *testing.T) {
+	c := NewResultCache(t.TempDir())
+	path := c.path("k") + ".lock"
+	unlock, err := c.lock("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another process took the lock over as stale.
+	if err := os.WriteFile(path, []byte("other"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("unlock removed a lock it no longer owns: %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if unlock, err = c.lock("k"); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("lock left behind: %v", err)
+	}
+}
+
+func TestResultCacheLockStaysWithSlowFill(t *testing.T) {
+	c := &ResultCache{Dir: t.TempDir(), LockTimeout: 5 * time.Second, StaleLockAge: 80 * time.Millisecond}
+	unlock, err := c.lock("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	released := make(chan time.Time, 1)
+	go func() {
+		time.Sleep(400 * time.Millisecond)
+		released <- time.Now()
+		unlock()
+	}()
+	second, err := c.lock("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := time.Now()
+	second()
+	if at := <-released; acquired.Before(at) {
+		t.Fatal("a refreshed lock was taken over while its holder was still filling")
+	}
+
+	// A lock nobody refreshes is abandoned and taken over.
+	path := c.path("k") + ".lock"
+	if err := os.WriteFile(path, []byte("crashed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Second)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if unlock, err = c.lock("k"); err != nil {
+		t.Fatalf("stale lock not taken over: %v", err)
+	}
+	unlock()
+}
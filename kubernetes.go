@@ -0,0 +1,181 @@
+package bcl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type KubernetesOptions struct {
+	Blocks    []string
+	Name      string
+	Namespace string
+	Labels    map[string]string
+	Secrets   []string
+}
+
+// ExportKubernetes renders the selected blocks of a compiled document as
+// ConfigMap and Secret manifests. Blocks are selected by "type" or "type.id";
+// with no selection the document body is exported under opts.Name. Paths
+// marked sensitive in the result, or listed in opts.Secrets, go to a Secret.
+// Sensitive values are only available when the document was compiled with
+// Options.RevealSensitive; exporting a redacted one is an error.
+func ExportKubernetes(result *CompileResult, opts KubernetesOptions) ([]byte, error) {
+	if result == nil || result.Normalized == nil {
+		return nil, fmt.Errorf("compile result is nil")
+	}
+	secret := make(map[string]bool, len(result.Sensitive)+len(opts.Secrets))
+	var redacted map[string]bool
+	if result.redacted {
+		redacted = make(map[string]bool, len(result.Sensitive))
+	}
+	for _, path := range result.Sensitive {
+		secret[path] = true
+		if redacted != nil {
+			redacted[path] = true
+		}
+	}
+	for _, path := range opts.Secrets {
+		secret[path] = true
+	}
+	var b bytes.Buffer
+	if len(opts.Blocks) == 0 {
+		name := opts.Name
+		if name == "" {
+			name = "bcl-config"
+		}
+		if err := writeKubernetesManifests(&b, name, "", result.Normalized.Body, secret, redacted, opts); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+	var found bool
+	for _, block := range result.Normalized.Blocks {
+		typ, _ := block["type"].(string)
+		id, _ := block["id"].(string)
+		if !kubernetesBlockSelected(opts.Blocks, typ, id) {
+			continue
+		}
+		found = true
+		body, _ := block["body"].(map[string]any)
+		name := typ
+		if id != "" {
+			name += "-" + id
+		}
+		if opts.Name != "" {
+			name = opts.Name + "-" + name
+		}
+		if err := writeKubernetesManifests(&b, name, joinPath(typ, id), body, secret, redacted, opts); err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no blocks match %s", strings.Join(opts.Blocks, ", "))
+	}
+	return b.Bytes(), nil
+}
+
+func kubernetesBlockSelected(selected []string, typ, id string) bool {
+	for _, sel := range selected {
+		if sel == typ || (id != "" && sel == typ+"."+id) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeKubernetesManifests(b *bytes.Buffer, name, prefix string, body map[string]any, secret, redacted map[string]bool, opts KubernetesOptions) error {
+	data := map[string]any{}
+	secrets := map[string]any{}
+	if err := flattenKubernetesData(body, "", prefix, secret, redacted, data, secrets); err != nil {
+		return err
+	}
+	name = kubernetesName(name)
+	if len(data) > 0 || len(secrets) == 0 {
+		writeKubernetesManifest(b, "ConfigMap", name, data, opts)
+	}
+	if len(secrets) > 0 {
+		writeKubernetesManifest(b, "Secret", name, secrets, opts)
+	}
+	return nil
+}
+
+func writeKubernetesManifest(b *bytes.Buffer, kind, name string, data map[string]any, opts KubernetesOptions) {
+	metadata := map[string]any{"name": name}
+	if opts.Namespace != "" {
+		metadata["namespace"] = opts.Namespace
+	}
+	if len(opts.Labels) > 0 {
+		labels := make(map[string]any, len(opts.Labels))
+		for k, v := range opts.Labels {
+			labels[k] = v
+		}
+		metadata["labels"] = labels
+	}
+	manifest := map[string]any{"apiVersion": "v1", "kind": kind, "metadata": metadata, "data": data}
+	if kind == "Secret" {
+		manifest["type"] = "Opaque"
+	}
+	if b.Len() > 0 {
+		b.WriteString("---\n")
+	}
+	writeYAML(b, manifest, 0)
+}
+
+func flattenKubernetesData(m map[string]any, key, path string, secret, redacted map[string]bool, data, secrets map[string]any) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := unwrapTypedScalar(m[k])
+		childKey, childPath := joinPath(key, k), joinPath(path, k)
+		if redacted[childPath] {
+			return fmt.Errorf("%s is sensitive and was redacted; compile with RevealSensitive to export it", childPath)
+		}
+		if child, ok := v.(map[string]any); ok && !secret[childPath] {
+			if err := flattenKubernetesData(child, childKey, childPath, secret, redacted, data, secrets); err != nil {
+				return err
+			}
+			continue
+		}
+		value := kubernetesString(v)
+		if secret[childPath] {
+			secrets[childKey] = base64.StdEncoding.EncodeToString([]byte(value))
+			continue
+		}
+		data[childKey] = value
+	}
+	return nil
+}
+
+func kubernetesString(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case map[string]any, []any:
+		b, _ := json.Marshal(x)
+		return string(b)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+func kubernetesName(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('-')
+		}
+	}
+	return strings.Trim(b.String(), "-.")
+}
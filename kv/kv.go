@@ -0,0 +1,376 @@
+// Package kv publishes compiled BCL documents to distributed key-value
+// stores such as Consul and etcd. Documents are flattened to slash
+// separated keys and written with compare-and-swap, so concurrent
+// publishers fail instead of overwriting each other.
+//
+//	n, _ := bcl.CompileBytes(src, &bcl.Options{RevealSensitive: true})
+//	changes, err := kv.Publish(ctx, &kv.Consul{}, n, kv.PublishOptions{Prefix: "app"})
+package kv
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oarkflow/bcl"
+)
+
+// Store is the minimal surface Publish needs from a distributed KV
+// backend. A zero version means the key must not exist yet.
+type Store interface {
+	List(ctx context.Context, prefix string) (map[string]Entry, error)
+	CompareAndSwap(ctx context.Context, key string, value []byte, version uint64) (bool, error)
+	CompareAndDelete(ctx context.Context, key string, version uint64) (bool, error)
+}
+
+type Entry struct {
+	Key     string `json:"key"`
+	Value   []byte `json:"value"`
+	Version uint64 `json:"version"`
+}
+
+// PublishOptions control Publish. Prune deletes keys under Prefix that
+// the document no longer has, so it requires a Prefix: pruning the whole
+// store would delete every key other publishers own.
+type PublishOptions struct {
+	Prefix string
+	Prune  bool
+	DryRun bool
+}
+
+type Change struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// Publish writes the flattened document under opts.Prefix. Only keys whose
+// value differs are written, and every write is guarded by the version read
+// from the store so concurrent publishers fail instead of clobbering. A
+// document compiled without bcl.Options.RevealSensitive is refused when it
+// holds sensitive values, since publishing would overwrite them with "****".
+func Publish(ctx context.Context, store Store, n *bcl.Normalized, opts PublishOptions) ([]Change, error) {
+	if store == nil {
+		return nil, fmt.Errorf("kv store is nil")
+	}
+	if n.Redacted() {
+		return nil, fmt.Errorf("kv: document has redacted sensitive values; compile with RevealSensitive to publish it")
+	}
+	prefix := strings.Trim(opts.Prefix, "/")
+	if opts.Prune && prefix == "" {
+		return nil, fmt.Errorf("kv prune requires a prefix")
+	}
+	desired := Flatten(n, prefix)
+	listPrefix := prefix
+	if listPrefix != "" {
+		listPrefix += "/"
+	}
+	current, err := store.List(ctx, listPrefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(desired))
+	for k := range desired {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var changes []Change
+	for _, key := range keys {
+		value := desired[key]
+		entry, exists := current[key]
+		if exists && string(entry.Value) == value {
+			continue
+		}
+		op := "create"
+		if exists {
+			op = "update"
+		}
+		changes = append(changes, Change{Op: op, Key: key, Value: value})
+		if opts.DryRun {
+			continue
+		}
+		ok, err := store.CompareAndSwap(ctx, key, []byte(value), entry.Version)
+		if err != nil {
+			return changes, err
+		}
+		if !ok {
+			return changes, fmt.Errorf("kv key %q changed concurrently", key)
+		}
+	}
+	if !opts.Prune {
+		return changes, nil
+	}
+	stale := make([]string, 0, len(current))
+	for k := range current {
+		if _, ok := desired[k]; !ok {
+			stale = append(stale, k)
+		}
+	}
+	sort.Strings(stale)
+	for _, key := range stale {
+		changes = append(changes, Change{Op: "delete", Key: key})
+		if opts.DryRun {
+			continue
+		}
+		ok, err := store.CompareAndDelete(ctx, key, current[key].Version)
+		if err != nil {
+			return changes, err
+		}
+		if !ok {
+			return changes, fmt.Errorf("kv key %q changed concurrently", key)
+		}
+	}
+	return changes, nil
+}
+
+// Flatten maps a compiled document to slash separated keys. Blocks are keyed
+// by type and id; scalars are rendered as text and lists/objects as JSON.
+func Flatten(n *bcl.Normalized, prefix string) map[string]string {
+	out := map[string]string{}
+	if n == nil {
+		return out
+	}
+	flatten(out, prefix, n.Body)
+	for _, block := range n.Blocks {
+		typ, _ := block["type"].(string)
+		id, _ := block["id"].(string)
+		body, _ := block["body"].(map[string]any)
+		flatten(out, join(join(prefix, typ), id), body)
+	}
+	return out
+}
+
+func flatten(out map[string]string, prefix string, m map[string]any) {
+	for k, v := range m {
+		v = unwrapTypedScalar(v)
+		key := join(prefix, k)
+		if child, ok := v.(map[string]any); ok {
+			flatten(out, key, child)
+			continue
+		}
+		out[key] = valueString(v)
+	}
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	if name == "" {
+		return prefix
+	}
+	return prefix + "/" + name
+}
+
+// Consul talks to the Consul KV HTTP API; versions are modify indexes. A
+// nil Client uses one with a 30 second timeout, as does Etcd.
+type Consul struct {
+	Address string
+	Token   string
+	Client  *http.Client
+}
+
+func (c *Consul) List(ctx context.Context, prefix string) (map[string]Entry, error) {
+	resp, err := c.do(ctx, http.MethodGet, prefix, url.Values{"recurse": {"true"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	out := map[string]Entry{}
+	if resp.StatusCode == http.StatusNotFound {
+		return out, nil
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("consul list %s returned %s", prefix, resp.Status)
+	}
+	var items []struct {
+		Key         string
+		Value       []byte
+		ModifyIndex uint64
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		out[item.Key] = Entry{Key: item.Key, Value: item.Value, Version: item.ModifyIndex}
+	}
+	return out, nil
+}
+
+func (c *Consul) CompareAndSwap(ctx context.Context, key string, value []byte, version uint64) (bool, error) {
+	return c.cas(ctx, http.MethodPut, key, value, version)
+}
+
+func (c *Consul) CompareAndDelete(ctx context.Context, key string, version uint64) (bool, error) {
+	return c.cas(ctx, http.MethodDelete, key, nil, version)
+}
+
+func (c *Consul) cas(ctx context.Context, method, key string, value []byte, version uint64) (bool, error) {
+	resp, err := c.do(ctx, method, key, url.Values{"cas": {strconv.FormatUint(version, 10)}}, value)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode >= 400 {
+		return false, fmt.Errorf("consul %s %s returned %s", strings.ToLower(method), key, resp.Status)
+	}
+	return strings.TrimSpace(string(body)) == "true", nil
+}
+
+func (c *Consul) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	addr := strings.TrimRight(cmp.Or(c.Address, "http://127.0.0.1:8500"), "/")
+	req, err := http.NewRequestWithContext(ctx, method, addr+"/v1/kv/"+escapePath(key)+"?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+	return httpClient(c.Client).Do(req)
+}
+
+// escapePath escapes each segment of a slash separated key, so a key
+// holding ?, # or % addresses itself rather than a query or another key.
+func escapePath(key string) string {
+	segs := strings.Split(key, "/")
+	for i, seg := range segs {
+		segs[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segs, "/")
+}
+
+// Etcd talks to the etcd v3 JSON gateway; versions are mod revisions.
+type Etcd struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+func (e *Etcd) List(ctx context.Context, prefix string) (map[string]Entry, error) {
+	var resp struct {
+		KVs []struct {
+			Key         []byte `json:"key"`
+			Value       []byte `json:"value"`
+			ModRevision string `json:"mod_revision"`
+		} `json:"kvs"`
+	}
+	start := []byte(prefix)
+	if err := e.post(ctx, "/v3/kv/range", map[string]any{"key": start, "range_end": etcdPrefixEnd(start)}, &resp); err != nil {
+		return nil, err
+	}
+	out := make(map[string]Entry, len(resp.KVs))
+	for _, kv := range resp.KVs {
+		rev, _ := strconv.ParseUint(kv.ModRevision, 10, 64)
+		out[string(kv.Key)] = Entry{Key: string(kv.Key), Value: kv.Value, Version: rev}
+	}
+	return out, nil
+}
+
+func (e *Etcd) CompareAndSwap(ctx context.Context, key string, value []byte, version uint64) (bool, error) {
+	return e.txn(ctx, key, version, map[string]any{"request_put": map[string]any{"key": []byte(key), "value": value}})
+}
+
+func (e *Etcd) CompareAndDelete(ctx context.Context, key string, version uint64) (bool, error) {
+	return e.txn(ctx, key, version, map[string]any{"request_delete_range": map[string]any{"key": []byte(key)}})
+}
+
+func (e *Etcd) txn(ctx context.Context, key string, version uint64, op map[string]any) (bool, error) {
+	var resp struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	req := map[string]any{
+		"compare": []any{map[string]any{"key": []byte(key), "target": "MOD", "result": "EQUAL", "mod_revision": strconv.FormatUint(version, 10)}},
+		"success": []any{op},
+	}
+	if err := e.post(ctx, "/v3/kv/txn", req, &resp); err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+func (e *Etcd) post(ctx context.Context, path string, payload, out any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	addr := strings.TrimRight(cmp.Or(e.Endpoint, "http://127.0.0.1:2379"), "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient(e.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("etcd %s returned %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func etcdPrefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+// defaultClient bounds each request, since http.DefaultClient would wait
+// forever on a backend that accepts the connection and never answers.
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+func httpClient(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return defaultClient
+}
+
+// unwrapTypedScalar returns the value of a typed scalar such as
+// {"$duration": "5s"}, and v itself otherwise.
+func unwrapTypedScalar(v any) any {
+	m, ok := v.(map[string]any)
+	if !ok || len(m) != 1 {
+		return v
+	}
+	for k, x := range m {
+		if strings.HasPrefix(k, "$") {
+			return x
+		}
+	}
+	return v
+}
+
+// valueString renders a value as stored: strings as they are, lists and
+// objects as JSON.
+func valueString(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case map[string]any, []any:
+		b, _ := json.Marshal(x)
+		return string(b)
+	default:
+		return fmt.Sprint(x)
+	}
+}
@@ -0,0 +1,133 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/oarkflow/bcl"
+)
+
+type memoryStore struct {
+	entries map[string]Entry
+	writes  int
+}
+
+func (m *memoryStore) List(_ context.Context, prefix string) (map[string]Entry, error) {
+	out := map[string]Entry{}
+	for k, v := range m.entries {
+		if strings.HasPrefix(k, prefix) {
+			out[k] = v
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryStore) CompareAndSwap(_ context.Context, key string, value []byte, version uint64) (bool, error) {
+	if m.entries[key].Version != version {
+		return false, nil
+	}
+	m.writes++
+	m.entries[key] = Entry{Key: key, Value: value, Version: version + 1}
+	return true, nil
+}
+
+func (m *memoryStore) CompareAndDelete(_ context.Context, key string, version uint64) (bool, error) {
+	if m.entries[key].Version != version {
+		return false, nil
+	}
+	m.writes++
+	delete(m.entries, key)
+	return true, nil
+}
+
+func TestPublishDiffOnlyWithCAS(t *testing.T) {
+	n, err := bcl.CompileBytes([]byte(`
+name "api"
+server "main" {
+  port 8080
+  hosts ["a", "b"]
+}
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryStore{entries: map[string]Entry{
+		"cfg/name":             {Key: "cfg/name", Value: []byte("api"), Version: 3},
+		"cfg/server/main/port": {Key: "cfg/server/main/port", Value: []byte("80"), Version: 4},
+		"cfg/stale":            {Key: "cfg/stale", Value: []byte("x"), Version: 1},
+		"cfgx/other":           {Key: "cfgx/other", Value: []byte("y"), Version: 1},
+	}}
+	changes, err := Publish(context.Background(), store, n, PublishOptions{Prefix: "/cfg/", Prune: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 || store.writes != 3 {
+		t.Fatalf("kv changes = %#v writes=%d", changes, store.writes)
+	}
+	if got := string(store.entries["cfg/server/main/port"].Value); got != "8080" {
+		t.Fatalf("port = %q", got)
+	}
+	if got := string(store.entries["cfg/server/main/hosts"].Value); got != `["a","b"]` {
+		t.Fatalf("hosts = %q", got)
+	}
+	if _, ok := store.entries["cfg/stale"]; ok {
+		t.Fatal("stale key was not pruned")
+	}
+	if _, ok := store.entries["cfgx/other"]; !ok {
+		t.Fatal("key outside prefix was pruned")
+	}
+	changes, err = Publish(context.Background(), store, n, PublishOptions{Prefix: "cfg", Prune: true})
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("second publish changes = %#v err=%v", changes, err)
+	}
+}
+
+func TestPublishGuardsPruneAndEscapesConsulKeys(t *testing.T) {
+	n, err := bcl.CompileBytes([]byte("name \"api\"\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryStore{entries: map[string]Entry{"other/team": {Key: "other/team", Value: []byte("x"), Version: 1}}}
+	if _, err := Publish(context.Background(), store, n, PublishOptions{Prefix: "/", Prune: true}); err == nil || !strings.Contains(err.Error(), "requires a prefix") {
+		t.Fatalf("err = %v", err)
+	}
+	if store.writes != 0 {
+		t.Fatalf("writes = %d", store.writes)
+	}
+
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		fmt.Fprint(w, "true")
+	}))
+	defer srv.Close()
+	consul := &Consul{Address: srv.URL}
+	if ok, err := consul.CompareAndSwap(context.Background(), "cfg/a?b#c/100%", []byte("v"), 0); err != nil || !ok {
+		t.Fatalf("cas = %v, %v", ok, err)
+	}
+	if want := "/v1/kv/cfg/a%3Fb%23c/100%25"; len(paths) != 1 || paths[0] != want {
+		t.Fatalf("paths = %v, want %s", paths, want)
+	}
+	if httpClient(nil).Timeout == 0 {
+		t.Fatal("default kv client has no timeout")
+	}
+
+	src := []byte("token sensitive(\"s3cret\")\n")
+	n, err = bcl.CompileBytes(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Publish(context.Background(), store, n, PublishOptions{Prefix: "cfg"}); err == nil || !strings.Contains(err.Error(), "RevealSensitive") {
+		t.Fatalf("redacted publish err = %v", err)
+	}
+	if n, err = bcl.CompileBytes(src, &bcl.Options{RevealSensitive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Publish(context.Background(), store, n, PublishOptions{Prefix: "cfg"}); err != nil || string(store.entries["cfg/token"].Value) != "s3cret" {
+		t.Fatalf("revealed publish = %q, %v", store.entries["cfg/token"].Value, err)
+	}
+}
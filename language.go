@@ -3,6 +3,7 @@ package bcl
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -99,6 +100,74 @@ type Completion struct {
 	InsertText    string `json:"insert_text,omitempty"`
 }
 
+// SchemaCompletion describes one field of a schema for editor completion.
+// Path is dotted for nested fields; Enum lists the allowed values.
+type SchemaCompletion struct {
+	Path          string `json:"path"`
+	Type          string `json:"type"`
+	Required      bool   `json:"required,omitempty"`
+	Enum          []any  `json:"enum,omitempty"`
+	Default       any    `json:"default,omitempty"`
+	Documentation string `json:"documentation,omitempty"`
+	Deprecated    string `json:"deprecated,omitempty"`
+}
+
+// CompletionItems lists the schema's fields, nested ones included, in
+// declaration order. The data comes from the same declaration validation
+// uses, so editors offering it never drift from the rules.
+func (s *SchemaDecl) CompletionItems() []SchemaCompletion {
+	if s == nil {
+		return nil
+	}
+	var out []SchemaCompletion
+	appendSchemaCompletions(&out, "", s.Fields)
+	return out
+}
+
+func appendSchemaCompletions(out *[]SchemaCompletion, prefix string, fields []SchemaField) {
+	for _, f := range fields {
+		item := SchemaCompletion{
+			Path:          joinPath(prefix, f.Name),
+			Type:          f.Type,
+			Required:      f.Required,
+			Documentation: firstNonEmpty(f.Description, f.Title),
+			Deprecated:    f.Deprecated,
+		}
+		for _, v := range f.Enum {
+			item.Enum = append(item.Enum, v.ToInterface(true))
+		}
+		if f.Default != nil {
+			item.Default = f.Default.ToInterface(true)
+		}
+		*out = append(*out, item)
+		appendSchemaCompletions(out, item.Path, f.Fields)
+	}
+}
+
+func schemaFieldCompletions(items []SchemaCompletion, field string) []Completion {
+	var out []Completion
+	for _, item := range items {
+		if item.Path == field {
+			for _, v := range item.Enum {
+				label := fmt.Sprint(v)
+				if str, ok := v.(string); ok {
+					label = strconv.Quote(str)
+				}
+				out = append(out, Completion{Label: label, Kind: "value", Detail: item.Type, Documentation: item.Documentation})
+			}
+		}
+		if strings.Contains(item.Path, ".") {
+			continue
+		}
+		detail := item.Type
+		if item.Required {
+			detail += " (required)"
+		}
+		out = append(out, Completion{Label: item.Path, Kind: "field", Detail: detail, Documentation: item.Documentation})
+	}
+	return out
+}
+
 type hintInfo struct {
 	Name        string
 	Kind        SymbolKind
@@ -121,6 +190,8 @@ type Analysis struct {
 	Index        WorkspaceIndex            `json:"index"`
 	Completions  []Completion              `json:"completions"`
 	Diagnostics  []Diagnostic              `json:"diagnostics,omitempty"`
+
+	schemaItems map[string][]SchemaCompletion
 }
 
 func TokenizeFile(name string, src []byte) ([]SyntaxToken, []Diagnostic) {
@@ -217,6 +288,9 @@ func CompletionsAt(a *Analysis, src []byte, line, column int) ([]Completion, Com
 	ctx.ExpectedValues = expectedValuesForContext(ctx)
 
 	out := append([]Completion(nil), a.Completions...)
+	if items, ok := a.schemaItems[ctx.EnclosingBlock]; ok {
+		out = append(out, schemaFieldCompletions(items, ctx.AssignmentName)...)
+	}
 	for _, v := range ctx.ExpectedValues {
 		out = append(out, Completion{Label: v, Kind: "value", Detail: "BCL value"})
 	}
@@ -431,13 +505,7 @@ func isBuiltinFunction(s string) bool {
 }
 
 func isSchemaPrimitiveOrFormat(s string) bool {
-	switch s {
-	case "any", "string", "number", "int", "float", "bool", "boolean", "object", "map", "block", "list", "array", "tuple",
-		"email", "url", "uri", "date", "date-time", "datetime", "time", "duration", "bytes", "regex", "cidr", "ip", "ipv4", "ipv6", "uuid":
-		return true
-	default:
-		return false
-	}
+	return schemaPrimitiveSet[s]
 }
 
 func analyzeNodes(nodes []Node, container string, a *Analysis) []LanguageSymbol {
@@ -482,6 +550,10 @@ func analyzeNodes(nodes []Node, container string, a *Analysis) []LanguageSymbol
 			}
 			out = append(out, s)
 			a.Schemas[x.Name] = s
+			if a.schemaItems == nil {
+				a.schemaItems = map[string][]SchemaCompletion{}
+			}
+			a.schemaItems[x.Name] = x.CompletionItems()
 			a.Declarations[x.Name] = s
 		case *Block:
 			name := x.Type
@@ -854,13 +926,15 @@ func expectedValuesForContext(ctx CompletionContext) []string {
 		return []string{"dev", "test", "staging", "production"}
 	case "diagnostics":
 		return []string{"none"}
+	case "shell":
+		return CommandShells
 	case "type", "kind":
 		if ctx.EnclosingBlock == "step" {
 			return []string{"task", "decision", "action", "terminal"}
 		}
 		return []string{"http", "file", "command", "json", "form", "text", "raw"}
 	case "format":
-		return []string{"email", "uri", "url", "uuid", "date", "date-time", "datetime", "time", "ipv4", "ipv6", "ip", "hostname"}
+		return []string{"email", "uri", "url", "uuid", "date", "date-time", "datetime", "time", "ipv4", "ipv6", "ip", "cidr", "mac", "hostname", "port", "duration"}
 	case "content_encoding":
 		return []string{"base64", "gzip", "br", "identity"}
 	case "content_media_type":
@@ -999,6 +1073,51 @@ var keywordHints = []hintInfo{
 	{Name: "none", Signature: `none`, Description: "Represents no diagnostics or no matches, depending on context."},
 }
 
+// languageKeywords drives keyword highlighting in the language server and
+// the exported editor grammars.
+var languageKeywords = []string{
+	"import", "as", "const", "type", "schema", "options", "fields", "validate_required_fields",
+	"validate_field_types", "required", "optional", "ref", "enum", "default", "description", "doc",
+	"title", "deprecated", "sensitive", "generated", "derived", "read_only", "write_only", "nullable",
+	"unique_items", "closed", "additional_properties", "min", "max", "exclusive_min", "exclusive_max",
+	"multiple_of", "min_len", "max_len", "min_items", "max_items", "min_props", "max_props",
+	"pattern", "format", "schemes", "content_encoding", "content_media_type", "examples", "items",
+	"prefix_items", "contains", "pattern_properties", "key_pattern", "subset_of", "dependent_required", "lt_field", "lte_field",
+	"gt_field", "gte_field", "eq_field", "all_of", "any_of", "one_of", "not", "if", "then", "else",
+	"classification", "audit", "explain", "pii", "policy_tag", "owner", "severity", "true", "false",
+	"null", "when", "override", "decision_schema", "decision_table", "rule_set", "ranking", "dataset",
+	"reason_code_catalog", "decision_bundle", "decision_release", "gate", "test_matrix",
+	"rule_template", "row", "record", "case", "outcome", "obligation", "advice", "event", "approval",
+	"governance", "chain", "watch", "step", "routes", "route", "lifecycle", "phase", "entity",
+	"entity_key", "method", "threshold", "window", "ttl", "sink", "sinks", "distinct", "decay",
+	"cooldown", "reset", "headers", "body", "body_code", "body_message", "field", "metric", "metrics",
+	"policy_package", "action_catalog", "output_contract", "standard_facts", "response_classifier",
+	"response", "lifecycle_test", "policy_overlay", "capabilities", "actions", "state", "external",
+	"contract", "severities", "retries", "healthy_below", "unhealthy_at_or_above",
+	"expected_client_statuses", "metadata", "attributes", "reason", "reason_code", "priority",
+	"hit_policy", "final_action", "blocking", "status", "retry_after_seconds", "grace_attempts",
+	"channel", "layer", "environment", "profile", "result", "id",
+}
+
+var schemaPrimitiveNames = []string{
+	"any", "string", "number", "int", "float", "bool", "boolean", "object", "map", "block", "list",
+	"array", "tuple", "email", "url", "uri", "date", "date-time", "datetime", "time", "duration",
+	"bytes", "regex", "cidr", "ip", "ipv4", "ipv6", "uuid",
+}
+
+var (
+	keywordSet         = nameSet(languageKeywords)
+	schemaPrimitiveSet = nameSet(schemaPrimitiveNames)
+)
+
+func nameSet(names []string) map[string]bool {
+	out := make(map[string]bool, len(names))
+	for _, name := range names {
+		out[name] = true
+	}
+	return out
+}
+
 var decisionBlockNames = []string{
 	"decision_schema", "decision_table", "rule_set", "ranking", "dataset", "reason_code_catalog",
 	"decision_bundle", "decision_release", "gate", "test_matrix", "rule_template", "row", "record",
@@ -1041,6 +1160,7 @@ var schemaFieldHints = []hintInfo{
 	{Name: "max_len", Signature: `max_len count`, Description: "Maximum string length."},
 	{Name: "pattern", Signature: `pattern "regex"`, Description: "Regular expression the string value must match."},
 	{Name: "format", Signature: `format email|uuid|date-time|...`, Description: "Semantic string format validation."},
+	{Name: "schemes", Signature: `schemes ["https", "http"]`, Description: "Allowed URL schemes for a url field."},
 	{Name: "content_encoding", Signature: `content_encoding base64`, Description: "Declares the content encoding for encoded strings."},
 	{Name: "content_media_type", Signature: `content_media_type application/json`, Description: "Declares the media type for encoded string content."},
 	{Name: "items", Signature: `items type`, Description: "Element type for a list field."},
@@ -1050,6 +1170,8 @@ var schemaFieldHints = []hintInfo{
 	{Name: "unique_items", Signature: `unique_items`, Description: "Requires list items to be unique."},
 	{Name: "contains", Signature: `contains type`, Description: "Requires at least one list item matching a type."},
 	{Name: "pattern_properties", Signature: `pattern_properties { "^x_" string }`, Description: "Validates object properties selected by regular-expression property names."},
+	{Name: "key_pattern", Signature: `key_pattern "^[a-z_]+$"`, Description: "Requires every map key to match a regular expression."},
+	{Name: "subset_of", Signature: `subset_of ["read", "write"]`, Description: "Requires every list item to be one of the given values."},
 	{Name: "closed", Signature: `closed true`, Description: "Rejects properties not declared by the schema."},
 	{Name: "additional_properties", Signature: `additional_properties false`, Description: "Controls whether undeclared object properties are allowed."},
 	{Name: "min_props", Signature: `min_props count`, Description: "Minimum object property count."},
@@ -1097,7 +1219,7 @@ var builtinFunctionHints = []hintInfo{
 	{Name: "session.required", Signature: `session.required(path)`, Description: "Reads a required session value and fails when absent.", InsertText: "session.required($1)", Examples: []string{`session.required("subject.id")`}},
 	{Name: "session.bool", Signature: `session.bool(path, default?)`, Description: "Reads a session value as a boolean.", InsertText: "session.bool($1)", Examples: []string{`session.bool("attrs.mfa", false)`}},
 	{Name: "session.duration", Signature: `session.duration(path, default?)`, Description: "Reads a session value as a duration.", InsertText: "session.duration($1)", Examples: []string{`session.duration("expires_in", 30m)`}},
-	{Name: "ref", Signature: `ref(target)`, Description: "Creates an explicit reference to another BCL declaration or block.", InsertText: "ref($1)"},
+	{Name: "ref", Signature: `ref(target)`, Description: "Creates an explicit reference to another BCL declaration or block. With a string path such as ref(\"server.web.port\") it reads that value, including ones declared later; reference cycles are reported as errors.", InsertText: "ref($1)", Examples: []string{`ref("server.web.port")`}},
 	{Name: "set", Signature: `set(name)`, Description: "Loads values from a named `set` block.", InsertText: "set($1)", Examples: []string{`set("admin-roles")`}},
 	{Name: "sensitive", Signature: `sensitive(value)`, Description: "Marks a value for redaction in exported output and hover summaries.", InsertText: "sensitive($1)"},
 	{Name: "concat", Signature: `concat(values...)`, Description: "Concatenates values into a string.", InsertText: "concat($1)"},
@@ -1105,11 +1227,18 @@ var builtinFunctionHints = []hintInfo{
 	{Name: "lower", Signature: `lower(value)`, Description: "Converts a string to lowercase.", InsertText: "lower($1)"},
 	{Name: "trim", Signature: `trim(value)`, Description: "Removes leading and trailing whitespace from a string.", InsertText: "trim($1)"},
 	{Name: "title", Signature: `title(value)`, Description: "Converts a string to title case.", InsertText: "title($1)"},
+	{Name: "camel_case", Signature: `camel_case(value)`, Description: "Converts a label to camelCase. Also available as `camelCase`.", InsertText: "camel_case($1)", Examples: []string{`camel_case("user id")`}},
+	{Name: "pascal_case", Signature: `pascal_case(value)`, Description: "Converts a label to PascalCase.", InsertText: "pascal_case($1)"},
+	{Name: "snake_case", Signature: `snake_case(value)`, Description: "Converts a label to snake_case, e.g. for SQL identifiers.", InsertText: "snake_case($1)", Examples: []string{`snake_case("HTTPServer Name")`}},
+	{Name: "kebab_case", Signature: `kebab_case(value)`, Description: "Converts a label to kebab-case. Also available as `kebabCase`.", InsertText: "kebab_case($1)"},
+	{Name: "slugify", Signature: `slugify(value)`, Description: "Lowercases a label and joins its words with dashes for use in resource names.", InsertText: "slugify($1)", Examples: []string{`slugify("Orders API (EU)")`}},
+	{Name: "truncate", Signature: `truncate(value, width, suffix?)`, Description: "Shortens a string to at most width characters, ending with suffix when cut.", InsertText: "truncate($1)", Examples: []string{`truncate(name, 63)`}},
 	{Name: "starts_with", Signature: `starts_with(value, prefix)`, Description: "Checks whether a string starts with a prefix.", InsertText: "starts_with($1)"},
 	{Name: "ends_with", Signature: `ends_with(value, suffix)`, Description: "Checks whether a string ends with a suffix.", InsertText: "ends_with($1)"},
 	{Name: "trim_prefix", Signature: `trim_prefix(value, prefix)`, Description: "Removes a prefix from a string when present.", InsertText: "trim_prefix($1)"},
 	{Name: "trim_suffix", Signature: `trim_suffix(value, suffix)`, Description: "Removes a suffix from a string when present.", InsertText: "trim_suffix($1)"},
-	{Name: "repeat", Signature: `repeat(value, count)`, Description: "Repeats a string a fixed number of times.", InsertText: "repeat($1)"},
+	{Name: "repeat", Signature: `repeat(value, count)`, Description: "Repeats a string a fixed number of times. `\"-\" * 40` is the same as `repeat(\"-\", 40)`.", InsertText: "repeat($1)"},
+	{Name: "format", Signature: `format(format, args...)`, Description: "Formats values printf-style, as in `format(\"%s:%d\", host, port)`. `\"%s:%d\" % [host, port]` is the same.", InsertText: "format($1)"},
 	{Name: "pad_left", Signature: `pad_left(value, width, pad?)`, Description: "Pads a string on the left to a target width.", InsertText: "pad_left($1)"},
 	{Name: "pad_right", Signature: `pad_right(value, width, pad?)`, Description: "Pads a string on the right to a target width.", InsertText: "pad_right($1)"},
 	{Name: "substr", Signature: `substr(value, start, length?)`, Description: "Returns a substring using zero-based indexes. Negative indexes count from the end.", InsertText: "substr($1)"},
@@ -1142,6 +1271,8 @@ var builtinFunctionHints = []hintInfo{
 	{Name: "difference", Signature: `difference(list, lists...)`, Description: "Returns values from the first list that are absent from the following lists.", InsertText: "difference($1)"},
 	{Name: "without", Signature: `without(list, values...)`, Description: "Returns a list without selected values.", InsertText: "without($1)"},
 	{Name: "range", Signature: `range(end) | range(start, end, step?)`, Description: "Returns an integer sequence ending before `end`.", InsertText: "range($1)"},
+	{Name: "seq", Signature: `seq(start, end, step?)`, Description: "Returns an integer sequence from start to end inclusive, counting down when end is smaller.", InsertText: "seq($1)", Examples: []string{`seq(100, 110, 2)`}},
+	{Name: "repeat_list", Signature: `repeat_list(value, count)`, Description: "Returns a list holding count copies of value.", InsertText: "repeat_list($1)", Examples: []string{`repeat_list({ size "10Gi" }, 3)`}},
 	{Name: "keys", Signature: `keys(object)`, Description: "Returns sorted object keys.", InsertText: "keys($1)"},
 	{Name: "values", Signature: `values(object)`, Description: "Returns object values sorted by key.", InsertText: "values($1)"},
 	{Name: "entries", Signature: `entries(object)`, Description: "Returns sorted `{key, value}` entries for an object.", InsertText: "entries($1)"},
@@ -1151,17 +1282,20 @@ var builtinFunctionHints = []hintInfo{
 	{Name: "merge", Signature: `merge(objects...)`, Description: "Merges objects from left to right.", InsertText: "merge($1)"},
 	{Name: "pick", Signature: `pick(object, keys...)`, Description: "Returns an object containing only selected keys.", InsertText: "pick($1)"},
 	{Name: "omit", Signature: `omit(object, keys...)`, Description: "Returns an object without selected keys.", InsertText: "omit($1)"},
-	{Name: "str", Signature: `str(value)`, Description: "Converts a value to a string.", InsertText: "str($1)"},
+	{Name: "str", Signature: `str(value)`, Description: "Converts a scalar to a string. Fails for null, lists and objects; use `json()` for structured values.", InsertText: "str($1)"},
 	{Name: "string", Signature: `string(value)`, Description: "Alias for `str(value)`.", InsertText: "string($1)"},
 	{Name: "to_string", Signature: `to_string(value)`, Description: "Alias for `str(value)`.", InsertText: "to_string($1)"},
-	{Name: "int", Signature: `int(value)`, Description: "Converts a value to an integer.", InsertText: "int($1)"},
+	{Name: "tostring", Signature: `tostring(value)`, Description: "Alias for `str(value)`.", InsertText: "tostring($1)"},
+	{Name: "tonumber", Signature: `tonumber(value)`, Description: "Converts a number or numeric string to an integer when whole, otherwise a float. Fails for non-numeric input.", InsertText: "tonumber($1)", Examples: []string{`tonumber(env("WORKERS"))`}},
+	{Name: "int", Signature: `int(value)`, Description: "Converts a number or numeric string (decimal, 0x, 0o, 0b) to an integer. Fails for fractions and non-numeric input.", InsertText: "int($1)"},
 	{Name: "to_int", Signature: `to_int(value)`, Description: "Alias for `int(value)`.", InsertText: "to_int($1)"},
-	{Name: "float", Signature: `float(value)`, Description: "Converts a value to a floating-point number.", InsertText: "float($1)"},
+	{Name: "float", Signature: `float(value)`, Description: "Converts a number or numeric string to a floating-point number. Fails for non-numeric input.", InsertText: "float($1)"},
 	{Name: "to_float", Signature: `to_float(value)`, Description: "Alias for `float(value)`.", InsertText: "to_float($1)"},
-	{Name: "bool", Signature: `bool(value)`, Description: "Converts a value to a boolean.", InsertText: "bool($1)"},
+	{Name: "bool", Signature: `bool(value)`, Description: "Converts true/false, yes/no, on/off, 1/0 to a boolean. Fails for any other value.", InsertText: "bool($1)"},
 	{Name: "to_bool", Signature: `to_bool(value)`, Description: "Alias for `bool(value)`.", InsertText: "to_bool($1)"},
 	{Name: "abs", Signature: `abs(value)`, Description: "Returns the absolute value of a number.", InsertText: "abs($1)"},
-	{Name: "floor", Signature: `floor(value)`, Description: "Rounds a number down.", InsertText: "floor($1)"},
+	{Name: "floor", Signature: `floor(value)`, Description: "Rounds a number down. In expressions `a // b` is `floor(a / b)` as an integer.", InsertText: "floor($1)"},
+	{Name: "safediv", Signature: `safediv(a, b, fallback)`, Description: "Divides a by b, or returns fallback when b is zero.", InsertText: "safediv($1)"},
 	{Name: "ceil", Signature: `ceil(value)`, Description: "Rounds a number up.", InsertText: "ceil($1)"},
 	{Name: "round", Signature: `round(value)`, Description: "Rounds a number to the nearest integer.", InsertText: "round($1)"},
 	{Name: "sqrt", Signature: `sqrt(value)`, Description: "Returns the square root of a number.", InsertText: "sqrt($1)"},
@@ -1184,15 +1318,46 @@ var builtinFunctionHints = []hintInfo{
 	{Name: "product", Signature: `product(values...)`, Description: "Returns the product of numeric values. Accepts varargs or one list.", InsertText: "product($1)"},
 	{Name: "median", Signature: `median(values...)`, Description: "Returns the median of numeric values. Accepts varargs or one list.", InsertText: "median($1)"},
 	{Name: "clamp", Signature: `clamp(value, min, max)`, Description: "Constrains a number to a minimum and maximum.", InsertText: "clamp($1)"},
+	{Name: "default", Signature: `default(value, fallback)`, Description: "Returns the fallback when the value is null or an empty string.", InsertText: "default($1)"},
 	{Name: "exists", Signature: `exists(value)`, Description: "Checks whether a runtime value is present.", InsertText: "exists($1)"},
 	{Name: "empty", Signature: `empty(value)`, Description: "Checks whether a value is null, empty, or blank.", InsertText: "empty($1)"},
 	{Name: "not_empty", Signature: `not_empty(value)`, Description: "Checks whether a value is present and not empty.", InsertText: "not_empty($1)"},
 	{Name: "hash", Signature: `hash(value)`, Description: "Computes a hash for a value when hash support is enabled.", InsertText: "hash($1)"},
 	{Name: "base64", Signature: `base64(value)`, Description: "Encodes a value as Base64 when encoding support is enabled.", InsertText: "base64($1)"},
 	{Name: "cidr", Signature: `cidr(value)`, Description: "Treats a string as a CIDR/network value.", InsertText: "cidr($1)"},
+	{Name: "mask_to_prefix", Signature: `mask_to_prefix(mask)`, Description: "Returns the prefix length of a netmask such as 255.255.255.0. Fails for non-contiguous masks.", InsertText: "mask_to_prefix($1)"},
+	{Name: "parse_mac", Signature: `parse_mac(value)`, Description: "Normalizes a MAC address in colon, dash or dotted form to lowercase colon form. Fails for invalid addresses.", InsertText: "parse_mac($1)"},
+	{Name: "rollout", Signature: `rollout(key, percent, salt?)`, Description: "Reports whether key falls in the first percent (0-100) of a stable hash bucketing, so a subject stays in as the percentage grows. Use a salt per rollout to vary the buckets; feature flags use their name.", InsertText: "rollout($1)"},
+	{Name: "hex", Signature: `hex(value, width?)`, Description: "Formats an integer as lowercase hex, zero-padded to width/4 digits when a bit width (8, 16, 32, 64) is given.", InsertText: "hex($1)"},
+	{Name: "bit_and", Signature: `bit_and(a, b, width?)`, Description: "Bitwise AND of two integers of the given bit width (8, 16, 32 or 64, default 64). Operands must fit the width; large values can be hex strings.", InsertText: "bit_and($1)"},
+	{Name: "bit_or", Signature: `bit_or(a, b, width?)`, Description: "Bitwise OR of two integers of the given bit width.", InsertText: "bit_or($1)"},
+	{Name: "bit_xor", Signature: `bit_xor(a, b, width?)`, Description: "Bitwise XOR of two integers of the given bit width.", InsertText: "bit_xor($1)"},
+	{Name: "bit_not", Signature: `bit_not(a, width?)`, Description: "Flips every bit of an integer within the given bit width.", InsertText: "bit_not($1)"},
+	{Name: "bit_shl", Signature: `bit_shl(a, n, width?)`, Description: "Shifts an integer left by n bits, dropping bits beyond the width.", InsertText: "bit_shl($1)"},
+	{Name: "bit_shr", Signature: `bit_shr(a, n, width?)`, Description: "Shifts an integer right by n bits, filling with zeros.", InsertText: "bit_shr($1)"},
 	{Name: "email", Signature: `email(value)`, Description: "Treats a string as an email value.", InsertText: "email($1)"},
 	{Name: "url", Signature: `url(value)`, Description: "Treats a string as a URL value.", InsertText: "url($1)"},
 	{Name: "regex", Signature: `regex(pattern)`, Description: "Compiles a regular expression pattern for matching.", InsertText: "regex($1)"},
+	{Name: "coalesce", Signature: `coalesce(values...)`, Description: "Returns the first argument that is neither null nor empty; later arguments are not evaluated.", InsertText: "coalesce($1)", Examples: []string{`coalesce(env.REGION, "us-east-1")`}},
+	{Name: "cond", Signature: `cond(condition, then, else)`, Description: "Returns then or else depending on condition. Only the selected argument is evaluated.", InsertText: "cond($1)", Examples: []string{`cond(replicas > 1, "ha", "single")`}},
+	{Name: "try", Signature: `try(expr, fallback)`, Description: "Returns fallback when expr fails to evaluate or is null.", InsertText: "try($1)", Examples: []string{`try(int(env.PORT), 8080)`}},
+	{Name: "assert", Signature: `assert(condition, message?)`, Description: "Aborts evaluation with a positioned error when the condition is false. Assign to `_` to discard the result.", InsertText: "assert($1)", Examples: []string{`_ = assert(port > 1024, "unprivileged port required")`}},
+	{Name: "fail", Signature: `fail(message?)`, Description: "Aborts evaluation with a positioned error, typically from an untaken ternary branch.", InsertText: "fail($1)", Examples: []string{`tier = env == "prod" ? "gold" : fail("unknown env")`}},
+	{Name: "semver_parse", Signature: `semver_parse(version)`, Description: "Parses a semantic version into major, minor, patch, prerelease and build fields.", InsertText: "semver_parse($1)"},
+	{Name: "semver_compare", Signature: `semver_compare(a, b)`, Description: "Compares two semantic versions by precedence, returning -1, 0 or 1.", InsertText: "semver_compare($1)"},
+	{Name: "semver_satisfies", Signature: `semver_satisfies(version, constraint)`, Description: "Checks a version against a constraint such as `>=1.2 <2`, `^1.4` or `~2.1 || 3.x`.", InsertText: "semver_satisfies($1)", Examples: []string{`semver_satisfies(version, ">=1.2 <2")`}},
+	{Name: "random_int", Signature: `random_int(min, max)`, Description: "Generates an integer between min and max inclusive. Reproducible when a seed is configured.", InsertText: "random_int($1)"},
+	{Name: "random_string", Signature: `random_string(length, charset?)`, Description: "Generates a random string from charset, alphanumeric by default. Reproducible when a seed is configured.", InsertText: "random_string($1)"},
+	{Name: "sequence", Signature: `sequence(start?, step?)`, Description: "Returns start, start+step, ... on successive calls during one compilation.", InsertText: "sequence($1)", Examples: []string{`sequence(100, 10)`}},
+	{Name: "os", Signature: `os()`, Description: "Returns the target operating system, e.g. linux, darwin or windows.", InsertText: "os()"},
+	{Name: "arch", Signature: `arch()`, Description: "Returns the target architecture, e.g. amd64 or arm64.", InsertText: "arch()"},
+	{Name: "pathjoin", Signature: `pathjoin(parts...)`, Description: "Joins path elements with the separator of the target operating system.", InsertText: "pathjoin($1)", Examples: []string{`pathjoin(env.HOME, ".config", "app.bcl")`}},
+	{Name: "path", Signature: `path(p)`, Description: "Resolves a relative path against the directory of the file that declares it.", InsertText: "path($1)", Examples: []string{`cert path("certs/server.pem")`}},
+	{Name: "csvdecode", Signature: `csvdecode(file_or_text, header?)`, Description: "Parses CSV from a file relative to the declaring file, or from text containing a newline, into a list of maps keyed by the header row. With header false each row is a list. Cells are strings.", InsertText: "csvdecode($1)", Examples: []string{`devices csvdecode("seed/devices.csv")`}},
+	{Name: "tsvdecode", Signature: `tsvdecode(file_or_text, header?)`, Description: "Like `csvdecode` for tab-separated data.", InsertText: "tsvdecode($1)"},
+	{Name: "on_windows", Signature: `on_windows(value, otherwise?)`, Description: "Returns value on Windows and otherwise elsewhere.", InsertText: "on_windows($1)", Examples: []string{`on_windows("C:\\tools\\app.exe", "/usr/local/bin/app")`}},
+	{Name: "on_linux", Signature: `on_linux(value, otherwise?)`, Description: "Returns value on Linux and otherwise elsewhere.", InsertText: "on_linux($1)"},
+	{Name: "on_darwin", Signature: `on_darwin(value, otherwise?)`, Description: "Returns value on macOS and otherwise elsewhere.", InsertText: "on_darwin($1)"},
 	{Name: "uuid", Signature: `uuid()`, Description: "Generates a random UUID for defaults and generated fields.", InsertText: "uuid()"},
 	{Name: "uuid_v4", Signature: `uuid_v4()`, Description: "Alias for `uuid()`.", InsertText: "uuid_v4()"},
 	{Name: "random_uuid", Signature: `random_uuid()`, Description: "Alias for `uuid()`.", InsertText: "random_uuid()"},
@@ -2335,15 +2500,7 @@ func sourceSnippet(src []byte, sp Span) string {
 }
 
 func isKeyword(s string) bool {
-	switch s {
-	case "import", "as", "const", "type", "schema", "options", "fields", "validate_required_fields", "validate_field_types", "required", "optional", "ref", "enum", "default", "description", "doc", "title", "deprecated", "sensitive", "generated", "derived", "read_only", "write_only", "nullable", "unique_items", "closed", "additional_properties", "min", "max", "exclusive_min", "exclusive_max", "multiple_of", "min_len", "max_len", "min_items", "max_items", "min_props", "max_props", "pattern", "format", "content_encoding", "content_media_type", "examples", "items", "prefix_items", "contains", "pattern_properties", "dependent_required", "lt_field", "lte_field", "gt_field", "gte_field", "eq_field", "all_of", "any_of", "one_of", "not", "if", "then", "else", "classification", "audit", "explain", "pii", "policy_tag", "owner", "severity", "true", "false", "null", "when", "override", "decision_schema", "decision_table", "rule_set", "ranking", "dataset", "reason_code_catalog", "decision_bundle", "decision_release", "gate", "test_matrix", "rule_template", "row", "record", "case", "outcome", "obligation", "advice", "event", "approval", "governance", "chain", "watch", "step", "routes", "route", "lifecycle", "phase", "entity", "entity_key", "method", "threshold", "window", "ttl", "sink", "sinks", "distinct", "decay", "cooldown", "reset", "headers", "body", "body_code", "body_message", "field", "metric", "metrics", "policy_package", "action_catalog", "output_contract", "standard_facts", "response_classifier", "response", "lifecycle_test", "policy_overlay", "capabilities", "actions", "state", "external", "contract", "severities", "retries", "healthy_below", "unhealthy_at_or_above", "expected_client_statuses", "metadata", "attributes", "reason", "reason_code", "priority", "hit_policy", "final_action", "blocking", "status", "retry_after_seconds", "grace_attempts", "channel", "layer", "environment", "profile", "result", "id":
-		return true
-	default:
-		if strings.HasPrefix(s, "x_") {
-			return true
-		}
-		return isKnownBlock(s)
-	}
+	return keywordSet[s] || strings.HasPrefix(s, "x_") || isKnownBlock(s)
 }
 
 func containsPosition(sp Span, line, column int) bool {
@@ -219,3 +219,666 @@ b match status {
 		}
 	}
 }
+
+func TestDefaultsBlockAndDefaultFunction(t *testing.T) {
+	n, err := CompileBytes([]byte(`
+defaults {
+  server {
+    port = 8080
+    tls {
+      enabled true
+      min_version "1.2"
+    }
+  }
+}
+
+server "a" {
+  host "a.local"
+}
+
+server "b" {
+  port 9090
+  tls {
+    enabled false
+  }
+}
+
+fallback = default(null, "x")
+kept = default("y", "x")
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.Body["defaults"]; ok {
+		t.Fatalf("defaults leaked into body: %#v", n.Body)
+	}
+	a := n.Blocks[0]["body"].(map[string]any)
+	if a["port"] != int64(8080) || a["host"] != "a.local" {
+		t.Fatalf("server a = %#v", a)
+	}
+	b := n.Blocks[1]["body"].(map[string]any)
+	tls := b["tls"].(map[string]any)
+	if b["port"] != int64(9090) || tls["enabled"] != false || tls["min_version"] != "1.2" {
+		t.Fatalf("server b = %#v", b)
+	}
+	if n.Body["fallback"] != "x" || n.Body["kept"] != "y" {
+		t.Fatalf("default() = %#v %#v", n.Body["fallback"], n.Body["kept"])
+	}
+}
+
+func TestPlainDefaultsKeysAreKept(t *testing.T) {
+	n, err := CompileBytes([]byte(`
+defaults = {
+  server {
+    port 1
+  }
+}
+
+svc {
+  defaults {
+    retries 3
+  }
+}
+
+server "a" {
+  host "a.local"
+}
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, _ := n.Body["defaults"].(map[string]any)
+	if server, _ := top["server"].(map[string]any); server["port"] != int64(1) {
+		t.Fatalf("defaults = %#v", n.Body["defaults"])
+	}
+	svc, _ := n.Body["svc"].(map[string]any)
+	if nested, _ := svc["defaults"].(map[string]any); nested["retries"] != int64(3) {
+		t.Fatalf("svc = %#v", svc)
+	}
+	if a := n.Blocks[0]["body"].(map[string]any); a["port"] != nil {
+		t.Fatalf("server a picked up a plain defaults key: %#v", a)
+	}
+}
+
+func TestWhenMetaAttributeSkipsBlocks(t *testing.T) {
+	src := []byte(`
+const PROD = false
+
+server "debug" {
+  when = !PROD
+  port 9000
+}
+
+server "metrics" {
+  when = PROD
+  port 9100
+}
+
+tracing {
+  when = PROD
+  sample 0.5
+}
+
+worker "legacy" {
+  enabled false
+  threads 2
+}
+
+rule "r" {
+  when {
+    request.amount > 10
+  }
+}
+
+job "report" {
+  when = "nightly"
+}
+`)
+	n, err := CompileBytes(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findBlock(n.Blocks, "server", "metrics") != nil {
+		t.Fatalf("disabled block emitted: %#v", n.Blocks)
+	}
+	debug := findBlock(n.Blocks, "server", "debug")
+	if debug == nil {
+		t.Fatalf("enabled block missing: %#v", n.Blocks)
+	}
+	if body := debug["body"].(map[string]any); body["when"] != nil || body["port"] != int64(9000) {
+		t.Fatalf("debug body = %#v", body)
+	}
+	if tracing, _ := n.Body["tracing"].(map[string]any); tracing["when"] != false {
+		t.Fatalf("when in an object should be plain data: %#v", n.Body["tracing"])
+	}
+	if job := findBlock(n.Blocks, "job", "report"); job == nil || job["body"].(map[string]any)["when"] != "nightly" {
+		t.Fatalf("non-bool when should be block data: %#v", job)
+	}
+	if findBlock(n.Blocks, "worker", "legacy") != nil {
+		t.Fatal("enabled = false block emitted")
+	}
+	if rule := findBlock(n.Blocks, "rule", "r"); rule == nil || rule["body"].(map[string]any)["when"] == nil {
+		t.Fatalf("rule condition lost: %#v", rule)
+	}
+
+	calls := 0
+	opts := &Options{EvalFunctions: map[string]EvalFunction{"flag": func(args []any, _ *EvalOptions) (any, error) {
+		calls++
+		return args[0] == "on", nil
+	}}}
+	n, err = CompileBytes([]byte(`
+worker "a" {
+  when = flag("on") == true
+  enabled = flag("on") == true
+}
+worker "b" {
+  enabled = flag("off") == true
+}
+`), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := findBlock(n.Blocks, "worker", "a")
+	if a == nil || findBlock(n.Blocks, "worker", "b") != nil {
+		t.Fatalf("blocks = %#v", n.Blocks)
+	}
+	if body := a["body"].(map[string]any); body["when"] != nil || body["enabled"] != true {
+		t.Fatalf("worker a body = %#v", body)
+	}
+	if calls != 3 {
+		t.Fatalf("meta attributes evaluated %d times, want 3", calls)
+	}
+}
+
+func TestIfElseIfChainsInBlocksAndMaps(t *testing.T) {
+	src := []byte(`
+const ENV = "staging"
+
+server "api" {
+  port 80
+  IF const.ENV == "prod" {
+    replicas 5
+  } ELSEIF const.ENV == "staging" {
+    replicas 2
+  } ELSE {
+    replicas 1
+  }
+}
+
+limits {
+  if const.ENV == "prod" {
+    rps 1000
+  }
+  else {
+    rps 10
+    if const.ENV == "staging" {
+      burst 20
+    }
+  }
+}
+`)
+	doc, err := Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := Compile(doc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := findBlock(n.Blocks, "server", "api")
+	if api == nil || api["body"].(map[string]any)["replicas"] != int64(2) {
+		t.Fatalf("server api = %#v", api)
+	}
+	limits := n.Body["limits"].(map[string]any)
+	if limits["rps"] != int64(10) || limits["burst"] != int64(20) {
+		t.Fatalf("limits = %#v", limits)
+	}
+	formatted, err := FormatDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(formatted), `} else if const.ENV == "staging" {`) {
+		t.Fatalf("formatted if chain:\n%s", formatted)
+	}
+	again, err := CompileBytes(formatted, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Body["limits"].(map[string]any)["burst"] != int64(20) {
+		t.Fatalf("formatted if chain compiled to %#v", again.Body)
+	}
+}
+
+func TestConstInsideTakenIfBranch(t *testing.T) {
+	n, err := CompileBytes([]byte(`
+const ENV = "prod"
+
+IF const.ENV == "prod" {
+  const REPLICAS = 5
+} ELSE {
+  const REPLICAS = 1
+}
+
+server "api" {
+  IF const.ENV == "prod" {
+    const PORT = 443
+  }
+  replicas = REPLICAS
+}
+
+port = PORT
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Constants["REPLICAS"] != int64(5) || n.Body["port"] != int64(443) {
+		t.Fatalf("constants = %#v, body = %#v", n.Constants, n.Body)
+	}
+	if api := findBlock(n.Blocks, "server", "api"); api == nil || api["body"].(map[string]any)["replicas"] != int64(5) {
+		t.Fatalf("server api = %#v", api)
+	}
+}
+
+func TestSeededRandomAndSequenceFunctions(t *testing.T) {
+	src := []byte(`
+port random_int(1024, 65535)
+suffix random_string(6, "abc")
+first sequence(10, 5)
+second sequence(10, 5)
+`)
+	compile := func(seed int64) *Normalized {
+		t.Helper()
+		n, err := CompileBytes(src, &Options{Seed: seed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	a, b := compile(7), compile(7)
+	if a.Body["port"] != b.Body["port"] || a.Body["suffix"] != b.Body["suffix"] {
+		t.Fatalf("seeded compiles differ: %#v vs %#v", a.Body, b.Body)
+	}
+	port, _ := a.Body["port"].(int64)
+	if port < 1024 || port > 65535 {
+		t.Fatalf("port = %#v", a.Body["port"])
+	}
+	if s, _ := a.Body["suffix"].(string); len(s) != 6 || strings.Trim(s, "abc") != "" {
+		t.Fatalf("suffix = %#v", a.Body["suffix"])
+	}
+	if a.Body["first"] != int64(10) || a.Body["second"] != int64(15) {
+		t.Fatalf("sequence = %#v, %#v", a.Body["first"], a.Body["second"])
+	}
+	if _, err := EvalExpr(`random_int(5, 1)`, nil); err == nil {
+		t.Fatal("expected error for inverted random_int bounds")
+	}
+	if _, err := EvalExpr(`random_string(100000000000)`, nil); err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Fatalf("oversized random_string err = %v", err)
+	}
+}
+
+func TestAssertAndFailAbortWithPositionedErrors(t *testing.T) {
+	n, err := CompileBytes([]byte(`
+const PORT = 8080
+_ = assert(const.PORT > 1024, "unprivileged port required")
+mode = const.PORT > 1024 ? "user" : fail("privileged ports are not allowed")
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.Body["_"]; ok || n.Body["mode"] != "user" {
+		t.Fatalf("body = %#v", n.Body)
+	}
+	_, err = CompileBytes([]byte(`
+const PORT = 80
+server {
+  _ = assert(const.PORT > 1024, "unprivileged port required")
+}
+`), nil)
+	if err == nil || !strings.Contains(err.Error(), "assertion failed: unprivileged port required") || !strings.Contains(err.Error(), ":4:") {
+		t.Fatalf("assert error = %v", err)
+	}
+	if _, err := EvalExpr(`1 > 2 ? "a" : fail("boom")`, nil); err == nil || err.Error() != "boom" {
+		t.Fatalf("fail error = %v", err)
+	}
+}
+
+func TestProfilesActivateFromActiveWhenRules(t *testing.T) {
+	src := []byte(`
+log_level "info"
+engine {
+  workers 4
+}
+profile "prod" {
+  active_when = env.APP_ENV == "prod"
+  log_level "warn"
+  override engine {
+    workers 16
+  }
+}
+profile "eu" {
+  active_when = env.REGION == "eu"
+  region "eu-west-1"
+}
+`)
+	env := map[string]string{"APP_ENV": "prod"}
+	lookup := func(k string) (string, bool) { v, ok := env[k]; return v, ok }
+	n, err := CompileBytes(src, &Options{AllowEnv: true, Env: lookup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, _ := n.Body["engine"].(map[string]any)
+	if n.Body["log_level"] != "warn" || engine["workers"] != int64(16) {
+		t.Fatalf("prod profile not applied: %#v", n.Body)
+	}
+	if _, ok := n.Body["region"]; ok {
+		t.Fatalf("inactive profile contributed values: %#v", n.Body)
+	}
+	if _, ok := n.Body["active_when"]; ok {
+		t.Fatal("active_when leaked into the body")
+	}
+	n, err = CompileBytes(src, &Options{AllowEnv: true, Env: lookup, Profile: "eu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["region"] != "eu-west-1" || n.Body["log_level"] != "warn" {
+		t.Fatalf("named and rule-activated profiles should both apply: %#v", n.Body)
+	}
+	if _, err := CompileBytes(src, &Options{Env: lookup}); err == nil || !strings.Contains(err.Error(), "AllowEnv") {
+		t.Fatalf("expected capability error, got %v", err)
+	}
+}
+
+func TestForwardReferencesInExpressions(t *testing.T) {
+	n, err := CompileBytes([]byte(`
+a = app.b + 1
+c = b + 2
+d = config.app.b + 10
+srv {
+  port = base + 1
+}
+b = 2
+base = 8000
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := n.Body["srv"].(map[string]any)
+	if n.Body["a"] != float64(3) || n.Body["c"] != float64(4) || n.Body["d"] != float64(12) || srv["port"] != float64(8001) {
+		t.Fatalf("body = %#v", n.Body)
+	}
+	_, err = CompileBytes([]byte("x = app.y + 1\ny = app.x + 1\n"), nil)
+	if err == nil || !strings.Contains(err.Error(), "reference cycle: x -> y -> x") {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+}
+
+func TestRefResolvesLaterDeclarationsAndDetectsCycles(t *testing.T) {
+	n, err := CompileBytes([]byte(`
+primary = ref("server.web.port")
+replica_hosts = ref("hosts")
+first_host = ref("hosts.0")
+timeout = ref("defaults.http.timeout") + 15
+hosts ["a.internal", "b.internal"]
+defaults = {
+  http {
+    timeout 15
+  }
+}
+server "web" {
+  port 8080
+}
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, _ := n.Body["replica_hosts"].([]any)
+	if n.Body["primary"] != int64(8080) || len(hosts) != 2 || n.Body["first_host"] != "a.internal" || n.Body["timeout"] != float64(30) {
+		t.Fatalf("body = %#v", n.Body)
+	}
+	_, err = CompileBytes([]byte("a = ref(\"b\")\nb = ref(\"c.x\")\nc {\n  x = ref(\"a\")\n}\n"), nil)
+	if err == nil || !strings.Contains(err.Error(), "reference cycle: a -> b -> c -> a") {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+	if _, err := CompileBytes([]byte("a = ref(\"missing.key\")\n"), nil); err == nil || !strings.Contains(err.Error(), `"missing" is not declared`) {
+		t.Fatalf("expected undeclared error, got %v", err)
+	}
+	n, err = CompileBytes([]byte("owner ref(team.platform)\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call, _ := n.Body["owner"].(map[string]any); call["$call"] != "ref" {
+		t.Fatalf("identifier refs should stay markers: %#v", n.Body["owner"])
+	}
+	userRef := func(args []any, _ *EvalOptions) (any, error) { return "user:" + args[0].(string), nil }
+	n, err = CompileBytes([]byte("secret = ref(\"vault/db\") + \"!\"\n"), &Options{EvalFunctions: map[string]EvalFunction{"ref": userRef}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["secret"] != "user:vault/db!" {
+		t.Fatalf("user ref replaced: %#v", n.Body["secret"])
+	}
+}
+
+func TestForwardRefsOnlyBindFreeNames(t *testing.T) {
+	n, err := CompileBytes([]byte(`
+x = map(list, x => x + 1)
+y = match list {
+  case [y, ...rest] => y + 1
+  case ANY => 0
+}
+z = match cfg {
+  case { k : v } => v + k
+  case ANY => 0
+}
+cfg = { k 1 }
+list = [1, 2]
+k = 7
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["y"] != float64(2) {
+		t.Fatalf("y = %#v", n.Body["y"])
+	}
+	if n.Body["z"] != float64(8) {
+		t.Fatalf("z = %#v", n.Body["z"])
+	}
+}
+
+func TestForLoopStampsOutBlocks(t *testing.T) {
+	src := []byte(`
+regions = ["eu", "us"]
+const PORTS = { web = 80, api = 8080 }
+
+for i, r in regions {
+  server "app-${r}" {
+    region = r
+    weight = i * 10 + 1
+    if r == "eu" {
+      primary = true
+    }
+    for name, port in const.PORTS {
+      listener "${r}-${name}" {
+        port = port
+      }
+    }
+  }
+}
+
+route "r" {
+  target = server.app-us
+}
+`)
+	doc, err := Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := Compile(doc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eu, us := findBlock(n.Blocks, "server", "app-eu"), findBlock(n.Blocks, "server", "app-us")
+	if eu == nil || us == nil {
+		t.Fatalf("blocks = %#v", n.Blocks)
+	}
+	euBody, usBody := eu["body"].(map[string]any), us["body"].(map[string]any)
+	if euBody["region"] != "eu" || euBody["weight"] != float64(1) || euBody["primary"] != true {
+		t.Fatalf("app-eu = %#v", euBody)
+	}
+	if usBody["weight"] != float64(11) || usBody["primary"] != nil {
+		t.Fatalf("app-us = %#v", usBody)
+	}
+	listeners := usBody["listener"].([]any)
+	if len(listeners) != 2 || listeners[0].(map[string]any)["id"] != "us-api" || listeners[0].(map[string]any)["body"].(map[string]any)["port"] != int64(8080) {
+		t.Fatalf("listeners = %#v", listeners)
+	}
+	formatted, err := FormatDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(formatted), "for i, r in regions {\n") {
+		t.Fatalf("formatted loop:\n%s", formatted)
+	}
+	bad, err := Parse([]byte("for x in 5 {\n  a = x\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Compile(bad, nil); err == nil || !strings.Contains(err.Error(), "cannot iterate") {
+		t.Fatalf("expected iteration error, got %v", err)
+	}
+	for _, src := range []string{"for s in nosuchthing {}\n", "for s in NAMES {\n  a = s\n}\n", "cfg { x 1 }\nfor s in cfg.items {}\n"} {
+		doc, err := Parse([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Compile(doc, nil); err == nil || !strings.Contains(err.Error(), "is not defined") {
+			t.Fatalf("%q: expected undefined iterable error, got %v", src, err)
+		}
+	}
+	empty, err := Parse([]byte("items null\nfor s in items {\n  a = s\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Compile(empty, nil); err != nil {
+		t.Fatalf("null iterable: %v", err)
+	}
+}
+
+func TestBareKeysAreTrueAndBangKeysFalse(t *testing.T) {
+	src := []byte(`debug
+!verbose
+opts = {
+  color
+  !quiet
+}
+logging {
+  enabled
+  !json
+}
+server "a" {
+  enabled
+  !tls
+}
+`)
+	n, err := CompileBytes(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["debug"] != true || n.Body["verbose"] != false {
+		t.Fatalf("body = %#v", n.Body)
+	}
+	if opts := n.Body["opts"].(map[string]any); opts["color"] != true || opts["quiet"] != false {
+		t.Fatalf("opts = %#v", opts)
+	}
+	if logging := n.Body["logging"].(map[string]any); logging["enabled"] != true || logging["json"] != false {
+		t.Fatalf("logging = %#v", logging)
+	}
+	if body := findBlock(n.Blocks, "server", "a")["body"].(map[string]any); body["enabled"] != true || body["tls"] != false {
+		t.Fatalf("server = %#v", body)
+	}
+	out, err := Format(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "\n!verbose\n") || !strings.Contains(string(out), "  !tls\n") {
+		t.Fatalf("formatted = %s", out)
+	}
+
+	type logging struct {
+		Enabled bool `bcl:"enabled,flag"`
+		JSON    bool `bcl:"json,flag"`
+	}
+	type config struct {
+		Logging logging `bcl:"logging"`
+		Feature logging `bcl:"feature"`
+	}
+	want := config{Logging: logging{Enabled: true}, Feature: logging{Enabled: true, JSON: true}}
+	b, err := Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "  enabled\n  !json\n") {
+		t.Fatalf("marshal = %s", b)
+	}
+	var got config
+	if err := Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("round trip = %#v from %s", got, b)
+	}
+}
+
+func TestDocumentFunctions(t *testing.T) {
+	src := []byte(`const GREETING = "hi"
+func greet(name) {
+  return const.GREETING + " " + name
+}
+func fact(n) {
+  return n <= 1 ? 1 : n * fact(n - 1)
+}
+who = "bob"
+plain = greet("ann")
+named = greet(who)
+expr = greet(who) + "!"
+total = fact(5)
+`)
+	n, err := CompileBytes(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Body["plain"] != "hi ann" || n.Body["named"] != "hi bob" || n.Body["expr"] != "hi bob!" || n.Body["total"] != float64(120) {
+		t.Fatalf("body = %#v", n.Body)
+	}
+	out, err := Format(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "func fact(n) {\n  return n <= 1 ? 1 : n * fact(n - 1)\n}\n") {
+		t.Fatalf("formatted = %s", out)
+	}
+
+	for _, bad := range []string{
+		"func f(x) { return f(x) }\nv = f(1)\n",
+		"func f(x) { return x }\nv = f(1, 2)\n",
+		"func f(x) { return x }\nfunc f(y) { return y }\n",
+		"func upper(x) { return x }\n",
+	} {
+		opts := &Options{EvalFunctions: map[string]EvalFunction{"upper": func(args []any, _ *EvalOptions) (any, error) { return args[0], nil }}}
+		if _, err := CompileBytes([]byte(bad), opts); err == nil {
+			t.Fatalf("%q: expected error", bad)
+		}
+	}
+
+	// The caller's functions, including its own ref, are never written to.
+	fns := map[string]EvalFunction{"ref": func(args []any, _ *EvalOptions) (any, error) { return "host", nil }}
+	opts := &Options{EvalFunctions: fns}
+	for i := 0; i < 2; i++ {
+		if n, err := CompileBytes(src, opts); err != nil || n.Body["plain"] != "hi ann" {
+			t.Fatalf("compile %d = %v, %v", i, n, err)
+		}
+	}
+	if len(fns) != 1 {
+		t.Fatalf("caller functions modified: %d entries", len(fns))
+	}
+}
@@ -94,6 +94,32 @@ chain "account_risk_chain" {
 	}
 }
 
+func TestExportGrammarMatchesEditorGrammar(t *testing.T) {
+	out, err := ExportGrammar("textmate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checked, err := os.ReadFile(filepath.Join("editors", "vscode", "syntaxes", "bcl.tmLanguage.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != string(checked) {
+		t.Fatal("editors/vscode/syntaxes/bcl.tmLanguage.json is stale; run bcl grammar export -out editors/vscode/syntaxes/bcl.tmLanguage.json")
+	}
+	for _, want := range []string{"decision_table", `env\\.required`, "starts_with", "ipv6"} {
+		if !strings.Contains(string(out), want) {
+			t.Fatalf("textmate grammar missing %s", want)
+		}
+	}
+	highlights, err := ExportGrammar("tree-sitter-highlights")
+	if err != nil || !strings.Contains(string(highlights), `"profile"`) || !strings.Contains(string(highlights), `"not_in"`) {
+		t.Fatalf("highlights = %s, %v", highlights, err)
+	}
+	if _, err := ExportGrammar("vim"); err == nil {
+		t.Fatal("expected unknown format error")
+	}
+}
+
 func TestTokenizeSchemaExposesExpandedSchemaTokens(t *testing.T) {
 	toks, diags := TokenizeFile("decision.schema", []byte(`schema ticket {
   options {
@@ -515,6 +541,45 @@ decision_table "fraud_aml" {
 	}
 }
 
+func TestSchemaCompletionItems(t *testing.T) {
+	src := []byte(`schema service {
+  required port number description "Listen port"
+  optional mode string enum ["fast", "safe"]
+  optional tls object {
+    optional cert string
+  }
+}
+
+service api {
+  mode fast
+}
+`)
+	doc, err := Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := doc.Items[0].(*SchemaDecl).CompletionItems()
+	var paths []string
+	for _, item := range items {
+		paths = append(paths, item.Path)
+	}
+	if strings.Join(paths, ",") != "port,mode,tls,tls.cert" || !items[0].Required || items[0].Documentation != "Listen port" {
+		t.Fatalf("items = %+v", items)
+	}
+	if len(items[1].Enum) != 2 || items[1].Enum[0] != "fast" {
+		t.Fatalf("enum = %+v", items[1])
+	}
+	a, _ := AnalyzeFile("service.bcl", src, nil)
+	comps, _ := CompletionsAt(a, src, 10, len("  ")+1)
+	if !completionLabelsContain(comps, "port", "mode", "tls") || completionLabelsContain(comps, "tls.cert") {
+		t.Fatalf("missing field completions: %+v", comps)
+	}
+	comps, ctx := CompletionsAt(a, src, 10, len("  mode ")+1)
+	if ctx.AssignmentName != "mode" || !completionLabelsContain(comps, `"fast"`, `"safe"`) {
+		t.Fatalf("missing enum completions ctx=%+v comps=%+v", ctx, comps)
+	}
+}
+
 func TestConditionCompletionsIncludeLocalRouteLifecycleChainAndActionIDs(t *testing.T) {
 	src := []byte(`bcl { version "1.0" }
 
@@ -1046,3 +1111,80 @@ pipeline "feature-rollout" {
 }
 `)
 }
+
+func TestIndexSymbolsRenamesAcrossImports(t *testing.T) {
+	dir := t.TempDir()
+	lib := "# shared ports\nbase_port = 8000\n\nservice \"api\" {\n  port = base_port   # keep\n}\n"
+	main := "import \"lib.bcl\"\n\nroute \"r\" {\n  target = service.api\n  port   = base_port + 1\n  url    = \"http://x:${base_port}\"\n  alt    = app.base_port\n  deep   = config.app.base_port\n  via    = ref(\"base_port\")\n  up     = ref(\"service.api.port\")\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "lib.bcl"), []byte(lib), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mainPath := filepath.Join(dir, "main.bcl")
+	if err := os.WriteFile(mainPath, []byte(main), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := IndexSymbols(mainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defs, refs := idx.Definitions("base_port"), idx.References("base_port"); len(defs) != 1 || len(refs) != 6 {
+		t.Fatalf("base_port: defs %v refs %v", defs, refs)
+	}
+	changed, err := idx.Rename("base_port", "http_port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	libOut := string(changed[filepath.Join(dir, "lib.bcl")])
+	if libOut != strings.ReplaceAll(lib, "base_port", "http_port") {
+		t.Fatalf("lib.bcl = %q", libOut)
+	}
+	if got := string(changed[mainPath]); got != strings.ReplaceAll(main, "base_port", "http_port") {
+		t.Fatalf("main.bcl = %q", got)
+	}
+	changed, err = idx.Rename("service.api", "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(changed[mainPath]); !strings.Contains(got, "target = service.gateway\n") || !strings.Contains(got, `ref("service.gateway.port")`) || !strings.Contains(string(changed[filepath.Join(dir, "lib.bcl")]), `service "gateway" {`) {
+		t.Fatalf("block rename: %q", changed)
+	}
+	if _, err := idx.Rename("base_port", "service.api"); err == nil {
+		t.Fatal("expected invalid name error")
+	}
+	if _, err := idx.Rename("base_port", "base_port"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSymbolIndexUnusedValues(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.bcl":   "import \"consts.bcl\"\nimport \"unused.bcl\"\n\nconst PORT = BASE + 1\nconst DEAD = 1\nconst CHAIN = 2\nconst ONLY_DEAD = CHAIN\nconst LIMIT = 10\nconst TIMEOUT = 30\n\nreplicas = 1\nreplicas = 3\ncount = 1\ncount = count + 1\n\nservice \"api\" {\n  port = PORT\n  limit = const.LIMIT\n  timeout = ref(\"TIMEOUT\")\n}\n",
+		"consts.bcl": "const BASE = 8000\n",
+		"unused.bcl": "# nothing here is used\nconst OLD = 1\n",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx, err := IndexSymbols(filepath.Join(dir, "main.bcl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range idx.Unused() {
+		got = append(got, d.Message)
+	}
+	want := []string{
+		`const "CHAIN" is never used`,
+		`const "DEAD" is never used`,
+		`const "OLD" is never used`,
+		`const "ONLY_DEAD" is never used`,
+		`value of "replicas" is overwritten before it is used`,
+		`import "` + filepath.Join(dir, "unused.bcl") + `" contributes nothing to the output`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Unused() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
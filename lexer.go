@@ -2,10 +2,12 @@ package bcl
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 	"unicode"
 	"unicode/utf8"
+	"unique"
 )
 
 type tokenKind int
@@ -41,6 +43,9 @@ type lexer struct {
 	pos  int
 	line int
 	col  int
+	// expr is set for standalone expressions, which have no comments, so
+	// // is integer division there.
+	expr bool
 }
 
 func lex(file string, src []byte) ([]token, ErrorList) {
@@ -51,6 +56,24 @@ func lexString(file string, src string) ([]token, ErrorList) {
 	return lexStringInto(file, src, make([]token, 0, estimatedTokenCount(len(src))))
 }
 
+// lexExpr lexes a standalone expression such as the raw text of an Expr or
+// an interpolation.
+func lexExpr(src string) ([]token, ErrorList) {
+	l := &lexer{file: "<expr>", src: src, line: 1, col: 1, expr: true}
+	toks := make([]token, 0, estimatedTokenCount(len(src)))
+	var errs ErrorList
+	for {
+		t, err := l.next()
+		if err != nil {
+			errs = append(errs, *err)
+		}
+		toks = append(toks, t)
+		if t.kind == tokEOF {
+			return toks, errs
+		}
+	}
+}
+
 func lexStringPooled(file string, src string) ([]token, ErrorList) {
 	return lexStringInto(file, src, getTokenScratch(estimatedTokenCount(len(src))))
 }
@@ -123,7 +146,7 @@ func (l *lexer) next() (token, *Diagnostic) {
 			return token{kind: tokNewline, text: "\n", span: sp}, nil
 		case r == '#':
 			l.skipLine()
-		case r == '/' && l.peekN(1) == '/':
+		case r == '/' && l.peekN(1) == '/' && !l.expr:
 			l.skipLine()
 		case r == '/' && l.peekN(1) == '*':
 			if err := l.skipBlockComment(); err != nil {
@@ -192,6 +215,27 @@ done:
 			return l.tok(tokOperator, ">=", start), nil
 		}
 		return l.tok(tokOperator, ">", start), nil
+	case '/':
+		l.advance()
+		if l.expr && l.peek() == '/' {
+			l.advance()
+			return l.tok(tokOperator, "//", start), nil
+		}
+		return l.tok(tokOperator, "/", start), nil
+	case '&', '|', '?':
+		// ?. is a null-safe dot; before a digit it is a ternary on a number.
+		if r == '?' && l.peekN(1) == '.' && !unicode.IsDigit(l.peekN(2)) {
+			l.advance()
+			l.advance()
+			return l.tok(tokDot, "?.", start), nil
+		}
+		// &&, || and ?? are single operators; a lone & starts a spread.
+		l.advance()
+		if l.peek() == r {
+			l.advance()
+			return l.tok(tokOperator, string(r)+string(r), start), nil
+		}
+		return l.tok(tokOperator, string(r), start), nil
 	case '"', '\'':
 		return l.string(start, r)
 	case '`':
@@ -201,7 +245,7 @@ done:
 		return l.ident(start), nil
 	}
 	if unicode.IsDigit(r) || (r == '-' && unicode.IsDigit(l.peekN(1))) {
-		return l.number(start), nil
+		return l.number(start)
 	}
 	l.advance()
 	return l.tok(tokOperator, string(r), start), nil
@@ -221,24 +265,78 @@ func (l *lexer) ident(start Span) token {
 		}
 		l.advance()
 	}
-	return l.tok(tokIdent, l.src[startOff:l.pos], start)
+	return l.tok(tokIdent, intern(l.src[startOff:l.pos]), start)
+}
+
+// intern returns the canonical copy of an identifier or key. Large configs
+// repeat the same few keys (host, port, enabled) thousands of times; sharing
+// one copy of each also lets the source text be freed once only the
+// compiled values are kept. The table is process-wide and its entries are
+// dropped when no longer referenced.
+func intern(s string) string {
+	if len(s) > maxInternLen {
+		return s
+	}
+	return unique.Make(s).Value()
 }
 
-func (l *lexer) number(start Span) token {
+const maxInternLen = 64
+
+func (l *lexer) number(start Span) (token, *Diagnostic) {
 	startOff := start.Start.Offset
 	if l.peek() == '-' {
 		l.advance()
 	}
 	for {
 		r := l.peek()
-		if r == 0 || !(unicode.IsDigit(r) || r == '.' || unicode.IsLetter(r) || r == '-' || r == ':' || r == 'T' || r == 'Z') {
+		if r == 0 || !(unicode.IsDigit(r) || r == '.' || unicode.IsLetter(r) || r == '-' || r == ':' || r == 'T' || r == 'Z' || r == '_') {
 			break
 		}
 		l.advance()
 	}
-	return l.tok(tokNumber, l.src[startOff:l.pos], start)
+	t := l.tok(tokNumber, l.src[startOff:l.pos], start)
+	if msg := checkNumber(t.text); msg != "" {
+		return t, &Diagnostic{Severity: "error", Message: msg, Span: t.span}
+	}
+	return t, nil
+}
+
+// checkNumber reports what is wrong with the digits of a number token:
+// a radix literal (0xFF, 0o755, 0b1010) that is not a valid integer, or a
+// "_" that does not separate two digits.
+func checkNumber(raw string) string {
+	if radixLiteral(raw) {
+		if _, err := strconv.ParseUint(strings.TrimPrefix(raw, "-"), 0, 64); err != nil {
+			return fmt.Sprintf("invalid number literal %q", raw)
+		}
+		return ""
+	}
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '_' && (i == 0 || i+1 == len(raw) || !isDigitByte(raw[i-1]) || !isDigitByte(raw[i+1])) {
+			return fmt.Sprintf("invalid number literal %q: _ must separate digits", raw)
+		}
+	}
+	return ""
+}
+
+// radixLiteral reports whether raw has a 0x, 0o or 0b prefix followed by
+// digits. 0b and 0B alone are byte sizes.
+func radixLiteral(raw string) bool {
+	raw = strings.TrimPrefix(raw, "-")
+	if len(raw) < 2 || raw[0] != '0' {
+		return false
+	}
+	switch raw[1] {
+	case 'x', 'X', 'o', 'O':
+		return true
+	case 'b', 'B':
+		return len(raw) > 2
+	}
+	return false
 }
 
+func isDigitByte(c byte) bool { return c >= '0' && c <= '9' }
+
 func (l *lexer) string(start Span, quote rune) (token, *Diagnostic) {
 	if quote == '"' && strings.HasPrefix(l.src[l.pos:], `"""`) {
 		l.advance()
@@ -285,8 +383,18 @@ func (l *lexer) string(start Span, quote rune) (token, *Diagnostic) {
 				b.WriteByte('\n')
 			case 't':
 				b.WriteByte('\t')
-			case '"', '\'', '\\':
-				b.WriteRune(esc)
+			case 'r':
+				b.WriteByte('\r')
+			case 'a':
+				b.WriteByte('\a')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'v':
+				b.WriteByte('\v')
+			case 'x', 'u', 'U':
+				l.hexEscape(&b, esc)
 			default:
 				b.WriteRune(esc)
 			}
@@ -298,6 +406,36 @@ func (l *lexer) string(start Span, quote rune) (token, *Diagnostic) {
 	}
 }
 
+// hexEscape decodes the digits of a \xHH, \uHHHH or \UHHHHHHHH escape,
+// the forms strconv.Quote writes. Without the full run of hex digits the
+// escape is the letter itself, as before these escapes existed.
+func (l *lexer) hexEscape(b *strings.Builder, esc rune) {
+	n := 2
+	switch esc {
+	case 'u':
+		n = 4
+	case 'U':
+		n = 8
+	}
+	if l.pos+n > len(l.src) {
+		b.WriteRune(esc)
+		return
+	}
+	v, err := strconv.ParseUint(l.src[l.pos:l.pos+n], 16, 32)
+	if err != nil || v > unicode.MaxRune {
+		b.WriteRune(esc)
+		return
+	}
+	for range n {
+		l.advance()
+	}
+	if esc == 'x' {
+		b.WriteByte(byte(v))
+	} else {
+		b.WriteRune(rune(v))
+	}
+}
+
 func (l *lexer) rawString(start Span) (token, *Diagnostic) {
 	l.advance()
 	contentStart := l.pos
@@ -0,0 +1,36 @@
+package bcl
+
+import (
+	"log/slog"
+	"time"
+)
+
+// The package writes nothing on its own; hosts that want to see what it
+// does set Options.Logger, or Runtime.SetLogger for every compilation of a
+// runtime. Compiles and includes log at debug level, warning diagnostics
+// at warn, and Binder reloads and Enforcer passes at info, warn and error.
+// Values are never logged, only names, paths and counts.
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// logger returns the Options logger, or one that discards every record.
+func (o *Options) logger() *slog.Logger {
+	if o != nil && o.Logger != nil {
+		return o.Logger
+	}
+	return discardLogger
+}
+
+func (c *compiler) logCompile(start time.Time) {
+	log := c.opts.logger()
+	ctx := c.opts.context()
+	errors := 0
+	for _, d := range c.errs {
+		if d.Severity == "warning" {
+			log.WarnContext(ctx, d.Message, "file", d.Span.File, "line", d.Span.Start.Line)
+		} else {
+			errors++
+		}
+	}
+	log.DebugContext(ctx, "compiled config", "file", c.file, "duration", time.Since(start), "blocks", len(c.out.Blocks), "errors", errors)
+}
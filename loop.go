@@ -0,0 +1,334 @@
+package bcl
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// expandLoops replaces every for loop in nodes, at any depth, with a copy
+// of its body per element. Loop variables are bound in the copies: plain
+// uses (item, item.name) become literals, ${...} interpolations that use
+// them in strings and block ids are evaluated, and expressions keep them
+// in scope for when they are evaluated. Inner loops see the variables of
+// outer ones.
+func (c *compiler) expandLoops(nodes []Node, scope map[string]any) []Node {
+	if !hasLoop(nodes) {
+		return nodes
+	}
+	out := make([]Node, 0, len(nodes))
+	for _, n := range nodes {
+		switch x := n.(type) {
+		case *ForLoop:
+			out = append(out, c.unrollLoop(x, scope)...)
+			continue
+		case *Block:
+			if hasLoop(x.Body) {
+				cp := *x
+				cp.Body = c.expandLoops(x.Body, scope)
+				n = &cp
+			}
+		case *Assignment:
+			if o, ok := x.Value.(*Object); ok && hasLoop(o.Fields) {
+				obj := *o
+				obj.Fields = c.expandLoops(o.Fields, scope)
+				cp := *x
+				cp.Value = &obj
+				n = &cp
+			}
+		case *IfChain:
+			if hasLoop([]Node{x}) {
+				cp := *x
+				cp.Branches = slices.Clone(x.Branches)
+				for i := range cp.Branches {
+					cp.Branches[i].Body = c.expandLoops(cp.Branches[i].Body, scope)
+				}
+				cp.Else = c.expandLoops(x.Else, scope)
+				n = &cp
+			}
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+func hasLoop(nodes []Node) bool {
+	for _, n := range nodes {
+		switch x := n.(type) {
+		case *ForLoop:
+			return true
+		case *Block:
+			if hasLoop(x.Body) {
+				return true
+			}
+		case *Assignment:
+			if o, ok := x.Value.(*Object); ok && hasLoop(o.Fields) {
+				return true
+			}
+		case *IfChain:
+			for _, b := range x.Branches {
+				if hasLoop(b.Body) {
+					return true
+				}
+			}
+			if hasLoop(x.Else) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *compiler) unrollLoop(loop *ForLoop, scope map[string]any) []Node {
+	c.exprSpan = loop.In.Span
+	c.evalOpts.Variables = c.scopeVars(loop.In.Raw, scope, loop.In.Span)
+	v, err := EvalExpr(loop.In.Raw, &c.evalOpts)
+	if err != nil {
+		c.errs = append(c.errs, Diagnostic{Severity: "error", Message: fmt.Sprintf("for %s: %v", loop.In.Raw, err), Span: loop.In.Span})
+		return nil
+	}
+	var out []Node
+	iter := func(key, value any) {
+		if c.canceled(loop.Span) {
+			return
+		}
+		inner := maps.Clone(scope)
+		if inner == nil {
+			inner = map[string]any{}
+		}
+		if loop.Key != "" {
+			inner[loop.Key] = key
+		}
+		inner[loop.Value] = value
+		out = append(out, c.expandLoops(c.bindNodes(loop.Body, inner), inner)...)
+	}
+	switch xs := v.(type) {
+	case nil:
+		if undefinedPath(loop.In.Raw, c.evalOpts.Variables) {
+			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: fmt.Sprintf("for %s: %s is not defined", loop.In.Raw, loop.In.Raw), Span: loop.In.Span})
+		}
+	case []any:
+		for i, item := range xs {
+			iter(int64(i), item)
+		}
+	case map[string]any:
+		for _, k := range slices.Sorted(maps.Keys(xs)) {
+			iter(k, xs[k])
+		}
+	default:
+		c.errs = append(c.errs, Diagnostic{Severity: "error", Message: fmt.Sprintf("for %s: cannot iterate over %T", loop.In.Raw, v), Span: loop.In.Span})
+	}
+	return out
+}
+
+// scopeVars returns the expression variables with the loop variables of
+// scope on top, and the top-level keys raw reads bound on demand.
+// undefinedPath reports whether raw is a name or dotted path that resolves
+// to nothing in vars. A path that holds null is defined and iterates zero
+// times.
+func undefinedPath(raw string, vars map[string]any) bool {
+	raw = strings.TrimSpace(raw)
+	for _, part := range strings.Split(raw, ".") {
+		if !isBCLIdent(part) {
+			return false
+		}
+	}
+	if _, ok := vars[raw]; ok {
+		return false
+	}
+	_, ok := getPath(vars, raw)
+	return !ok
+}
+
+func (c *compiler) scopeVars(raw string, scope map[string]any, sp Span) map[string]any {
+	vars := c.evalVars()
+	maps.Copy(vars, scope)
+	c.forwardVars(raw, vars, sp)
+	return vars
+}
+
+// bindNodes copies nodes with the loop variables of scope bound.
+func (c *compiler) bindNodes(nodes []Node, scope map[string]any) []Node {
+	out := make([]Node, len(nodes))
+	for i, n := range nodes {
+		out[i] = c.bindNode(n, scope)
+	}
+	return out
+}
+
+func (c *compiler) bindNode(n Node, scope map[string]any) Node {
+	switch x := n.(type) {
+	case *Assignment:
+		cp := *x
+		cp.Value = c.bindValue(x.Value, scope)
+		return &cp
+	case *Block:
+		cp := *x
+		cp.ID = c.interpolateScope(x.ID, scope, x.Span)
+		cp.Body = c.bindNodes(x.Body, scope)
+		return &cp
+	case *Object:
+		cp := *x
+		cp.Fields = c.bindNodes(x.Fields, scope)
+		return &cp
+	case *Spread:
+		cp := *x
+		cp.Body = c.bindNodes(x.Body, scope)
+		return &cp
+	case *IfChain:
+		cp := *x
+		cp.Branches = make([]IfBranch, len(x.Branches))
+		for i, b := range x.Branches {
+			cp.Branches[i] = IfBranch{Cond: bindExpr(b.Cond, scope), Body: c.bindNodes(b.Body, scope)}
+		}
+		cp.Else = c.bindNodes(x.Else, scope)
+		return &cp
+	case *ForLoop:
+		cp := *x
+		cp.In = bindExpr(x.In, scope)
+		cp.Body = c.bindNodes(x.Body, scope)
+		return &cp
+	}
+	return n
+}
+
+func (c *compiler) bindValue(v Value, scope map[string]any) Value {
+	switch x := v.(type) {
+	case *Literal:
+		switch x.Type {
+		case "identifier":
+			if val, ok := scope[fmt.Sprint(x.Data)]; ok {
+				return valueLiteral(val, x.Span)
+			}
+		case "string":
+			if s, ok := x.Data.(string); ok {
+				if bound := c.interpolateScope(s, scope, x.Span); bound != s {
+					cp := *x
+					cp.Data = bound
+					return &cp
+				}
+			}
+		}
+	case *Reference:
+		root, rest, _ := strings.Cut(x.Path, ".")
+		if val, ok := scope[root]; ok {
+			if rest != "" {
+				val, ok = walkPath(val, strings.Split(rest, "."))
+				if !ok {
+					c.errs = append(c.errs, Diagnostic{Severity: "error", Message: fmt.Sprintf("%s: path not found", x.Path), Span: x.Span})
+				}
+			}
+			return valueLiteral(val, x.Span)
+		}
+	case *List:
+		cp := *x
+		cp.Items = make([]Value, len(x.Items))
+		for i, item := range x.Items {
+			cp.Items[i] = c.bindValue(item, scope)
+		}
+		return &cp
+	case *Object:
+		cp := *x
+		cp.Fields = c.bindNodes(x.Fields, scope)
+		return &cp
+	case *Expr:
+		return bindExpr(x, scope)
+	case *Call:
+		cp := *x
+		cp.Args = make([]Value, len(x.Args))
+		for i, arg := range x.Args {
+			cp.Args[i] = c.bindValue(arg, scope)
+		}
+		return &cp
+	case *Condition:
+		cp := *x
+		cp.Expr = bindExpr(x.Expr, scope)
+		cp.Children = make([]*Condition, len(x.Children))
+		for i, child := range x.Children {
+			cp.Children[i] = c.bindValue(child, scope).(*Condition)
+		}
+		return &cp
+	}
+	return v
+}
+
+func bindExpr(e *Expr, scope map[string]any) *Expr {
+	if e == nil {
+		return nil
+	}
+	cp := *e
+	cp.scope = maps.Clone(e.scope)
+	if cp.scope == nil {
+		cp.scope = map[string]any{}
+	}
+	maps.Copy(cp.scope, scope)
+	return &cp
+}
+
+// interpolateScope evaluates the ${...} parts of s that use a loop
+// variable, leaving the others for compile-time interpolation.
+func (c *compiler) interpolateScope(s string, scope map[string]any, sp Span) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	return interpolationPattern.ReplaceAllStringFunc(s, func(match string) string {
+		expr := strings.TrimSuffix(strings.TrimPrefix(match, "${"), "}")
+		if !usesScope(expr, scope) {
+			return match
+		}
+		c.evalOpts.Variables = c.scopeVars(expr, scope, sp)
+		v, err := EvalExpr(expr, &c.evalOpts)
+		if err != nil {
+			c.errs = append(c.errs, Diagnostic{Severity: "error", Message: err.Error(), Span: sp})
+			return match
+		}
+		return sprintValue(v)
+	})
+}
+
+func usesScope(expr string, scope map[string]any) bool {
+	toks, err := exprTokens(expr)
+	if err != nil {
+		return false
+	}
+	for i, t := range toks {
+		if t.kind == tokIdent && (i == 0 || toks[i-1].kind != tokDot) {
+			if _, ok := scope[t.text]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// valueLiteral turns an evaluated value back into an AST value.
+func valueLiteral(v any, sp Span) Value {
+	switch x := v.(type) {
+	case nil:
+		return &Literal{Type: "null", Span: sp}
+	case string:
+		return &Literal{Type: "string", Data: x, Span: sp}
+	case bool:
+		return &Literal{Type: "bool", Data: x, Span: sp}
+	case int:
+		return &Literal{Type: "int", Data: int64(x), Span: sp}
+	case int64:
+		return &Literal{Type: "int", Data: x, Span: sp}
+	case float64:
+		return &Literal{Type: "float", Data: x, Span: sp}
+	case []any:
+		items := make([]Value, len(x))
+		for i, item := range x {
+			items[i] = valueLiteral(item, sp)
+		}
+		return &List{Items: items, Span: sp}
+	case map[string]any:
+		obj := &Object{Span: sp}
+		for _, k := range slices.Sorted(maps.Keys(x)) {
+			obj.Fields = append(obj.Fields, &Assignment{Name: k, Value: valueLiteral(x[k], sp), Span: sp})
+		}
+		return obj
+	}
+	return &Literal{Type: "string", Data: fmt.Sprint(v), Span: sp}
+}
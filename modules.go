@@ -450,6 +450,9 @@ type WatchEvent struct {
 	Diagnostics []Diagnostic
 	Error       error
 	Dependency  string
+	// Changes lists the keys that differ from the previous successful
+	// compilation; on the first one every key is added.
+	Changes ConfigChanges
 }
 
 type Watcher struct {
@@ -470,6 +473,7 @@ func (w *Watcher) Start(onChange func(WatchEvent)) chan struct{} {
 	}
 	go func() {
 		var last time.Time
+		var prev *Normalized
 		tick := time.NewTicker(w.Interval)
 		defer tick.Stop()
 		for {
@@ -492,6 +496,9 @@ func (w *Watcher) Start(onChange func(WatchEvent)) chan struct{} {
 					if e, ok := err.(ErrorList); ok {
 						ev.Diagnostics = e
 					}
+				} else {
+					ev.Changes = DiffConfigs(prev, n)
+					prev = n
 				}
 				onChange(ev)
 			}
@@ -116,7 +116,7 @@ module "m" {
 }
 
 func TestNextWaveFormatPreservesCommentsAndTrivia(t *testing.T) {
-	src := []byte("# keep me\npolicy \"p\" { // same line\n  effect allow\n}\n")
+	src := []byte("# keep me\npolicy p { // same line\n  effect allow\n}\n")
 	out, err := Format(src)
 	if err != nil {
 		t.Fatal(err)
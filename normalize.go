@@ -0,0 +1,150 @@
+package bcl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A Normalizer rewrites the compiled body before Unmarshal decodes it into
+// the target. baseDir is Options.BaseDir, the directory of the config file
+// when decoding from a file. Normalizers run in the order of
+// Options.Normalizers; an error aborts the decode.
+type Normalizer func(body map[string]any, baseDir string) (map[string]any, error)
+
+// LowercaseKeys lowercases every map key. When two keys collide the one
+// that is already lowercase wins.
+func LowercaseKeys(body map[string]any, _ string) (map[string]any, error) {
+	return lowercaseKeys(body), nil
+}
+
+func lowercaseKeys(m map[string]any) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		lk := strings.ToLower(k)
+		if _, taken := out[lk]; taken && lk != k {
+			continue
+		}
+		out[lk] = mapListItems(v, func(x any) any {
+			if child, ok := x.(map[string]any); ok {
+				return lowercaseKeys(child)
+			}
+			return x
+		})
+	}
+	return out
+}
+
+// TrimStrings removes leading and trailing white space from every string.
+func TrimStrings(body map[string]any, _ string) (map[string]any, error) {
+	return rewriteStrings(body, "", func(_, s string) string { return strings.TrimSpace(s) }), nil
+}
+
+// ExpandHome replaces a leading "~" or "~/" in string values with the
+// current user's home directory.
+func ExpandHome(body map[string]any, _ string) (map[string]any, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	return rewriteStrings(body, "", func(_, s string) string {
+		if s == "~" {
+			return home
+		}
+		if strings.HasPrefix(s, "~/") {
+			return filepath.Join(home, s[2:])
+		}
+		return s
+	}), nil
+}
+
+// ResolvePaths returns a Normalizer that makes relative path strings
+// absolute against the config file's directory. patterns are dotted paths
+// whose segments may use glob syntax ("tls.cert", "storage.*.dir"); list
+// items share the path of their list.
+func ResolvePaths(patterns ...string) Normalizer {
+	pats := splitPatterns(patterns)
+	return func(body map[string]any, baseDir string) (map[string]any, error) {
+		if baseDir == "" {
+			return body, nil
+		}
+		return rewriteStrings(body, "", func(path, s string) string {
+			if s == "" || filepath.IsAbs(s) || strings.HasPrefix(s, "~") || !pathMatchesExactly(pats, path) {
+				return s
+			}
+			return filepath.Join(baseDir, s)
+		}), nil
+	}
+}
+
+func pathMatchesExactly(patterns [][]string, path string) bool {
+	p := strings.Split(path, ".")
+	for _, pat := range patterns {
+		if len(pat) == len(p) && segmentsMatch(pat, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteStrings returns a copy of m with fn applied to every string,
+// passing the dotted path of the value.
+func rewriteStrings(m map[string]any, prefix string, fn func(path, s string) string) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		p := joinPath(prefix, k)
+		out[k] = mapListItems(v, func(x any) any {
+			switch y := x.(type) {
+			case string:
+				return fn(p, y)
+			case map[string]any:
+				return rewriteStrings(y, p, fn)
+			}
+			return x
+		})
+	}
+	return out
+}
+
+// mapListItems applies fn to v, or to each item when v is a list.
+func mapListItems(v any, fn func(any) any) any {
+	items, ok := v.([]any)
+	if !ok {
+		return fn(v)
+	}
+	out := make([]any, len(items))
+	for i, item := range items {
+		out[i] = mapListItems(item, fn)
+	}
+	return out
+}
+
+// absPath makes p absolute, resolving a relative p against dir.
+func absPath(dir, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	p = filepath.Join(dir, p)
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+// sourceDirs returns a lookup from a value path to the directory of the file
+// that set it, falling back to baseDir when the origin is unknown.
+func sourceDirs(n *Normalized, baseDir string) func(path []string) string {
+	return func(path []string) string {
+		for i := len(path); i > 0; i-- {
+			o, ok := n.provenance[strings.Join(path[:i], ".")]
+			if !ok {
+				continue
+			}
+			if f := o.Span.File; f != "" && f != "<input>" {
+				return filepath.Dir(f)
+			}
+			break
+		}
+		return baseDir
+	}
+}
@@ -1,6 +1,8 @@
 package bcl
 
 import (
+	"fmt"
+	"math"
 	"os"
 	"strconv"
 	"strings"
@@ -19,7 +21,57 @@ func ParseFile(name string, src []byte) (*Document, error) {
 	if len(errs) > 0 {
 		return nil, errs
 	}
-	p := &parser{file: name, source: source, toks: toks}
+	return parseTokens(name, source, toks, nil)
+}
+
+// Parser parses a sequence of inputs while keeping its token buffer between
+// calls, for services that parse many small snippets (per-request rules).
+// Documents returned by Parse stay valid after Reset. A Parser is not safe
+// for concurrent use; keep one per goroutine or in a sync.Pool.
+type Parser struct {
+	// File names the input in spans and diagnostics; "<input>" when empty.
+	File string
+	// Arena allocates literal and assignment nodes in slabs shared by the
+	// document, trading a little retained memory for fewer allocations.
+	Arena bool
+
+	src  string
+	toks []token
+}
+
+func NewParser(input string) *Parser {
+	return &Parser{src: input}
+}
+
+// Reset replaces the input, keeping File and the buffers.
+func (p *Parser) Reset(input string) {
+	clear(p.toks)
+	p.toks = p.toks[:0]
+	p.src = input
+}
+
+func (p *Parser) Parse() (*Document, error) {
+	name := p.File
+	if name == "" {
+		name = "<input>"
+	}
+	if cap(p.toks) == 0 {
+		p.toks = make([]token, 0, estimatedTokenCount(len(p.src)))
+	}
+	toks, errs := lexStringInto(name, p.src, p.toks[:0])
+	p.toks = toks
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	var arena *nodeArena
+	if p.Arena {
+		arena = newNodeArena(len(toks))
+	}
+	return parseTokens(name, p.src, toks, arena)
+}
+
+func parseTokens(name, source string, toks []token, arena *nodeArena) (*Document, error) {
+	p := &parser{file: name, source: source, toks: toks, arena: arena}
 	doc := &Document{File: name}
 	doc.Items = p.parseNodes(tokEOF)
 	if len(p.errs) > 0 {
@@ -53,30 +105,10 @@ func ParseFileWithTrivia(name string, src []byte) (*TriviaDocument, error) {
 
 func collectCommentTrivia(file, src string) []Trivia {
 	var out []Trivia
-	l := &lexer{file: file, src: src, line: 1, col: 1}
-	for {
-		r := l.peek()
-		if r == 0 {
-			return out
-		}
-		if r == '#' || r == '/' && l.peekN(1) == '/' {
-			sp := l.spanAt()
-			start := l.pos
-			l.skipLine()
-			sp.End = l.posn()
-			out = append(out, Trivia{Text: src[start:l.pos], Span: sp})
-			continue
-		}
-		if r == '/' && l.peekN(1) == '*' {
-			sp := l.spanAt()
-			start := l.pos
-			_ = l.skipBlockComment()
-			sp.End = l.posn()
-			out = append(out, Trivia{Text: src[start:l.pos], Span: sp})
-			continue
-		}
-		l.advance()
+	for _, c := range scanComments(file, src).comments {
+		out = append(out, Trivia{Text: c.Text, Span: c.Span})
 	}
+	return out
 }
 
 func ParsePath(path string) (*Document, error) {
@@ -84,6 +116,9 @@ func ParsePath(path string) (*Document, error) {
 	if err != nil {
 		return nil, err
 	}
+	if IsEncrypted(b) {
+		return nil, fmt.Errorf("%s is encrypted; decrypt it with a key provider first", path)
+	}
 	return ParseFile(path, b)
 }
 
@@ -93,15 +128,24 @@ type parser struct {
 	toks   []token
 	pos    int
 	errs   ErrorList
+	arena  *nodeArena
+	// nested counts the bodies being parsed; zero at the top level.
+	nested int
 }
 
 func (p *parser) parseNodes(until tokenKind) []Node {
 	nodes := make([]Node, 0, p.nodeCapacity(until))
+	if until != tokEOF {
+		p.nested++
+	}
 	for {
 		p.skipNodeSeparators()
 		if p.peek().kind == until || p.peek().kind == tokEOF {
-			if until != tokEOF && p.peek().kind == until {
-				p.next()
+			if until != tokEOF {
+				p.nested--
+				if p.peek().kind == until {
+					p.next()
+				}
 			}
 			return nodes
 		}
@@ -140,11 +184,23 @@ func (p *parser) parseNode() Node {
 	if t.kind == tokOperator && t.text == "&" {
 		return p.parseSpread()
 	}
+	if t.kind == tokOperator && t.text == "@" && p.peekN(1).kind == tokIdent {
+		return p.parseDirective()
+	}
 	if t.kind == tokLBrace {
 		lb := p.next()
 		body := p.parseNodes(tokRBrace)
 		return &Object{Fields: body, Span: lb.span}
 	}
+	if t.kind == tokOperator && t.text == "!" && p.peekN(1).kind == tokIdent {
+		switch p.peekN(2).kind {
+		case tokNewline, tokRBrace, tokEOF:
+			bang := p.next()
+			name := p.next()
+			sp := spanJoin(bang.span, name.span)
+			return &Assignment{Name: intern(name.text), Value: &Literal{Type: "bool", Raw: "!", Data: false, Span: sp}, Span: sp}
+		}
+	}
 	if t.kind != tokIdent && t.kind != tokString && t.kind != tokNumber {
 		p.error(t, "expected declaration, assignment, or block")
 		return nil
@@ -155,19 +211,20 @@ func (p *parser) parseNode() Node {
 			return &Assignment{Name: name.text, Value: &Reference{Path: "", Span: name.span}, Span: name.span}
 		}
 		v := p.parseValueUntilLine()
-		return &Assignment{Name: name.text, Value: v, Span: spanJoin(name.span, v.GetSpan())}
+		return p.assign(Assignment{Name: name.text, Value: v, Span: spanJoin(name.span, v.GetSpan())})
 	}
 	if t.kind == tokString {
 		name := p.next()
+		name.text = intern(name.text)
 		if p.peek().kind == tokLBrace {
 			lb := p.next()
-			return &Assignment{Name: name.text, Value: &Object{Fields: p.parseNodes(tokRBrace), Span: spanJoin(name.span, lb.span)}, Span: name.span}
+			return &Assignment{Name: name.text, Quoted: true, Value: &Object{Fields: p.parseNodes(tokRBrace), Span: spanJoin(name.span, lb.span)}, Span: name.span}
 		}
 		if p.peek().kind == tokNewline || p.peek().kind == tokRBrace || p.peek().kind == tokEOF {
-			return &Assignment{Name: name.text, Value: &Literal{Type: "string", Data: name.text, Span: name.span}, Span: name.span}
+			return &Assignment{Name: name.text, Quoted: true, Value: &Literal{Type: "string", Data: name.text, Span: name.span}, Span: name.span}
 		}
 		v := p.parseValueUntilLine()
-		return &Assignment{Name: name.text, Value: v, Span: spanJoin(name.span, v.GetSpan())}
+		return p.assign(Assignment{Name: name.text, Quoted: true, Value: v, Span: spanJoin(name.span, v.GetSpan())})
 	}
 	switch t.text {
 	case "import":
@@ -204,9 +261,18 @@ func (p *parser) parseNode() Node {
 		bodyStart := p.next()
 		return &Block{Type: "use", ID: targetType.text + "." + targetID.text, Body: p.parseNodes(tokRBrace), Span: spanJoin(name.span, bodyStart.span)}
 	}
-	if name.text == "when" && p.peek().kind != tokLBrace {
+	if name.text == "when" && p.peek().kind != tokLBrace && p.peek().kind != tokEqual {
 		return p.parseConditionalBlock(name)
 	}
+	if (name.text == "if" || name.text == "IF") && p.peek().kind != tokLBrace && p.peek().kind != tokEqual {
+		return p.parseIfChain(name)
+	}
+	if name.text == "for" && p.forLoopAhead() {
+		return p.parseForLoop(name)
+	}
+	if name.text == "func" && p.peek().kind == tokIdent && p.peekN(1).kind == tokLParen {
+		return p.parseFuncDecl(name)
+	}
 	if p.peek().kind == tokLParen {
 		return p.parseExprNode(name)
 	}
@@ -248,7 +314,9 @@ func (p *parser) parseNode() Node {
 	if p.peek().kind == tokLBrace {
 		lb := p.next()
 		body := p.parseNodes(tokRBrace)
-		if isKnownBlock(name.text) || isCapitalizedBlockName(name.text) {
+		// A top-level `defaults { ... }` holds block defaults; elsewhere,
+		// and written `defaults = { ... }`, it is an ordinary key.
+		if isKnownBlock(name.text) || isCapitalizedBlockName(name.text) || name.text == "defaults" && p.nested == 0 {
 			return &Block{Type: name.text, Body: body, Span: spanJoin(name.span, lb.span)}
 		}
 		return &Assignment{Name: name.text, Value: blockValue(name.text, body, spanJoin(name.span, lb.span)), Span: name.span}
@@ -263,7 +331,7 @@ func (p *parser) parseNode() Node {
 		return &Assignment{Name: name.text, Value: &Reference{Path: "", Span: name.span}, Span: name.span}
 	}
 	v := p.parseValueUntilLine()
-	return &Assignment{Name: name.text, Value: v, Span: spanJoin(name.span, v.GetSpan())}
+	return p.assign(Assignment{Name: name.text, Value: v, Span: spanJoin(name.span, v.GetSpan())})
 }
 
 func (p *parser) parseSpread() Node {
@@ -282,6 +350,23 @@ func (p *parser) parseSpread() Node {
 	return &Spread{Target: target.text, Body: body, Span: sp}
 }
 
+func (p *parser) parseDirective() Node {
+	at := p.next()
+	name := p.next()
+	d := &Directive{Name: name.text, Span: spanJoin(at.span, name.span)}
+	if p.peek().kind == tokLParen {
+		call := p.parseCall(name).(*Call)
+		d.Args = call.Args
+		d.Span = spanJoin(d.Span, call.Span)
+	}
+	if p.peek().kind == tokLBrace {
+		lb := p.next()
+		d.Body = p.parseNodes(tokRBrace)
+		d.Span = spanJoin(d.Span, lb.span)
+	}
+	return d
+}
+
 func (p *parser) parseSpreadTarget() token {
 	t := p.peek()
 	if t.kind != tokIdent && t.kind != tokString && t.kind != tokNumber {
@@ -312,6 +397,12 @@ func (p *parser) parseSpreadTarget() token {
 }
 
 func (p *parser) parseConditionalBlock(first token) Node {
+	cond, bodyStart := p.parseConditionHead(first)
+	body := p.parseNodes(tokRBrace)
+	return &Block{Type: first.text, ID: cond.Raw, Body: body, Span: spanJoin(first.span, bodyStart.span)}
+}
+
+func (p *parser) parseConditionHead(first token) (*Expr, token) {
 	start := first.span
 	depth := 0
 	rawStart := first.span.End.Offset
@@ -330,9 +421,102 @@ func (p *parser) parseConditionalBlock(first token) Node {
 		rawEnd = p.next().span.End.Offset
 	}
 	bodyStart := p.expect(tokLBrace, "expected conditional block body")
-	body := p.parseNodes(tokRBrace)
-	cond := &Expr{Raw: p.rawExpr(rawStart, rawEnd), Span: spanJoin(start, bodyStart.span)}
-	return &Block{Type: first.text, ID: cond.Raw, Body: body, Span: spanJoin(start, bodyStart.span)}
+	return &Expr{Raw: p.rawExpr(rawStart, rawEnd), Span: spanJoin(start, bodyStart.span)}, bodyStart
+}
+
+// forLoopAhead reports whether `for` starts a loop header: a variable, or
+// two separated by a comma, followed by `in`.
+func (p *parser) forLoopAhead() bool {
+	if p.peek().kind != tokIdent {
+		return false
+	}
+	if p.peekN(1).kind == tokIdent && p.peekN(1).text == "in" {
+		return true
+	}
+	return p.peekN(1).kind == tokComma && p.peekN(2).kind == tokIdent && p.peekN(3).kind == tokIdent && p.peekN(3).text == "in"
+}
+
+func (p *parser) parseForLoop(first token) Node {
+	loop := &ForLoop{Value: p.next().text}
+	if p.peek().kind == tokComma {
+		p.next()
+		loop.Key, loop.Value = loop.Value, p.next().text
+	}
+	in := p.next()
+	loop.In, _ = p.parseConditionHead(in)
+	loop.Body = p.parseNodes(tokRBrace)
+	loop.Span = spanJoin(first.span, loop.In.Span)
+	return loop
+}
+
+// parseFuncDecl reads `func name(a, b) { return expr }`; the body is a
+// single return expression.
+func (p *parser) parseFuncDecl(first token) Node {
+	fn := &FuncDecl{Name: intern(p.next().text)}
+	p.next()
+	for p.peek().kind != tokRParen {
+		param := p.expect(tokIdent, "expected parameter name")
+		if param.kind != tokIdent {
+			return nil
+		}
+		fn.Params = append(fn.Params, param.text)
+		if p.peek().kind == tokComma {
+			p.next()
+		}
+	}
+	p.next()
+	p.expect(tokLBrace, "expected function body")
+	p.skipNewlines()
+	if t := p.peek(); t.kind != tokIdent || t.text != "return" {
+		p.error(t, "function body must be `return <expression>`")
+		return nil
+	}
+	ret := p.next()
+	fn.Body, _ = p.parseExprLine().(*Expr)
+	if fn.Body == nil || fn.Body.Raw == "" {
+		p.error(ret, "return requires an expression")
+		return nil
+	}
+	p.skipNewlines()
+	end := p.expect(tokRBrace, "expected } after return expression")
+	fn.Span = spanJoin(first.span, end.span)
+	return fn
+}
+
+func (p *parser) parseIfChain(first token) Node {
+	chain := &IfChain{Span: first.span}
+	cond, _ := p.parseConditionHead(first)
+	chain.Branches = append(chain.Branches, IfBranch{Cond: cond, Body: p.parseNodes(tokRBrace)})
+	for {
+		i := p.pos
+		for i < len(p.toks) && p.toks[i].kind == tokNewline {
+			i++
+		}
+		if i >= len(p.toks) || p.toks[i].kind != tokIdent {
+			return chain
+		}
+		switch p.toks[i].text {
+		case "elseif", "ELSEIF", "elif":
+			p.pos = i
+			kw := p.next()
+			cond, _ := p.parseConditionHead(kw)
+			chain.Branches = append(chain.Branches, IfBranch{Cond: cond, Body: p.parseNodes(tokRBrace)})
+		case "else", "ELSE":
+			p.pos = i
+			p.next()
+			if t := p.peek(); t.kind == tokIdent && (t.text == "if" || t.text == "IF") {
+				kw := p.next()
+				cond, _ := p.parseConditionHead(kw)
+				chain.Branches = append(chain.Branches, IfBranch{Cond: cond, Body: p.parseNodes(tokRBrace)})
+				continue
+			}
+			p.expect(tokLBrace, "expected else block body")
+			chain.Else = p.parseNodes(tokRBrace)
+			return chain
+		default:
+			return chain
+		}
+	}
 }
 
 func (p *parser) startsExpressionAfterName() bool {
@@ -376,7 +560,7 @@ func (p *parser) parseDottedAssignment(first token) Node {
 		p.next()
 	}
 	v := p.parseValueUntilLine()
-	return &Assignment{Name: name, Value: v, Span: spanJoin(first.span, v.GetSpan())}
+	return p.assign(Assignment{Name: name, Value: v, Span: spanJoin(first.span, v.GetSpan())})
 }
 
 func (p *parser) parseExprNode(first token) Node {
@@ -521,6 +705,22 @@ func (p *parser) parseSchema() Node {
 			p.parseLegacySchemaTypeClause(s, fieldsByName)
 			continue
 		}
+		if p.peek().kind == tokIdent && p.peekN(1).kind == tokLBracket {
+			switch p.peek().text {
+			case "all_of":
+				p.next()
+				s.AllOf = p.schemaTypeListClause()
+				continue
+			case "any_of":
+				p.next()
+				s.AnyOf = p.schemaTypeListClause()
+				continue
+			case "one_of":
+				p.next()
+				s.OneOf = p.schemaTypeListClause()
+				continue
+			}
+		}
 		if p.peek().kind == tokIdent && p.peekN(1).kind == tokLBrace {
 			name := p.next()
 			p.next()
@@ -671,6 +871,9 @@ func mergeSchemaField(base, next SchemaField) SchemaField {
 	if len(next.Enum) > 0 {
 		base.Enum = next.Enum
 	}
+	if len(next.SubsetOf) > 0 {
+		base.SubsetOf = next.SubsetOf
+	}
 	if len(next.Fields) > 0 {
 		base.Fields = next.Fields
 	}
@@ -745,6 +948,9 @@ func mergeSchemaField(base, next SchemaField) SchemaField {
 	if next.Format != "" {
 		base.Format = next.Format
 	}
+	if len(next.Schemes) > 0 {
+		base.Schemes = next.Schemes
+	}
 	if next.ContentEncoding != "" {
 		base.ContentEncoding = next.ContentEncoding
 	}
@@ -876,6 +1082,11 @@ func (p *parser) parseSchemaField() SchemaField {
 			if l, ok := p.parseValue().(*List); ok {
 				field.Enum = l.Items
 			}
+		case "subset_of":
+			p.next()
+			if l, ok := p.parseValue().(*List); ok {
+				field.SubsetOf = l.Items
+			}
 		case "default":
 			p.next()
 			field.Default = p.parseValue()
@@ -960,6 +1171,9 @@ func (p *parser) parseSchemaField() SchemaField {
 		case "format":
 			p.next()
 			field.Format = p.schemaStringClause()
+		case "schemes":
+			p.next()
+			field.Schemes = p.schemaTypeListClause()
 		case "content_encoding":
 			p.next()
 			field.ContentEncoding = p.schemaStringClause()
@@ -983,6 +1197,9 @@ func (p *parser) parseSchemaField() SchemaField {
 		case "pattern_properties":
 			p.next()
 			field.PatternProperties = p.parseValue()
+		case "key_pattern":
+			p.next()
+			field.KeyPattern = p.schemaStringClause()
 		case "dependent_required":
 			p.next()
 			field.DependentRequired = p.parseValue()
@@ -1105,6 +1322,11 @@ func (p *parser) parseSchemaFieldBlockClause(field *SchemaField) bool {
 		if l, ok := p.parseValue().(*List); ok {
 			field.Enum = l.Items
 		}
+	case "subset_of":
+		p.next()
+		if l, ok := p.parseValue().(*List); ok {
+			field.SubsetOf = l.Items
+		}
 	case "default":
 		p.next()
 		field.Default = p.parseValue()
@@ -1189,6 +1411,9 @@ func (p *parser) parseSchemaFieldBlockClause(field *SchemaField) bool {
 	case "format":
 		p.next()
 		field.Format = p.schemaStringClause()
+	case "schemes":
+		p.next()
+		field.Schemes = p.schemaTypeListClause()
 	case "content_encoding":
 		p.next()
 		field.ContentEncoding = p.schemaStringClause()
@@ -1212,6 +1437,9 @@ func (p *parser) parseSchemaFieldBlockClause(field *SchemaField) bool {
 	case "pattern_properties":
 		p.next()
 		field.PatternProperties = p.parseValue()
+	case "key_pattern":
+		p.next()
+		field.KeyPattern = p.schemaStringClause()
 	case "dependent_required":
 		p.next()
 		field.DependentRequired = p.parseValue()
@@ -1360,7 +1588,7 @@ func (p *parser) parseSchemaType() string {
 
 func isSchemaClause(s string) bool {
 	switch s {
-	case "required", "optional", "ref", "const", "enum", "default", "description", "doc", "title", "deprecated", "sensitive", "generated", "derived", "read_only", "write_only", "nullable", "unique_items", "closed", "additional_properties", "min", "max", "exclusive_min", "exclusive_max", "multiple_of", "min_len", "max_len", "min_items", "max_items", "min_props", "max_props", "pattern", "format", "content_encoding", "content_media_type", "examples", "items", "prefix_items", "contains", "pattern_properties", "dependent_required", "lt_field", "lte_field", "gt_field", "eq_field", "all_of", "any_of", "one_of", "not", "if", "then", "else", "classification", "audit", "explain", "pii", "policy_tag", "owner", "severity":
+	case "required", "optional", "ref", "const", "enum", "subset_of", "default", "description", "doc", "title", "deprecated", "sensitive", "generated", "derived", "read_only", "write_only", "nullable", "unique_items", "closed", "additional_properties", "min", "max", "exclusive_min", "exclusive_max", "multiple_of", "min_len", "max_len", "min_items", "max_items", "min_props", "max_props", "pattern", "format", "schemes", "content_encoding", "content_media_type", "examples", "items", "prefix_items", "contains", "pattern_properties", "key_pattern", "dependent_required", "lt_field", "lte_field", "gt_field", "eq_field", "all_of", "any_of", "one_of", "not", "if", "then", "else", "classification", "audit", "explain", "pii", "policy_tag", "owner", "severity":
 		return true
 	default:
 		if strings.HasPrefix(s, "x_") {
@@ -1381,25 +1609,40 @@ func (p *parser) parseValue() Value {
 	t := p.next()
 	switch t.kind {
 	case tokString, tokHeredoc:
-		return &Literal{Type: "string", Data: t.text, Span: t.span}
+		return p.lit(Literal{Type: "string", Data: t.text, Span: t.span})
 	case tokNumber:
-		return parseNumber(t)
+		return p.lit(numberLiteral(t))
 	case tokIdent:
+		start := p.pos
 		path := p.collectRef(t)
 		switch t.text {
 		case "true":
-			return &Literal{Type: "bool", Data: true, Span: t.span}
+			return p.lit(Literal{Type: "bool", Data: true, Span: t.span})
 		case "false":
-			return &Literal{Type: "bool", Data: false, Span: t.span}
+			return p.lit(Literal{Type: "bool", Data: false, Span: t.span})
 		case "null":
-			return &Literal{Type: "null", Data: nil, Span: t.span}
+			return p.lit(Literal{Type: "null", Data: nil, Span: t.span})
 		}
 		if p.peek().kind == tokLParen {
 			t.text = path
-			return p.parseCall(t)
+			call := p.parseCall(t)
+			if isLazyCall(path) || path == "format" {
+				// Arguments of cond/try/coalesce resolve missing values to null and
+				// only run when selected, which the expression evaluator handles;
+				// format arguments name variables like any expression.
+				sp := call.(*Call).Span
+				return &Expr{Raw: p.rawExpr(sp.Start.Offset, sp.End.Offset), Span: sp}
+			}
+			return call
 		}
 		if path == t.text && !looksConstantName(path) {
-			return &Literal{Type: "identifier", Data: path, Span: t.span}
+			return p.lit(Literal{Type: "identifier", Data: path, Span: t.span})
+		}
+		if nullSafe(p.toks[start:p.pos]) {
+			// a?.b is evaluated, so a missing a gives null rather than a
+			// reference left unresolved.
+			end := p.toks[p.pos-1].span
+			return &Expr{Raw: p.rawExpr(t.span.Start.Offset, end.End.Offset), Span: spanJoin(t.span, end)}
 		}
 		return &Reference{Path: path, Span: t.span}
 	case tokLBracket:
@@ -1409,10 +1652,23 @@ func (p *parser) parseValue() Value {
 		return &Object{Fields: body, Span: t.span}
 	default:
 		p.error(t, "expected value")
-		return &Literal{Type: "null", Data: nil, Span: t.span}
+		return p.lit(Literal{Type: "null", Data: nil, Span: t.span})
 	}
 }
 
+func nullSafe(toks []token) bool {
+	for _, t := range toks {
+		if t.kind == tokDot && t.text == "?." {
+			return true
+		}
+	}
+	return false
+}
+
+func isLazyCall(name string) bool {
+	return name == "cond" || name == "try" || name == "coalesce"
+}
+
 func (p *parser) parseList(start token) Value {
 	items := make([]Value, 0, 4)
 	for {
@@ -1443,7 +1699,11 @@ func (p *parser) parseCall(name token) Value {
 			call.Span = spanJoin(call.Span, p.next().span)
 			return call
 		}
-		call.Args = append(call.Args, p.parseValue())
+		if p.isExpressionArg() {
+			call.Args = append(call.Args, p.parseExprArg())
+		} else {
+			call.Args = append(call.Args, p.parseValue())
+		}
 		p.skipNewlines()
 		if p.peek().kind == tokComma {
 			p.next()
@@ -1451,14 +1711,44 @@ func (p *parser) parseCall(name token) Value {
 	}
 }
 
+// isExpressionArg reports whether the next call argument contains an operator,
+// as in assert(port > 1024, "..."), and must be kept as an expression.
+func (p *parser) isExpressionArg() bool {
+	depth := 0
+	for i := p.pos; i < len(p.toks); i++ {
+		t := p.toks[i]
+		if t.kind == tokEOF || (depth == 0 && (t.kind == tokComma || t.kind == tokRParen || t.kind == tokNewline)) {
+			return false
+		}
+		if depth == 0 && t.kind == tokOperator && i > p.pos {
+			return true
+		}
+		switch t.kind {
+		case tokLParen, tokLBracket, tokLBrace:
+			depth++
+		case tokRParen, tokRBracket, tokRBrace:
+			depth--
+		}
+	}
+	return false
+}
+
+func (p *parser) parseExprArg() Value {
+	return p.parseExprUntil(func(t token) bool { return t.kind == tokComma || t.kind == tokRParen || t.kind == tokNewline })
+}
+
 func (p *parser) parseExprLine() Value {
+	return p.parseExprUntil(func(t token) bool { return t.kind == tokNewline || t.kind == tokRBrace })
+}
+
+func (p *parser) parseExprUntil(stop func(token) bool) Value {
 	start := p.peek().span
 	depth := 0
 	rawStart := start.Start.Offset
 	rawEnd := start.End.Offset
 	for {
 		t := p.peek()
-		if t.kind == tokEOF || (depth == 0 && (t.kind == tokNewline || t.kind == tokRBrace)) {
+		if t.kind == tokEOF || (depth == 0 && stop(t)) {
 			break
 		}
 		if t.kind == tokLBrace || t.kind == tokLBracket || t.kind == tokLParen {
@@ -1489,6 +1779,14 @@ func (p *parser) isExpressionLine() bool {
 		if depth == 0 && (t.kind == tokOperator || isExprOperator(t.text)) {
 			return true
 		}
+		// `*` lexes as a wildcard identifier; between two operands it
+		// multiplies, as in "-" * 40.
+		if depth == 0 && t.kind == tokIdent && t.text == "*" && i > p.pos && i+1 < len(p.toks) {
+			switch p.toks[i+1].kind {
+			case tokNumber, tokString, tokIdent, tokLParen:
+				return true
+			}
+		}
 		if t.kind == tokLBracket || t.kind == tokLParen {
 			depth++
 		}
@@ -1565,35 +1863,62 @@ func buildCondition(op string, body []Node, sp Span) Value {
 }
 
 func parseNumber(t token) Value {
+	l := numberLiteral(t)
+	return &l
+}
+
+func numberLiteral(t token) Literal {
 	raw := t.text
 	if looksDateTime(raw) {
 		typ := "date"
 		if strings.Contains(raw, "T") || strings.Count(raw, ":") > 0 {
 			typ = "datetime"
 		}
-		return &Literal{Type: typ, Raw: raw, Data: raw, Span: t.span}
+		return Literal{Type: typ, Raw: raw, Data: raw, Span: t.span}
+	}
+	if radixLiteral(raw) {
+		if i, err := strconv.ParseInt(raw, 0, 64); err == nil {
+			return Literal{Type: "int", Raw: raw, Data: i, Span: t.span}
+		}
+		u, _ := strconv.ParseUint(strings.TrimPrefix(raw, "-"), 0, 64)
+		if strings.HasPrefix(raw, "-") {
+			return Literal{Type: "float", Raw: raw, Data: -float64(u), Span: t.span}
+		}
+		return Literal{Type: "int", Raw: raw, Data: u, Span: t.span}
 	}
-	unitStart := len(raw)
-	for i, r := range raw {
+	// Raw keeps the digit separators for the formatter; the value does not.
+	text := strings.ReplaceAll(raw, "_", "")
+	unitStart := len(text)
+	for i, r := range text {
 		if i > 0 && (r < '0' || r > '9') && r != '.' {
 			unitStart = i
 			break
 		}
 	}
-	num, unit := raw[:unitStart], raw[unitStart:]
+	num, unit := text[:unitStart], text[unitStart:]
 	if unit != "" {
 		typ := "duration"
 		if isByteUnit(unit) {
 			typ = "bytes"
 		}
-		return &Literal{Type: typ, Raw: raw, Data: raw, Span: t.span}
+		return Literal{Type: typ, Raw: raw, Data: text, Span: t.span}
 	}
 	if strings.Contains(num, ".") {
 		f, _ := strconv.ParseFloat(num, 64)
-		return &Literal{Type: "float", Raw: raw, Data: f, Span: t.span}
+		return Literal{Type: "float", Raw: raw, Data: f, Span: t.span}
+	}
+	i, err := strconv.ParseInt(num, 10, 64)
+	if err != nil {
+		// Past the int64 range, keep the value as uint64 or, failing
+		// that, as a float rather than clamping it.
+		if u, err := strconv.ParseUint(num, 10, 64); err == nil {
+			return Literal{Type: "int", Raw: raw, Data: u, Span: t.span}
+		}
+		if f, err := strconv.ParseFloat(num, 64); err == nil {
+			return Literal{Type: "float", Raw: raw, Data: f, Span: t.span}
+		}
 	}
-	i, _ := strconv.ParseInt(num, 10, 64)
-	return &Literal{Type: "int", Raw: raw, Data: i, Span: t.span}
+	return Literal{Type: "int", Raw: raw, Data: i, Span: t.span}
 }
 
 func looksDateTime(s string) bool {
@@ -1702,13 +2027,16 @@ func spanJoin(a Span, b Span) Span {
 	return a
 }
 
+var exprOperatorWords = []string{
+	"in", "not_in", "contains", "starts_with", "ends_with", "matches", "has", "has_any", "has_all",
+	"between", "exists", "empty", "equals", "greater_than", "less_than", "greater_or_equal",
+	"less_or_equal", "to", "and", "or",
+}
+
+var exprOperatorSet = nameSet(exprOperatorWords)
+
 func isExprOperator(s string) bool {
-	switch s {
-	case "in", "not_in", "contains", "starts_with", "ends_with", "matches", "has", "has_any", "has_all", "between", "exists", "empty", "equals", "greater_than", "less_than", "greater_or_equal", "less_or_equal", "to", "and", "or":
-		return true
-	default:
-		return false
-	}
+	return exprOperatorSet[s]
 }
 
 func isCommandStatement(s string) bool {
@@ -1720,13 +2048,15 @@ func isCommandStatement(s string) bool {
 	}
 }
 
+var knownBlockNames = []string{
+	"bcl", "namespace", "profile", "module", "override", "runtime", "evaluation", "audit", "context",
+	"session", "all", "any", "not", "none",
+}
+
+var knownBlockSet = nameSet(knownBlockNames)
+
 func isKnownBlock(s string) bool {
-	switch s {
-	case "bcl", "namespace", "profile", "module", "override", "runtime", "evaluation", "audit", "context", "session", "all", "any", "not", "none":
-		return true
-	default:
-		return false
-	}
+	return knownBlockSet[s]
 }
 
 func isCapitalizedBlockName(s string) bool {
@@ -1746,3 +2076,35 @@ func isByteUnit(s string) bool {
 		return false
 	}
 }
+
+// byteSize returns the byte count of a size such as 10MB, 2GiB or 1.5KB.
+// KB, MB, GB and TB are binary multiples like KiB, MiB, GiB and TiB, the
+// way server configs read them, so 10MB is 10485760. Sizes that are not a
+// whole number of bytes are rejected.
+func byteSize(raw string) (int64, bool) {
+	raw = strings.TrimSpace(raw)
+	i := strings.IndexFunc(raw, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i <= 0 || !isByteUnit(raw[i:]) {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(raw[:i], 64)
+	if err != nil {
+		return 0, false
+	}
+	shift := 0
+	switch raw[i] {
+	case 'K', 'k':
+		shift = 10
+	case 'M', 'm':
+		shift = 20
+	case 'G', 'g':
+		shift = 30
+	case 'T', 't':
+		shift = 40
+	}
+	size := n * float64(int64(1)<<shift)
+	if size != math.Trunc(size) || size >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(size), true
+}
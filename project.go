@@ -0,0 +1,117 @@
+package bcl
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ProjectFile is the manifest LoadProject reads from a project directory.
+const ProjectFile = "bcl.project"
+
+// Project describes a multi-file config project so the CLI, the language
+// server and applications agree on what to load. The manifest is BCL:
+//
+//	entrypoints   ["main.bcl"]
+//	include_roots ["shared"]
+//	var_files     [".env"]
+//	schema_files  ["schemas/app.schema"]
+//	lint {
+//	  strict             true
+//	  ignore             ["missing bcl version declaration"]
+//	  warnings_as_errors false
+//	}
+//
+// Relative paths are resolved against the project directory.
+type Project struct {
+	Dir          string      `bcl:"-" json:"dir"`
+	Entrypoints  []string    `bcl:"entrypoints,path" json:"entrypoints"`
+	IncludeRoots []string    `bcl:"include_roots,path" json:"include_roots,omitempty"`
+	VarFiles     []string    `bcl:"var_files,path" json:"var_files,omitempty"`
+	SchemaFiles  []string    `bcl:"schema_files,path" json:"schema_files,omitempty"`
+	Lint         ProjectLint `bcl:"lint" json:"lint"`
+}
+
+// ProjectLint holds the lint settings of a project. Ignore drops
+// diagnostics whose message contains any of its entries.
+type ProjectLint struct {
+	Strict           bool     `bcl:"strict" json:"strict,omitempty"`
+	Ignore           []string `bcl:"ignore" json:"ignore,omitempty"`
+	WarningsAsErrors bool     `bcl:"warnings_as_errors" json:"warnings_as_errors,omitempty"`
+}
+
+// LoadProject reads dir/bcl.project. A project without entrypoints defaults
+// to main.bcl.
+func LoadProject(dir string) (*Project, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	p := &Project{Dir: abs}
+	path := filepath.Join(abs, ProjectFile)
+	if err := DecodeFileWithOptions(path, p, &Options{BaseDir: abs, ResolvePathFields: true}); err != nil {
+		return nil, fmt.Errorf("load project: %w", err)
+	}
+	if len(p.Entrypoints) == 0 {
+		p.Entrypoints = []string{filepath.Join(abs, "main.bcl")}
+	}
+	return p, nil
+}
+
+// Options returns compile options for the project's entrypoints. BaseDir is
+// left to each entrypoint's own directory.
+func (p *Project) Options() *Options {
+	return &Options{
+		ResolveImports: true,
+		ResolveModules: true,
+		IncludeRoots:   append([]string(nil), p.IncludeRoots...),
+		EnvFiles:       append([]string(nil), p.VarFiles...),
+		Strict:         p.Lint.Strict,
+	}
+}
+
+// Document parses one entrypoint with the declarations of the project's
+// schema files prepended, so its blocks are checked against them.
+func (p *Project) Document(entrypoint string) (*Document, error) {
+	doc, err := ParsePath(entrypoint)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.SchemaFiles) == 0 {
+		return doc, nil
+	}
+	var items []Node
+	for _, path := range p.SchemaFiles {
+		schema, err := ParsePath(path)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, schema.Items...)
+	}
+	doc.Items = append(items, doc.Items...)
+	return doc, nil
+}
+
+// Apply filters diags through the lint settings.
+func (l ProjectLint) Apply(diags []Diagnostic) []Diagnostic {
+	out := diags[:0:0]
+	for _, d := range diags {
+		if l.ignores(d.Message) {
+			continue
+		}
+		if l.WarningsAsErrors && d.Severity == "warning" {
+			d.Severity = "error"
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+func (l ProjectLint) ignores(msg string) bool {
+	for _, s := range l.Ignore {
+		if s != "" && strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package bcl
+
+import "strings"
+
+// ValueOrigin describes which layer supplied a value of a compiled config.
+// Layer is "document", "import", "profile <id>", "override" or
+// "override <target>"; Span points at the assignment or block that set it.
+type ValueOrigin struct {
+	Layer string `json:"layer"`
+	Span  Span   `json:"span"`
+}
+
+// Provenance returns the origin of every value path ("log_level",
+// "db.host", "server.web.port") of a config compiled with
+// Options.TrackProvenance. Later layers replace the origin of the paths they
+// set, so the map answers which file, profile or override produced each
+// final value. It returns nil when provenance was not tracked.
+func Provenance(n *Normalized) map[string]ValueOrigin {
+	if n == nil || n.provenance == nil {
+		return nil
+	}
+	out := make(map[string]ValueOrigin, len(n.provenance))
+	for k, v := range n.provenance {
+		out[k] = v
+	}
+	return out
+}
+
+func (c *compiler) origin(layer string, sp Span) ValueOrigin {
+	if layer == "document" && sp.File != "" && sp.File != c.file {
+		layer = "import"
+	}
+	return ValueOrigin{Layer: layer, Span: sp}
+}
+
+func (c *compiler) blockOrigin(i int, layer string) ValueOrigin {
+	var sp Span
+	if i < len(c.blockSpans) {
+		sp = c.blockSpans[i]
+	}
+	return c.origin(layer, sp)
+}
+
+// traceSet records v as replacing path and everything below it.
+func (c *compiler) traceSet(path string, v any, o ValueOrigin) {
+	if c.out.provenance == nil || path == "" {
+		return
+	}
+	c.traceDrop(path)
+	c.out.provenance[path] = o
+	if m, ok := v.(map[string]any); ok {
+		for k, fv := range m {
+			c.traceSet(path+"."+k, fv, o)
+		}
+	}
+}
+
+// traceMerge mirrors mergeMap: nil entries delete, maps merge and other
+// values replace.
+func (c *compiler) traceMerge(path string, src map[string]any, o ValueOrigin) {
+	if c.out.provenance == nil {
+		return
+	}
+	for k, v := range src {
+		p := k
+		if path != "" {
+			p = path + "." + k
+		}
+		switch x := v.(type) {
+		case nil:
+			c.traceDrop(p)
+		case map[string]any:
+			c.out.provenance[p] = o
+			c.traceMerge(p, x, o)
+		default:
+			c.traceSet(p, v, o)
+		}
+	}
+}
+
+func (c *compiler) traceDrop(path string) {
+	delete(c.out.provenance, path)
+	prefix := path + "."
+	for k := range c.out.provenance {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.out.provenance, k)
+		}
+	}
+}
+
+func blockPath(b map[string]any) string {
+	kind, _ := b["type"].(string)
+	if id, ok := b["id"].(string); ok && id != "" {
+		return kind + "." + id
+	}
+	return kind
+}
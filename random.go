@@ -0,0 +1,85 @@
+package bcl
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+)
+
+const defaultRandomCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// Generator backs random_int, random_string and sequence. A generator built
+// with a seed yields the same values for the same evaluation order, which
+// keeps plan and apply runs (and tests) stable.
+type Generator struct {
+	mu   sync.Mutex
+	rng  *rand.Rand
+	seqs map[string]int64
+}
+
+func NewGenerator(seed int64) *Generator {
+	return &Generator{rng: rand.New(rand.NewPCG(uint64(seed), uint64(seed)^0x9e3779b97f4a7c15)), seqs: map[string]int64{}}
+}
+
+func newRandomGenerator() *Generator {
+	return &Generator{rng: rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())), seqs: map[string]int64{}}
+}
+
+func (g *Generator) Int(min, max int64) (int64, error) {
+	if max < min {
+		return 0, fmt.Errorf("random_int max %d is less than min %d", max, min)
+	}
+	span := uint64(max-min) + 1
+	if g == nil {
+		if span == 0 {
+			return int64(rand.Uint64()), nil
+		}
+		return min + int64(rand.Uint64N(span)), nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if span == 0 {
+		return int64(g.rng.Uint64()), nil
+	}
+	return min + int64(g.rng.Uint64N(span)), nil
+}
+
+func (g *Generator) String(n int, charset string) (string, error) {
+	if n < 0 {
+		return "", fmt.Errorf("random_string length must not be negative")
+	}
+	if n > maxGeneratedItems {
+		return "", fmt.Errorf("random_string length %d exceeds limit of %d", n, maxGeneratedItems)
+	}
+	if charset == "" {
+		charset = defaultRandomCharset
+	}
+	chars := []rune(charset)
+	out := make([]rune, n)
+	if g != nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+	}
+	for i := range out {
+		if g == nil {
+			out[i] = chars[rand.IntN(len(chars))]
+		} else {
+			out[i] = chars[g.rng.IntN(len(chars))]
+		}
+	}
+	return string(out), nil
+}
+
+// Next returns start, start+step, start+2*step, ... on successive calls with
+// the same start and step. Without a generator every call returns start.
+func (g *Generator) Next(start, step int64) int64 {
+	if g == nil {
+		return start
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := fmt.Sprintf("%d:%d", start, step)
+	n := g.seqs[key]
+	g.seqs[key] = n + 1
+	return start + n*step
+}
@@ -0,0 +1,261 @@
+package bcl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Evaluation order: Compile first collects every declaration (constants,
+// schemas, top-level keys and labeled blocks), then evaluates values in
+// document order. A value that needs a top-level key which has not been
+// emitted yet evaluates that key on demand, so later declarations are
+// visible to earlier ones; each key is evaluated at most once. This covers
+// ref(), app.key and config.app.key in expressions and bare top-level names
+// inside expressions.
+//
+// ref("path.to.value") reads another value of the document regardless of
+// where it is declared. The first path segment names a top-level key, a
+// constant, or together with the second segment a labeled block
+// ("server.web.port"); the rest walks maps and list indexes. Targets are
+// evaluated on demand, so references may point at later declarations, and a
+// reference back into a value that is still being evaluated is reported as a
+// cycle.
+
+func (c *compiler) indexDecls(nodes []Node) {
+	c.decls = map[string]*Assignment{}
+	c.declValues = map[*Assignment]any{}
+	for _, n := range nodes {
+		if a, ok := n.(*Assignment); ok && a.Name != "_" {
+			c.decls[a.Name] = a
+		}
+	}
+}
+
+// declValue evaluates a top-level assignment once, tracking it on the
+// reference stack so cycles through it are detected.
+func (c *compiler) declValue(a *Assignment) any {
+	if v, ok := c.declValues[a]; ok {
+		return v
+	}
+	c.refStack = append(c.refStack, a.Name)
+	v := c.assignmentValue(a)
+	c.refStack = c.refStack[:len(c.refStack)-1]
+	if c.declValues != nil {
+		c.declValues[a] = v
+	}
+	return v
+}
+
+func (c *compiler) refValue(path string) (any, error) {
+	parts := strings.Split(path, ".")
+	if path == "" || parts[0] == "" {
+		return nil, fmt.Errorf("ref requires a path")
+	}
+	if err := c.refCycle(parts[0]); err != nil {
+		return nil, err
+	}
+	if len(parts) > 1 {
+		if err := c.refCycle(parts[0] + "." + parts[1]); err != nil {
+			return nil, err
+		}
+	}
+	var root any
+	rest := parts[1:]
+	if a := c.decls[parts[0]]; a != nil {
+		root = c.declValue(a)
+	} else if cv, ok := c.consts[parts[0]]; ok {
+		root = c.value(cv)
+	} else if b := c.refBlock(parts); b != nil {
+		key := b.Type + "." + b.ID
+		c.refStack = append(c.refStack, key)
+		root = c.block(b)["body"]
+		c.refStack = c.refStack[:len(c.refStack)-1]
+		rest = parts[2:]
+	} else {
+		return nil, fmt.Errorf("ref %q: %q is not declared", path, parts[0])
+	}
+	v, ok := walkPath(root, rest)
+	if !ok {
+		return nil, fmt.Errorf("ref %q: path not found", path)
+	}
+	return v, nil
+}
+
+func (c *compiler) refCycle(name string) error {
+	for i, active := range c.refStack {
+		if active == name {
+			chain := append(append([]string(nil), c.refStack[i:]...), name)
+			return fmt.Errorf("reference cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+	return nil
+}
+
+// forwardVars binds the top-level keys an expression reads through app.key,
+// config.app.key or a bare name, evaluating keys declared later on demand.
+// Existing variables are never shadowed, and only free names count: names
+// bound by lambdas or match patterns and object keys are not reads.
+func (c *compiler) forwardVars(raw string, vars map[string]any, sp Span) {
+	if len(c.decls) == 0 {
+		return
+	}
+	toks, err := exprTokens(raw)
+	if err != nil {
+		return
+	}
+	bound := boundNames(toks)
+	var app map[string]any
+	for i, t := range toks {
+		if t.kind != tokIdent || i > 0 && toks[i-1].kind == tokDot || bound[t.text] || isObjectKey(toks, i) {
+			continue
+		}
+		key, bare := "", false
+		switch {
+		case t.text == "app" && i+2 < len(toks) && toks[i+1].kind == tokDot && toks[i+2].kind == tokIdent:
+			key = toks[i+2].text
+		case t.text == "config" && i+4 < len(toks) && toks[i+1].kind == tokDot && toks[i+2].text == "app" && toks[i+3].kind == tokDot && toks[i+4].kind == tokIdent:
+			key = toks[i+4].text
+		case i+1 < len(toks) && toks[i+1].kind == tokLParen:
+			continue
+		default:
+			if _, taken := vars[t.text]; taken {
+				continue
+			}
+			key, bare = t.text, true
+		}
+		a := c.decls[key]
+		if a == nil {
+			continue
+		}
+		v, ok := c.out.Body[key]
+		if !ok {
+			if err := c.refCycle(key); err != nil {
+				c.errs = append(c.errs, Diagnostic{Severity: "error", Message: err.Error(), Span: sp})
+				continue
+			}
+			v = c.declValue(a)
+		}
+		if bare {
+			vars[key] = v
+			continue
+		}
+		if _, ok := c.out.Body[key]; !ok {
+			if app == nil {
+				app = make(map[string]any, len(c.out.Body)+1)
+				for k, bv := range c.out.Body {
+					app[k] = bv
+				}
+			}
+			app[key] = v
+		}
+	}
+	if app != nil {
+		vars["app"] = app
+		vars["config"] = map[string]any{"app": app}
+	}
+}
+
+// boundNames returns the names an expression binds itself: lambda
+// parameters, both `x => ...` and `(a, b) => ...`, and the bindings of
+// match case patterns.
+func boundNames(toks []token) map[string]bool {
+	var bound map[string]bool
+	bind := func(t token) {
+		if bound == nil {
+			bound = map[string]bool{}
+		}
+		bound[t.text] = true
+	}
+	for i := 1; i+1 < len(toks); i++ {
+		if toks[i].kind == tokIdent && toks[i].text == "case" {
+			for j := i + 1; j+1 < len(toks) && toks[j].text != "if" && !isArrow(toks, j); j++ {
+				if toks[j].kind == tokIdent && !isObjectKey(toks, j) && (j+1 >= len(toks) || toks[j+1].kind != tokLParen) {
+					bind(toks[j])
+				}
+			}
+			continue
+		}
+		if !isArrow(toks, i) {
+			continue
+		}
+		switch toks[i-1].kind {
+		case tokIdent:
+			bind(toks[i-1])
+		case tokRParen:
+			for j := i - 2; j >= 0 && toks[j].kind != tokLParen; j-- {
+				if toks[j].kind == tokIdent {
+					bind(toks[j])
+				}
+			}
+		}
+	}
+	return bound
+}
+
+func isArrow(toks []token, i int) bool {
+	return toks[i].kind == tokEqual && i+1 < len(toks) && toks[i+1].text == ">"
+}
+
+// isObjectKey reports whether toks[i] is the key of an object literal entry.
+func isObjectKey(toks []token, i int) bool {
+	if i == 0 || i+1 >= len(toks) || toks[i+1].text != ":" {
+		return false
+	}
+	prev := toks[i-1].kind
+	return prev == tokLBrace || prev == tokComma
+}
+
+func (c *compiler) refBlock(parts []string) *Block {
+	if len(parts) < 2 {
+		return nil
+	}
+	return c.blockIndex[parts[0]+"."+parts[1]]
+}
+
+func walkPath(v any, parts []string) (any, bool) {
+	for _, part := range parts {
+		switch x := v.(type) {
+		case map[string]any:
+			next, ok := x[part]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(x) {
+				return nil, false
+			}
+			v = x[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// refFunctions exposes ref to expressions alongside the caller's
+// EvalFunctions. A ref supplied by the caller is kept, and document-level
+// ref() calls are then left to it as well. The result is always a copy, as
+// the compiler adds the document's own functions to it.
+func (c *compiler) refFunctions(fns map[string]EvalFunction) map[string]EvalFunction {
+	out := make(map[string]EvalFunction, len(fns)+1)
+	for k, fn := range fns {
+		out[k] = fn
+	}
+	if _, ok := fns["ref"]; ok {
+		return out
+	}
+	out["ref"] = func(args []any, _ *EvalOptions) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("ref requires 1 argument")
+		}
+		path, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("ref path must be a string")
+		}
+		return c.refValue(path)
+	}
+	return out
+}
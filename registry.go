@@ -0,0 +1,168 @@
+package bcl
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Process-wide state in this package is limited to the registries filled by
+// RegisterDecisionFunction, RegisterDecisionDatasetAdapter and
+// RegisterDirective, which are guarded by locks, and to caches keyed by
+// source text (tokens, regexes, match programs) that are safe to share.
+// Libraries that embed BCL and must not see or leak registrations use a
+// Runtime instead.
+
+// Runtime is an instance-scoped registry of functions, dataset adapters,
+// decision actions, rankers, directives and decode hooks. Options built by
+// a Runtime see only its own registrations plus those set on the Options
+// themselves; the process-wide registries are ignored. A Runtime is safe
+// for concurrent use.
+type Runtime struct {
+	mu       sync.RWMutex
+	funcs    map[string]EvalFunction
+	adapters map[string]DecisionDatasetAdapter
+	actions  map[string]DecisionActionHandler
+	rankers  map[string]DecisionRankingScorer
+	dirs     map[string]DirectiveFunc
+	hooks    map[reflect.Type]DecodeHook
+	logger   *slog.Logger
+}
+
+func NewRuntime() *Runtime {
+	return &Runtime{
+		funcs:    map[string]EvalFunction{},
+		adapters: map[string]DecisionDatasetAdapter{},
+		actions:  map[string]DecisionActionHandler{},
+		rankers:  map[string]DecisionRankingScorer{},
+		dirs:     map[string]DirectiveFunc{},
+		hooks:    map[reflect.Type]DecodeHook{},
+	}
+}
+
+func (rt *Runtime) RegisterFunction(name string, fn EvalFunction) {
+	name = strings.TrimSpace(name)
+	if name == "" || fn == nil {
+		return
+	}
+	rt.mu.Lock()
+	rt.funcs[name] = fn
+	rt.mu.Unlock()
+}
+
+func (rt *Runtime) RegisterDatasetAdapter(kind string, adapter DecisionDatasetAdapter) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if kind == "" || adapter == nil {
+		return
+	}
+	rt.mu.Lock()
+	rt.adapters[kind] = adapter
+	rt.mu.Unlock()
+}
+
+func (rt *Runtime) RegisterAction(name string, handler DecisionActionHandler) {
+	if name == "" || handler == nil {
+		return
+	}
+	rt.mu.Lock()
+	rt.actions[name] = handler
+	rt.mu.Unlock()
+}
+
+func (rt *Runtime) RegisterRanker(id string, scorer DecisionRankingScorer) {
+	if id == "" || scorer == nil {
+		return
+	}
+	rt.mu.Lock()
+	rt.rankers[id] = scorer
+	rt.mu.Unlock()
+}
+
+func (rt *Runtime) RegisterDirective(name string, fn DirectiveFunc) {
+	if name == "" || fn == nil {
+		return
+	}
+	rt.mu.Lock()
+	rt.dirs[name] = fn
+	rt.mu.Unlock()
+}
+
+// RegisterDecodeHook sets the hook Unmarshal uses for targets of type t.
+func (rt *Runtime) RegisterDecodeHook(t reflect.Type, hook DecodeHook) {
+	if t == nil || hook == nil {
+		return
+	}
+	rt.mu.Lock()
+	rt.hooks[t] = hook
+	rt.mu.Unlock()
+}
+
+// SetLogger sets the logger of the Options the runtime builds, unless they
+// set their own.
+func (rt *Runtime) SetLogger(l *slog.Logger) {
+	rt.mu.Lock()
+	rt.logger = l
+	rt.mu.Unlock()
+}
+
+// Options returns a copy of base (which may be nil) carrying the runtime's
+// registrations. Entries already set on base take precedence.
+func (rt *Runtime) Options(base *Options) *Options {
+	var opts Options
+	if base != nil {
+		opts = *base
+	}
+	rt.mu.RLock()
+	opts.EvalFunctions = mergeRegistry(rt.funcs, opts.EvalFunctions)
+	opts.DecisionDatasetAdapters = mergeRegistry(rt.adapters, opts.DecisionDatasetAdapters)
+	opts.DecisionActions = mergeRegistry(rt.actions, opts.DecisionActions)
+	opts.DecisionRankers = mergeRegistry(rt.rankers, opts.DecisionRankers)
+	opts.Directives = mergeRegistry(rt.dirs, opts.Directives)
+	opts.DecodeHooks = mergeRegistry(rt.hooks, opts.DecodeHooks)
+	if opts.Logger == nil {
+		opts.Logger = rt.logger
+	}
+	rt.mu.RUnlock()
+	opts.isolated = true
+	return &opts
+}
+
+func (rt *Runtime) Compile(doc *Document, opts *Options) (*Normalized, error) {
+	return Compile(doc, rt.Options(opts))
+}
+
+func (rt *Runtime) CompileBytes(src []byte, opts *Options) (*Normalized, error) {
+	return CompileBytes(src, rt.Options(opts))
+}
+
+func (rt *Runtime) CompileFile(path string, opts *Options) (*Normalized, error) {
+	return CompileFile(path, rt.Options(opts))
+}
+
+func (rt *Runtime) CompileDecisionDocument(doc *Document, opts *Options) (*DecisionProgram, error) {
+	return CompileDecisionDocument(doc, rt.Options(opts))
+}
+
+func (rt *Runtime) EvaluateDecision(program *DecisionProgram, decision string, input map[string]any, opts *Options) (*DecisionResult, error) {
+	return EvaluateDecision(program, decision, input, rt.Options(opts))
+}
+
+func (rt *Runtime) OpenDecisionDataset(ctx context.Context, program *DecisionProgram, datasetID string, opts *Options) (DecisionRecordIterator, error) {
+	return OpenDecisionDataset(ctx, program, datasetID, rt.Options(opts))
+}
+
+func mergeRegistry[K comparable, V any](scoped, own map[K]V) map[K]V {
+	if len(scoped) == 0 {
+		return own
+	}
+	out := make(map[K]V, len(scoped)+len(own))
+	for k, v := range scoped {
+		out[k] = v
+	}
+	for k, v := range own {
+		out[k] = v
+	}
+	return out
+}
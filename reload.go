@@ -0,0 +1,178 @@
+package bcl
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Binder keeps the last good compilation of a BCL file and swaps it
+// atomically on reload, so readers never observe a half-applied config.
+type Binder struct {
+	Path     string
+	Options  *Options
+	OnReload func(*Normalized)
+	// OnChange receives the keys that differ from the previous config. It
+	// is not called when a reload changes nothing.
+	OnChange func(*Normalized, ConfigChanges)
+
+	mu       sync.Mutex
+	current  atomic.Pointer[Normalized]
+	loadedAt atomic.Int64
+}
+
+func NewBinder(path string, opts *Options) (*Binder, error) {
+	b := &Binder{Path: path, Options: opts}
+	if _, _, err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Binder) Current() *Normalized {
+	return b.current.Load()
+}
+
+func (b *Binder) LoadedAt() time.Time {
+	if ns := b.loadedAt.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// Reload parses, validates and compiles the bound file. The current config is
+// only replaced when there are no error diagnostics.
+func (b *Binder) Reload() (*Normalized, []Diagnostic, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, diags, err := b.reload()
+	if err != nil {
+		b.Options.logger().Error("config reload failed", "path", b.Path, "error", err)
+	}
+	return n, diags, err
+}
+
+func (b *Binder) reload() (*Normalized, []Diagnostic, error) {
+	n, diags, err := b.compile()
+	if err != nil {
+		return nil, diags, err
+	}
+	prev := b.current.Swap(n)
+	b.loadedAt.Store(optionsNow(b.Options).UnixNano())
+	if b.OnReload != nil {
+		b.OnReload(n)
+	}
+	log := b.Options.logger()
+	if prev != nil && (b.OnChange != nil || log.Enabled(context.Background(), slog.LevelInfo)) {
+		if changes := DiffConfigs(prev, n); len(changes) > 0 {
+			log.Info("reloaded config", "path", b.Path, "changes", len(changes))
+			if b.OnChange != nil {
+				b.OnChange(n, changes)
+			}
+		}
+	}
+	return n, diags, nil
+}
+
+// compile parses, validates and compiles the bound file without binding it.
+func (b *Binder) compile() (*Normalized, []Diagnostic, error) {
+	doc, err := ParsePath(b.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	diags := Validate(doc, b.Options)
+	if b.Options != nil && b.Options.Strict {
+		diags = append(diags, strictDiagnostics(doc, b.Options)...)
+	}
+	if hasErrorDiagnostics(diags) {
+		return nil, diags, ErrorList(diags)
+	}
+	n, err := Compile(doc, b.Options)
+	if err != nil {
+		return nil, diags, err
+	}
+	return n, diags, nil
+}
+
+// Drift compiles the bound file as it is on disk now and reports how the
+// running config differs from it, with Old from the file and New from the
+// running config. Nothing is swapped; an empty result means the process
+// runs what is committed.
+func (b *Binder) Drift() (ConfigChanges, error) {
+	disk, _, err := b.compile()
+	if err != nil {
+		return nil, err
+	}
+	return DiffConfigs(disk, b.Current()), nil
+}
+
+// ReloadHandler serves POST /-/reload to re-evaluate the bound config, GET
+// /config to return the effective, redacted config as JSON and GET
+// /config/drift to list the keys where it differs from the file on disk.
+// Drift reports keys only, so it leaks no values.
+func ReloadHandler(b *Binder) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		n, diags, err := b.Reload()
+		if err != nil {
+			writeReloadJSON(w, http.StatusUnprocessableEntity, map[string]any{"status": "error", "error": err.Error(), "diagnostics": diags})
+			return
+		}
+		writeReloadJSON(w, http.StatusOK, map[string]any{"status": "reloaded", "diagnostics": diags, "config": n})
+	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		n := b.Current()
+		if n == nil {
+			http.Error(w, "config not loaded", http.StatusServiceUnavailable)
+			return
+		}
+		data, err := n.JSON(true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if loaded := b.LoadedAt(); !loaded.IsZero() {
+			w.Header().Set("Last-Modified", loaded.UTC().Format(http.TimeFormat))
+		}
+		_, _ = w.Write(data)
+	})
+	mux.HandleFunc("/config/drift", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		changes, err := b.Drift()
+		if err != nil {
+			writeReloadJSON(w, http.StatusUnprocessableEntity, map[string]any{"status": "error", "error": err.Error()})
+			return
+		}
+		status := "in_sync"
+		if len(changes) > 0 {
+			status = "drifted"
+		}
+		writeReloadJSON(w, http.StatusOK, map[string]any{"status": status, "keys": changes.Keys()})
+	})
+	return mux
+}
+
+func writeReloadJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -2,8 +2,18 @@ package bcl
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestRemainingExportCodegenDocsMigrate(t *testing.T) {
@@ -183,3 +193,545 @@ action "call" {
 		t.Fatalf("bad response plan: %#v", plan)
 	}
 }
+
+func TestGenerateConfigDocsFromSchemaAndStruct(t *testing.T) {
+	doc, err := Parse([]byte(`
+schema server {
+  required host string description "Listen address"
+  optional port int default 8080
+  optional password string sensitive
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample, err := CompileBytes([]byte(`
+server "main" {
+  host "0.0.0.0"
+  port 9000
+}
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := GenerateConfigDocs(doc, DocgenOptions{Sample: sample})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"## `server`", "| `host` | `string` | yes |  | Listen address | `0.0.0.0` |", "| `port` | `int` | no | `8080` |", "(sensitive)"} {
+		if !strings.Contains(string(md), want) {
+			t.Fatalf("markdown docs missing %q:\n%s", want, md)
+		}
+	}
+	htmlDoc, err := GenerateConfigDocs(doc, DocgenOptions{Format: "html", Title: "Server <config>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(htmlDoc), "<h1>Server &lt;config&gt;</h1>") || !strings.Contains(string(htmlDoc), "<code>host</code>") {
+		t.Fatalf("html docs:\n%s", htmlDoc)
+	}
+	type limits struct {
+		Burst int `bcl:"burst" doc:"Max burst"`
+	}
+	type config struct {
+		Name   string        `bcl:"name" doc:"Service name"`
+		Limits limits        `bcl:"limits"`
+		Token  string        `bcl:"token,sensitive,omitempty"`
+		Hosts  []string      `json:"hosts" default:"[]"`
+		Skip   string        `bcl:"-"`
+		Wait   time.Duration `bcl:"wait"`
+	}
+	structDoc, err := GenerateStructDocs(&config{}, DocgenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"| `limits.burst` | `int` | yes |  | Max burst |", "| `hosts` | `list<string>` | no | `[]` |", "| `wait` | `duration` |"} {
+		if !strings.Contains(string(structDoc), want) {
+			t.Fatalf("struct docs missing %q:\n%s", want, structDoc)
+		}
+	}
+	if strings.Contains(string(structDoc), "skip") {
+		t.Fatalf("struct docs include skipped field:\n%s", structDoc)
+	}
+}
+
+func TestGenerateGoLiteralFromConfig(t *testing.T) {
+	src := []byte(`name "api"
+timeout 90s
+tags ["a", "b"]
+server "web" {
+  host "h"
+  weight 1
+}
+server "db" {
+  host "d"
+  weight 2.5
+}
+`)
+	n, err := CompileBytes(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := GenerateGoLiteral(n, "defaults", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"package defaults",
+		`import "time"`,
+		"[]AppServer   `json:\"server\" bcl:\"server,block\"`",
+		"Weight float64 `json:\"weight\" bcl:\"weight\"`",
+		"var DefaultApp = App{",
+		"Timeout: 90 * time.Second,",
+		`ID:     "web",`,
+		"Weight: 1.0,",
+	} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+	// The generated tags must decode the same file.
+	type AppServer struct {
+		ID     string  `bcl:",id"`
+		Host   string  `json:"host" bcl:"host"`
+		Weight float64 `json:"weight" bcl:"weight"`
+	}
+	var cfg struct {
+		Name   string      `json:"name" bcl:"name"`
+		Server []AppServer `json:"server" bcl:"server,block"`
+		Tags   []string    `json:"tags" bcl:"tags"`
+	}
+	if err := Unmarshal(src, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Server) != 2 || cfg.Server[1].ID != "db" || cfg.Server[1].Weight != 2.5 || len(cfg.Tags) != 2 {
+		t.Fatalf("cfg = %#v", cfg)
+	}
+}
+
+func TestSQLIdentifierHelpers(t *testing.T) {
+	for _, tc := range []struct{ name, dialect, want string }{
+		{"seo_metadatas", "postgres", "seo_metadatas"},
+		{"order", "postgres", `"order"`},
+		{"order", "mysql", "`order`"},
+		{"public.Users", "postgres", `public."Users"`},
+		{"vacuum", "sqlite", `"vacuum"`},
+		{"vacuum", "postgres", "vacuum"},
+	} {
+		got, err := QuoteSQLIdentifier(tc.name, tc.dialect)
+		if err != nil || got != tc.want {
+			t.Fatalf("QuoteSQLIdentifier(%q, %s) = %q, %v; want %q", tc.name, tc.dialect, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"users; DROP TABLE x", "a b", `x"y`, "id--", "", "a..b", strings.Repeat("x", 64)} {
+		if _, err := QuoteSQLIdentifier(bad, "postgres"); err == nil {
+			t.Fatalf("QuoteSQLIdentifier(%q) accepted", bad)
+		}
+	}
+	if err := ValidateSQLIdentifier("users", "oracle"); err == nil {
+		t.Fatal("unknown dialect accepted")
+	}
+}
+
+func TestSQLBindHelpers(t *testing.T) {
+	q, args, err := DeleteSQL("postgres", "sessions", "user_id = ? AND note <> 'why?'", []any{int64(7)})
+	if err != nil || q != `DELETE FROM sessions WHERE user_id = $1 AND note <> 'why?'` || len(args) != 1 {
+		t.Fatalf("delete = %q %v %v", q, args, err)
+	}
+	q, args, err = InsertSQL("mysql", "order", []map[string]any{{"id": 1, "name": "a"}, {"id": 2}})
+	if err != nil || q != "INSERT INTO `order` (id, name) VALUES (?, ?), (?, ?)" || len(args) != 4 || args[3] != nil {
+		t.Fatalf("insert = %q %v %v", q, args, err)
+	}
+	got, err := BindSQLPlaceholders("postgres", "a = ? /* b = ?; */ AND c = ? -- why?", 1, []any{1, 2})
+	if err != nil || got != "a = $1 /* b = ?; */ AND c = $2 -- why?\n" {
+		t.Fatalf("bind = %q %v", got, err)
+	}
+	got, err = BindSQLPlaceholders("mysql", "a = ? -- really?\nAND b = ?", 1, []any{1, 2})
+	if err != nil || got != "a = ? -- really?\nAND b = ?" {
+		t.Fatalf("bind = %q %v", got, err)
+	}
+	for _, where := range []string{"id = 1; DROP TABLE x", "id = ? AND x = ?", "", "id = ? /* open"} {
+		if _, _, err := DeleteSQL("sqlite", "t", where, []any{1}); err == nil {
+			t.Fatalf("DeleteSQL accepted %q", where)
+		}
+	}
+}
+
+type fakeSQLExecer struct {
+	ran  []string
+	args [][]any
+}
+
+func (f *fakeSQLExecer) ExecContext(_ context.Context, query string, args ...any) (sql.Result, error) {
+	f.ran = append(f.ran, query)
+	f.args = append(f.args, args)
+	if strings.Contains(query, "bad") {
+		return nil, errors.New("syntax error")
+	}
+	return driver.RowsAffected(2), nil
+}
+
+func TestExecSQLStatements(t *testing.T) {
+	db := &fakeSQLExecer{}
+	results, err := ExecSQLStatements(context.Background(), db, SQLStatements("CREATE TABLE a (id int)", "bad", "DROP TABLE a"))
+	var stmtErr *SQLStatementError
+	if !errors.As(err, &stmtErr) || stmtErr.Index != 1 || stmtErr.SQL != "bad" {
+		t.Fatalf("err = %v", err)
+	}
+	if len(db.ran) != 2 || len(results) != 2 || results[0].RowsAffected != 2 || results[1].Err == nil {
+		t.Fatalf("results = %#v ran = %v", results, db.ran)
+	}
+
+	var events []string
+	_, err = ExecSQLStatementsProgress(context.Background(), &fakeSQLExecer{}, SQLStatements("UPDATE a SET x = 1", "UPDATE b SET y = 2"), func(p SQLProgress) {
+		events = append(events, fmt.Sprintf("%d/%d %v %d", p.Index+1, p.Total, p.Done, p.RowsAffected))
+	})
+	if err != nil || strings.Join(events, ",") != "1/2 false 0,1/2 true 2,2/2 false 0,2/2 true 2" {
+		t.Fatalf("progress = %v %v", events, err)
+	}
+
+	ins, insArgs, err := InsertSQL("postgres", "t", []map[string]any{{"id": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	del, delArgs, err := DeleteSQL("postgres", "t", "id = ?", []any{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = &fakeSQLExecer{}
+	if _, err := ExecSQLStatements(context.Background(), db, []SQLStatement{{SQL: ins, Args: insArgs}, {SQL: del, Args: delArgs}}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(db.ran, db.args) != "[INSERT INTO t (id) VALUES ($1) DELETE FROM t WHERE id = $1] [[1] [2]]" {
+		t.Fatalf("ran %v with %v", db.ran, db.args)
+	}
+}
+
+// fakeSQLDriver is a database/sql driver whose connections log what they
+// run and answer every query with the rows (1, "a") and (2, "b").
+type fakeSQLDriver struct{ log *[]string }
+
+func (d fakeSQLDriver) Open(string) (driver.Conn, error) { return fakeSQLConn(d), nil }
+
+type fakeSQLConn struct{ log *[]string }
+
+func (c fakeSQLConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeSQLConn) Close() error                        { return nil }
+func (c fakeSQLConn) Begin() (driver.Tx, error)           { *c.log = append(*c.log, "BEGIN"); return c, nil }
+func (c fakeSQLConn) Commit() error                       { *c.log = append(*c.log, "COMMIT"); return nil }
+func (c fakeSQLConn) Rollback() error                     { *c.log = append(*c.log, "ROLLBACK"); return nil }
+
+func (c fakeSQLConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	*c.log = append(*c.log, query)
+	if strings.Contains(query, "bad") {
+		return nil, errors.New("syntax error")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c fakeSQLConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	*c.log = append(*c.log, fmt.Sprint(query, len(args)))
+	return &fakeSQLRows{rows: [][]driver.Value{{int64(1), []byte("a")}, {int64(2), []byte("b")}}}, nil
+}
+
+type fakeSQLRows struct{ rows [][]driver.Value }
+
+func (r *fakeSQLRows) Columns() []string { return []string{"id", "name"} }
+func (r *fakeSQLRows) Close() error      { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestExecSQLStatementsTxAndQuerySQL(t *testing.T) {
+	var log []string
+	sql.Register("bcl-fake-tx", fakeSQLDriver{log: &log})
+	db, err := sql.Open("bcl-fake-tx", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := ExecSQLStatementsTx(ctx, db, SQLStatements("INSERT a", "bad", "INSERT b"), nil); err == nil {
+		t.Fatal("expected failing statement error")
+	}
+	results, err := ExecSQLStatementsTx(ctx, db, SQLStatements("INSERT c"), &sql.TxOptions{})
+	if err != nil || len(results) != 1 || results[0].RowsAffected != 1 {
+		t.Fatalf("results = %#v, %v", results, err)
+	}
+	if got := strings.Join(log, ","); got != "BEGIN,INSERT a,bad,ROLLBACK,BEGIN,INSERT c,COMMIT" {
+		t.Fatalf("ran %s", got)
+	}
+	rows, err := QuerySQL(ctx, db, "SELECT id, name FROM t WHERE id > ?", 0)
+	if err != nil || len(rows) != 2 || rows[1]["id"] != int64(2) || rows[1]["name"] != "b" {
+		t.Fatalf("rows = %#v, %v", rows, err)
+	}
+}
+
+type recordingSink struct{ got []string }
+
+func (s *recordingSink) Count(name string, delta int64, labels map[string]string) {
+	s.got = append(s.got, fmt.Sprintf("%s %d %s", name, delta, labels["status"]))
+}
+
+func (s *recordingSink) Observe(name string, _ float64, labels map[string]string) {
+	s.got = append(s.got, name+" "+labels["status"])
+}
+
+func TestSQLMigrationReport(t *testing.T) {
+	start := time.Now()
+	results, err := ExecSQLStatements(context.Background(), &fakeSQLExecer{}, SQLStatements("CREATE TABLE a (id int)", "bad"))
+	report := NewSQLMigrationReport("0001_init", start, results, err)
+	if report.Status != MigrationFailed || report.Applied != 1 || report.Failed != 1 || report.ExitCode() != 1 || report.Statements[1].Error != "syntax error" {
+		t.Fatalf("report = %+v", report)
+	}
+	sink := &recordingSink{}
+	report.Emit(sink)
+	if want := "bcl_migration_runs_total 1 failed,bcl_migration_statements_total 1 failed,bcl_migration_failures_total 1 failed,bcl_migration_duration_seconds failed"; strings.Join(sink.got, ",") != want {
+		t.Fatalf("metrics = %v", sink.got)
+	}
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := report.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	var back SQLMigrationReport
+	if b, err := os.ReadFile(path); err != nil || json.Unmarshal(b, &back) != nil || back.Migration != "0001_init" || len(back.Statements) != 2 {
+		t.Fatalf("written report = %+v %v", back, err)
+	}
+	if ok := NewSQLMigrationReport("0002", start, results[:1], nil); ok.Status != MigrationApplied || ok.ExitCode() != 0 {
+		t.Fatalf("applied report = %+v", ok)
+	}
+}
+
+func TestSQLIndexMigration(t *testing.T) {
+	q, err := CreateIndexSQL("postgres", SQLIndex{Name: "users_email_idx", Table: "users", Columns: []string{"email"}, Unique: true, Concurrently: true})
+	if err != nil || q != "CREATE UNIQUE INDEX CONCURRENTLY users_email_idx ON users (email)" {
+		t.Fatalf("create = %q %v", q, err)
+	}
+	if _, err := CreateIndexSQL("mysql", SQLIndex{Name: "i", Table: "t", Columns: []string{"a"}, Concurrently: true}); err == nil {
+		t.Fatal("mysql concurrently accepted")
+	}
+	if q, err := DropIndexSQL("mysql", "order", "i", false); err != nil || q != "DROP INDEX i ON `order`" {
+		t.Fatalf("drop = %q %v", q, err)
+	}
+	batches := SQLMigrationBatches(SQLStatements("CREATE TABLE a (id int)", "ALTER TABLE a ADD b int", "CREATE INDEX b_idx ON a (b)", "DROP INDEX CONCURRENTLY i", "CREATE INDEX CONCURRENTLY j ON a (b)", "UPDATE a SET b = 1"))
+	if len(batches) != 4 || !batches[0].Tx || len(batches[0].Stmts) != 3 || batches[1].Tx || batches[2].Tx || !batches[3].Tx {
+		t.Fatalf("batches = %#v", batches)
+	}
+}
+
+func TestSQLDriverForDSN(t *testing.T) {
+	cases := map[string][2]string{
+		"postgres://u@db/app?sslmode=require": {"pgx", "postgres://u@db/app?sslmode=require"},
+		"mysql://u:pw@tcp(db:3306)/app":       {"mysql", "u:pw@tcp(db:3306)/app"},
+		"sqlite://data/app.db":                {"sqlite", "data/app.db"},
+		"file:app.db?cache=shared":            {"sqlite", "file:app.db?cache=shared"},
+	}
+	for dsn, want := range cases {
+		name, d, err := SQLDriverForDSN(dsn)
+		if err != nil || name != want[0] || d != want[1] {
+			t.Fatalf("%s = %s %s %v", dsn, name, d, err)
+		}
+	}
+	if _, err := OpenSQL(context.Background(), "mssql://x", SQLPool{}); err == nil {
+		t.Fatal("expected unsupported scheme error")
+	}
+	if _, err := OpenSQL(context.Background(), "postgres://x", SQLPool{}); err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestApplySQLTLS(t *testing.T) {
+	full := SQLTLS{Mode: "verify-full", CAFile: "/etc/ca.pem", CertFile: "/etc/c.pem", KeyFile: "/etc/k.pem"}
+	cases := []struct {
+		dsn  string
+		tls  SQLTLS
+		want string
+	}{
+		{"postgres://u@db/app", full, "postgres://u@db/app?sslmode=verify-full&sslrootcert=%2Fetc%2Fca.pem&sslcert=%2Fetc%2Fc.pem&sslkey=%2Fetc%2Fk.pem"},
+		{"postgres://u@db/app?sslmode=disable", SQLTLS{Mode: "require", CAFile: "ca.pem"}, "postgres://u@db/app?sslmode=disable&sslrootcert=ca.pem"},
+		{"mysql://u:pw@tcp(db:3306)/app?parseTime=true", SQLTLS{Mode: "verify-full"}, "mysql://u:pw@tcp(db:3306)/app?parseTime=true&tls=true"},
+		{"sqlite://app.db", SQLTLS{}, "sqlite://app.db"},
+	}
+	for _, tc := range cases {
+		if got, err := ApplySQLTLS(tc.dsn, tc.tls); err != nil || got != tc.want {
+			t.Fatalf("ApplySQLTLS(%s) = %s, %v", tc.dsn, got, err)
+		}
+	}
+	for dsn, tls := range map[string]SQLTLS{"mysql://u@tcp(db)/app": full, "sqlite://app.db": {Mode: "require"}} {
+		if _, err := ApplySQLTLS(dsn, tls); err == nil {
+			t.Fatalf("ApplySQLTLS(%s) accepted %+v", dsn, tls)
+		}
+	}
+}
+
+func TestMigrationTablesERDiagram(t *testing.T) {
+	n, err := CompileBytes([]byte(`
+Migration "2_users" {
+  Up {
+    CreateTable "users" {
+      Column "id" {
+        type integer
+        primary_key true
+      }
+      Column "org_id" {
+        type integer
+        references "orgs.id"
+      }
+    }
+    AlterTable "orgs" {
+      AddColumn "name" {
+        type string
+      }
+      DropColumn "legacy" {}
+    }
+    DropTable "tmp" {}
+  }
+}
+Migration "1_orgs" {
+  Up {
+    CreateTable "orgs" {
+      Column "id" {
+        type integer
+        primary_key true
+      }
+      Column "legacy" {
+        type string
+      }
+    }
+    CreateTable "tmp" {}
+  }
+}
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := MigrationTables(n)
+	if len(tables) != 2 || tables[0].Name != "orgs" || len(tables[0].Columns) != 2 || tables[0].Columns[1].Name != "name" {
+		t.Fatalf("tables = %#v", tables)
+	}
+	out, err := ERDiagram(tables, "mermaid")
+	if err != nil || !strings.Contains(string(out), "integer org_id FK") || !strings.Contains(string(out), `orgs ||--o{ users : "org_id"`) {
+		t.Fatalf("mermaid = %s %v", out, err)
+	}
+	out, err = ERDiagram(tables, "dot")
+	if err != nil || !strings.Contains(string(out), `"users" -> "orgs" [label="org_id"]`) {
+		t.Fatalf("dot = %s %v", out, err)
+	}
+}
+
+func TestDestructiveMigrations(t *testing.T) {
+	doc, err := Parse([]byte(`
+Migration "1_cleanup" {
+  Up {
+    DropTable "tmp" {}
+    AlterTable "users" {
+      DropColumn "legacy" {}
+    }
+    DeleteData "sessions" {
+      Where = "expired"
+    }
+    DeleteData "events" {
+      Where = "old"
+      Limit = 100
+    }
+  }
+  Down {
+    DropTable "users" {}
+  }
+}
+Migration "2_approved" {
+  approved = true
+  Up {
+    DropTable "old" {}
+  }
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diags := DestructiveMigrations(doc, false)
+	if len(diags) != 3 || diags[0].Severity != "error" || !strings.Contains(diags[1].Message, `DropColumn "legacy"`) || !strings.Contains(diags[2].Message, `DeleteData "sessions"`) {
+		t.Fatalf("diags = %#v", diags)
+	}
+	if diags := DestructiveMigrations(doc, true); len(diags) != 3 || diags[0].Severity != "warning" {
+		t.Fatalf("allowed diags = %#v", diags)
+	}
+	doc, err = Parse([]byte(`
+const ENV = "prod"
+const REVIEWED = false
+Migration "3_conditional" {
+  approved = REVIEWED
+  Up {
+    IF const.ENV == "prod" {
+      DropTable "audit" {}
+    }
+    DeleteData "jobs" {
+      limit = 0
+    }
+  }
+}
+Migration "4_evaluated" {
+  approved = !REVIEWED
+  Up {
+    DropTable "tmp" {}
+  }
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diags = DestructiveMigrations(doc, false)
+	if len(diags) != 2 || !strings.Contains(diags[0].Message, `DropTable "audit"`) || !strings.Contains(diags[1].Message, `DeleteData "jobs"`) {
+		t.Fatalf("diags = %#v", diags)
+	}
+}
+
+func TestMigrationChecksums(t *testing.T) {
+	doc, err := Parse([]byte(`
+Migration "1_users" {
+  Up {
+    CreateTable "users" {}
+  }
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sums, err := MigrationChecksums(doc, "")
+	if err != nil || !strings.HasPrefix(sums["1_users"], "sha256:") {
+		t.Fatalf("sums = %v %v", sums, err)
+	}
+	reformatted, err := Parse([]byte("# users table\nMigration \"1_users\" {\n\tUp {\n\t\tCreateTable \"users\" {\n\t\t}\n\t}\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diags, err := VerifyMigrationChecksums(reformatted, sums); err != nil || len(diags) != 0 {
+		t.Fatalf("reformatted diags = %v %v", diags, err)
+	}
+	edited, err := Parse([]byte(`Migration "1_users" {
+  Up {
+    CreateTable "accounts" {}
+  }
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sums["0_gone"] = sums["1_users"]
+	diags, err := VerifyMigrationChecksums(edited, sums)
+	if err != nil || len(diags) != 2 || !strings.Contains(diags[0].Message, "missing") || !strings.Contains(diags[1].Message, "edited") {
+		t.Fatalf("edited diags = %v %v", diags, err)
+	}
+	repaired, changed, err := RepairMigrationChecksums(edited, sums, "sha512")
+	if err != nil || len(changed) != 1 || !strings.HasPrefix(repaired["1_users"], "sha512:") || repaired["0_gone"] != sums["0_gone"] {
+		t.Fatalf("repaired = %v %v %v", repaired, changed, err)
+	}
+	if _, err := MigrationChecksums(doc, "md4"); err == nil {
+		t.Fatal("unknown algorithm accepted")
+	}
+}
@@ -0,0 +1,80 @@
+package bcl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// applyRenames moves values set under the deprecated paths of
+// Options.Renames to their replacement paths, after profiles and overrides
+// have been applied, and records a deprecation warning for each one. When
+// both paths are set the new one wins.
+func (c *compiler) applyRenames() {
+	olds := make([]string, 0, len(c.opts.Renames))
+	for old := range c.opts.Renames {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+	for _, old := range olds {
+		next := c.opts.Renames[old]
+		v, ok := getPath(c.out.Body, old)
+		if !ok || next == "" || next == old {
+			continue
+		}
+		deletePath(c.out.Body, old)
+		sp := c.renameSpan(old)
+		msg := fmt.Sprintf("%q is deprecated; use %q", old, next)
+		if _, exists := getPath(c.out.Body, next); exists {
+			msg += " (both are set; ignoring the old value)"
+			c.traceDrop(old)
+		} else {
+			setPath(c.out.Body, next, v)
+			c.renameProvenance(old, next)
+		}
+		c.out.Diagnostics = append(c.out.Diagnostics, Diagnostic{Severity: "warning", Message: msg, Span: sp})
+	}
+}
+
+func (c *compiler) renameSpan(path string) Span {
+	if o, ok := c.out.provenance[path]; ok {
+		return o.Span
+	}
+	if a := c.decls[path]; a != nil {
+		return a.Span
+	}
+	return Span{}
+}
+
+func (c *compiler) renameProvenance(old, next string) {
+	if c.out.provenance == nil {
+		return
+	}
+	moved := map[string]ValueOrigin{}
+	for k, o := range c.out.provenance {
+		if k == old || strings.HasPrefix(k, old+".") {
+			moved[next+strings.TrimPrefix(k, old)] = o
+			delete(c.out.provenance, k)
+		}
+	}
+	for k, o := range moved {
+		c.out.provenance[k] = o
+	}
+}
+
+// deletePath removes path from m along with parent maps it leaves empty.
+func deletePath(m map[string]any, path string) {
+	head, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		delete(m, head)
+		return
+	}
+	child, ok := m[head].(map[string]any)
+	if !ok {
+		return
+	}
+	deletePath(child, rest)
+	if len(child) == 0 {
+		delete(m, head)
+	}
+}
@@ -30,6 +30,7 @@ func CompileDetailed(doc *Document, opts *Options) (*CompileResult, error) {
 		Tests:         n.Tests,
 		Strict:        opts != nil && opts.Strict,
 		ActiveProfile: "",
+		redacted:      opts == nil || !opts.RevealSensitive,
 	}
 	if opts != nil {
 		result.ActiveProfile = opts.Profile
@@ -52,13 +53,13 @@ func CompileDetailed(doc *Document, opts *Options) (*CompileResult, error) {
 }
 
 func CompileFileWithLock(path, lockPath string, opts *Options) (*CompileResult, error) {
-	doc, err := ParsePath(path)
-	if err != nil {
-		return nil, err
-	}
 	if opts == nil {
 		opts = &Options{}
 	}
+	doc, err := opts.ASTCache.ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
 	opts.BaseDir = filepath.Dir(path)
 	opts.LockfilePath = lockPath
 	opts.ResolveImports = true
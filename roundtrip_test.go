@@ -0,0 +1,206 @@
+package bcl
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The round-trip suite checks Unmarshal(Marshal(x)) == x for generated
+// values. Strings are drawn from fragments the lexer, parser or compiler
+// treat specially, so quoting, escaping, keywords and typed literals are
+// all exercised.
+
+type roundTripLeaf struct {
+	Name  string            `bcl:"name"`
+	Count int64             `bcl:"count"`
+	Ratio float64           `bcl:"ratio"`
+	On    bool              `bcl:"on"`
+	Tags  []string          `bcl:"tags"`
+	Attrs map[string]string `bcl:"attrs"`
+}
+
+type roundTripDoc struct {
+	Title  string                   `bcl:"title"`
+	I8     int8                     `bcl:"i8"`
+	U8     uint8                    `bcl:"u8"`
+	U64    uint64                   `bcl:"u64"`
+	F32    float32                  `bcl:"f32"`
+	Pair   [2]string                `bcl:"pair"`
+	Leaf   roundTripLeaf            `bcl:"leaf"`
+	Ptr    *roundTripLeaf           `bcl:"ptr"`
+	List   []roundTripLeaf          `bcl:"list"`
+	ByName map[string]roundTripLeaf `bcl:"by_name"`
+	Nums   []float64                `bcl:"nums"`
+	Mixed  []any                    `bcl:"mixed"`
+	// any and module are block names in documents.
+	Any    map[string]any `bcl:"any"`
+	Module roundTripLeaf  `bcl:"module"`
+}
+
+var roundTripFragments = []string{
+	"", "plain", "if", "for", "const", "import", "true", "null", "module", "all", "Server",
+	"a b", "a-b", "a:", "1abc", "$x", "${name}", "@dir", "#hash", "// c", "/* c */",
+	`quote"d`, "it's", "back`tick", `"""`, "tab\tsep", "line\nbreak", "cr\rret", `back\slash`,
+	"ünï", "日本", "\x00nul", "\u2028", " ", `end"`, `"start`, "'", "`", "a\"b'c`d",
+	"-", "5s", "10MB", "0x10", "1e5", "-3", "3.0", "a.b", "10.0.0.1", ".",
+}
+
+type roundTripGen struct{ r *rand.Rand }
+
+func (g roundTripGen) string() string {
+	if g.r.Intn(3) > 0 {
+		return roundTripFragments[g.r.Intn(len(roundTripFragments))]
+	}
+	var b strings.Builder
+	for n := g.r.Intn(6); n > 0; n-- {
+		b.WriteString(roundTripFragments[g.r.Intn(len(roundTripFragments))])
+	}
+	return b.String()
+}
+
+// key is a string usable as a map key: a bare "_" is discarded, by
+// design of the language.
+func (g roundTripGen) key() string {
+	k := g.string()
+	if k == "_" {
+		return ""
+	}
+	return k
+}
+
+func (g roundTripGen) float() float64 {
+	switch g.r.Intn(5) {
+	case 0:
+		return float64(g.r.Intn(100))
+	case 1:
+		return g.r.NormFloat64() * 1e300
+	case 2:
+		return g.r.NormFloat64() * 1e-300
+	case 3:
+		return 0
+	default:
+		return g.r.NormFloat64() * 1000
+	}
+}
+
+func (g roundTripGen) value(t reflect.Type, depth int) reflect.Value {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(g.string())
+	case reflect.Bool:
+		v.SetBool(g.r.Intn(2) == 0)
+	case reflect.Int8:
+		v.SetInt(int64(int8(g.r.Int())))
+	case reflect.Int64:
+		v.SetInt([]int64{0, -1, 1 << 62, -1 << 63, 1<<63 - 1, g.r.Int63()}[g.r.Intn(6)])
+	case reflect.Uint8:
+		v.SetUint(uint64(uint8(g.r.Int())))
+	case reflect.Uint64:
+		v.SetUint([]uint64{0, 1 << 63, 1<<64 - 1, g.r.Uint64()}[g.r.Intn(4)])
+	case reflect.Float32:
+		v.SetFloat(float64(float32(g.float() / 1e270)))
+	case reflect.Float64:
+		v.SetFloat(g.float())
+	case reflect.Pointer:
+		if depth > 0 && g.r.Intn(2) == 0 {
+			v.Set(g.value(t.Elem(), depth-1).Addr())
+		}
+	case reflect.Array:
+		for i := 0; i < t.Len(); i++ {
+			v.Index(i).Set(g.value(t.Elem(), depth))
+		}
+	case reflect.Slice:
+		if g.r.Intn(3) == 0 {
+			break
+		}
+		n := g.r.Intn(4)
+		if depth <= 0 {
+			n = 0
+		}
+		s := reflect.MakeSlice(t, n, n)
+		for i := 0; i < n; i++ {
+			s.Index(i).Set(g.value(t.Elem(), depth-1))
+		}
+		v.Set(s)
+	case reflect.Map:
+		if g.r.Intn(3) == 0 {
+			break
+		}
+		m := reflect.MakeMap(t)
+		for n := g.r.Intn(4); n > 0 && depth > 0; n-- {
+			m.SetMapIndex(reflect.ValueOf(g.key()), g.value(t.Elem(), depth-1))
+		}
+		v.Set(m)
+	case reflect.Interface:
+		var x any
+		switch g.r.Intn(6) {
+		case 0:
+			x = g.string()
+		case 1:
+			x = g.r.Int63()
+		case 2:
+			x = g.float()
+		case 3:
+			x = g.r.Intn(2) == 0
+		case 4:
+			x = []any{g.string(), g.r.Int63(), g.float(), nil, map[string]any{"k": g.string()}}
+		default:
+			x = map[string]any{"n": g.float(), "l": []any{true, "x"}, "m": map[string]any{}}
+		}
+		v.Set(reflect.ValueOf(x))
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			v.Field(i).Set(g.value(t.Field(i).Type, depth))
+		}
+	}
+	return v
+}
+
+func TestMarshalUnmarshalRoundTripProperty(t *testing.T) {
+	n := 400
+	if testing.Short() {
+		n = 50
+	}
+	g := roundTripGen{r: rand.New(rand.NewSource(4761))}
+	for i := 0; i < n; i++ {
+		want := g.value(reflect.TypeFor[roundTripDoc](), 2).Interface().(roundTripDoc)
+		data, err := Marshal(want)
+		if err != nil {
+			t.Fatalf("case %d: marshal: %v", i, err)
+		}
+		var got roundTripDoc
+		if err := Unmarshal(data, &got); err != nil {
+			t.Fatalf("case %d: unmarshal: %v\n%s", i, err, data)
+		}
+		if reflect.DeepEqual(got, want) {
+			continue
+		}
+		gv, wv := reflect.ValueOf(got), reflect.ValueOf(want)
+		for f := 0; f < wv.NumField(); f++ {
+			if !reflect.DeepEqual(gv.Field(f).Interface(), wv.Field(f).Interface()) {
+				t.Errorf("case %d: %s = %#v, want %#v", i, wv.Type().Field(f).Name, gv.Field(f).Interface(), wv.Field(f).Interface())
+			}
+		}
+		t.Fatalf("case %d document:\n%s", i, data)
+	}
+}
+
+func TestStringEscapesRoundTrip(t *testing.T) {
+	for _, s := range []string{"cr\rret", "nul\x00", "bell\a", "\u2028", "bad\xffbyte", `C:\users\x`} {
+		n, err := CompileBytes([]byte("v "+quoteBCLString(s)+"\n"), nil)
+		if err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		if n.Body["v"] != s {
+			t.Fatalf("%q read back as %q", s, n.Body["v"])
+		}
+	}
+	// Escapes without their hex digits keep the old meaning.
+	n, err := CompileBytes([]byte(`v "C:\users\xyz"`+"\n"), nil)
+	if err != nil || n.Body["v"] != "C:usersxyz" {
+		t.Fatalf("v = %#v, %v", n.Body["v"], err)
+	}
+}
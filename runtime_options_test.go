@@ -1,6 +1,15 @@
 package bcl
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -53,3 +62,199 @@ func TestOpenDecisionDatasetEnforcesAdapterPolicy(t *testing.T) {
 		t.Fatal("expected adapter policy error")
 	}
 }
+
+func TestRegisteredDirectivesExpandAtCompileTime(t *testing.T) {
+	src := []byte(`service "api" {
+  @secret("db/password") {
+    key "db_password"
+  }
+  port 8080
+}
+`)
+	rt := NewRuntime()
+	rt.RegisterDirective("secret", func(d *Directive, args []any, _ *Options) ([]Node, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("want one path, got %d", len(args))
+		}
+		var key string
+		for _, n := range d.Body {
+			if a, ok := n.(*Assignment); ok && a.Name == "key" {
+				key, _ = a.Value.(*Literal).Data.(string)
+			}
+		}
+		return []Node{&Assignment{Name: key, Value: &Literal{Type: "string", Data: "vault:" + args[0].(string)}}}, nil
+	})
+	n, err := rt.CompileBytes(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := n.Blocks[0]["body"].(map[string]any)
+	if body["db_password"] != "vault:db/password" || body["port"] != int64(8080) {
+		t.Fatalf("body = %#v", body)
+	}
+	if _, err := CompileBytes(src, nil); err == nil || !strings.Contains(err.Error(), "unknown directive @secret") {
+		t.Fatalf("unscoped compile err = %v", err)
+	}
+	out, err := Format(src)
+	if err != nil || !strings.Contains(string(out), `@secret("db/password") {`) {
+		t.Fatalf("format = %s %v", out, err)
+	}
+}
+
+func TestCachedDirectivesAndRemoteIncludes(t *testing.T) {
+	runs := 0
+	rt := NewRuntime()
+	rt.RegisterDirective("exec", CacheDirective(func(d *Directive, args []any, _ *Options) ([]Node, error) {
+		runs++
+		return []Node{&Assignment{Name: "out", Value: &Literal{Type: "string", Data: fmt.Sprint(args[0], " #", runs)}}}, nil
+	}, time.Minute))
+	fetched := 0
+	opts := rt.Options(&Options{Cache: NewResultCache(t.TempDir()), IncludeCacheTTL: time.Minute, ResolveImports: true, IncludeResolver: IncludeResolverFunc(func(name string) ([]byte, error) {
+		fetched++
+		return []byte("base 1\n"), nil
+	})})
+	src := []byte("import \"https://configs.example/base.bcl\"\n@exec(\"date\")\n")
+	for range 2 {
+		n, err := CompileBytes(src, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.Body["out"] != "date #1" || n.Body["base"] != int64(1) {
+			t.Fatalf("body = %#v", n.Body)
+		}
+	}
+	if runs != 1 || fetched != 1 {
+		t.Fatalf("directive ran %d times and include fetched %d times, want once each", runs, fetched)
+	}
+	if n, err := CompileBytes([]byte("@exec(\"uptime\")\n"), opts); err != nil || n.Body["out"] != "uptime #2" {
+		t.Fatalf("other args = %v, %v", n, err)
+	}
+}
+
+type slowResolver struct{}
+
+func (slowResolver) Resolve(name string) ([]byte, error) { return nil, fmt.Errorf("no context") }
+
+func (slowResolver) ResolveContext(ctx context.Context, name string) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCompileContextStopsAtDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := CompileBytesContext(ctx, []byte("import \"https://configs.example/base.bcl\"\nname \"x\"\n"), &Options{ResolveImports: true, IncludeResolver: slowResolver{}})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+		t.Fatalf("err = %v after %s", err, time.Since(start))
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	src := []byte("func spin(n) { return n <= 0 ? 0 : spin(n - 1) }\nv = spin(10)\n")
+	if _, err := CompileBytesContext(canceled, src, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled compile err = %v", err)
+	}
+	if n, err := CompileBytesContext(context.Background(), src, nil); err != nil || n.Body["v"] != int64(0) {
+		t.Fatalf("live compile = %v, %v", n, err)
+	}
+	if _, err := EvalExprContext(canceled, `upper("a")`, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("eval err = %v", err)
+	}
+}
+
+func TestSafeOptionsRefuseDirectivesAndRemoteSources(t *testing.T) {
+	var fetched []string
+	resolver := IncludeResolverFunc(func(name string) ([]byte, error) {
+		fetched = append(fetched, name)
+		return []byte("x = 1\n"), nil
+	})
+	rt := NewRuntime()
+	rt.RegisterDirective("exec", func(*Directive, []any, *Options) ([]Node, error) {
+		t.Fatal("directive ran under SafeOptions")
+		return nil, nil
+	})
+	opts := rt.Options(SafeOptions())
+	opts.ResolveImports = true
+	opts.IncludeResolver = resolver
+	for _, src := range []string{
+		"@exec(\"rm -rf /\")\n",
+		"import \"https://configs.example/base.bcl\"\n",
+		"import \"git::https://example.com/configs.git\"\n",
+	} {
+		if _, err := CompileBytes([]byte(src), opts); err == nil || !strings.Contains(err.Error(), "disabled") {
+			t.Fatalf("%q: err = %v", src, err)
+		}
+	}
+	if n, err := CompileBytes([]byte("import \"base.bcl\"\n"), opts); err != nil || n.Body["x"] != int64(1) {
+		t.Fatalf("local import = %v, %v", n, err)
+	}
+	if len(fetched) != 1 || fetched[0] != "base.bcl" {
+		t.Fatalf("fetched = %v", fetched)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hosts.csv"), []byte("name\nweb\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	safe := SafeOptions()
+	safe.BaseDir = dir
+	if _, err := CompileBytes([]byte(`rows csvdecode("hosts.csv")`), safe); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Fatalf("local csvdecode under SafeOptions: err = %v", err)
+	}
+	if _, err := CompileBytes([]byte(`rows tsvdecode("/etc/passwd", false)`), SafeOptions()); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Fatalf("absolute tsvdecode under SafeOptions: err = %v", err)
+	}
+	safe.FS, safe.BaseDir = os.DirFS(dir), ""
+	if n, err := CompileBytes([]byte(`rows csvdecode("hosts.csv")`), safe); err != nil || !reflect.DeepEqual(n.Body["rows"], []any{map[string]any{"name": "web"}}) {
+		t.Fatalf("csvdecode through FS = %v, %v", n, err)
+	}
+	program := &DecisionProgram{Datasets: map[string]*DatasetDefinition{
+		"remote": {ID: "remote", Source: DatasetSource{Adapter: "https", Config: map[string]any{"url": "https://data.example/x.json"}}},
+	}}
+	if _, err := OpenDecisionDataset(context.Background(), program, "remote", SafeOptions()); err == nil {
+		t.Fatal("expected http dataset to be refused")
+	}
+}
+
+func TestLoggerReceivesCompileAndReloadRecords(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	rt := NewRuntime()
+	rt.SetLogger(logger)
+	resolver := IncludeResolverFunc(func(string) ([]byte, error) { return []byte("x = 1\n"), nil })
+	if _, err := rt.CompileBytes([]byte("import \"base.bcl\"\n"), &Options{ResolveImports: true, IncludeResolver: resolver}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`msg="loaded include" name=base.bcl bytes=6`, `msg="compiled config"`, "errors=0"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("log missing %s:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	path := filepath.Join(t.TempDir(), "app.bcl")
+	if err := os.WriteFile(path, []byte("port 80\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBinder(path, &Options{Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("port 81\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("port \"unterminated\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Reload(); err == nil {
+		t.Fatal("expected reload error")
+	}
+	for _, want := range []string{`level=INFO msg="reloaded config"`, "changes=1", `level=ERROR msg="config reload failed"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("log missing %s:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package bcl
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ExprSandbox evaluates expressions written by end users, such as the rule
+// conditions of a SaaS product, with only the capabilities it grants.
+//
+// Threat model: the expression author is untrusted; the host that
+// configures the sandbox and supplies the variables is trusted. Against
+// the author the sandbox guarantees that an expression
+//   - reads nothing but the variables passed to Eval: env.X is rejected,
+//     and time, hash and encoding helpers are off unless granted;
+//   - calls only the functions named in Functions, builtin or from Custom;
+//     cond, try and coalesce are control forms and always available;
+//   - is at most MaxExprLen bytes and yields no string or list, as an
+//     operator or function result or as the final value, beyond
+//     MaxStringLen bytes or MaxListLen items; repetition, formatting,
+//     padding, join and replace are refused before such a string is built.
+//
+// Regular expressions use RE2 and run in linear time. The sandbox does not
+// bound evaluation time beyond these size limits, and variables must not
+// hold functions or values the author should not read.
+type ExprSandbox struct {
+	Functions []string
+	Custom    map[string]EvalFunction
+	AllowTime bool
+	// MaxExprLen defaults to 4 KiB, MaxStringLen to 64 KiB and MaxListLen
+	// to 10000.
+	MaxExprLen   int
+	MaxStringLen int
+	MaxListLen   int
+}
+
+// Eval evaluates raw against vars under the sandbox.
+func (s *ExprSandbox) Eval(raw string, vars map[string]any) (any, error) {
+	if len(raw) > limitOr(s.MaxExprLen, 4<<10) {
+		return nil, fmt.Errorf("sandbox: expression of %d bytes exceeds the limit of %d", len(raw), limitOr(s.MaxExprLen, 4<<10))
+	}
+	if exprReadsEnv(raw) {
+		return nil, fmt.Errorf("sandbox: environment variables are not available")
+	}
+	opts := &EvalOptions{AllowTime: s.AllowTime, Variables: vars, sandbox: s}
+	v, err := evalExpr(raw, vars, opts)
+	if err != nil {
+		return nil, err
+	}
+	return v, s.checkSize(v)
+}
+
+func (s *ExprSandbox) call(name string, args []any, opts *EvalOptions) (any, error) {
+	if !slices.Contains(s.Functions, name) {
+		return nil, fmt.Errorf("sandbox: function %q is not allowed", name)
+	}
+	if err := s.checkCall(name, args); err != nil {
+		return nil, err
+	}
+	var v any
+	var err error
+	if fn := s.Custom[name]; fn != nil {
+		v, err = fn(args, opts)
+	} else {
+		v, err = evalBuiltin(name, args, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, s.checkSize(v)
+}
+
+// checkOp refuses, before they are built, operator results that would
+// exceed MaxStringLen. The remaining operators grow a string by at most its
+// operands, so checkSize on the result is enough for them.
+func (s *ExprSandbox) checkOp(op string, a, b any) error {
+	switch op {
+	case "*":
+		if str, ok := a.(string); ok {
+			return s.checkRepeat(op, str, b)
+		}
+		if str, ok := b.(string); ok {
+			return s.checkRepeat(op, str, a)
+		}
+	case "%":
+		if format, ok := a.(string); ok {
+			return s.checkBuild(op, formatFieldBytes(format))
+		}
+	}
+	return nil
+}
+
+// checkCall is checkOp for the builtins whose result can be much larger
+// than their arguments.
+func (s *ExprSandbox) checkCall(name string, args []any) error {
+	switch name {
+	case "repeat":
+		if len(args) == 2 {
+			return s.checkRepeat(name, fmt.Sprint(args[0]), args[1])
+		}
+	case "pad_left", "pad_right", "padLeft", "padRight":
+		if len(args) >= 2 {
+			if width, ok := intScalarValue(args[1]); ok {
+				return s.checkBuild(name, width)
+			}
+		}
+	case "format":
+		if len(args) > 0 {
+			if format, ok := args[0].(string); ok {
+				return s.checkBuild(name, formatFieldBytes(format))
+			}
+		}
+	case "join":
+		if len(args) == 2 {
+			if xs, ok := args[0].([]any); ok {
+				n := len(fmt.Sprint(args[1])) * len(xs)
+				for _, x := range xs {
+					if n += len(fmt.Sprint(x)); n > limitOr(s.MaxStringLen, 64<<10) {
+						break
+					}
+				}
+				return s.checkBuild(name, n)
+			}
+		}
+	case "replace":
+		if len(args) == 3 {
+			str, old := fmt.Sprint(args[0]), fmt.Sprint(args[1])
+			return s.checkBuild(name, len(str)+strings.Count(str, old)*len(fmt.Sprint(args[2])))
+		}
+	}
+	return nil
+}
+
+func (s *ExprSandbox) checkRepeat(name, str string, count any) error {
+	if n, ok := intScalarValue(count); ok && n > 0 && len(str) > limitOr(s.MaxStringLen, 64<<10)/n {
+		return fmt.Errorf("sandbox: %s would build a string over the limit of %d bytes", name, limitOr(s.MaxStringLen, 64<<10))
+	}
+	return nil
+}
+
+func (s *ExprSandbox) checkBuild(name string, n int) error {
+	if limit := limitOr(s.MaxStringLen, 64<<10); n > limit {
+		return fmt.Errorf("sandbox: %s would build a string over the limit of %d bytes", name, limit)
+	}
+	return nil
+}
+
+func (s *ExprSandbox) checkSize(v any) error {
+	switch x := v.(type) {
+	case string:
+		if limit := limitOr(s.MaxStringLen, 64<<10); len(x) > limit {
+			return fmt.Errorf("sandbox: string of %d bytes exceeds the limit of %d", len(x), limit)
+		}
+	case []any:
+		if limit := limitOr(s.MaxListLen, 10000); len(x) > limit {
+			return fmt.Errorf("sandbox: list of %d items exceeds the limit of %d", len(x), limit)
+		}
+		for _, item := range x {
+			if err := s.checkSize(item); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for _, item := range x {
+			if err := s.checkSize(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SafeOptions returns compile options for documents from untrusted
+// authors, such as configs submitted by users:
+//   - DisableExec rejects directives, the hook through which a host can
+//     run commands at compile time (an @exec directive, say); the compiler
+//     itself never runs a command, and command blocks compile to plain
+//     data for the host to pass to CommandArgv;
+//   - DisableRemoteInclude refuses imports, modules and data files named
+//     by URL or git source, and http(s) decision datasets, even when an
+//     IncludeResolver could fetch them;
+//   - DisableLocalDataFiles stops csvdecode and tsvdecode from reading
+//     host files; they read only through an FS or IncludeResolver;
+//   - env access stays off and includes are capped at a depth of 8, 1 MiB
+//     per file and 8 MiB in total.
+//
+// Callers may adjust the result, for instance set an FS to confine local
+// imports and data files to one directory tree.
+func SafeOptions() *Options {
+	return &Options{
+		DisableExec:           true,
+		DisableRemoteInclude:  true,
+		DisableLocalDataFiles: true,
+		MaxIncludeDepth:       8,
+		MaxIncludeFileSize:    1 << 20,
+		MaxIncludeBytes:       8 << 20,
+	}
+}
+
+func limitOr(n, def int) int {
+	if n > 0 {
+		return n
+	}
+	return def
+}
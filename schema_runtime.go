@@ -8,9 +8,12 @@ import (
 	"net/mail"
 	"net/url"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // ValidateSchemaValue validates a runtime value against a normalized BCL schema map.
@@ -22,12 +25,32 @@ func ValidateSchemaValue(schemaName string, schema any, value any) []Diagnostic
 	return ctx.diags
 }
 
+// ValidateSchemaValueIn validates value against schemas[name]. Unlike
+// ValidateSchemaValue, all_of, any_of, one_of, not, if/then/else and item
+// types may name other schemas of the set, which is how polymorphic blocks
+// (a notifier that is either slack or email) are described.
+func ValidateSchemaValueIn(schemas map[string]any, name string, value any) []Diagnostic {
+	schema, ok := schemas[name]
+	if !ok {
+		return []Diagnostic{{Severity: "error", Message: fmt.Sprintf("unknown schema %q", name)}}
+	}
+	ctx := &schemaValidationContext{schemaName: name, root: schema, schemas: schemas}
+	ctx.validateSchema(schema, value, "", map[any]bool{})
+	return ctx.diags
+}
+
 type schemaValidationContext struct {
 	schemaName string
 	root       any
+	schemas    map[string]any
+	depth      int
 	diags      []Diagnostic
 }
 
+// maxSchemaRefDepth bounds named-schema resolution so self-referencing
+// compositions terminate.
+const maxSchemaRefDepth = 32
+
 func (c *schemaValidationContext) validateSchema(schema any, value any, path string, stack map[any]bool) bool {
 	m, ok := schema.(map[string]any)
 	if !ok {
@@ -42,6 +65,8 @@ func (c *schemaValidationContext) validateSchema(schema any, value any, path str
 		}
 		c.validateFields(fields, obj, path, m)
 	}
+	c.validateCompositions(m, value, path)
+	c.validateConditional(m, value, path)
 	return len(c.diags) == before
 }
 
@@ -125,6 +150,7 @@ func (c *schemaValidationContext) validateFieldWithOptions(field map[string]any,
 		}
 	}
 	c.validateNumberConstraints(field, v, path)
+	c.validateLength(field, v, path)
 	c.validateStringConstraints(field, v, path)
 	c.validateListConstraints(field, v, path)
 	if obj, ok := v.(map[string]any); ok {
@@ -153,6 +179,9 @@ func (c *schemaValidationContext) validateNumberConstraints(field map[string]any
 	if max, ok := numericFloat(field["exclusive_max"]); ok && got >= max {
 		c.add(path, "must be less than exclusive maximum")
 	}
+	if scalarString(field["format"]) == "port" && !validPort(got) {
+		c.add(path, "does not match format port")
+	}
 	if multiple, ok := numericFloat(field["multiple_of"]); ok && multiple != 0 {
 		q := got / multiple
 		if math.Abs(q-math.Round(q)) > 1e-9 {
@@ -161,17 +190,35 @@ func (c *schemaValidationContext) validateNumberConstraints(field map[string]any
 	}
 }
 
-func (c *schemaValidationContext) validateStringConstraints(field map[string]any, v any, path string) {
-	s, ok := v.(string)
-	if !ok {
-		return
+// validateLength applies min_len and max_len: runes for strings, items for
+// lists and entries for maps.
+func (c *schemaValidationContext) validateLength(field map[string]any, v any, path string) {
+	var n int
+	switch x := v.(type) {
+	case string:
+		n = utf8.RuneCountInString(x)
+	case map[string]any:
+		n = len(x)
+	default:
+		items, ok := sliceValues(v)
+		if !ok {
+			return
+		}
+		n = len(items)
 	}
-	if min, ok := intScalarValue(field["min_len"]); ok && len([]rune(s)) < min {
+	if min, ok := intScalarValue(field["min_len"]); ok && n < min {
 		c.add(path, "is shorter than minimum length")
 	}
-	if max, ok := intScalarValue(field["max_len"]); ok && len([]rune(s)) > max {
+	if max, ok := intScalarValue(field["max_len"]); ok && n > max {
 		c.add(path, "is longer than maximum length")
 	}
+}
+
+func (c *schemaValidationContext) validateStringConstraints(field map[string]any, v any, path string) {
+	s, ok := v.(string)
+	if !ok {
+		return
+	}
 	if pattern := scalarString(field["pattern"]); pattern != "" {
 		re, err := regexp.Compile(pattern)
 		if err != nil {
@@ -183,6 +230,12 @@ func (c *schemaValidationContext) validateStringConstraints(field map[string]any
 	if format := scalarString(field["format"]); format != "" && !schemaFormatMatches(format, s) {
 		c.add(path, fmt.Sprintf("does not match format %s", format))
 	}
+	if schemes := stringList(field["schemes"]); len(schemes) > 0 {
+		u, err := url.Parse(s)
+		if err != nil || !slices.ContainsFunc(schemes, func(x string) bool { return strings.EqualFold(x, u.Scheme) }) {
+			c.add(path, fmt.Sprintf("must use scheme %s", strings.Join(schemes, ", ")))
+		}
+	}
 }
 
 func (c *schemaValidationContext) validateListConstraints(field map[string]any, v any, path string) {
@@ -207,6 +260,13 @@ func (c *schemaValidationContext) validateListConstraints(field map[string]any,
 			seen[key] = true
 		}
 	}
+	if allowed := asAnySlice(field["subset_of"]); len(allowed) > 0 {
+		for i, item := range items {
+			if !slices.ContainsFunc(allowed, func(x any) bool { return equalLoose(item, x) }) {
+				c.add(fmt.Sprintf("%s[%d]", path, i), "is not in the allowed set")
+			}
+		}
+	}
 	if typ := scalarString(field["items"]); typ != "" {
 		for i, item := range items {
 			c.validateTypeRef(typ, item, fmt.Sprintf("%s[%d]", path, i))
@@ -239,6 +299,18 @@ func (c *schemaValidationContext) validateObjectConstraints(schema map[string]an
 	if max, ok := intScalarValue(schema["max_props"]); ok && len(obj) > max {
 		c.add(path, "has too many properties")
 	}
+	if pattern := scalarString(schema["key_pattern"]); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			c.add(path, fmt.Sprintf("has invalid key pattern: %v", err))
+		} else {
+			for _, key := range sortedSchemaNames(obj) {
+				if !re.MatchString(key) {
+					c.add(joinSchemaPath(path, key), "does not match key pattern")
+				}
+			}
+		}
+	}
 	patterns := schemaPatternProperties(schema["pattern_properties"])
 	closed, _ := schema["closed"].(bool)
 	additional, hasAdditional := schema["additional_properties"].(bool)
@@ -324,25 +396,17 @@ func (c *schemaValidationContext) validateCompositions(field map[string]any, v a
 		c.validateTypeRef(typ, v, path)
 	}
 	if xs := stringList(field["any_of"]); len(xs) > 0 {
-		matches := 0
-		for _, typ := range xs {
-			if c.typeRefMatches(typ, v) {
-				matches++
-			}
-		}
-		if matches == 0 {
-			c.add(path, "does not match any allowed schema")
+		if len(c.matchingTypeRefs(xs, v)) == 0 {
+			c.add(path, fmt.Sprintf("does not match any allowed schema (%s)", strings.Join(xs, ", ")))
 		}
 	}
 	if xs := stringList(field["one_of"]); len(xs) > 0 {
-		matches := 0
-		for _, typ := range xs {
-			if c.typeRefMatches(typ, v) {
-				matches++
+		if matched := c.matchingTypeRefs(xs, v); len(matched) != 1 {
+			msg := fmt.Sprintf("must match exactly one schema of %s", strings.Join(xs, ", "))
+			if len(matched) > 1 {
+				msg += fmt.Sprintf("; matched %s", strings.Join(matched, ", "))
 			}
-		}
-		if matches != 1 {
-			c.add(path, "must match exactly one schema")
+			c.add(path, msg)
 		}
 	}
 	if typ := scalarString(field["not"]); typ != "" && c.typeRefMatches(typ, v) {
@@ -366,10 +430,26 @@ func (c *schemaValidationContext) validateConditional(field map[string]any, v an
 	}
 }
 
+func (c *schemaValidationContext) matchingTypeRefs(types []string, v any) []string {
+	var out []string
+	for _, typ := range types {
+		if c.typeRefMatches(typ, v) {
+			out = append(out, typ)
+		}
+	}
+	return out
+}
+
 func (c *schemaValidationContext) validateTypeRef(typ string, v any, path string) {
 	if typ == "" || typ == "any" {
 		return
 	}
+	if schema, ok := c.schemas[typ]; ok && c.depth < maxSchemaRefDepth {
+		c.depth++
+		c.validateSchema(schema, v, path, nil)
+		c.depth--
+		return
+	}
 	if !c.typeRefMatches(typ, v) {
 		c.add(path, fmt.Sprintf("should match %s", typ))
 	}
@@ -379,6 +459,13 @@ func (c *schemaValidationContext) typeRefMatches(typ string, v any) bool {
 	if typ == "" || typ == "any" {
 		return true
 	}
+	if schema, ok := c.schemas[typ]; ok {
+		if c.depth >= maxSchemaRefDepth {
+			return false
+		}
+		sub := &schemaValidationContext{schemaName: c.schemaName, root: schema, schemas: c.schemas, depth: c.depth + 1}
+		return sub.validateSchema(schema, v, "", nil)
+	}
 	if runtimeTypeMatches(typ, v) {
 		return true
 	}
@@ -407,7 +494,19 @@ func schemaFormatMatches(format, s string) bool {
 		u, err := url.ParseRequestURI(s)
 		return err == nil && u.Scheme != ""
 	case "hostname":
-		return len(s) <= 253 && regexp.MustCompile(`^[A-Za-z0-9.-]+$`).MatchString(s)
+		return validHostname(s)
+	case "cidr":
+		_, _, err := net.ParseCIDR(s)
+		return err == nil
+	case "mac":
+		_, err := net.ParseMAC(s)
+		return err == nil
+	case "port":
+		n, err := strconv.Atoi(s)
+		return err == nil && validPort(float64(n))
+	case "duration":
+		_, err := time.ParseDuration(s)
+		return err == nil
 	case "ipv4":
 		ip := net.ParseIP(s)
 		return ip != nil && ip.To4() != nil
@@ -433,6 +532,31 @@ func schemaFormatMatches(format, s string) bool {
 	}
 }
 
+// validHostname applies RFC 1123: dot-separated labels of letters, digits
+// and inner hyphens, each at most 63 bytes, 253 in total.
+func validHostname(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			ch := label[i]
+			if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func validPort(n float64) bool {
+	return n >= 1 && n <= 65535 && n == math.Trunc(n)
+}
+
 func schemaValueKey(v any) string {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -403,3 +403,157 @@ func TestImportJSONSchema(t *testing.T) {
 		t.Fatalf("bad imported schema: %#v", decl)
 	}
 }
+
+func TestSchemaNetworkFormats(t *testing.T) {
+	n, err := CompileBytes([]byte(`
+schema listener {
+  required host string format hostname
+  optional addr string format ip
+  optional subnet string format cidr
+  optional hw string format mac
+  optional port int format port
+  optional timeout string format duration
+  optional upstream string format url schemes ["https", "grpc"]
+}
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := n.Schemas["listener"]
+	valid := map[string]any{
+		"host": "api.example.com", "addr": "10.0.0.1", "subnet": "10.0.0.0/8", "hw": "00:1a:2b:3c:4d:5e",
+		"port": int64(8443), "timeout": "1m30s", "upstream": "https://backend:9000",
+	}
+	if diags := ValidateSchemaValue("listener", schema, valid); len(diags) != 0 {
+		t.Fatalf("valid listener diagnostics = %v", diags)
+	}
+	diags := ValidateSchemaValue("listener", schema, map[string]any{
+		"host": "-bad-.example", "addr": "10.0.0.300", "subnet": "10.0.0.0/33", "hw": "zz:zz",
+		"port": int64(70000), "timeout": "soon", "upstream": "http://backend",
+	})
+	text := FormatDiagnostics(diags)
+	for _, want := range []string{"format hostname", "format ip", "format cidr", "format mac", "format port", "format duration", "must use scheme https, grpc"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("missing %q in diagnostics:\n%s", want, text)
+		}
+	}
+}
+
+func TestSchemaCollectionValidators(t *testing.T) {
+	n, err := CompileBytes([]byte(`
+schema grant {
+  required scopes list items string unique_items subset_of ["read", "write", "admin"]
+  optional labels map key_pattern "^[a-z][a-z0-9_]*$" min_len 1 max_len 2
+}
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := n.Schemas["grant"]
+	valid := map[string]any{"scopes": []any{"read", "write"}, "labels": map[string]any{"team": "core"}}
+	if diags := ValidateSchemaValue("grant", schema, valid); len(diags) != 0 {
+		t.Fatalf("valid grant diagnostics = %v", diags)
+	}
+	diags := ValidateSchemaValue("grant", schema, map[string]any{
+		"scopes": []any{"read", "read", "delete"},
+		"labels": map[string]any{"Team": "core", "env": "prod", "tier": "1"},
+	})
+	text := FormatDiagnostics(diags)
+	for _, want := range []string{"duplicate items", "scopes[2]\" is not in the allowed set", "labels.Team\" does not match key pattern", "labels\" is longer than maximum length"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("missing %q in diagnostics:\n%s", want, text)
+		}
+	}
+	if diags := ValidateSchemaValue("grant", schema, map[string]any{"scopes": []any{}, "labels": map[string]any{}}); !strings.Contains(FormatDiagnostics(diags), "shorter than minimum length") {
+		t.Fatalf("empty map should be below min_len: %v", diags)
+	}
+}
+
+func TestSchemaCompositionAcrossSchemas(t *testing.T) {
+	src := `
+schema slack_notifier {
+  required webhook string format url
+  optional channel string
+}
+
+schema email_notifier {
+  required to string format email
+  optional subject string
+}
+
+schema notifier {
+  one_of [slack_notifier, email_notifier]
+}
+`
+	doc, err := Parse([]byte(src + `
+notifier ops {
+  webhook "https://hooks.example.com/x"
+}
+notifier billing {
+  to "billing@example.com"
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diags := Validate(doc, nil); len(diags) != 0 {
+		t.Fatalf("valid notifiers diagnostics = %v", diags)
+	}
+	doc, err = Parse([]byte(src + `
+notifier broken {
+  channel "#ops"
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := FormatDiagnostics(Validate(doc, nil))
+	if !strings.Contains(text, "must match exactly one schema of slack_notifier, email_notifier") {
+		t.Fatalf("missing one_of diagnostic:\n%s", text)
+	}
+	n, err := CompileBytes([]byte(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	both := map[string]any{"webhook": "https://x.example", "to": "a@example.com"}
+	if text := FormatDiagnostics(ValidateSchemaValueIn(n.Schemas, "notifier", both)); !strings.Contains(text, "matched slack_notifier, email_notifier") {
+		t.Fatalf("missing ambiguous match diagnostic:\n%s", text)
+	}
+}
+
+func TestInferSchemaFromSamples(t *testing.T) {
+	schema, err := InferSchema(
+		[]byte("name \"a\"\nmode \"fast\"\nport 8080\nratio 1\ndb { host \"h1\" }\n"),
+		[]byte("name \"b\"\nmode \"fast\"\nport 9090\nratio 0.5\ntags [\"x\"]\ndb {\n  host \"h2\"\n  port 5432\n}\n"),
+		[]byte("name \"c\"\nmode \"safe\"\nport 1\nratio 2\ndb { host \"h3\" }\n"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := FormatDocument(&Document{Items: []Node{schema}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"required db object {", "optional port int", `required mode string enum ["fast", "safe"]`,
+		"required name string\n", "required ratio number", "optional tags list items string",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Fatalf("inferred schema missing %q:\n%s", want, out)
+		}
+	}
+	doc, err := Parse(append(out, []byte("\nname \"d\"\nmode \"safe\"\nport 2\nratio 3\ndb { host \"h4\" }\n")...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := Compile(doc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diags := ValidateSchemaValue("inferred", n.Schemas["inferred"], n.Body); len(diags) != 0 {
+		t.Fatalf("new sample should satisfy the inferred schema: %v", diags)
+	}
+	if _, err := InferSchema([]byte("broken \"")); err == nil || !strings.Contains(err.Error(), "sample 1") {
+		t.Fatalf("expected sample error, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package bcl
+
+import (
+	"path"
+	"strings"
+)
+
+// applySelection trims the compiled output to Options.Only and drops
+// Options.Exclude. Patterns are dotted paths whose segments may use glob
+// syntax ("server", "database.*", "internal.*"); a pattern selects the
+// whole subtree at every path it matches. Labelled blocks are matched by
+// "type" and "type.id". Maps emptied by the selection are dropped.
+func (c *compiler) applySelection() {
+	if len(c.opts.Only) == 0 && len(c.opts.Exclude) == 0 {
+		return
+	}
+	only := splitPatterns(c.opts.Only)
+	exclude := splitPatterns(c.opts.Exclude)
+	c.out.Body = selectTree(c.out.Body, nil, only, exclude)
+	blocks := c.out.Blocks[:0]
+	for _, b := range c.out.Blocks {
+		p := []string{stringValue(b["type"])}
+		if id := stringValue(b["id"]); id != "" {
+			p = append(p, id)
+		}
+		if patternsCover(exclude, p) || len(only) > 0 && !patternsCover(only, p) {
+			continue
+		}
+		blocks = append(blocks, b)
+	}
+	c.out.Blocks = blocks
+	if c.out.provenance != nil {
+		for k := range c.out.provenance {
+			p := strings.Split(k, ".")
+			if patternsCover(exclude, p) || len(only) > 0 && !patternsCover(only, p) {
+				delete(c.out.provenance, k)
+			}
+		}
+	}
+}
+
+func splitPatterns(patterns []string) [][]string {
+	out := make([][]string, 0, len(patterns))
+	for _, p := range patterns {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, strings.Split(p, "."))
+		}
+	}
+	return out
+}
+
+func selectTree(m map[string]any, prefix []string, only, exclude [][]string) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		p := append(prefix[:len(prefix):len(prefix)], k)
+		if patternsCover(exclude, p) {
+			continue
+		}
+		child, isMap := v.(map[string]any)
+		switch {
+		case len(only) == 0 || patternsCover(only, p):
+			if isMap {
+				sub := selectTree(child, p, nil, exclude)
+				if len(sub) == 0 && len(child) > 0 {
+					continue
+				}
+				v = sub
+			}
+			out[k] = v
+		case isMap && patternsLeadInto(only, p):
+			if sub := selectTree(child, p, only, exclude); len(sub) > 0 {
+				out[k] = sub
+			}
+		}
+	}
+	return out
+}
+
+// patternsCover reports whether some pattern matches p or an ancestor of p.
+func patternsCover(patterns [][]string, p []string) bool {
+	for _, pat := range patterns {
+		if len(pat) <= len(p) && segmentsMatch(pat, p[:len(pat)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// patternsLeadInto reports whether some pattern selects a path below p.
+func patternsLeadInto(patterns [][]string, p []string) bool {
+	for _, pat := range patterns {
+		if len(pat) > len(p) && segmentsMatch(pat[:len(p)], p) {
+			return true
+		}
+	}
+	return false
+}
+
+func segmentsMatch(pat, p []string) bool {
+	for i := range pat {
+		if ok, err := path.Match(pat[i], p[i]); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
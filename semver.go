@@ -0,0 +1,246 @@
+package bcl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type semver struct {
+	Major, Minor, Patch int64
+	Pre                 []string
+	Build               string
+	// parts records how many numeric components were written, so "1.2"
+	// in a constraint can be treated as the range 1.2.x.
+	parts int
+}
+
+// parseSemver accepts MAJOR[.MINOR[.PATCH]][-PRE][+BUILD] with an optional
+// leading "v". Missing components default to zero.
+func parseSemver(s string) (semver, error) {
+	raw := s
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	var v semver
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		v.Build = s[i+1:]
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		if s[i+1:] == "" {
+			return v, fmt.Errorf("invalid semantic version %q", raw)
+		}
+		v.Pre = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+	nums := strings.Split(s, ".")
+	if s == "" || len(nums) > 3 {
+		return v, fmt.Errorf("invalid semantic version %q", raw)
+	}
+	wildcard := false
+	for i, part := range nums {
+		if part == "x" || part == "X" || part == "*" {
+			wildcard = true
+			continue
+		}
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || n < 0 || wildcard {
+			return v, fmt.Errorf("invalid semantic version %q", raw)
+		}
+		switch i {
+		case 0:
+			v.Major = n
+		case 1:
+			v.Minor = n
+		case 2:
+			v.Patch = n
+		}
+		v.parts = i + 1
+	}
+	return v, nil
+}
+
+func (v semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Pre) > 0 {
+		s += "-" + strings.Join(v.Pre, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+func (v semver) toMap() map[string]any {
+	pre := make([]any, len(v.Pre))
+	for i, p := range v.Pre {
+		pre[i] = p
+	}
+	return map[string]any{
+		"major":      v.Major,
+		"minor":      v.Minor,
+		"patch":      v.Patch,
+		"prerelease": pre,
+		"build":      v.Build,
+		"version":    v.String(),
+	}
+}
+
+// compareSemver orders versions by SemVer 2.0 precedence; build metadata is
+// ignored.
+func compareSemver(a, b semver) int {
+	for _, d := range [...]int64{a.Major - b.Major, a.Minor - b.Minor, a.Patch - b.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	switch {
+	case len(a.Pre) == 0 && len(b.Pre) == 0:
+		return 0
+	case len(a.Pre) == 0:
+		return 1
+	case len(b.Pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.Pre) && i < len(b.Pre); i++ {
+		if c := comparePrerelease(a.Pre[i], b.Pre[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a.Pre) < len(b.Pre):
+		return -1
+	case len(a.Pre) > len(b.Pre):
+		return 1
+	}
+	return 0
+}
+
+func comparePrerelease(a, b string) int {
+	an, aErr := strconv.ParseInt(a, 10, 64)
+	bn, bErr := strconv.ParseInt(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		if an < bn {
+			return -1
+		}
+		if an > bn {
+			return 1
+		}
+		return 0
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// semverSatisfies checks a version against a constraint. Space separated
+// comparators are ANDed and "||" separates alternatives; ^, ~, x-ranges and
+// partial versions are supported, e.g. ">=1.2 <2", "^1.4", "~2.1 || 3.x".
+func semverSatisfies(version, constraint string) (bool, error) {
+	v, err := parseSemver(version)
+	if err != nil {
+		return false, err
+	}
+	for _, alt := range strings.Split(constraint, "||") {
+		ok, err := semverSatisfiesAll(v, strings.Fields(strings.ReplaceAll(alt, ",", " ")))
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func semverSatisfiesAll(v semver, comparators []string) (bool, error) {
+	for i := 0; i < len(comparators); i++ {
+		c := comparators[i]
+		// Allow a space between operator and version: ">= 1.2".
+		if strings.Trim(c, "<>=!~^") == "" && i+1 < len(comparators) {
+			c += comparators[i+1]
+			i++
+		}
+		ok, err := semverMatch(v, c)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func semverMatch(v semver, c string) (bool, error) {
+	if c == "*" || c == "x" || c == "X" {
+		return true, nil
+	}
+	op := strings.TrimRight(c[:len(c)-len(strings.TrimLeft(c, "<>=!~^"))], " ")
+	target, err := parseSemver(c[len(op):])
+	if err != nil {
+		return false, fmt.Errorf("invalid version constraint %q", c)
+	}
+	cmp := compareSemver(v, target)
+	switch op {
+	case "", "=", "==":
+		if target.parts < 3 {
+			return semverInRange(v, target, semverBump(target, target.parts)), nil
+		}
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case ">":
+		if target.parts < 3 {
+			return compareSemver(v, semverBump(target, target.parts)) >= 0, nil
+		}
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		if target.parts < 3 {
+			return compareSemver(v, semverBump(target, target.parts)) < 0, nil
+		}
+		return cmp <= 0, nil
+	case "~":
+		parts := 2
+		if target.parts < 2 {
+			parts = 1
+		}
+		return semverInRange(v, target, semverBump(target, parts)), nil
+	case "^":
+		parts := 1
+		switch {
+		case target.Major == 0 && target.Minor == 0 && target.parts >= 3:
+			parts = 3
+		case target.Major == 0 && target.parts >= 2:
+			parts = 2
+		}
+		return semverInRange(v, target, semverBump(target, parts)), nil
+	default:
+		return false, fmt.Errorf("invalid version constraint %q", c)
+	}
+}
+
+func semverInRange(v, lo, hi semver) bool {
+	return compareSemver(v, lo) >= 0 && compareSemver(v, hi) < 0
+}
+
+// semverBump returns the lowest version above every version sharing the
+// first parts components of v.
+func semverBump(v semver, parts int) semver {
+	switch parts {
+	case 0:
+		return semver{Major: 1 << 62}
+	case 1:
+		return semver{Major: v.Major + 1}
+	case 2:
+		return semver{Major: v.Major, Minor: v.Minor + 1}
+	default:
+		return semver{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+	}
+}
@@ -0,0 +1,220 @@
+package bcl
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SQLPlaceholder returns the n-th (1-based) bind placeholder for dialect:
+// $n for postgres and ? for mysql and sqlite.
+func SQLPlaceholder(dialect string, n int) string {
+	if dialect == "postgres" {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// BindSQLPlaceholders rewrites the ? placeholders of a clause written in
+// config, such as a DeleteData Where, into dialect placeholders starting at
+// first. Placeholders inside quoted strings and -- or /* */ comments are
+// left alone, and the number of placeholders must match args so values are
+// never spliced into SQL.
+func BindSQLPlaceholders(dialect, clause string, first int, args []any) (string, error) {
+	if _, ok := sqlIdentLimits[dialect]; !ok {
+		return "", fmt.Errorf("unknown SQL dialect %q", dialect)
+	}
+	var b strings.Builder
+	n := 0
+	var quote byte
+	for i := 0; i < len(clause); i++ {
+		c := clause[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(clause[i:], "--"):
+			end := strings.IndexByte(clause[i:], '\n')
+			if end < 0 {
+				// End the comment so SQL appended after the clause is
+				// not commented out.
+				b.WriteString(clause[i:])
+				b.WriteByte('\n')
+				i = len(clause)
+				continue
+			}
+			b.WriteString(clause[i : i+end])
+			i += end - 1
+			continue
+		case strings.HasPrefix(clause[i:], "/*"):
+			end := strings.Index(clause[i+2:], "*/")
+			if end < 0 {
+				return "", fmt.Errorf("SQL clause %q has an unterminated comment", clause)
+			}
+			b.WriteString(clause[i : i+end+4])
+			i += end + 3
+			continue
+		case c == ';':
+			return "", fmt.Errorf("SQL clause %q contains a statement separator", clause)
+		case c == '?':
+			b.WriteString(SQLPlaceholder(dialect, first+n))
+			n++
+			continue
+		}
+		b.WriteByte(c)
+	}
+	if quote != 0 {
+		return "", fmt.Errorf("SQL clause %q has an unterminated quote", clause)
+	}
+	if n != len(args) {
+		return "", fmt.Errorf("SQL clause %q has %d placeholders for %d arguments", clause, n, len(args))
+	}
+	return b.String(), nil
+}
+
+// DeleteSQL builds a DELETE for table with where bound to args. An empty
+// where deletes every row and must be asked for with where "true".
+func DeleteSQL(dialect, table, where string, args []any) (string, []any, error) {
+	name, err := QuoteSQLIdentifier(table, dialect)
+	if err != nil {
+		return "", nil, err
+	}
+	if strings.TrimSpace(where) == "" {
+		return "", nil, fmt.Errorf("delete from %s requires a where clause", table)
+	}
+	clause, err := BindSQLPlaceholders(dialect, where, 1, args)
+	if err != nil {
+		return "", nil, err
+	}
+	return "DELETE FROM " + name + " WHERE " + clause, append([]any(nil), args...), nil
+}
+
+// InsertSQL builds a multi-row INSERT for table. Columns are the sorted
+// union of the row keys; a row missing a column binds NULL.
+func InsertSQL(dialect, table string, rows []map[string]any) (string, []any, error) {
+	name, err := QuoteSQLIdentifier(table, dialect)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(rows) == 0 {
+		return "", nil, fmt.Errorf("insert into %s has no rows", table)
+	}
+	seen := map[string]bool{}
+	var cols []string
+	for _, row := range rows {
+		for k := range row {
+			if !seen[k] {
+				seen[k] = true
+				cols = append(cols, k)
+			}
+		}
+	}
+	sort.Strings(cols)
+	quoted := make([]string, len(cols))
+	for i, col := range cols {
+		if quoted[i], err = QuoteSQLIdentifier(col, dialect); err != nil {
+			return "", nil, err
+		}
+	}
+	var b strings.Builder
+	b.WriteString("INSERT INTO " + name + " (" + strings.Join(quoted, ", ") + ") VALUES ")
+	args := make([]any, 0, len(rows)*len(cols))
+	for i, row := range rows {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('(')
+		for j, col := range cols {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			args = append(args, row[col])
+			b.WriteString(SQLPlaceholder(dialect, len(args)))
+		}
+		b.WriteByte(')')
+	}
+	return b.String(), args, nil
+}
+
+// SQLIndex describes a CreateIndex migration command:
+//
+//	CreateIndex "users_email_idx" {
+//	  table "users"
+//	  columns ["email"]
+//	  unique true
+//	  concurrently true
+//	}
+//
+// Concurrently builds the index without locking writes; it is postgres only
+// and the statement must run outside a transaction, which ExecSQLMigration
+// takes care of.
+type SQLIndex struct {
+	Name         string
+	Table        string
+	Columns      []string
+	Unique       bool
+	Concurrently bool
+}
+
+// CreateIndexSQL builds the CREATE INDEX statement for idx.
+func CreateIndexSQL(dialect string, idx SQLIndex) (string, error) {
+	if idx.Concurrently && dialect != "postgres" {
+		return "", fmt.Errorf("index %s: concurrently is only supported by postgres", idx.Name)
+	}
+	if len(idx.Columns) == 0 {
+		return "", fmt.Errorf("index %s has no columns", idx.Name)
+	}
+	name, err := QuoteSQLIdentifier(idx.Name, dialect)
+	if err != nil {
+		return "", err
+	}
+	table, err := QuoteSQLIdentifier(idx.Table, dialect)
+	if err != nil {
+		return "", err
+	}
+	cols := make([]string, len(idx.Columns))
+	for i, col := range idx.Columns {
+		if cols[i], err = QuoteSQLIdentifier(col, dialect); err != nil {
+			return "", err
+		}
+	}
+	var b strings.Builder
+	b.WriteString("CREATE ")
+	if idx.Unique {
+		b.WriteString("UNIQUE ")
+	}
+	b.WriteString("INDEX ")
+	if idx.Concurrently {
+		b.WriteString("CONCURRENTLY ")
+	}
+	b.WriteString(name + " ON " + table + " (" + strings.Join(cols, ", ") + ")")
+	return b.String(), nil
+}
+
+// DropIndexSQL builds the DROP INDEX statement for the index name on table.
+// Mysql needs the table; concurrently is postgres only, as for
+// CreateIndexSQL.
+func DropIndexSQL(dialect, table, name string, concurrently bool) (string, error) {
+	if concurrently && dialect != "postgres" {
+		return "", fmt.Errorf("index %s: concurrently is only supported by postgres", name)
+	}
+	quoted, err := QuoteSQLIdentifier(name, dialect)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case dialect == "mysql":
+		t, err := QuoteSQLIdentifier(table, dialect)
+		if err != nil {
+			return "", err
+		}
+		return "DROP INDEX " + quoted + " ON " + t, nil
+	case concurrently:
+		return "DROP INDEX CONCURRENTLY " + quoted, nil
+	}
+	return "DROP INDEX " + quoted, nil
+}
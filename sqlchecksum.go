@@ -0,0 +1,121 @@
+package bcl
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	checksumMu         sync.RWMutex
+	checksumAlgorithms = map[string]func() hash.Hash{
+		"sha256": sha256.New,
+		"sha512": sha512.New,
+	}
+)
+
+// RegisterChecksumAlgorithm makes algo available to MigrationChecksum.
+// sha256 and sha512 are built in; blake3 can be added by the caller, for
+// example with lukechampine.com/blake3:
+//
+//	bcl.RegisterChecksumAlgorithm("blake3", func() hash.Hash { return blake3.New(32, nil) })
+func RegisterChecksumAlgorithm(algo string, fn func() hash.Hash) {
+	checksumMu.Lock()
+	defer checksumMu.Unlock()
+	checksumAlgorithms[algo] = fn
+}
+
+// MigrationChecksum returns "algo:hex" for m, hashed over its canonical
+// FormatDocument form rather than its source bytes, so reindenting,
+// comments and `=` style do not change it. An empty algo means sha256.
+func MigrationChecksum(m *Block, algo string) (string, error) {
+	if algo == "" {
+		algo = "sha256"
+	}
+	checksumMu.RLock()
+	fn := checksumAlgorithms[algo]
+	checksumMu.RUnlock()
+	if fn == nil {
+		return "", fmt.Errorf("unknown checksum algorithm %q", algo)
+	}
+	src, err := FormatDocument(&Document{Items: []Node{m}})
+	if err != nil {
+		return "", err
+	}
+	h := fn()
+	h.Write(src)
+	return algo + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// MigrationChecksums returns the checksum of every Migration block in doc
+// by migration id.
+func MigrationChecksums(doc *Document, algo string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, m := range childBlocks(doc.Items, "Migration") {
+		sum, err := MigrationChecksum(m, algo)
+		if err != nil {
+			return nil, err
+		}
+		out[m.ID] = sum
+	}
+	return out, nil
+}
+
+// VerifyMigrationChecksums compares the checksums recorded when migrations
+// were applied with doc, hashing each migration with the algorithm named in
+// its recorded value. Edited and missing migrations are reported as errors.
+func VerifyMigrationChecksums(doc *Document, recorded map[string]string) ([]Diagnostic, error) {
+	migrations := map[string]*Block{}
+	for _, m := range childBlocks(doc.Items, "Migration") {
+		migrations[m.ID] = m
+	}
+	var diags []Diagnostic
+	for _, id := range slices.Sorted(maps.Keys(recorded)) {
+		m := migrations[id]
+		if m == nil {
+			diags = append(diags, Diagnostic{Severity: "error", Message: fmt.Sprintf("applied migration %q is missing", id)})
+			continue
+		}
+		algo, _, ok := strings.Cut(recorded[id], ":")
+		if !ok {
+			return nil, fmt.Errorf("migration %q: checksum %q has no algorithm prefix", id, recorded[id])
+		}
+		sum, err := MigrationChecksum(m, algo)
+		if err != nil {
+			return nil, err
+		}
+		if sum != recorded[id] {
+			diags = append(diags, Diagnostic{Severity: "error", Message: fmt.Sprintf("applied migration %q was edited: checksum %s, recorded %s", id, sum, recorded[id]), Span: m.Span})
+		}
+	}
+	return diags, nil
+}
+
+// RepairMigrationChecksums returns recorded with the checksum of every
+// migration still in doc recomputed with algo, and the ids whose value
+// changed. Store the result after checking that the edits were harmless,
+// or to move history to the normalized form or a new algorithm.
+func RepairMigrationChecksums(doc *Document, recorded map[string]string, algo string) (map[string]string, []string, error) {
+	current, err := MigrationChecksums(doc, algo)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := make(map[string]string, len(recorded))
+	var changed []string
+	for id, sum := range recorded {
+		out[id] = sum
+		if cur, ok := current[id]; ok && cur != sum {
+			out[id] = cur
+			changed = append(changed, id)
+		}
+	}
+	sort.Strings(changed)
+	return out, changed, nil
+}
@@ -0,0 +1,240 @@
+package bcl
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQLExecer is the part of *sql.DB, *sql.Conn and *sql.Tx that
+// ExecSQLStatements needs; ExecSQLStatementsTx runs statements atomically.
+type SQLExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// SQLTxBeginner is the part of *sql.DB and *sql.Conn that
+// ExecSQLStatementsTx needs.
+type SQLTxBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// SQLQueryer is the part of *sql.DB, *sql.Conn and *sql.Tx that QuerySQL
+// needs.
+type SQLQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// SQLStatement is a statement with the arguments bound to its
+// placeholders, such as the query and args returned by InsertSQL.
+type SQLStatement struct {
+	SQL  string
+	Args []any
+}
+
+// SQLStatements wraps statements that take no arguments.
+func SQLStatements(sql ...string) []SQLStatement {
+	out := make([]SQLStatement, len(sql))
+	for i, q := range sql {
+		out[i] = SQLStatement{SQL: q}
+	}
+	return out
+}
+
+// SQLStatementResult records one executed statement so history and checks
+// can store what actually ran.
+type SQLStatementResult struct {
+	Index        int
+	SQL          string
+	Duration     time.Duration
+	RowsAffected int64
+	Err          error
+}
+
+// SQLStatementError is returned by ExecSQLStatements for the statement that
+// failed.
+type SQLStatementError struct {
+	Index int
+	SQL   string
+	Err   error
+}
+
+func (e *SQLStatementError) Error() string {
+	return fmt.Sprintf("statement %d: %v: %s", e.Index, e.Err, e.SQL)
+}
+
+func (e *SQLStatementError) Unwrap() error { return e.Err }
+
+// SQLProgress reports a statement of ExecSQLStatementsProgress: once with
+// Done false when it starts and once with Done true when it finishes.
+// Elapsed is measured from the start of the first statement.
+type SQLProgress struct {
+	Index        int
+	Total        int
+	SQL          string
+	Done         bool
+	Elapsed      time.Duration
+	RowsAffected int64
+	Err          error
+}
+
+// ExecSQLStatements runs stmts in order and stops at the first failure,
+// which is included in the results and returned as a *SQLStatementError.
+func ExecSQLStatements(ctx context.Context, db SQLExecer, stmts []SQLStatement) ([]SQLStatementResult, error) {
+	return ExecSQLStatementsProgress(ctx, db, stmts, nil)
+}
+
+// ExecSQLStatementsProgress is ExecSQLStatements with progress reported to
+// fn, so a long migration can show which statement it is waiting on.
+func ExecSQLStatementsProgress(ctx context.Context, db SQLExecer, stmts []SQLStatement, fn func(SQLProgress)) ([]SQLStatementResult, error) {
+	run := &sqlRun{total: len(stmts), begin: time.Now(), fn: fn}
+	err := run.exec(ctx, db, stmts)
+	return run.results, err
+}
+
+// ExecSQLStatementsTx runs stmts in one transaction begun with opts, which
+// may be nil. It commits when every statement succeeds and otherwise rolls
+// back, so either all of stmts apply or none do.
+func ExecSQLStatementsTx(ctx context.Context, db SQLTxBeginner, stmts []SQLStatement, opts *sql.TxOptions) ([]SQLStatementResult, error) {
+	run := &sqlRun{total: len(stmts), begin: time.Now()}
+	err := run.execTx(ctx, db, stmts, opts)
+	return run.results, err
+}
+
+// QuerySQL runs query with args and returns its rows as maps from column
+// name to value, with []byte values as strings, for checks such as a
+// migration's precondition or verification query.
+func QuerySQL(ctx context.Context, db SQLQueryer, query string, args ...any) ([]map[string]any, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var out []map[string]any
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]any, len(cols))
+		for i, col := range cols {
+			if b, ok := vals[i].([]byte); ok {
+				row[col] = string(b)
+			} else {
+				row[col] = vals[i]
+			}
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+// SQLBatch is a run of statements that ExecSQLMigration executes together:
+// in one transaction when Tx is set, otherwise one by one outside any
+// transaction.
+type SQLBatch struct {
+	Tx    bool
+	Stmts []SQLStatement
+}
+
+// SQLMigrationBatches splits stmts, keeping their order, into transactional
+// batches separated by the statements postgres refuses to run inside a
+// transaction block, such as CREATE INDEX CONCURRENTLY and VACUUM.
+func SQLMigrationBatches(stmts []SQLStatement) []SQLBatch {
+	var out []SQLBatch
+	for _, stmt := range stmts {
+		tx := !sqlNeedsNoTx(stmt.SQL)
+		if len(out) == 0 || out[len(out)-1].Tx != tx || !tx {
+			out = append(out, SQLBatch{Tx: tx})
+		}
+		out[len(out)-1].Stmts = append(out[len(out)-1].Stmts, stmt)
+	}
+	return out
+}
+
+// ExecSQLMigration runs stmts batched by SQLMigrationBatches, committing
+// each transactional batch before the next non-transactional statement, and
+// reports progress to fn as ExecSQLStatementsProgress does. A failure rolls
+// back the current batch only; batches already committed stay applied.
+func ExecSQLMigration(ctx context.Context, db *sql.DB, stmts []SQLStatement, fn func(SQLProgress)) ([]SQLStatementResult, error) {
+	run := &sqlRun{total: len(stmts), begin: time.Now(), fn: fn}
+	for _, batch := range SQLMigrationBatches(stmts) {
+		if !batch.Tx {
+			if err := run.exec(ctx, db, batch.Stmts); err != nil {
+				return run.results, err
+			}
+			continue
+		}
+		if err := run.execTx(ctx, db, batch.Stmts, nil); err != nil {
+			return run.results, err
+		}
+	}
+	return run.results, nil
+}
+
+// sqlNeedsNoTx reports whether postgres rejects stmt inside a transaction.
+func sqlNeedsNoTx(stmt string) bool {
+	words := strings.Fields(strings.ToUpper(stmt))
+	for i, w := range words {
+		if i > 4 {
+			break
+		}
+		if w == "CONCURRENTLY" {
+			return true
+		}
+	}
+	return len(words) > 0 && (words[0] == "VACUUM" || len(words) > 1 && words[0] == "CREATE" && words[1] == "DATABASE")
+}
+
+// sqlRun executes statements for ExecSQLStatementsProgress and
+// ExecSQLMigration, numbering them across batches.
+type sqlRun struct {
+	total   int
+	begin   time.Time
+	fn      func(SQLProgress)
+	results []SQLStatementResult
+}
+
+func (run *sqlRun) execTx(ctx context.Context, db SQLTxBeginner, stmts []SQLStatement, opts *sql.TxOptions) error {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if err := run.exec(ctx, tx, stmts); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (run *sqlRun) exec(ctx context.Context, db SQLExecer, stmts []SQLStatement) error {
+	for _, s := range stmts {
+		i, stmt := len(run.results), s.SQL
+		if run.fn != nil {
+			run.fn(SQLProgress{Index: i, Total: run.total, SQL: stmt, Elapsed: time.Since(run.begin)})
+		}
+		start := time.Now()
+		res, err := db.ExecContext(ctx, stmt, s.Args...)
+		r := SQLStatementResult{Index: i, SQL: stmt, Duration: time.Since(start), Err: err}
+		if err == nil {
+			r.RowsAffected, _ = res.RowsAffected()
+		}
+		run.results = append(run.results, r)
+		if run.fn != nil {
+			run.fn(SQLProgress{Index: i, Total: run.total, SQL: stmt, Done: true, Elapsed: time.Since(run.begin), RowsAffected: r.RowsAffected, Err: err})
+		}
+		if err != nil {
+			return &SQLStatementError{Index: i, SQL: stmt, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package bcl
+
+import "fmt"
+
+// DestructiveMigrations reports, as errors, the DropTable, DropColumn and
+// unlimited DeleteData commands in the Up blocks of Migration blocks in
+// doc. IF chains are expanded and values evaluated as Compile would: a
+// migration whose approved evaluates to true is exempt, and a DeleteData
+// counts as limited only when its limit is a positive number. allow turns
+// the errors into warnings, for --allow-destructive style flags.
+func DestructiveMigrations(doc *Document, allow bool) []Diagnostic {
+	severity := "error"
+	if allow {
+		severity = "warning"
+	}
+	c := &compiler{opts: &Options{}, out: &Normalized{Body: map[string]any{}, Constants: map[string]any{}}, consts: map[string]Value{}, sets: map[string][]Value{}, types: map[string]string{}, schemaDecls: map[string]*SchemaDecl{}}
+	for _, n := range doc.Items {
+		if x, ok := n.(*ConstDecl); ok {
+			c.consts[x.Name] = x.Value
+			c.out.Constants[x.Name] = c.value(x.Value)
+		}
+	}
+	var diags []Diagnostic
+	for _, m := range childBlocks(c.expandIf(doc.Items), "Migration") {
+		body := c.expandIf(m.Body)
+		if c.assignedValue(body, "approved") == true {
+			continue
+		}
+		for _, up := range childBlocks(body, "Up") {
+			var walk func([]Node)
+			walk = func(nodes []Node) {
+				for _, b := range childBlocks(c.expandIf(nodes), "") {
+					destructive := b.Type == "DropTable" || b.Type == "DropColumn"
+					if b.Type == "DeleteData" && !c.positiveLimit(c.expandIf(b.Body)) {
+						destructive = true
+					}
+					if destructive {
+						diags = append(diags, Diagnostic{Severity: severity, Message: fmt.Sprintf("migration %q: %s %q is destructive; set approved = true to apply it", m.ID, b.Type, b.ID), Span: b.Span})
+					}
+					walk(b.Body)
+				}
+			}
+			walk(up.Body)
+		}
+	}
+	return diags
+}
+
+// childBlocks returns the blocks among nodes, only those of type typ when
+// it is not empty.
+func childBlocks(nodes []Node, typ string) []*Block {
+	var out []*Block
+	for _, n := range nodes {
+		if b, ok := n.(*Block); ok && (typ == "" || b.Type == typ) {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// assignedValue evaluates the last assignment to name among nodes, or
+// returns nil.
+func (c *compiler) assignedValue(nodes []Node, name string) any {
+	var v any
+	for _, n := range nodes {
+		if a, ok := n.(*Assignment); ok && a.Name == name {
+			v = c.value(a.Value)
+		}
+	}
+	return v
+}
+
+func (c *compiler) positiveLimit(nodes []Node) bool {
+	for _, name := range []string{"limit", "Limit"} {
+		if n, ok := numericFloat(c.assignedValue(nodes, name)); ok && n > 0 {
+			return true
+		}
+	}
+	return false
+}
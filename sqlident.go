@@ -0,0 +1,115 @@
+package bcl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SQL identifier helpers for tools that turn migration blocks such as
+// CreateTable "users" { Column "id" { ... } } into SQL. Table and column
+// names come from config, so they must be checked before they are spliced
+// into statements; values belong in bind parameters instead.
+
+// SQLDialects lists the dialects the identifier helpers understand.
+var SQLDialects = []string{"postgres", "mysql", "sqlite"}
+
+var sqlIdentLimits = map[string]int{"postgres": 63, "mysql": 64, "sqlite": 0}
+
+var sqlReservedCommon = []string{
+	"all", "alter", "and", "any", "as", "asc", "between", "by", "case", "check", "column", "constraint",
+	"create", "cross", "default", "delete", "desc", "distinct", "drop", "else", "end", "exists", "foreign",
+	"from", "full", "group", "having", "in", "index", "inner", "insert", "intersect", "into", "is", "join",
+	"key", "left", "like", "limit", "not", "null", "on", "or", "order", "outer", "primary", "references",
+	"right", "select", "set", "table", "then", "to", "union", "unique", "update", "using", "values", "when",
+	"where", "with",
+}
+
+var sqlReserved = map[string]map[string]bool{
+	"postgres": nameSet(append([]string{
+		"analyse", "analyze", "array", "asymmetric", "both", "cast", "collate", "current_date",
+		"current_role", "current_time", "current_timestamp", "current_user", "deferrable", "do", "except",
+		"false", "fetch", "for", "grant", "initially", "lateral", "leading", "localtime", "localtimestamp",
+		"offset", "only", "placing", "returning", "session_user", "some", "symmetric", "trailing", "true",
+		"user", "variadic", "window",
+	}, sqlReservedCommon...)),
+	"mysql": nameSet(append([]string{
+		"accessible", "before", "call", "change", "condition", "database", "databases", "div", "dual",
+		"explain", "fulltext", "grant", "interval", "keys", "kill", "load", "lock", "match", "mod", "option",
+		"range", "read", "regexp", "rename", "replace", "require", "revoke", "rlike", "schema", "show",
+		"spatial", "sql", "starting", "trigger", "usage", "use", "write", "xor",
+	}, sqlReservedCommon...)),
+	"sqlite": nameSet(append([]string{
+		"abort", "autoincrement", "commit", "conflict", "except", "glob", "ignore", "indexed", "instead",
+		"isnull", "notnull", "offset", "pragma", "raise", "regexp", "reindex", "release", "rename", "replace",
+		"rollback", "savepoint", "temp", "temporary", "transaction", "trigger", "vacuum", "view", "virtual",
+	}, sqlReservedCommon...)),
+}
+
+// ValidateSQLIdentifier rejects names that cannot be used as a table or
+// column identifier in dialect, even quoted: empty names, names over the
+// dialect's length limit, and names holding whitespace, quotes, semicolons,
+// comment markers or control characters. "schema.table" is checked per part.
+func ValidateSQLIdentifier(name, dialect string) error {
+	limit, ok := sqlIdentLimits[dialect]
+	if !ok {
+		return fmt.Errorf("unknown SQL dialect %q", dialect)
+	}
+	for _, part := range strings.Split(name, ".") {
+		switch {
+		case part == "":
+			return fmt.Errorf("SQL identifier %q has an empty part", name)
+		case limit > 0 && len(part) > limit:
+			return fmt.Errorf("SQL identifier %q exceeds the %s limit of %d bytes", part, dialect, limit)
+		case strings.Contains(part, "--") || strings.Contains(part, "/*"):
+			return fmt.Errorf("SQL identifier %q contains a comment marker", part)
+		}
+		for _, r := range part {
+			if r < 0x20 || r == 0x7f || r == ' ' || r == '\t' || strings.ContainsRune("'\"`;\\[]", r) {
+				return fmt.Errorf("SQL identifier %q contains %q", part, r)
+			}
+		}
+	}
+	return nil
+}
+
+// IsSQLReserved reports whether word is reserved in dialect.
+func IsSQLReserved(word, dialect string) bool {
+	return sqlReserved[dialect][strings.ToLower(word)]
+}
+
+// QuoteSQLIdentifier validates name and returns it ready to splice into a
+// statement: plain lower-case names are returned as is, while reserved
+// words and names with other characters are quoted for the dialect, so
+// order becomes "order" for postgres and sqlite and `order` for mysql.
+func QuoteSQLIdentifier(name, dialect string) (string, error) {
+	if err := ValidateSQLIdentifier(name, dialect); err != nil {
+		return "", err
+	}
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		if plainSQLIdentifier(part) && !IsSQLReserved(part, dialect) {
+			continue
+		}
+		if dialect == "mysql" {
+			parts[i] = "`" + part + "`"
+		} else {
+			parts[i] = `"` + part + `"`
+		}
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// plainSQLIdentifier reports whether s needs no quoting in any dialect.
+// Upper-case letters are excluded because postgres folds unquoted names to
+// lower case.
+func plainSQLIdentifier(s string) bool {
+	for i, r := range s {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
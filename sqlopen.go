@@ -0,0 +1,177 @@
+package bcl
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SQLPool configures the connection pool and connect retries of OpenSQL.
+// Zero fields keep the database/sql defaults; Retries and RetryDelay govern
+// the initial ping.
+type SQLPool struct {
+	MaxOpen     int
+	MaxIdle     int
+	MaxLifetime time.Duration
+	MaxIdleTime time.Duration
+	Retries     int
+	RetryDelay  time.Duration
+}
+
+// sqlDrivers maps DSN schemes to the database/sql driver names registered
+// by github.com/jackc/pgx/v5/stdlib, github.com/go-sql-driver/mysql and
+// modernc.org/sqlite.
+var sqlDrivers = map[string]string{
+	"postgres":   "pgx",
+	"postgresql": "pgx",
+	"mysql":      "mysql",
+	"sqlite":     "sqlite",
+	"sqlite3":    "sqlite",
+	"file":       "sqlite",
+}
+
+// SQLDriverForDSN returns the driver name and driver-specific DSN for a URL
+// style dsn such as postgres://..., mysql://user:pw@tcp(host)/db or
+// sqlite://path. TLS options stay in the DSN in each driver's own form
+// (sslmode=..., tls=...), as written by ApplySQLTLS.
+func SQLDriverForDSN(dsn string) (driverName, driverDSN string, err error) {
+	scheme, rest, ok := strings.Cut(dsn, "://")
+	if !ok {
+		if scheme, rest, ok = strings.Cut(dsn, ":"); !ok {
+			return "", "", fmt.Errorf("DSN %q has no scheme", dsn)
+		}
+	}
+	name, ok := sqlDrivers[strings.ToLower(scheme)]
+	if !ok {
+		return "", "", fmt.Errorf("DSN scheme %q is not supported", scheme)
+	}
+	switch name {
+	case "pgx":
+		return name, dsn, nil
+	case "sqlite":
+		if scheme == "file" {
+			return name, dsn, nil
+		}
+	}
+	return name, rest, nil
+}
+
+// SQLTLS describes the TLS settings ApplySQLTLS writes into a DSN. Mode is
+// one of disable, require (encrypt without verifying the server),
+// verify-ca and verify-full; the files are PEM paths.
+type SQLTLS struct {
+	Mode     string
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// ApplySQLTLS returns dsn with t written as the parameters its driver
+// reads: sslmode, sslrootcert, sslcert and sslkey for postgres, and tls
+// for mysql. MySQL certificates must be registered with the driver's
+// RegisterTLSConfig and named in the DSN instead, so setting files for a
+// mysql DSN is an error, as is any TLS for sqlite. Parameters the DSN
+// already sets are not overridden.
+func ApplySQLTLS(dsn string, t SQLTLS) (string, error) {
+	name, _, err := SQLDriverForDSN(dsn)
+	if err != nil || t == (SQLTLS{}) {
+		return dsn, err
+	}
+	var params [][2]string
+	switch name {
+	case "pgx":
+		params = [][2]string{{"sslmode", t.Mode}, {"sslrootcert", t.CAFile}, {"sslcert", t.CertFile}, {"sslkey", t.KeyFile}}
+	case "mysql":
+		if t.CAFile != "" || t.CertFile != "" || t.KeyFile != "" {
+			return "", fmt.Errorf("mysql TLS certificates need mysql.RegisterTLSConfig and tls=<name> in the DSN")
+		}
+		modes := map[string]string{"disable": "false", "require": "skip-verify", "verify-full": "true"}
+		mode, ok := modes[t.Mode]
+		if !ok {
+			return "", fmt.Errorf("TLS mode %q is not supported for mysql", t.Mode)
+		}
+		params = [][2]string{{"tls", mode}}
+	default:
+		return "", fmt.Errorf("TLS is not supported for %s", name)
+	}
+	base, query, _ := strings.Cut(dsn, "?")
+	set, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("DSN %q: %w", dsn, err)
+	}
+	var b strings.Builder
+	b.WriteString(query)
+	for _, p := range params {
+		if p[1] == "" || set.Has(p[0]) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(p[0] + "=" + url.QueryEscape(p[1]))
+	}
+	if b.Len() == 0 {
+		return base, nil
+	}
+	return base + "?" + b.String(), nil
+}
+
+// OpenSQL opens dsn with the driver its scheme selects, applies pool and
+// pings until the database answers or the retries run out. bcl does not
+// depend on any driver; link the ones a program needs with blank imports:
+//
+//	import (
+//		_ "github.com/go-sql-driver/mysql" // mysql://
+//		_ "github.com/jackc/pgx/v5/stdlib" // postgres://, postgresql://
+//		_ "modernc.org/sqlite"             // sqlite://, sqlite3://, file:
+//	)
+//
+// Use ApplySQLTLS to add TLS settings to dsn.
+func OpenSQL(ctx context.Context, dsn string, pool SQLPool) (*sql.DB, error) {
+	name, driverDSN, err := SQLDriverForDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if !sqlDriverRegistered(name) {
+		return nil, fmt.Errorf("SQL driver %q is not registered; import its package", name)
+	}
+	db, err := sql.Open(name, driverDSN)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(pool.MaxOpen)
+	if pool.MaxIdle > 0 {
+		db.SetMaxIdleConns(pool.MaxIdle)
+	}
+	db.SetConnMaxLifetime(pool.MaxLifetime)
+	db.SetConnMaxIdleTime(pool.MaxIdleTime)
+	for attempt := 0; ; attempt++ {
+		if err = db.PingContext(ctx); err == nil {
+			return db, nil
+		}
+		if attempt >= pool.Retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(pool.RetryDelay):
+			continue
+		}
+		break
+	}
+	db.Close()
+	return nil, err
+}
+
+func sqlDriverRegistered(name string) bool {
+	for _, d := range sql.Drivers() {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
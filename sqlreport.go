@@ -0,0 +1,112 @@
+package bcl
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// MetricsSink receives the measurements of a migration run; hosts adapt it
+// to Prometheus, OpenTelemetry or StatsD. Labels are small and fixed:
+// migration and status.
+type MetricsSink interface {
+	Count(name string, delta int64, labels map[string]string)
+	Observe(name string, value float64, labels map[string]string)
+}
+
+// Migration run statuses reported by SQLMigrationReport.
+const (
+	MigrationApplied = "applied"
+	MigrationFailed  = "failed"
+)
+
+// SQLMigrationReport is the machine-readable record of one migration run,
+// written with WriteFile as a per-invocation artifact and sent to a
+// MetricsSink with Emit:
+//
+//	start := time.Now()
+//	results, err := bcl.ExecSQLMigration(ctx, db, bcl.SQLStatements(stmts...), nil)
+//	report := bcl.NewSQLMigrationReport("0042_orders", start, results, err)
+//	report.Emit(sink)
+//	_ = report.WriteFile("migration-report.json")
+type SQLMigrationReport struct {
+	Migration  string               `json:"migration" bcl:"migration"`
+	Status     string               `json:"status" bcl:"status"`
+	StartedAt  time.Time            `json:"started_at" bcl:"started_at"`
+	DurationMS int64                `json:"duration_ms" bcl:"duration_ms"`
+	Applied    int                  `json:"applied" bcl:"applied"`
+	Failed     int                  `json:"failed" bcl:"failed"`
+	Error      string               `json:"error,omitempty" bcl:"error,omitempty"`
+	Statements []SQLStatementReport `json:"statements" bcl:"statements,block"`
+}
+
+// SQLStatementReport is one executed statement of a SQLMigrationReport.
+type SQLStatementReport struct {
+	Index        int    `json:"index" bcl:",id"`
+	SQL          string `json:"sql" bcl:"sql"`
+	DurationMS   int64  `json:"duration_ms" bcl:"duration_ms"`
+	RowsAffected int64  `json:"rows_affected" bcl:"rows_affected"`
+	Error        string `json:"error,omitempty" bcl:"error,omitempty"`
+}
+
+// NewSQLMigrationReport summarizes a run of ExecSQLMigration or
+// ExecSQLStatements that began at started and returned results and err.
+// err also covers failures outside any statement, such as a failed commit.
+func NewSQLMigrationReport(migration string, started time.Time, results []SQLStatementResult, err error) SQLMigrationReport {
+	r := SQLMigrationReport{
+		Migration:  migration,
+		Status:     MigrationApplied,
+		StartedAt:  started.UTC(),
+		DurationMS: time.Since(started).Milliseconds(),
+		Statements: make([]SQLStatementReport, 0, len(results)),
+	}
+	for _, res := range results {
+		s := SQLStatementReport{Index: res.Index, SQL: res.SQL, DurationMS: res.Duration.Milliseconds(), RowsAffected: res.RowsAffected}
+		if res.Err != nil {
+			s.Error = res.Err.Error()
+			r.Failed++
+		} else {
+			r.Applied++
+		}
+		r.Statements = append(r.Statements, s)
+	}
+	if err != nil {
+		r.Status, r.Error = MigrationFailed, err.Error()
+	}
+	return r
+}
+
+// Emit sends the run to sink as bcl_migration_runs_total,
+// bcl_migration_statements_total, bcl_migration_failures_total and
+// bcl_migration_duration_seconds, labeled by migration and status.
+func (r SQLMigrationReport) Emit(sink MetricsSink) {
+	if sink == nil {
+		return
+	}
+	labels := map[string]string{"migration": r.Migration, "status": r.Status}
+	sink.Count("bcl_migration_runs_total", 1, labels)
+	sink.Count("bcl_migration_statements_total", int64(r.Applied), labels)
+	if r.Status == MigrationFailed {
+		sink.Count("bcl_migration_failures_total", 1, labels)
+	}
+	sink.Observe("bcl_migration_duration_seconds", float64(r.DurationMS)/1000, labels)
+}
+
+// WriteFile writes the report as indented JSON to path.
+func (r SQLMigrationReport) WriteFile(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	return os.WriteFile(path, b, 0644)
+}
+
+// ExitCode is the process exit status for r: 1 when the run failed and 0
+// otherwise.
+func (r SQLMigrationReport) ExitCode() int {
+	if r.Status == MigrationFailed {
+		return 1
+	}
+	return 0
+}
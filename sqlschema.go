@@ -0,0 +1,193 @@
+package bcl
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// SQLTable is a table as left by a sequence of migrations.
+type SQLTable struct {
+	Name    string
+	Columns []SQLColumn
+}
+
+// SQLColumn is one column of a SQLTable. References holds the target of a
+// foreign key as "table" or "table.column".
+type SQLColumn struct {
+	Name       string
+	Type       string
+	PrimaryKey bool
+	Nullable   bool
+	References string
+}
+
+// MigrationTables replays the Up blocks of every Migration block in n, in
+// migration id order, and returns the resulting tables sorted by name.
+// CreateTable, AlterTable (AddColumn, DropColumn) and DropTable commands
+// are understood; anything else is ignored.
+//
+//	Migration "1748976351_create_users" {
+//	  Up {
+//	    CreateTable "users" {
+//	      Column "org_id" {
+//	        type integer
+//	        references "orgs.id"
+//	      }
+//	    }
+//	  }
+//	}
+func MigrationTables(n *Normalized) []SQLTable {
+	var migrations []map[string]any
+	for _, b := range n.Blocks {
+		if b["type"] == "Migration" {
+			migrations = append(migrations, b)
+		}
+	}
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return fmt.Sprint(migrations[i]["id"]) < fmt.Sprint(migrations[j]["id"])
+	})
+	tables := map[string]*SQLTable{}
+	for _, m := range migrations {
+		for _, up := range commandBlocks(blockBody(m), "Up") {
+			body := blockBody(up)
+			for _, b := range commandBlocks(body, "CreateTable") {
+				t := &SQLTable{Name: fmt.Sprint(b["id"])}
+				for _, col := range commandBlocks(blockBody(b), "Column") {
+					t.Columns = append(t.Columns, sqlColumn(col))
+				}
+				tables[t.Name] = t
+			}
+			for _, b := range commandBlocks(body, "AlterTable") {
+				t := tables[fmt.Sprint(b["id"])]
+				if t == nil {
+					continue
+				}
+				for _, col := range commandBlocks(blockBody(b), "AddColumn") {
+					t.Columns = append(t.Columns, sqlColumn(col))
+				}
+				for _, col := range commandBlocks(blockBody(b), "DropColumn") {
+					name := fmt.Sprint(col["id"])
+					t.Columns = slices.DeleteFunc(t.Columns, func(c SQLColumn) bool { return c.Name == name })
+				}
+			}
+			for _, b := range commandBlocks(body, "DropTable") {
+				delete(tables, fmt.Sprint(b["id"]))
+			}
+		}
+	}
+	out := make([]SQLTable, 0, len(tables))
+	for _, t := range tables {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// ERDiagram renders tables as a Mermaid erDiagram ("mermaid") or a
+// Graphviz digraph ("dot"), with one edge per foreign key.
+func ERDiagram(tables []SQLTable, format string) ([]byte, error) {
+	var b bytes.Buffer
+	switch format {
+	case "", "mermaid":
+		b.WriteString("erDiagram\n")
+		for _, t := range tables {
+			fmt.Fprintf(&b, "    %s {\n", mermaidWord(t.Name))
+			for _, c := range t.Columns {
+				fmt.Fprintf(&b, "        %s %s%s\n", mermaidWord(c.Type), mermaidWord(c.Name), columnKeys(c, " PK", " FK"))
+			}
+			b.WriteString("    }\n")
+		}
+		for _, t := range tables {
+			for _, c := range t.Columns {
+				if c.References != "" {
+					target, _, _ := strings.Cut(c.References, ".")
+					fmt.Fprintf(&b, "    %s ||--o{ %s : %q\n", mermaidWord(target), mermaidWord(t.Name), c.Name)
+				}
+			}
+		}
+	case "dot":
+		b.WriteString("digraph schema {\n    node [shape=record];\n")
+		for _, t := range tables {
+			fields := []string{dotEscape(t.Name)}
+			for _, c := range t.Columns {
+				fields = append(fields, dotEscape(c.Name+" : "+c.Type+columnKeys(c, " (PK)", " (FK)"))+`\l`)
+			}
+			fmt.Fprintf(&b, "    %q [label=\"{%s}\"];\n", t.Name, strings.Join(fields, "|"))
+		}
+		for _, t := range tables {
+			for _, c := range t.Columns {
+				if c.References != "" {
+					target, _, _ := strings.Cut(c.References, ".")
+					fmt.Fprintf(&b, "    %q -> %q [label=%q];\n", t.Name, target, c.Name)
+				}
+			}
+		}
+		b.WriteString("}\n")
+	default:
+		return nil, fmt.Errorf("unknown ER diagram format %q", format)
+	}
+	return b.Bytes(), nil
+}
+
+func sqlColumn(block map[string]any) SQLColumn {
+	body := blockBody(block)
+	c := SQLColumn{Name: fmt.Sprint(block["id"])}
+	c.Type, _ = body["type"].(string)
+	c.PrimaryKey, _ = body["primary_key"].(bool)
+	c.Nullable, _ = body["is_nullable"].(bool)
+	c.References, _ = body["references"].(string)
+	return c
+}
+
+func columnKeys(c SQLColumn, pk, fk string) string {
+	var s string
+	if c.PrimaryKey {
+		s += pk
+	}
+	if c.References != "" {
+		s += fk
+	}
+	return s
+}
+
+// commandBlocks returns the nested blocks of type typ in body, which the
+// compiler stores as either []map[string]any or []any.
+func commandBlocks(body map[string]any, typ string) []map[string]any {
+	switch xs := body[typ].(type) {
+	case []map[string]any:
+		return xs
+	case []any:
+		var out []map[string]any
+		for _, x := range xs {
+			if m, ok := x.(map[string]any); ok {
+				out = append(out, m)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func blockBody(block map[string]any) map[string]any {
+	body, _ := block["body"].(map[string]any)
+	return body
+}
+
+func mermaidWord(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "{", `\{`, "}", `\}`, "|", `\|`, "<", `\<`, ">", `\>`).Replace(s)
+}
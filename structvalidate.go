@@ -0,0 +1,187 @@
+package bcl
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidateStruct checks a decoded config struct against its `validate` tags,
+// recursing into nested structs, slices, arrays and maps. Paths use the
+// config names (bcl or json tag), e.g. `servers[1].tls.port`.
+//
+// Supported rules: required, min=N, max=N, len=N, oneof=a b c, and the schema
+// formats (email, url, hostname, ip, cidr, mac, port, duration, uuid, ...).
+// min, max and len compare numbers by value and strings, slices and maps by
+// length. Rules other than required are skipped for zero values, so optional
+// fields may be left unset.
+func ValidateStruct(v any) []Diagnostic {
+	rv := indirectValue(reflect.ValueOf(v))
+	if !rv.IsValid() || rv.Kind() != reflect.Struct {
+		return []Diagnostic{{Severity: "error", Message: fmt.Sprintf("ValidateStruct requires a struct, got %T", v)}}
+	}
+	var diags []Diagnostic
+	validateStructValue(rv, "", &diags)
+	return diags
+}
+
+var structFormatRules = nameSet([]string{
+	"email", "url", "uri", "hostname", "ip", "ipv4", "ipv6", "cidr", "mac", "port", "duration",
+	"uuid", "date", "date-time", "datetime", "time",
+})
+
+func validateStructValue(rv reflect.Value, prefix string, diags *[]Diagnostic) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := parseTag(sf.Tag.Get("bcl"))
+		if tag.skip {
+			continue
+		}
+		fv := rv.Field(i)
+		if tag.inline {
+			if inner := indirectValue(fv); inner.IsValid() && inner.Kind() == reflect.Struct {
+				validateStructValue(inner, prefix, diags)
+			}
+			continue
+		}
+		name := tag.name
+		if name == "" {
+			name = parseJSONName(sf.Tag.Get("json"))
+		}
+		if name == "" {
+			name = lowerFirst(sf.Name)
+		}
+		path := joinPath(prefix, name)
+		if rules := sf.Tag.Get("validate"); rules != "" && rules != "-" {
+			applyStructRules(fv, path, rules, diags)
+		}
+		validateStructChildren(fv, path, diags)
+	}
+}
+
+func validateStructChildren(fv reflect.Value, path string, diags *[]Diagnostic) {
+	fv = indirectValue(fv)
+	if !fv.IsValid() {
+		return
+	}
+	switch fv.Kind() {
+	case reflect.Struct:
+		if fv.Type() != reflect.TypeOf(time.Time{}) {
+			validateStructValue(fv, path, diags)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < fv.Len(); i++ {
+			validateStructChildren(fv.Index(i), fmt.Sprintf("%s[%d]", path, i), diags)
+		}
+	case reflect.Map:
+		keys := fv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			validateStructChildren(fv.MapIndex(k), fmt.Sprintf("%s[%v]", path, k), diags)
+		}
+	}
+}
+
+func applyStructRules(fv reflect.Value, path, rules string, diags *[]Diagnostic) {
+	fail := func(msg string) {
+		*diags = append(*diags, Diagnostic{Severity: "error", Message: fmt.Sprintf("field %q %s", path, msg)})
+	}
+	v := indirectValue(fv)
+	if !v.IsValid() || v.IsZero() {
+		if strings.Contains(","+rules+",", ",required,") {
+			fail("is required")
+		}
+		return
+	}
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "", "required", "omitempty":
+		case "min", "max", "len":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				fail(fmt.Sprintf("has invalid %s rule %q", name, arg))
+				continue
+			}
+			got, isLength, ok := structRuleMeasure(v)
+			if !ok {
+				fail(fmt.Sprintf("does not support the %s rule", name))
+				continue
+			}
+			subject := "must be"
+			if isLength {
+				subject = "length must be"
+			}
+			switch {
+			case name == "min" && got < limit:
+				fail(fmt.Sprintf("%s >= %s", subject, arg))
+			case name == "max" && got > limit:
+				fail(fmt.Sprintf("%s <= %s", subject, arg))
+			case name == "len" && got != limit:
+				fail(fmt.Sprintf("%s %s", subject, arg))
+			}
+		case "oneof":
+			allowed := strings.Fields(arg)
+			got := fmt.Sprint(v.Interface())
+			found := false
+			for _, a := range allowed {
+				if a == got {
+					found = true
+					break
+				}
+			}
+			if !found {
+				fail(fmt.Sprintf("must be one of %s", strings.Join(allowed, ", ")))
+			}
+		default:
+			if !structFormatRules[name] {
+				fail(fmt.Sprintf("has unknown validate rule %q", name))
+				continue
+			}
+			if !structFormatMatches(name, v) {
+				fail(fmt.Sprintf("does not match format %s", name))
+			}
+		}
+	}
+}
+
+// structRuleMeasure returns the number min/max/len compare against and
+// whether it is a length.
+func structRuleMeasure(v reflect.Value) (float64, bool, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false, true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true, true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true, true
+	}
+	return 0, false, false
+}
+
+func structFormatMatches(format string, v reflect.Value) bool {
+	if v.Kind() == reflect.String {
+		return schemaFormatMatches(format, v.String())
+	}
+	if format == "port" {
+		if n, isLength, ok := structRuleMeasure(v); ok && !isLength {
+			return validPort(n)
+		}
+	}
+	if d, ok := v.Interface().(time.Duration); ok && format == "duration" {
+		return d >= 0
+	}
+	return false
+}
@@ -0,0 +1,502 @@
+package bcl
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// SymbolOccurrence is a place where a symbol is defined or used. Span
+// covers exactly the text Rename replaces: the name of a variable, or the
+// id of a block without its quotes.
+type SymbolOccurrence struct {
+	File       string `json:"file"`
+	Span       Span   `json:"span"`
+	Definition bool   `json:"definition,omitempty"`
+	// Kind is set on definitions: "variable", "const", "param" or "block".
+	Kind string `json:"kind,omitempty"`
+
+	// owner is the const or param whose value holds a use; empty for uses
+	// in statements that reach the output.
+	owner string
+}
+
+// SymbolIndex records where the symbols of a project are defined and
+// referenced, across every file reached through imports. Top-level
+// variables, consts and params are indexed by name and labeled top-level
+// blocks as type.id, such as "service.api".
+type SymbolIndex struct {
+	// Files holds the source of every indexed file by path.
+	Files   map[string][]byte             `json:"-"`
+	Symbols map[string][]SymbolOccurrence `json:"symbols"`
+
+	aliases map[string]bool
+	// imports maps an imported file to the import that first loaded it,
+	// and outputs marks the files with top-level statements that reach
+	// the compiled output.
+	imports map[string]SymbolOccurrence
+	outputs map[string]bool
+	order   []string
+}
+
+// IndexSymbols reads the given files and the files they import, and
+// indexes their symbols. Uses are found in values, expressions, `if`
+// conditions, ${...} interpolations and the paths given to ref(), with or
+// without an app. or config.app. prefix; keys and function names are not
+// uses.
+func IndexSymbols(paths ...string) (*SymbolIndex, error) {
+	x := &SymbolIndex{
+		Files:   map[string][]byte{},
+		Symbols: map[string][]SymbolOccurrence{},
+		aliases: map[string]bool{},
+		imports: map[string]SymbolOccurrence{},
+		outputs: map[string]bool{},
+	}
+	var load func(path string) error
+	load = func(path string) error {
+		if _, ok := x.Files[path]; ok {
+			return nil
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		doc, err := ParseFile(path, src)
+		if err != nil {
+			return err
+		}
+		x.Files[path] = src
+		x.order = append(x.order, path)
+		for _, n := range doc.Items {
+			imp, ok := n.(*ImportDecl)
+			if !ok {
+				x.outputs[path] = x.outputs[path] || producesOutput(n)
+				continue
+			}
+			if isRemoteSource(imp.Path) {
+				continue
+			}
+			if imp.Alias != "" {
+				x.aliases[imp.Alias] = true
+			}
+			matches, err := resolveSourceFiles(imp.Path, filepath.Dir(path))
+			if err != nil {
+				return fmt.Errorf("%s: import %q: %w", path, imp.Path, err)
+			}
+			for _, m := range matches {
+				if _, ok := x.imports[m]; !ok {
+					x.imports[m] = SymbolOccurrence{File: path, Span: imp.Span}
+				}
+				if err := load(m); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, p := range paths {
+		if err := load(filepath.Clean(p)); err != nil {
+			return nil, err
+		}
+	}
+	toks := map[string][]token{}
+	for _, path := range x.order {
+		ts, errs := lex(path, x.Files[path])
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		toks[path] = ts
+		x.definitions(path, ts)
+	}
+	for _, path := range x.order {
+		x.uses(path, toks[path])
+	}
+	for name, occ := range x.Symbols {
+		sort.SliceStable(occ, func(i, j int) bool {
+			if occ[i].File != occ[j].File {
+				return occ[i].File < occ[j].File
+			}
+			return occ[i].Span.Start.Offset < occ[j].Span.Start.Offset
+		})
+		x.Symbols[name] = occ
+	}
+	return x, nil
+}
+
+// Definitions returns where symbol is defined; more than one definition
+// means later files override earlier ones.
+func (x *SymbolIndex) Definitions(symbol string) []SymbolOccurrence {
+	return slices.DeleteFunc(slices.Clone(x.Symbols[symbol]), func(o SymbolOccurrence) bool { return !o.Definition })
+}
+
+// References returns where symbol is used.
+func (x *SymbolIndex) References(symbol string) []SymbolOccurrence {
+	return slices.DeleteFunc(slices.Clone(x.Symbols[symbol]), func(o SymbolOccurrence) bool { return o.Definition })
+}
+
+// Rename rewrites every definition and use of symbol to newName and returns
+// the new contents of the files that changed; everything else in them,
+// comments and layout included, is kept byte for byte. For a block symbol
+// newName is the new id, with or without its type prefix. The index itself
+// is not updated.
+func (x *SymbolIndex) Rename(symbol, newName string) (map[string][]byte, error) {
+	occ := x.Symbols[symbol]
+	if len(occ) == 0 {
+		return nil, fmt.Errorf("rename: unknown symbol %q", symbol)
+	}
+	target := newName
+	if typ, _, ok := strings.Cut(symbol, "."); ok {
+		newName = strings.TrimPrefix(newName, typ+".")
+		target = typ + "." + newName
+	}
+	if !validSymbolName(newName) {
+		return nil, fmt.Errorf("rename: %q is not a valid name", newName)
+	}
+	if target == symbol {
+		return map[string][]byte{}, nil
+	}
+	if len(x.Symbols[target]) > 0 {
+		return nil, fmt.Errorf("rename: %q is already defined", target)
+	}
+	byFile := map[string][]SymbolOccurrence{}
+	for _, o := range occ {
+		byFile[o.File] = append(byFile[o.File], o)
+	}
+	out := make(map[string][]byte, len(byFile))
+	for file, edits := range byFile {
+		src := x.Files[file]
+		var b []byte
+		last := 0
+		for _, e := range edits {
+			b = append(b, src[last:e.Span.Start.Offset]...)
+			b = append(b, newName...)
+			last = e.Span.End.Offset
+		}
+		out[file] = append(b, src[last:]...)
+	}
+	return out, nil
+}
+
+func validSymbolName(s string) bool {
+	for i, r := range s {
+		if i == 0 && !isIdentStart(r) || !isIdentPart(r) && r != '-' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func (x *SymbolIndex) add(name, file string, sp Span, kind string) {
+	x.Symbols[name] = append(x.Symbols[name], SymbolOccurrence{File: file, Span: sp, Definition: true, Kind: kind})
+}
+
+// definitions indexes the top-level statements of one file.
+func (x *SymbolIndex) definitions(file string, toks []token) {
+	depth := 0
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		switch t.kind {
+		case tokLBrace, tokLBracket, tokLParen:
+			depth++
+			continue
+		case tokRBrace, tokRBracket, tokRParen:
+			depth--
+			continue
+		}
+		if depth != 0 || t.kind != tokIdent || !statementStart(toks, i) {
+			continue
+		}
+		next := tokenAt(toks, i+1)
+		switch {
+		case (t.text == "const" || t.text == "param") && next.kind == tokIdent:
+			x.add(next.text, file, next.span, t.text)
+		case t.text == "import" || t.text == "if" || t.text == "schema" || t.text == "type":
+		case (next.kind == tokString || next.kind == tokIdent) && tokenAt(toks, i+2).kind == tokLBrace:
+			x.add(t.text+"."+next.text, file, symbolTextSpan(next), "block")
+		case next.kind == tokEqual || next.kind != tokLBrace && next.kind != tokNewline && next.kind != tokEOF:
+			name, sp := strings.TrimSuffix(t.text, ":"), t.span
+			sp.End.Offset -= len(t.text) - len(name)
+			sp.End.Column -= len(t.text) - len(name)
+			x.add(name, file, sp, "variable")
+		}
+	}
+}
+
+// uses indexes the references of one file to symbols already defined.
+func (x *SymbolIndex) uses(file string, toks []token) {
+	src := string(x.Files[file])
+	var stack []tokenKind
+	owner := ""
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		switch t.kind {
+		case tokLBrace, tokLBracket, tokLParen:
+			stack = append(stack, t.kind)
+			continue
+		case tokRBrace, tokRBracket, tokRParen:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		case tokString, tokHeredoc:
+			x.interpolatedUses(file, owner, src, t)
+			continue
+		case tokIdent:
+		default:
+			continue
+		}
+		inBody := len(stack) == 0 || stack[len(stack)-1] == tokLBrace
+		if inBody && statementStart(toks, i) {
+			if len(stack) == 0 {
+				owner = ""
+				if t.text == "const" || t.text == "param" {
+					owner = tokenAt(toks, i+1).text
+				}
+			}
+			i = skipStatementHead(toks, i)
+			continue
+		}
+		if tokenAt(toks, i-1).kind == tokDot {
+			continue
+		}
+		segs := []token{t}
+		for tokenAt(toks, i+1).kind == tokDot && tokenAt(toks, i+2).kind == tokIdent {
+			segs = append(segs, toks[i+2])
+			i += 2
+		}
+		next, after := tokenAt(toks, i+1), tokenAt(toks, i+2)
+		if len(segs) == 1 && t.text == "ref" && next.kind == tokLParen && after.kind == tokString && tokenAt(toks, i+3).kind == tokRParen {
+			x.use(file, owner, refPathSegments(after))
+			continue
+		}
+		if next.kind == tokLParen || next.kind == tokEqual && after.kind == tokOperator && after.text == ">" {
+			continue
+		}
+		x.use(file, owner, segs)
+	}
+}
+
+// refPathSegments splits the path argument of ref("a.b") into one token per
+// segment, each spanning its text inside the quotes. A string written with
+// escapes has no such spans and yields nothing.
+func refPathSegments(t token) []token {
+	if t.span.End.Offset-t.span.Start.Offset != len(t.text)+2 {
+		return nil
+	}
+	sp := symbolTextSpan(t)
+	var segs []token
+	off := 0
+	for _, part := range strings.Split(t.text, ".") {
+		seg := token{kind: tokIdent, text: part, span: sp}
+		seg.span.Start.Offset += off
+		seg.span.Start.Column += off
+		seg.span.End.Offset = seg.span.Start.Offset + len(part)
+		seg.span.End.Column = seg.span.Start.Column + len(part)
+		segs = append(segs, seg)
+		off += len(part) + 1
+	}
+	return segs
+}
+
+// use records the reference spelled by the dotted path segs. The app. and
+// config.app. prefixes through which expressions read the output, and the
+// const. prefix of a const, are dropped first.
+func (x *SymbolIndex) use(file, owner string, segs []token) {
+	switch {
+	case len(segs) > 1 && x.aliases[segs[0].text]:
+		segs = segs[1:]
+	case len(segs) > 1 && segs[0].text == "const":
+		if !slices.ContainsFunc(x.Symbols[segs[1].text], func(o SymbolOccurrence) bool { return o.Kind == "const" }) {
+			return
+		}
+		segs = segs[1:]
+	case len(segs) > 2 && segs[0].text == "config" && segs[1].text == "app":
+		segs = segs[2:]
+	case len(segs) > 1 && segs[0].text == "app":
+		segs = segs[1:]
+	}
+	if len(segs) == 0 {
+		return
+	}
+	name, t := segs[0].text, segs[0]
+	if _, ok := x.Symbols[name]; !ok {
+		if len(segs) < 2 {
+			return
+		}
+		name, t = segs[0].text+"."+segs[1].text, segs[1]
+		if _, ok := x.Symbols[name]; !ok {
+			return
+		}
+	}
+	x.Symbols[name] = append(x.Symbols[name], SymbolOccurrence{File: file, Span: t.span, owner: owner})
+}
+
+// interpolatedUses indexes the references inside the ${...} parts of a
+// string token, locating them in the raw source so escapes do not shift
+// their spans.
+func (x *SymbolIndex) interpolatedUses(file, owner, src string, t token) {
+	raw := src[t.span.Start.Offset:t.span.End.Offset]
+	for _, m := range interpolationPattern.FindAllStringSubmatchIndex(raw, -1) {
+		inner := raw[m[2]:m[3]]
+		toks, errs := lexString(file, inner)
+		if len(errs) > 0 {
+			continue
+		}
+		base := t.span.Start.Offset + m[2]
+		for i := 0; i < len(toks); i++ {
+			if toks[i].kind != tokIdent || tokenAt(toks, i-1).kind == tokDot {
+				continue
+			}
+			segs := []token{shiftToken(src, toks[i], base)}
+			for tokenAt(toks, i+1).kind == tokDot && tokenAt(toks, i+2).kind == tokIdent {
+				segs = append(segs, shiftToken(src, toks[i+2], base))
+				i += 2
+			}
+			if tokenAt(toks, i+1).kind != tokLParen {
+				x.use(file, owner, segs)
+			}
+		}
+	}
+}
+
+// shiftToken moves a token lexed from a substring starting at base to its
+// position in src.
+func shiftToken(src string, t token, base int) token {
+	start, end := base+t.span.Start.Offset, base+t.span.End.Offset
+	t.span.Start = offsetPosition(src, start)
+	t.span.End = offsetPosition(src, end)
+	return t
+}
+
+func offsetPosition(src string, off int) Position {
+	line := strings.Count(src[:off], "\n") + 1
+	col := off - strings.LastIndexByte(src[:off], '\n')
+	return Position{Line: line, Column: col, Offset: off}
+}
+
+func tokenAt(toks []token, i int) token {
+	if i < 0 || i >= len(toks) {
+		return token{kind: tokEOF}
+	}
+	return toks[i]
+}
+
+// statementStart reports whether toks[i] begins a line or follows `{`.
+func statementStart(toks []token, i int) bool {
+	switch tokenAt(toks, i-1).kind {
+	case tokEOF, tokNewline, tokLBrace:
+		return true
+	}
+	return false
+}
+
+// skipStatementHead steps over the key of the statement at toks[i], and the
+// labels of a block header, returning the index of the last skipped token.
+// The conditions of `if` and `else if` are values and are not skipped.
+func skipStatementHead(toks []token, i int) int {
+	switch toks[i].text {
+	case "if", "IF", "elseif", "ELSEIF", "elif", "else", "ELSE":
+		return i
+	case "const", "param":
+		return i + 1
+	}
+	j := i + 1
+	for tokenAt(toks, j).kind == tokString || tokenAt(toks, j).kind == tokIdent {
+		j++
+	}
+	if tokenAt(toks, j).kind == tokLBrace {
+		return j - 1
+	}
+	return i
+}
+
+// symbolTextSpan returns the span of a name token without its quotes.
+func symbolTextSpan(t token) Span {
+	sp := t.span
+	if t.kind == tokString && sp.End.Offset-sp.Start.Offset == len(t.text)+2 {
+		sp.Start.Offset++
+		sp.Start.Column++
+		sp.End.Offset--
+		sp.End.Column--
+	}
+	return sp
+}
+
+// Unused reports the values of the index that never influence the compiled
+// output: consts and params not used by any statement that reaches it,
+// directly or through other consts, variables overwritten in the same file
+// before any use, and imported files that define nothing used and produce
+// no output of their own. Each is a warning at the definition or import.
+func (x *SymbolIndex) Unused() []Diagnostic {
+	live := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for name, occ := range x.Symbols {
+			if live[name] {
+				continue
+			}
+			for _, o := range occ {
+				if !o.Definition && (o.owner == "" || live[o.owner]) {
+					live[name], changed = true, true
+					break
+				}
+			}
+		}
+	}
+	var diags []Diagnostic
+	usedFiles := map[string]bool{}
+	for _, name := range slices.Sorted(maps.Keys(x.Symbols)) {
+		occ := x.Symbols[name]
+		for i, o := range occ {
+			if !o.Definition {
+				continue
+			}
+			if live[name] {
+				usedFiles[o.File] = true
+			}
+			switch o.Kind {
+			case "const", "param":
+				if !live[name] {
+					diags = append(diags, Diagnostic{Severity: "warning", Message: fmt.Sprintf("%s %q is never used", o.Kind, name), Span: o.Span})
+				}
+			case "variable":
+				if j := i + 1; j < len(occ) && occ[j].File == o.File && occ[j].Kind == "variable" && !usedOnLine(occ[j+1:], occ[j]) {
+					diags = append(diags, Diagnostic{Severity: "warning", Message: fmt.Sprintf("value of %q is overwritten before it is used", name), Span: o.Span})
+				}
+			}
+		}
+	}
+	for _, file := range x.order {
+		imp, ok := x.imports[file]
+		if ok && !x.outputs[file] && !usedFiles[file] {
+			diags = append(diags, Diagnostic{Severity: "warning", Message: fmt.Sprintf("import %q contributes nothing to the output", file), Span: imp.Span})
+		}
+	}
+	return diags
+}
+
+// usedOnLine reports whether occ holds a use on the line of def, as in
+// `x = x + 1`, which reads the value it replaces.
+func usedOnLine(occ []SymbolOccurrence, def SymbolOccurrence) bool {
+	return slices.ContainsFunc(occ, func(o SymbolOccurrence) bool {
+		return !o.Definition && o.File == def.File && o.Span.Start.Line == def.Span.Start.Line
+	})
+}
+
+// producesOutput reports whether a top-level statement can reach the
+// compiled output; declarations only matter through their uses.
+func producesOutput(n Node) bool {
+	switch x := n.(type) {
+	case *ConstDecl, *ParamDecl, *TypeDecl, *SchemaDecl:
+		return false
+	case *Assignment:
+		return x.Name != "_"
+	case *Block:
+		return x.Type != "bcl" && x.Type != "test" && x.Type != "schema"
+	}
+	return true
+}
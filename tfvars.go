@@ -0,0 +1,162 @@
+package bcl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ToTFVars renders the evaluated values of a compile result as
+// terraform.tfvars.json. Top-level assignments become variables and blocks
+// are grouped by type, keyed by id when one is present; several blocks of a
+// type without ids become a list. Names that would map to the same variable,
+// such as a-b and a_b or a key and a block type, are an error, and so is a
+// result whose sensitive values were redacted: compile with
+// Options.RevealSensitive to export them.
+func ToTFVars(result *CompileResult) ([]byte, error) {
+	vars, err := tfvarsValues(result)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(vars, "", "  ")
+}
+
+// ToTFVarsHCL renders the same variables as ToTFVars in HCL tfvars syntax.
+func ToTFVarsHCL(result *CompileResult) ([]byte, error) {
+	vars, err := tfvarsValues(result)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, k := range sortedSchemaNames(vars) {
+		fmt.Fprintf(&b, "%s = ", k)
+		writeHCLValue(&b, vars[k], 0)
+		b.WriteByte('\n')
+	}
+	return b.Bytes(), nil
+}
+
+func tfvarsValues(result *CompileResult) (map[string]any, error) {
+	if result == nil || result.Normalized == nil {
+		return nil, fmt.Errorf("compile result is nil")
+	}
+	if result.redacted && len(result.Sensitive) > 0 {
+		return nil, fmt.Errorf("tfvars: %s is sensitive and was redacted; compile with RevealSensitive to export it", result.Sensitive[0])
+	}
+	n := result.Normalized
+	vars := make(map[string]any, len(n.Body)+len(n.Blocks))
+	owners := make(map[string]string, len(vars))
+	claim := func(name, owner string) error {
+		if prev, ok := owners[name]; ok && prev != owner {
+			return fmt.Errorf("tfvars: %s and %s both map to variable %q", prev, owner, name)
+		}
+		owners[name] = owner
+		return nil
+	}
+	for _, k := range sortedSchemaNames(n.Body) {
+		name := tfIdentifier(k)
+		if err := claim(name, fmt.Sprintf("key %q", k)); err != nil {
+			return nil, err
+		}
+		vars[name] = tfValue(n.Body[k])
+	}
+	unnamed := map[string]int{}
+	for _, block := range n.Blocks {
+		typ, _ := block["type"].(string)
+		id, _ := block["id"].(string)
+		body := tfValue(block["body"])
+		name := tfIdentifier(typ)
+		if id == "" {
+			if err := claim(name, fmt.Sprintf("block %q", typ)); err != nil {
+				return nil, err
+			}
+			switch unnamed[name]++; unnamed[name] {
+			case 1:
+				vars[name] = body
+			case 2:
+				vars[name] = []any{vars[name], body}
+			default:
+				vars[name] = append(vars[name].([]any), body)
+			}
+			continue
+		}
+		if err := claim(name, fmt.Sprintf("labeled block %q", typ)); err != nil {
+			return nil, err
+		}
+		group, ok := vars[name].(map[string]any)
+		if !ok {
+			group = map[string]any{}
+			vars[name] = group
+		}
+		if _, dup := group[id]; dup {
+			return nil, fmt.Errorf("tfvars: duplicate %s %q", typ, id)
+		}
+		group[id] = body
+	}
+	return vars, nil
+}
+
+func tfValue(v any) any {
+	switch x := unwrapTypedScalar(v).(type) {
+	case map[string]any:
+		out := make(map[string]any, len(x))
+		for k, item := range x {
+			out[k] = tfValue(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(x))
+		for i, item := range x {
+			out[i] = tfValue(item)
+		}
+		return out
+	default:
+		return x
+	}
+}
+
+func tfIdentifier(s string) string {
+	s = strings.NewReplacer("-", "_", ".", "_", "/", "_", ":", "_").Replace(s)
+	if s != "" && s[0] >= '0' && s[0] <= '9' {
+		s = "_" + s
+	}
+	return s
+}
+
+func writeHCLValue(b *bytes.Buffer, v any, indent int) {
+	switch x := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case string:
+		b.WriteString(strconv.Quote(x))
+	case bool:
+		b.WriteString(strconv.FormatBool(x))
+	case []any:
+		b.WriteByte('[')
+		for i, item := range x {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeHCLValue(b, item, indent)
+		}
+		b.WriteByte(']')
+	case map[string]any:
+		if len(x) == 0 {
+			b.WriteString("{}")
+			return
+		}
+		b.WriteString("{\n")
+		pad := strings.Repeat("  ", indent+1)
+		for _, k := range sortedSchemaNames(x) {
+			fmt.Fprintf(b, "%s%s = ", pad, strconv.Quote(k))
+			writeHCLValue(b, x[k], indent+1)
+			b.WriteByte('\n')
+		}
+		b.WriteString(strings.Repeat("  ", indent))
+		b.WriteByte('}')
+	default:
+		fmt.Fprint(b, x)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
 
@@ -83,6 +84,12 @@ func Validate(doc *Document, opts *Options) []Diagnostic {
 				if o, ok := x.Value.(*Object); ok {
 					walk(o.Fields, depth, currentType)
 				}
+			case *IfChain:
+				for _, branch := range x.Branches {
+					validateValueAdvanced(branch.Cond, &diags, refs, constUses, setUses)
+					walk(branch.Body, depth+1, currentType)
+				}
+				walk(x.Else, depth+1, currentType)
 			}
 		}
 	}
@@ -1050,19 +1057,30 @@ func patternIsCatchAll(pattern string) bool {
 }
 
 func validateSchemas(nodes []Node, schemas map[string]*SchemaDecl, aliases map[string]string, diags *[]Diagnostic) {
+	if len(schemas) == 0 {
+		return
+	}
+	maps := make(map[string]any, len(schemas))
+	for name, schema := range schemas {
+		maps[name] = schemaDeclValidationMap(schema)
+	}
+	validateSchemaBlocks(nodes, schemas, maps, aliases, diags)
+}
+
+func validateSchemaBlocks(nodes []Node, schemas map[string]*SchemaDecl, maps map[string]any, aliases map[string]string, diags *[]Diagnostic) {
 	for _, n := range nodes {
 		b, ok := n.(*Block)
 		if !ok {
 			continue
 		}
 		if schema := schemas[b.Type]; schema != nil {
-			validateBlockAgainstSchema(b, schema, aliases, diags)
+			validateBlockAgainstSchema(b, schema, maps, aliases, diags)
 		}
-		validateSchemas(b.Body, schemas, aliases, diags)
+		validateSchemaBlocks(b.Body, schemas, maps, aliases, diags)
 	}
 }
 
-func validateBlockAgainstSchema(b *Block, schema *SchemaDecl, aliases map[string]string, diags *[]Diagnostic) {
+func validateBlockAgainstSchema(b *Block, schema *SchemaDecl, maps map[string]any, aliases map[string]string, diags *[]Diagnostic) {
 	fields := map[string]Value{}
 	blockFields := map[string][]*Block{}
 	for _, n := range b.Body {
@@ -1109,7 +1127,7 @@ func validateBlockAgainstSchema(b *Block, schema *SchemaDecl, aliases map[string
 			}
 		}
 	}
-	for _, d := range ValidateSchemaValue(schema.Name, schemaDeclValidationMap(schema), blockValidationMap(b)) {
+	for _, d := range ValidateSchemaValueIn(maps, schema.Name, blockValidationMap(b)) {
 		*diags = append(*diags, d)
 	}
 }
@@ -1120,6 +1138,7 @@ func schemaDeclValidationMap(schema *SchemaDecl) map[string]any {
 		fields = append(fields, schemaFieldValidationMap(f))
 	}
 	m := map[string]any{"fields": fields}
+	schemaCompositionToMap(m, schema)
 	if len(schema.Options) > 0 {
 		options := map[string]any{}
 		for key, value := range schema.Options {
@@ -1130,6 +1149,14 @@ func schemaDeclValidationMap(schema *SchemaDecl) map[string]any {
 	return m
 }
 
+func schemaCompositionToMap(m map[string]any, s *SchemaDecl) {
+	for key, names := range map[string][]string{"all_of": s.AllOf, "any_of": s.AnyOf, "one_of": s.OneOf} {
+		if len(names) > 0 {
+			m[key] = append([]string(nil), names...)
+		}
+	}
+}
+
 func schemaFieldValidationMap(f SchemaField) map[string]any {
 	m := map[string]any{"name": f.Name, "type": f.Type, "required": f.Required}
 	if f.Ref != "" {
@@ -1145,6 +1172,13 @@ func schemaFieldValidationMap(f SchemaField) map[string]any {
 		}
 		m["enum"] = vals
 	}
+	if len(f.SubsetOf) > 0 {
+		vals := make([]any, 0, len(f.SubsetOf))
+		for _, v := range f.SubsetOf {
+			vals = append(vals, valueInterface(v))
+		}
+		m["subset_of"] = vals
+	}
 	if len(f.Fields) > 0 {
 		children := make([]map[string]any, 0, len(f.Fields))
 		for _, child := range f.Fields {
@@ -1188,9 +1222,15 @@ func schemaFieldValidationMap(f SchemaField) map[string]any {
 	if f.Format != "" {
 		m["format"] = f.Format
 	}
+	if len(f.Schemes) > 0 {
+		m["schemes"] = append([]string(nil), f.Schemes...)
+	}
 	if f.PatternProperties != nil {
 		m["pattern_properties"] = valueInterface(f.PatternProperties)
 	}
+	if f.KeyPattern != "" {
+		m["key_pattern"] = f.KeyPattern
+	}
 	if f.DependentRequired != nil {
 		m["dependent_required"] = valueInterface(f.DependentRequired)
 	}
@@ -1588,6 +1628,12 @@ func declarationUsage(doc *Document) (usageSet, usageCount) {
 				countUses(x.Body)
 			case *Assignment:
 				countDeclarationValueUsage(x.Value, decls, uses)
+			case *IfChain:
+				for _, branch := range x.Branches {
+					countDeclarationValueUsage(branch.Cond, decls, uses)
+					countUses(branch.Body)
+				}
+				countUses(x.Else)
 			}
 		}
 	}
@@ -1814,9 +1860,13 @@ func validateIntegrations(nodes []Node, diags *[]Diagnostic) {
 				*diags = append(*diags, Diagnostic{Severity: "error", Message: fmt.Sprintf("file %q requires path and mode", b.ID), Span: b.Span})
 			}
 		case "command":
-			if blockString(b, "exec") == "" {
+			fields := blockAssignments(b)
+			if list, ok := fields["exec"].(*List); !(ok && len(list.Items) > 0) && blockString(b, "exec") == "" {
 				*diags = append(*diags, Diagnostic{Severity: "error", Message: fmt.Sprintf("command %q requires structured exec", b.ID), Span: b.Span})
 			}
+			if shell := blockString(b, "shell"); shell != "" && !slices.Contains(CommandShells, strings.ToLower(shell)) {
+				*diags = append(*diags, Diagnostic{Severity: "error", Message: fmt.Sprintf("command %q has unsupported shell %q (want one of %s)", b.ID, shell, strings.Join(CommandShells, ", ")), Span: b.Span})
+			}
 		}
 		validateIntegrations(b.Body, diags)
 	}